@@ -0,0 +1,164 @@
+// Package cli holds the command-line subcommands of the server binary.
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"fakidoosuurdoris/app/Internal/services"
+	"fakidoosuurdoris/app/config"
+)
+
+// Sync uploads every poll definition found under a directory to a server's
+// import endpoint. Definitions are matched to existing polls by key, so
+// running it again only applies what changed. Polls whose definition was
+// removed are left alone. The server and token default to server.base_url
+// and sync.token of the configuration. It returns the process exit code.
+func Sync(args []string) int {
+	cfg, err := config.Read(nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	flags := flag.NewFlagSet("sync", flag.ContinueOnError)
+	server := flags.String("server", cfg.Server.BaseURL, "base URL of the server")
+	token := flags.String("token", "", "Firebase ID token of an admin (default sync.token or $SYNC_TOKEN)")
+	dryRun := flags.Bool("dry-run", false, "only validate the definitions")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: sync [flags] <directory>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *token == "" {
+		*token = cfg.Sync.Token
+	}
+	if flags.NArg() != 1 || *token == "" {
+		flags.Usage()
+		return 2
+	}
+
+	defs, sources, err := readDefinitions(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if len(defs) == 0 {
+		fmt.Fprintln(os.Stderr, "No poll definitions found in", flags.Arg(0))
+		return 1
+	}
+
+	body, err := json.Marshal(defs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	endpoint := strings.TrimRight(*server, "/") + "/api/v1/polls/import"
+	if *dryRun {
+		endpoint += "?dry_run=true"
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+*token)
+
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Request failed:", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Error   string                  `json:"error"`
+		Results []services.ImportResult `json:"results"`
+	}
+	raw, _ := io.ReadAll(resp.Body)
+	if err := json.Unmarshal(raw, &out); err != nil || (out.Error == "" && out.Results == nil) {
+		fmt.Fprintf(os.Stderr, "Unexpected response (%s): %s\n", resp.Status, raw)
+		return 1
+	}
+	if out.Error != "" {
+		fmt.Fprintf(os.Stderr, "Import failed (%s): %s\n", resp.Status, out.Error)
+		return 1
+	}
+
+	for _, r := range out.Results {
+		source := ""
+		if r.Index >= 0 && r.Index < len(sources) {
+			source = sources[r.Index]
+		}
+		line := fmt.Sprintf("%-9s %s (%s)", r.Status, r.Key, source)
+		if r.PollID != 0 {
+			line += fmt.Sprintf(" poll %d", r.PollID)
+		}
+		fmt.Println(line)
+		for _, problem := range r.Errors {
+			fmt.Println("          -", problem)
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintln(os.Stderr, "Nothing was imported.")
+		return 1
+	}
+	if *dryRun {
+		fmt.Println("Dry run: nothing was changed.")
+	}
+	return 0
+}
+
+// readDefinitions decodes the .json, .yaml and .yml files under dir in
+// path order and returns the definitions with the file each came from.
+func readDefinitions(dir string) ([]services.PollDefinition, []string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".json", ".yaml", ".yml":
+			if !d.IsDir() {
+				paths = append(paths, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(paths)
+
+	var defs []services.PollDefinition
+	var sources []string
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		fileDefs, err := services.DecodeDefinitions(data, strings.ToLower(filepath.Ext(path)) != ".json")
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", path, err)
+		}
+		for range fileDefs {
+			sources = append(sources, path)
+		}
+		defs = append(defs, fileDefs...)
+	}
+	return defs, sources, nil
+}
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS account_deletions (
+    user_id VARCHAR PRIMARY KEY,
+    requested_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    scheduled_for TIMESTAMP NOT NULL
+);
+
+-- Polls and shared templates of deleted accounts are kept under this user,
+-- so they still join to a users row. It has no sign-in and no email, and
+-- its role matches no eligibility rule.
+INSERT INTO users (id, firstname, lastname, email, role)
+VALUES ('deleted-user', 'Deleted', 'user', '', 'deleted')
+ON CONFLICT (id) DO NOTHING;
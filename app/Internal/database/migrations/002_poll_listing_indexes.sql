@@ -0,0 +1,5 @@
+CREATE INDEX IF NOT EXISTS polls_title_fts_idx ON polls USING GIN (to_tsvector('simple', title));
+CREATE INDEX IF NOT EXISTS polls_created_at_id_idx ON polls (created_at, id);
+CREATE INDEX IF NOT EXISTS polls_start_date_id_idx ON polls (start_date, id);
+CREATE INDEX IF NOT EXISTS polls_user_id_idx ON polls (user_id);
+CREATE INDEX IF NOT EXISTS votes_poll_id_voted_by_idx ON votes (poll_id, voted_by);
@@ -0,0 +1,9 @@
+ALTER TABLE options ADD COLUMN IF NOT EXISTS description TEXT NOT NULL DEFAULT '';
+ALTER TABLE options ADD COLUMN IF NOT EXISTS image_url VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE options ADD COLUMN IF NOT EXISTS link_url VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE options ADD COLUMN IF NOT EXISTS position INT NOT NULL DEFAULT 0;
+ALTER TABLE polls ADD COLUMN IF NOT EXISTS option_order VARCHAR NOT NULL DEFAULT 'fixed';
+
+UPDATE options o SET position = ranked.position
+FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY poll_id ORDER BY id) - 1 AS position FROM options) ranked
+WHERE o.id = ranked.id;
@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS email_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    dedupe_key VARCHAR UNIQUE,
+    recipient VARCHAR NOT NULL,
+    subject VARCHAR NOT NULL,
+    body TEXT NOT NULL,
+    status VARCHAR NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS email_outbox_pending_idx ON email_outbox (next_attempt_at) WHERE status = 'pending';
+
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id VARCHAR PRIMARY KEY,
+    poll_opened BOOLEAN NOT NULL DEFAULT TRUE,
+    poll_reminder BOOLEAN NOT NULL DEFAULT TRUE,
+    poll_closed BOOLEAN NOT NULL DEFAULT TRUE,
+    admin_granted BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+ALTER TABLE polls ADD COLUMN IF NOT EXISTS opened_notified_at TIMESTAMP;
+ALTER TABLE polls ADD COLUMN IF NOT EXISTS reminder_sent_at TIMESTAMP;
+ALTER TABLE polls ADD COLUMN IF NOT EXISTS closed_notified_at TIMESTAMP;
+
+-- Polls that already opened or closed before notifications existed must not
+-- trigger a burst of emails on the first run.
+UPDATE polls SET opened_notified_at = NOW() WHERE start_date <= NOW();
+UPDATE polls SET reminder_sent_at = NOW(), closed_notified_at = NOW() WHERE end_date < NOW();
@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+    id BIGSERIAL PRIMARY KEY,
+    url VARCHAR NOT NULL,
+    secret VARCHAR NOT NULL,
+    events TEXT[] NOT NULL,
+    poll_id BIGINT,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by VARCHAR NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    webhook_id BIGINT NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event VARCHAR NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    response_code INT,
+    last_error TEXT,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    redelivery_of BIGINT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    delivered_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, id DESC);
+
+ALTER TABLE polls ADD COLUMN IF NOT EXISTS opened_event_at TIMESTAMP;
+ALTER TABLE polls ADD COLUMN IF NOT EXISTS closed_event_at TIMESTAMP;
+
+UPDATE polls SET opened_event_at = NOW() WHERE start_date <= NOW();
+UPDATE polls SET closed_event_at = NOW() WHERE end_date < NOW();
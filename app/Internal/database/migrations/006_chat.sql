@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS chat_link_codes (
+    code VARCHAR PRIMARY KEY,
+    user_id VARCHAR NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS chat_link_codes_user_idx ON chat_link_codes (user_id);
+
+CREATE TABLE IF NOT EXISTS chat_links (
+    team_id VARCHAR NOT NULL,
+    chat_user_id VARCHAR NOT NULL,
+    user_id VARCHAR NOT NULL,
+    linked_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (team_id, chat_user_id)
+);
+CREATE INDEX IF NOT EXISTS chat_links_user_idx ON chat_links (user_id);
+
+CREATE TABLE IF NOT EXISTS chat_polls (
+    poll_id BIGINT PRIMARY KEY,
+    team_id VARCHAR NOT NULL,
+    channel_id VARCHAR NOT NULL,
+    response_url TEXT,
+    results_posted_at TIMESTAMP
+);
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS poll_templates (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR NOT NULL,
+    owner_id VARCHAR NOT NULL,
+    shared BOOLEAN NOT NULL DEFAULT FALSE,
+    title VARCHAR NOT NULL,
+    question_type VARCHAR NOT NULL,
+    options JSONB NOT NULL DEFAULT '[]',
+    option_order VARCHAR NOT NULL DEFAULT 'fixed',
+    is_anonymous BOOLEAN NOT NULL DEFAULT FALSE,
+    duration_minutes INT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS poll_templates_owner_idx ON poll_templates (owner_id);
+CREATE INDEX IF NOT EXISTS poll_templates_shared_idx ON poll_templates (shared) WHERE shared;
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS poll_series (
+    id BIGSERIAL PRIMARY KEY,
+    owner_id VARCHAR NOT NULL,
+    title VARCHAR NOT NULL,
+    question_type VARCHAR NOT NULL,
+    options JSONB NOT NULL DEFAULT '[]',
+    option_order VARCHAR NOT NULL DEFAULT 'fixed',
+    is_anonymous BOOLEAN NOT NULL DEFAULT FALSE,
+    frequency VARCHAR NOT NULL,
+    repeat_interval INT NOT NULL DEFAULT 1,
+    weekdays INT[] NOT NULL DEFAULT '{}',
+    until TIMESTAMP,
+    count INT,
+    start_date TIMESTAMP NOT NULL,
+    duration_minutes INT NOT NULL,
+    next_run_at TIMESTAMP,
+    occurrences INT NOT NULL DEFAULT 0,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS poll_series_due_idx ON poll_series (next_run_at) WHERE active;
+CREATE INDEX IF NOT EXISTS poll_series_owner_idx ON poll_series (owner_id);
+
+ALTER TABLE polls ADD COLUMN IF NOT EXISTS series_id BIGINT;
+CREATE INDEX IF NOT EXISTS polls_series_idx ON polls (series_id, start_date) WHERE series_id IS NOT NULL;
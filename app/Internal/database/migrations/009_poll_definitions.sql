@@ -0,0 +1,9 @@
+ALTER TABLE polls ADD COLUMN IF NOT EXISTS external_key VARCHAR;
+CREATE UNIQUE INDEX IF NOT EXISTS polls_external_key_idx ON polls (external_key) WHERE external_key IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS poll_eligibility (
+    poll_id BIGINT NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+    kind VARCHAR NOT NULL,
+    value VARCHAR NOT NULL,
+    PRIMARY KEY (poll_id, kind, value)
+);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS answer_tags (
+    id BIGSERIAL PRIMARY KEY,
+    poll_id BIGINT NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+    name VARCHAR NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    UNIQUE (poll_id, name)
+);
+
+-- answer holds the normalized text answer, so a tag covers every spelling.
+CREATE TABLE IF NOT EXISTS answer_tag_assignments (
+    tag_id BIGINT NOT NULL REFERENCES answer_tags(id) ON DELETE CASCADE,
+    answer VARCHAR NOT NULL,
+    PRIMARY KEY (tag_id, answer)
+);
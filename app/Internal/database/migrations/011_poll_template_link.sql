@@ -0,0 +1,2 @@
+ALTER TABLE polls ADD COLUMN IF NOT EXISTS template_id BIGINT REFERENCES poll_templates(id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS polls_template_idx ON polls (template_id, start_date) WHERE template_id IS NOT NULL;
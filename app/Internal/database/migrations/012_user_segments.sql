@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS department VARCHAR;
+
+-- Users who signed up before this column existed keep a NULL signup date
+-- rather than the date of the migration.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMP;
+ALTER TABLE users ALTER COLUMN created_at SET DEFAULT NOW();
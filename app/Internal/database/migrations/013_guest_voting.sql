@@ -0,0 +1,42 @@
+-- A poll with a public_slug can be voted on without an account through
+-- /p/<slug>. guest_access is 'link' (anyone with the link) or 'invite'
+-- (only holders of a one-time invite token).
+ALTER TABLE polls ADD COLUMN IF NOT EXISTS public_slug VARCHAR;
+ALTER TABLE polls ADD COLUMN IF NOT EXISTS guest_access VARCHAR NOT NULL DEFAULT 'link';
+ALTER TABLE polls ADD COLUMN IF NOT EXISTS guest_email_verification BOOLEAN NOT NULL DEFAULT FALSE;
+CREATE UNIQUE INDEX IF NOT EXISTS polls_public_slug_idx ON polls (public_slug) WHERE public_slug IS NOT NULL;
+
+-- Only a SHA-256 hash of each invite token is stored.
+CREATE TABLE IF NOT EXISTS guest_invites (
+    id BIGSERIAL PRIMARY KEY,
+    poll_id BIGINT NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+    token_hash VARCHAR NOT NULL UNIQUE,
+    label VARCHAR NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    used_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS guest_invites_poll_idx ON guest_invites (poll_id);
+
+CREATE TABLE IF NOT EXISTS guest_email_codes (
+    poll_id BIGINT NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+    email VARCHAR NOT NULL,
+    code_hash VARCHAR NOT NULL,
+    sent_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (poll_id, email)
+);
+
+-- Every identity a guest voted under: their cookie, verified email and
+-- invite. A guest whose identities are already claimed has voted.
+CREATE TABLE IF NOT EXISTS guest_ballots (
+    poll_id BIGINT NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+    guest_key VARCHAR NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (poll_id, guest_key)
+);
+
+-- Guests have no users row, so their votes carry the guest_ballots key
+-- they voted under instead of a user_id or voted_by.
+ALTER TABLE votes ADD COLUMN IF NOT EXISTS guest_key VARCHAR;
+CREATE INDEX IF NOT EXISTS votes_poll_id_guest_key_idx ON votes (poll_id, guest_key) WHERE guest_key IS NOT NULL;
@@ -0,0 +1,18 @@
+-- An invite is unsent until emailed (sent_at), and used or revoked at most
+-- once. A reissued invite replaces a revoked one for the same invitee.
+ALTER TABLE guest_invites ADD COLUMN IF NOT EXISTS email VARCHAR NOT NULL DEFAULT '';
+ALTER TABLE guest_invites ADD COLUMN IF NOT EXISTS sent_at TIMESTAMP;
+ALTER TABLE guest_invites ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP;
+ALTER TABLE guest_invites ADD COLUMN IF NOT EXISTS reissued_from BIGINT REFERENCES guest_invites(id) ON DELETE SET NULL;
+
+-- actor_id is NULL for actions taken by the invitee, such as voting.
+CREATE TABLE IF NOT EXISTS guest_invite_audit (
+    id BIGSERIAL PRIMARY KEY,
+    poll_id BIGINT NOT NULL REFERENCES polls(id) ON DELETE CASCADE,
+    invite_id BIGINT NOT NULL REFERENCES guest_invites(id) ON DELETE CASCADE,
+    action VARCHAR NOT NULL,
+    actor_id VARCHAR,
+    detail TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS guest_invite_audit_poll_idx ON guest_invite_audit (poll_id, created_at);
@@ -0,0 +1,3 @@
+-- Live results of a poll can be embedded on other sites through its public
+-- link once its creator allows it.
+ALTER TABLE polls ADD COLUMN IF NOT EXISTS embed_results BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,49 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+
+	"fakidoosuurdoris/app/Internal/services"
+)
+
+// WriteCrosstabCSV writes a crosstab as a matrix with a count and a percent
+// column per segment. Suppressed values are written as "<n", n being the
+// minimum cell size.
+func WriteCrosstabCSV(w io.Writer, tab *services.Crosstab) error {
+	cw := csv.NewWriter(w)
+	suppressed := "<" + strconv.Itoa(tab.MinCellSize)
+
+	cw.Write([]string{"Poll ID", "Title", "Question Type", "Dimension", "Minimum Cell Size"})
+	cw.Write([]string{strconv.FormatInt(tab.PollID, 10), tab.Title, tab.QuestionType, tab.Dimension, strconv.Itoa(tab.MinCellSize)})
+	cw.Write([]string{})
+
+	header := []string{resultLabel(tab.QuestionType)}
+	voters := []string{"Voters"}
+	for _, segment := range tab.Segments {
+		header = append(header, segment.Label, segment.Label+" %")
+		if segment.Voters != nil {
+			voters = append(voters, strconv.Itoa(*segment.Voters), "")
+		} else {
+			voters = append(voters, suppressed, "")
+		}
+	}
+	cw.Write(header)
+	cw.Write(voters)
+
+	for _, row := range tab.Rows {
+		record := []string{row.Label}
+		for _, cell := range row.Cells {
+			if cell.Count == nil {
+				record = append(record, suppressed, "")
+				continue
+			}
+			record = append(record, strconv.Itoa(*cell.Count), strconv.FormatFloat(*cell.Percent, 'f', 1, 64))
+		}
+		cw.Write(record)
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,186 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"fakidoosuurdoris/app/Internal/services"
+)
+
+func init() {
+	Register("csv", csvExporter{})
+}
+
+type csvExporter struct{}
+
+func (csvExporter) ContentType() string { return "text/csv; charset=utf-8" }
+func (csvExporter) Extension() string   { return "csv" }
+
+func (csvExporter) WriteResults(w io.Writer, results *services.PollResults) error {
+	cw := csv.NewWriter(w)
+
+	cw.Write([]string{"Poll ID", "Title", "Question Type", "Is Anonymous", "Voters"})
+	cw.Write([]string{strconv.FormatInt(results.PollID, 10), results.Title, results.QuestionType,
+		strconv.FormatBool(results.IsAnonymous), strconv.Itoa(results.VoterCount)})
+
+	cw.Write([]string{})
+	cw.Write([]string{"Voters"})
+	cw.Write([]string{"User ID", "Email"})
+	for _, voter := range results.Voters {
+		cw.Write([]string{voter.UserID, voter.Email})
+	}
+
+	cw.Write([]string{})
+	cw.Write([]string{"Results"})
+	if results.Text == nil {
+		cw.Write([]string{resultLabel(results.QuestionType), "Count", "Percent"})
+		for _, row := range results.Rows {
+			cw.Write([]string{row.Label, strconv.Itoa(row.Count), strconv.FormatFloat(row.Percent, 'f', 1, 64)})
+		}
+	} else {
+		cw.Write([]string{resultLabel(results.QuestionType), "Count", "Percent", "Variants", "Tags"})
+		for _, row := range results.Rows {
+			cw.Write([]string{row.Label, strconv.Itoa(row.Count), strconv.FormatFloat(row.Percent, 'f', 1, 64),
+				strings.Join(row.Variants, "; "), strings.Join(row.Tags, "; ")})
+		}
+		writeTextAnalytics(cw, results.Text)
+	}
+	if results.Scale != nil {
+		writeScaleStats(cw, results)
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func (csvExporter) WriteBallots(w io.Writer, results *services.PollResults, ballots Ballots) error {
+	cw := csv.NewWriter(w)
+	cw.Write(ballotHeader)
+	err := ballots(func(b services.Ballot) error {
+		return cw.Write(ballotRecord(b))
+	})
+	if err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeTextAnalytics(cw *csv.Writer, text *services.TextAnalytics) {
+	cw.Write([]string{})
+	cw.Write([]string{"Top Words", "Language: " + text.Language})
+	cw.Write([]string{"Word", "Count"})
+	for _, term := range text.Words {
+		cw.Write([]string{term.Term, strconv.Itoa(term.Count)})
+	}
+
+	cw.Write([]string{})
+	cw.Write([]string{"Top Bigrams"})
+	cw.Write([]string{"Bigram", "Count"})
+	for _, term := range text.Bigrams {
+		cw.Write([]string{term.Term, strconv.Itoa(term.Count)})
+	}
+
+	cw.Write([]string{})
+	cw.Write([]string{"Tags"})
+	cw.Write([]string{"Tag", "Answer Groups", "Count", "Percent"})
+	for _, tag := range text.Tags {
+		cw.Write([]string{tag.Name, strconv.Itoa(tag.Groups), strconv.Itoa(tag.Count), strconv.FormatFloat(tag.Percent, 'f', 1, 64)})
+	}
+}
+
+func writeScaleStats(cw *csv.Writer, results *services.PollResults) {
+	stats := results.Scale
+	cw.Write([]string{})
+	cw.Write([]string{"Statistics"})
+	cw.Write([]string{"Responses", strconv.Itoa(stats.Count)})
+	cw.Write([]string{"Mean", formatStat(stats.Mean)})
+	cw.Write([]string{"Mean 95% CI", formatStat(stats.MeanLow), formatStat(stats.MeanHigh)})
+	cw.Write([]string{"Median", formatStat(stats.Median)})
+	mode := make([]string, len(stats.Mode))
+	for i, value := range stats.Mode {
+		mode[i] = strconv.Itoa(value)
+	}
+	cw.Write([]string{"Mode", strings.Join(mode, "; ")})
+	cw.Write([]string{"Standard Deviation", formatStat(stats.StdDev)})
+	if net := stats.NetScore; net != nil {
+		cw.Write([]string{"Net Score", formatStat(net.Score)})
+		cw.Write([]string{"Net Score 95% CI", formatStat(net.Low), formatStat(net.High)})
+		cw.Write([]string{"Promoters", strconv.Itoa(net.Promoters)})
+		cw.Write([]string{"Passives", strconv.Itoa(net.Passives)})
+		cw.Write([]string{"Detractors", strconv.Itoa(net.Detractors)})
+	}
+
+	cw.Write([]string{})
+	cw.Write([]string{"Distribution"})
+	cw.Write([]string{"Scale Value", "Count", "Percent", "95% CI Low", "95% CI High"})
+	for _, bucket := range stats.Distribution {
+		cw.Write([]string{strconv.Itoa(bucket.Value), strconv.Itoa(bucket.Count), strconv.FormatFloat(bucket.Percent, 'f', 1, 64),
+			strconv.FormatFloat(bucket.Low, 'f', 1, 64), strconv.FormatFloat(bucket.High, 'f', 1, 64)})
+	}
+
+	if results.Comparison != nil {
+		cw.Write([]string{})
+		cw.Write([]string{"Comparison", "Polls in the same " + results.Comparison.Basis})
+		cw.Write([]string{"Poll ID", "Title", "Start Date", "Responses", "Mean", "Mean 95% CI Low", "Mean 95% CI High", "Median", "Net Score"})
+		for _, point := range results.Comparison.Polls {
+			record := []string{strconv.FormatInt(point.PollID, 10), point.Title, point.StartDate.UTC().Format(time.RFC3339),
+				strconv.Itoa(point.Stats.Count), "", "", "", "", ""}
+			if point.Stats.Count > 0 {
+				record[4], record[5], record[6] = formatStat(point.Stats.Mean), formatStat(point.Stats.MeanLow), formatStat(point.Stats.MeanHigh)
+				record[7] = formatStat(point.Stats.Median)
+				if point.Stats.NetScore != nil {
+					record[8] = formatStat(point.Stats.NetScore.Score)
+				}
+			}
+			cw.Write(record)
+		}
+	}
+}
+
+// formatStat rounds a statistic to two decimals.
+func formatStat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 2, 64)
+}
+
+// resultLabel names the label column of a poll's result rows.
+func resultLabel(questionType string) string {
+	switch questionType {
+	case "text":
+		return "Text Answer"
+	case "scale":
+		return "Scale Value"
+	default:
+		return "Option"
+	}
+}
+
+var ballotHeader = []string{"Ballot", "Voter ID", "Email", "Cast At", "Choices", "Option IDs", "Scale Value", "Text Answer"}
+
+// ballotRecord flattens a ballot into the columns of ballotHeader. Multiple
+// choices are joined with "; ".
+func ballotRecord(b services.Ballot) []string {
+	record := make([]string, len(ballotHeader))
+	record[0] = strconv.Itoa(b.Number)
+	record[1] = b.VoterID
+	record[2] = b.Email
+	if b.CastAt != nil {
+		record[3] = b.CastAt.UTC().Format(time.RFC3339)
+	}
+	record[4] = strings.Join(b.Choices, "; ")
+	ids := make([]string, len(b.OptionIDs))
+	for i, id := range b.OptionIDs {
+		ids[i] = strconv.FormatInt(id, 10)
+	}
+	record[5] = strings.Join(ids, "; ")
+	if b.ScaleValue != nil {
+		record[6] = strconv.FormatInt(*b.ScaleValue, 10)
+	}
+	if b.TextAnswer != nil {
+		record[7] = *b.TextAnswer
+	}
+	return record
+}
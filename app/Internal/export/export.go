@@ -0,0 +1,51 @@
+// Package export writes poll results in the formats offered for download.
+// Each format registers an Exporter under its name; formats that can also
+// list raw ballots implement BallotExporter.
+package export
+
+import (
+	"io"
+	"sort"
+
+	"fakidoosuurdoris/app/Internal/services"
+)
+
+// Exporter writes a poll's aggregated results in one format.
+type Exporter interface {
+	ContentType() string
+	Extension() string
+	WriteResults(w io.Writer, results *services.PollResults) error
+}
+
+// BallotExporter is an Exporter that can also write one record per ballot.
+// Ballots are written as they are read, so the output is never buffered.
+type BallotExporter interface {
+	Exporter
+	WriteBallots(w io.Writer, results *services.PollResults, ballots Ballots) error
+}
+
+// Ballots calls fn for every ballot of a poll, as PollService.StreamBallots
+// does.
+type Ballots func(fn func(services.Ballot) error) error
+
+var registry = make(map[string]Exporter)
+
+// Register makes an exporter available under a format name.
+func Register(format string, e Exporter) {
+	registry[format] = e
+}
+
+func Lookup(format string) (Exporter, bool) {
+	e, ok := registry[format]
+	return e, ok
+}
+
+// Formats returns the registered format names in alphabetical order.
+func Formats() []string {
+	formats := make([]string, 0, len(registry))
+	for format := range registry {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
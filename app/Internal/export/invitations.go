@@ -0,0 +1,36 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+
+	"fakidoosuurdoris/app/Internal/services"
+)
+
+// WriteInvitesCSV writes newly issued invites with their tokens for a mail
+// merge. linkBase is the poll's public link, to which each token is added;
+// the Link column is left empty when it is empty.
+func WriteInvitesCSV(w io.Writer, invites []services.GuestInvite, linkBase string) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Invite ID", "Label", "Email", "Code", "Link"})
+	for _, invite := range invites {
+		link := ""
+		if linkBase != "" {
+			link = linkBase + "?invite=" + invite.Token
+		}
+		cw.Write([]string{strconv.FormatInt(invite.ID, 10), safeCell(invite.Label), safeCell(invite.Email), invite.Token, link})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// safeCell stops spreadsheets from reading s as a formula by prefixing it
+// with an apostrophe when it starts like one.
+func safeCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
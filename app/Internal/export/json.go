@@ -0,0 +1,122 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+
+	"fakidoosuurdoris/app/Internal/services"
+)
+
+func init() {
+	Register("json", jsonExporter{})
+	Register("ndjson", ndjsonExporter{})
+}
+
+type jsonExporter struct{}
+
+func (jsonExporter) ContentType() string { return "application/json" }
+func (jsonExporter) Extension() string   { return "json" }
+
+func (jsonExporter) WriteResults(w io.Writer, results *services.PollResults) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
+
+// WriteBallots writes {"poll_id": ..., "ballots": [...]}, encoding each
+// ballot as it arrives.
+func (jsonExporter) WriteBallots(w io.Writer, results *services.PollResults, ballots Ballots) error {
+	if _, err := io.WriteString(w, `{"poll_id":`); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(results.PollID); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, `,"ballots":[`); err != nil {
+		return err
+	}
+	first := true
+	err := ballots(func(b services.Ballot) error {
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+		return enc.Encode(b)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
+
+// ndjsonExporter writes one JSON object per line, each carrying the poll ID,
+// so large exports can be processed line by line.
+type ndjsonExporter struct{}
+
+func (ndjsonExporter) ContentType() string { return "application/x-ndjson" }
+func (ndjsonExporter) Extension() string   { return "ndjson" }
+
+func (ndjsonExporter) WriteResults(w io.Writer, results *services.PollResults) error {
+	enc := json.NewEncoder(w)
+	for _, row := range results.Rows {
+		err := enc.Encode(struct {
+			PollID int64 `json:"poll_id"`
+			services.ResultRow
+		}{results.PollID, row})
+		if err != nil {
+			return err
+		}
+	}
+	if results.Text == nil {
+		return nil
+	}
+	// Text analytics follow the answer groups, one line per term or tag,
+	// told apart by their "word", "bigram" or "tag" field.
+	for _, term := range results.Text.Words {
+		err := enc.Encode(struct {
+			PollID int64  `json:"poll_id"`
+			Word   string `json:"word"`
+			Count  int    `json:"count"`
+		}{results.PollID, term.Term, term.Count})
+		if err != nil {
+			return err
+		}
+	}
+	for _, term := range results.Text.Bigrams {
+		err := enc.Encode(struct {
+			PollID int64  `json:"poll_id"`
+			Bigram string `json:"bigram"`
+			Count  int    `json:"count"`
+		}{results.PollID, term.Term, term.Count})
+		if err != nil {
+			return err
+		}
+	}
+	for _, tag := range results.Text.Tags {
+		err := enc.Encode(struct {
+			PollID  int64   `json:"poll_id"`
+			Tag     string  `json:"tag"`
+			Groups  int     `json:"groups"`
+			Count   int     `json:"count"`
+			Percent float64 `json:"percent"`
+		}{results.PollID, tag.Name, tag.Groups, tag.Count, tag.Percent})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ndjsonExporter) WriteBallots(w io.Writer, results *services.PollResults, ballots Ballots) error {
+	enc := json.NewEncoder(w)
+	return ballots(func(b services.Ballot) error {
+		return enc.Encode(struct {
+			PollID int64 `json:"poll_id"`
+			services.Ballot
+		}{results.PollID, b})
+	})
+}
@@ -0,0 +1,786 @@
+package export
+
+import (
+	"embed"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"fakidoosuurdoris/app/Internal/services"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+//go:embed fonts/*.ttf
+var fonts embed.FS
+
+func init() {
+	Register("pdf", PDFReport{})
+}
+
+// PDFReport writes results as a report for print: poll metadata, the
+// participation rate, charts of the results and appendices with text answers
+// and voters. A Unicode font is embedded so option text in any script the
+// font covers renders correctly.
+type PDFReport struct {
+	// LogoPath optionally names a PNG or JPEG shown in the page header.
+	LogoPath string
+	// FontPath optionally names a TrueType font used instead of the bundled
+	// DejaVu Sans, for scripts it does not cover.
+	FontPath string
+}
+
+func (PDFReport) ContentType() string { return "application/pdf" }
+func (PDFReport) Extension() string   { return "pdf" }
+
+const (
+	pageMargin   = 15.0
+	headerHeight = 20.0
+	contentWidth = 210 - 2*pageMargin
+	reportFont   = "Report"
+)
+
+var (
+	brandDark  = [3]int{31, 78, 68}
+	brandLight = [3]int{167, 243, 208}
+	textGray   = [3]int{90, 90, 90}
+	gridGray   = [3]int{225, 225, 225}
+	// chartColors cycle through pie slices and bars.
+	chartColors = [][3]int{
+		{31, 78, 68}, {52, 211, 153}, {59, 130, 246}, {245, 158, 11},
+		{239, 68, 68}, {139, 92, 246}, {236, 72, 153}, {20, 184, 166},
+	}
+)
+
+type report struct {
+	pdf     *gofpdf.Fpdf
+	results *services.PollResults
+	logo    string
+}
+
+func (r PDFReport) WriteResults(w io.Writer, results *services.PollResults) error {
+	results = pdfSafe(results)
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	if err := r.loadFonts(pdf); err != nil {
+		return err
+	}
+	pdf.SetMargins(pageMargin, headerHeight+10, pageMargin)
+	pdf.SetAutoPageBreak(true, 18)
+	pdf.AliasNbPages("")
+	pdf.SetTitle("Poll results: "+results.Title, true)
+	pdf.SetCreator("VoteEasy", true)
+
+	rep := &report{pdf: pdf, results: results}
+	if r.LogoPath != "" {
+		pdf.RegisterImageOptions(r.LogoPath, gofpdf.ImageOptions{ReadDpi: true})
+		if pdf.Ok() {
+			rep.logo = r.LogoPath
+		} else {
+			slog.Warn("Report logo could not be loaded", "path", r.LogoPath, "error", pdf.Error())
+			pdf.ClearError()
+		}
+	}
+	generated := time.Now().UTC().Format("2006-01-02 15:04 UTC")
+	pdf.SetHeaderFunc(rep.header)
+	pdf.SetFooterFunc(func() { rep.footer(generated) })
+
+	pdf.AddPage()
+	rep.metadata()
+	rep.participation()
+	switch results.QuestionType {
+	case "scale":
+		rep.scale()
+	case "text":
+		rep.textSummary()
+	default:
+		rep.choices()
+	}
+	if results.QuestionType == "text" && len(results.Rows) > 0 {
+		rep.textAppendix()
+	}
+	if !results.IsAnonymous && len(results.Voters) > 0 {
+		rep.voterAppendix()
+	}
+	return pdf.Output(w)
+}
+
+func (r PDFReport) loadFonts(pdf *gofpdf.Fpdf) error {
+	if r.FontPath != "" {
+		data, err := os.ReadFile(r.FontPath)
+		if err != nil {
+			return err
+		}
+		pdf.AddUTF8FontFromBytes(reportFont, "", data)
+		pdf.AddUTF8FontFromBytes(reportFont, "B", data)
+		return pdf.Error()
+	}
+	for style, name := range map[string]string{"": "DejaVuSansCondensed.ttf", "B": "DejaVuSansCondensed-Bold.ttf"} {
+		data, err := fonts.ReadFile("fonts/" + name)
+		if err != nil {
+			return err
+		}
+		pdf.AddUTF8FontFromBytes(reportFont, style, data)
+	}
+	return pdf.Error()
+}
+
+func (rep *report) color(c [3]int) {
+	rep.pdf.SetFillColor(c[0], c[1], c[2])
+}
+
+func (rep *report) textColor(c [3]int) {
+	rep.pdf.SetTextColor(c[0], c[1], c[2])
+}
+
+// ensureSpace starts a new page unless h millimetres are left on this one.
+// Drawing primitives do not trigger automatic page breaks.
+func (rep *report) ensureSpace(h float64) {
+	_, pageHeight := rep.pdf.GetPageSize()
+	_, _, _, bottom := rep.pdf.GetMargins()
+	if rep.pdf.GetY()+h > pageHeight-bottom {
+		rep.pdf.AddPage()
+	}
+}
+
+// pdfSafe returns a copy of results whose text only uses characters of the
+// Basic Multilingual Plane, the range gofpdf can lay out; others, such as
+// emoji, become U+FFFD.
+func pdfSafe(results *services.PollResults) *services.PollResults {
+	clean := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r > 0xFFFF {
+				return '\uFFFD'
+			}
+			return r
+		}, s)
+	}
+	cleanAll := func(list []string) []string {
+		out := make([]string, len(list))
+		for i, s := range list {
+			out[i] = clean(s)
+		}
+		return out
+	}
+
+	safe := *results
+	safe.Title = clean(results.Title)
+	safe.Voters = make([]services.Voter, len(results.Voters))
+	for i, voter := range results.Voters {
+		safe.Voters[i] = services.Voter{UserID: clean(voter.UserID), Email: clean(voter.Email)}
+	}
+	safe.Rows = make([]services.ResultRow, len(results.Rows))
+	for i, row := range results.Rows {
+		row.Label = clean(row.Label)
+		row.Variants = cleanAll(row.Variants)
+		row.Tags = cleanAll(row.Tags)
+		safe.Rows[i] = row
+	}
+	if results.Text != nil {
+		text := *results.Text
+		text.Words = append([]services.TermCount(nil), text.Words...)
+		for i := range text.Words {
+			text.Words[i].Term = clean(text.Words[i].Term)
+		}
+		text.Bigrams = append([]services.TermCount(nil), text.Bigrams...)
+		for i := range text.Bigrams {
+			text.Bigrams[i].Term = clean(text.Bigrams[i].Term)
+		}
+		text.Tags = append([]services.TagCount(nil), text.Tags...)
+		for i := range text.Tags {
+			text.Tags[i].Name = clean(text.Tags[i].Name)
+		}
+		safe.Text = &text
+	}
+	if results.Comparison != nil {
+		comparison := *results.Comparison
+		comparison.Polls = append([]services.ScalePoint(nil), comparison.Polls...)
+		for i := range comparison.Polls {
+			comparison.Polls[i].Title = clean(comparison.Polls[i].Title)
+		}
+		safe.Comparison = &comparison
+	}
+	return &safe
+}
+
+// fit shortens s with an ellipsis so it fits in width w at the current font.
+func (rep *report) fit(s string, w float64) string {
+	if rep.pdf.GetStringWidth(s) <= w {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && rep.pdf.GetStringWidth(string(runes)+"…") > w {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
+
+func (rep *report) header() {
+	pdf := rep.pdf
+	rep.color(brandDark)
+	pdf.Rect(0, 0, 210, headerHeight, "F")
+
+	x := pageMargin
+	if rep.logo != "" {
+		pdf.ImageOptions(rep.logo, x, 4, 0, headerHeight-8, false, gofpdf.ImageOptions{ReadDpi: true}, 0, "")
+		x += 40
+	} else {
+		// A simple ballot mark: a light square with a check.
+		rep.color(brandLight)
+		pdf.RoundedRect(x, 5, 10, 10, 2, "1234", "F")
+		pdf.SetDrawColor(brandDark[0], brandDark[1], brandDark[2])
+		pdf.SetLineWidth(1.2)
+		pdf.Line(x+2.5, 10, x+4.5, 12.5)
+		pdf.Line(x+4.5, 12.5, x+8, 7)
+		pdf.SetLineWidth(0.2)
+		x += 13
+	}
+
+	pdf.SetFont(reportFont, "B", 16)
+	pdf.SetTextColor(255, 255, 255)
+	pdf.SetXY(x, 5)
+	pdf.CellFormat(60, 10, "VoteEasy", "", 0, "LM", false, 0, "")
+	pdf.SetFont(reportFont, "", 11)
+	pdf.SetXY(pageMargin, 5)
+	pdf.CellFormat(contentWidth, 10, "Poll Results Report", "", 0, "RM", false, 0, "")
+	pdf.SetXY(pageMargin, headerHeight+10)
+	rep.textColor([3]int{0, 0, 0})
+}
+
+func (rep *report) footer(generated string) {
+	pdf := rep.pdf
+	pdf.SetY(-12)
+	pdf.SetFont(reportFont, "", 8)
+	rep.textColor(textGray)
+	pdf.CellFormat(contentWidth/2, 6, "Generated "+generated, "T", 0, "L", false, 0, "")
+	pdf.CellFormat(contentWidth/2, 6, fmt.Sprintf("Page %d of {nb}", pdf.PageNo()), "T", 0, "R", false, 0, "")
+}
+
+func (rep *report) heading(text string) {
+	pdf := rep.pdf
+	rep.ensureSpace(16)
+	pdf.Ln(4)
+	pdf.SetFont(reportFont, "B", 13)
+	rep.textColor(brandDark)
+	pdf.CellFormat(contentWidth, 8, text, "B", 1, "L", false, 0, "")
+	pdf.Ln(2)
+	pdf.SetFont(reportFont, "", 10)
+	rep.textColor([3]int{0, 0, 0})
+}
+
+func (rep *report) metadata() {
+	pdf, res := rep.pdf, rep.results
+	pdf.SetFont(reportFont, "B", 18)
+	pdf.MultiCell(contentWidth, 8, res.Title, "", "L", false)
+	pdf.Ln(2)
+
+	status := "Open"
+	now := time.Now()
+	if res.StartDate.After(now) {
+		status = "Not started"
+	} else if res.EndDate != nil && res.EndDate.Before(now) {
+		status = "Closed"
+	}
+	closes := "No end date"
+	if res.EndDate != nil {
+		closes = res.EndDate.Format("2006-01-02 15:04")
+	}
+	anonymous := "No"
+	if res.IsAnonymous {
+		anonymous = "Yes"
+	}
+
+	rows := [][2]string{
+		{"Poll ID", strconv.FormatInt(res.PollID, 10)},
+		{"Question type", questionTypeName(res.QuestionType)},
+		{"Anonymous", anonymous},
+		{"Opens", res.StartDate.Format("2006-01-02 15:04")},
+		{"Closes", closes},
+		{"Status", status},
+	}
+	for i, row := range rows {
+		fill := i%2 == 0
+		rep.color([3]int{243, 250, 247})
+		pdf.SetFont(reportFont, "B", 10)
+		pdf.CellFormat(45, 7, row[0], "", 0, "L", fill, 0, "")
+		pdf.SetFont(reportFont, "", 10)
+		pdf.CellFormat(contentWidth-45, 7, row[1], "", 1, "L", fill, 0, "")
+	}
+}
+
+func questionTypeName(questionType string) string {
+	switch questionType {
+	case "single_choice":
+		return "Single choice"
+	case "multiple_choice":
+		return "Multiple choice"
+	case "scale":
+		return fmt.Sprintf("Scale (1–%d)", services.ScaleMax)
+	case "text":
+		return "Text answer"
+	}
+	return questionType
+}
+
+func (rep *report) participation() {
+	pdf, res := rep.pdf, rep.results
+	rep.heading("Participation")
+
+	rate := 0.0
+	if res.EligibleCount > 0 {
+		rate = math.Min(float64(res.VoterCount)*100/float64(res.EligibleCount), 100)
+	}
+	pdf.CellFormat(contentWidth, 6, fmt.Sprintf("%d of %d eligible users voted (%.1f%%).", res.VoterCount, res.EligibleCount, rate), "", 1, "L", false, 0, "")
+	pdf.Ln(1)
+
+	y := pdf.GetY()
+	rep.color(gridGray)
+	pdf.RoundedRect(pageMargin, y, contentWidth, 5, 2.5, "1234", "F")
+	if rate > 0 {
+		rep.color(brandDark)
+		pdf.RoundedRect(pageMargin, y, math.Max(contentWidth*rate/100, 5), 5, 2.5, "1234", "F")
+	}
+	pdf.SetY(y + 8)
+}
+
+// choices draws a bar chart of every option, a pie chart for single choice
+// polls, whose shares add up to 100%, and a table of the exact figures.
+func (rep *report) choices() {
+	pdf, res := rep.pdf, rep.results
+	rep.heading("Results")
+	if len(res.Rows) == 0 {
+		pdf.CellFormat(contentWidth, 6, "This poll has no options.", "", 1, "L", false, 0, "")
+		return
+	}
+
+	const labelWidth, valueWidth, rowHeight = 55.0, 28.0, 8.0
+	barWidth := contentWidth - labelWidth - valueWidth
+	for i, row := range res.Rows {
+		rep.ensureSpace(rowHeight)
+		y := pdf.GetY()
+		pdf.SetFont(reportFont, "", 9)
+		pdf.CellFormat(labelWidth, rowHeight, rep.fit(row.Label, labelWidth-2), "", 0, "L", false, 0, "")
+		rep.color(gridGray)
+		pdf.Rect(pageMargin+labelWidth, y+1.5, barWidth, rowHeight-3, "F")
+		if row.Percent > 0 {
+			rep.color(chartColors[i%len(chartColors)])
+			pdf.Rect(pageMargin+labelWidth, y+1.5, barWidth*math.Min(row.Percent, 100)/100, rowHeight-3, "F")
+		}
+		pdf.SetX(pageMargin + labelWidth + barWidth)
+		pdf.CellFormat(valueWidth, rowHeight, fmt.Sprintf("%d (%.1f%%)", row.Count, row.Percent), "", 1, "R", false, 0, "")
+	}
+	if res.QuestionType == "multiple_choice" {
+		pdf.SetFont(reportFont, "", 8)
+		rep.textColor(textGray)
+		pdf.CellFormat(contentWidth, 5, "Voters could pick several options, so percentages add up to more than 100%.", "", 1, "L", false, 0, "")
+		rep.textColor([3]int{0, 0, 0})
+	}
+
+	if res.QuestionType == "single_choice" && res.VoterCount > 0 {
+		rep.pie()
+	}
+	rep.resultTable()
+}
+
+func (rep *report) pie() {
+	pdf, res := rep.pdf, rep.results
+	const radius = 30.0
+	rep.ensureSpace(2*radius + 12)
+	pdf.Ln(4)
+	cx, cy := pageMargin+radius+5, pdf.GetY()+radius
+
+	total := 0
+	for _, row := range res.Rows {
+		total += row.Count
+	}
+	start := -90.0
+	for i, row := range res.Rows {
+		if row.Count == 0 {
+			continue
+		}
+		sweep := 360 * float64(row.Count) / float64(total)
+		points := []gofpdf.PointType{{X: cx, Y: cy}}
+		for a := start; a < start+sweep; a += 2 {
+			points = append(points, polar(cx, cy, radius, a))
+		}
+		points = append(points, polar(cx, cy, radius, start+sweep))
+		rep.color(chartColors[i%len(chartColors)])
+		pdf.Polygon(points, "F")
+		start += sweep
+	}
+
+	// Legend to the right of the pie.
+	x := cx + radius + 12
+	y := cy - radius
+	pdf.SetFont(reportFont, "", 9)
+	for i, row := range res.Rows {
+		if y > cy+radius-5 {
+			break
+		}
+		rep.color(chartColors[i%len(chartColors)])
+		pdf.Rect(x, y+1, 4, 4, "F")
+		pdf.SetXY(x+6, y)
+		pdf.CellFormat(contentWidth-(x+6-pageMargin), 6, rep.fit(fmt.Sprintf("%s — %.1f%%", row.Label, row.Percent), contentWidth-(x+6-pageMargin)), "", 0, "L", false, 0, "")
+		y += 6
+	}
+	pdf.SetXY(pageMargin, cy+radius+6)
+}
+
+func polar(cx, cy, r, degrees float64) gofpdf.PointType {
+	rad := degrees * math.Pi / 180
+	return gofpdf.PointType{X: cx + r*math.Cos(rad), Y: cy + r*math.Sin(rad)}
+}
+
+// resultTable lists every row with its full label, wrapping long labels.
+func (rep *report) resultTable() {
+	pdf, res := rep.pdf, rep.results
+	const countWidth, percentWidth, lineHeight = 25.0, 25.0, 6.0
+	labelWidth := contentWidth - countWidth - percentWidth
+
+	rep.ensureSpace(2 * lineHeight)
+	pdf.Ln(2)
+	pdf.SetFont(reportFont, "B", 10)
+	rep.color(brandLight)
+	pdf.CellFormat(labelWidth, lineHeight+1, resultLabel(res.QuestionType), "", 0, "L", true, 0, "")
+	pdf.CellFormat(countWidth, lineHeight+1, "Votes", "", 0, "R", true, 0, "")
+	pdf.CellFormat(percentWidth, lineHeight+1, "Share", "", 1, "R", true, 0, "")
+
+	pdf.SetFont(reportFont, "", 10)
+	for _, row := range res.Rows {
+		lines := pdf.SplitText(row.Label, labelWidth-2)
+		if len(lines) == 0 {
+			lines = []string{""}
+		}
+		h := lineHeight * float64(len(lines))
+		rep.ensureSpace(h)
+		y := pdf.GetY()
+		pdf.MultiCell(labelWidth, lineHeight, strings.Join(lines, "\n"), "B", "L", false)
+		pdf.SetXY(pageMargin+labelWidth, y)
+		pdf.CellFormat(countWidth, h, strconv.Itoa(row.Count), "B", 0, "R", false, 0, "")
+		pdf.CellFormat(percentWidth, h, fmt.Sprintf("%.1f%%", row.Percent), "B", 1, "R", false, 0, "")
+	}
+}
+
+// scale draws a histogram of the scale values with the mean and median.
+func (rep *report) scale() {
+	pdf, res := rep.pdf, rep.results
+	rep.heading("Results")
+
+	stats := res.Scale
+	if stats == nil || stats.Count == 0 {
+		pdf.CellFormat(contentWidth, 6, "No responses yet.", "", 1, "L", false, 0, "")
+		return
+	}
+	mean := stats.Mean
+	maxCount := 0
+	for _, row := range res.Rows {
+		maxCount = max(maxCount, row.Count)
+	}
+
+	pdf.SetFont(reportFont, "B", 10)
+	pdf.CellFormat(contentWidth, 6, fmt.Sprintf("Responses: %d    Mean: %.2f    Median: %s", stats.Count, mean, formatNumber(stats.Median)), "", 1, "L", false, 0, "")
+	pdf.SetFont(reportFont, "", 9)
+
+	const chartHeight = 55.0
+	rep.ensureSpace(chartHeight + 24)
+	pdf.Ln(8)
+	top := pdf.GetY()
+	base := top + chartHeight
+	slot := contentWidth / float64(len(res.Rows))
+
+	pdf.SetDrawColor(gridGray[0], gridGray[1], gridGray[2])
+	for i := 0; i <= 4; i++ {
+		y := base - chartHeight*float64(i)/4
+		pdf.Line(pageMargin, y, pageMargin+contentWidth, y)
+	}
+	for i, row := range res.Rows {
+		x := pageMargin + slot*float64(i) + slot*0.15
+		h := chartHeight * float64(row.Count) / float64(maxCount)
+		rep.color(brandDark)
+		if h > 0 {
+			pdf.Rect(x, base-h, slot*0.7, h, "F")
+		}
+		pdf.SetXY(x, base-h-6)
+		pdf.CellFormat(slot*0.7, 6, fmt.Sprintf("%d (%.0f%%)", row.Count, row.Percent), "", 0, "C", false, 0, "")
+		pdf.SetXY(x, base+1)
+		pdf.CellFormat(slot*0.7, 6, row.Label, "", 0, "C", false, 0, "")
+	}
+
+	// Mark the mean on the value axis.
+	meanX := pageMargin + slot*(mean-0.5)
+	pdf.SetDrawColor(239, 68, 68)
+	pdf.SetDashPattern([]float64{1.5, 1}, 0)
+	pdf.Line(meanX, top, meanX, base+7)
+	pdf.SetDashPattern([]float64{}, 0)
+	pdf.SetTextColor(239, 68, 68)
+	pdf.SetXY(meanX-15, base+7)
+	pdf.CellFormat(30, 5, fmt.Sprintf("mean %.2f", mean), "", 0, "C", false, 0, "")
+	rep.textColor([3]int{0, 0, 0})
+	pdf.SetDrawColor(0, 0, 0)
+	pdf.SetXY(pageMargin, base+14)
+
+	rep.resultTable()
+	rep.scaleStatistics()
+	if res.Comparison != nil {
+		rep.comparison()
+	}
+}
+
+// scaleStatistics lists the summary statistics of a scale poll with their
+// confidence intervals.
+func (rep *report) scaleStatistics() {
+	pdf, stats := rep.pdf, rep.results.Scale
+	const lineHeight = 6.0
+	mode := make([]string, len(stats.Mode))
+	for i, value := range stats.Mode {
+		mode[i] = strconv.Itoa(value)
+	}
+	lines := [][2]string{
+		{"Responses", strconv.Itoa(stats.Count)},
+		{"Mean", fmt.Sprintf("%.2f  (95%% CI %.2f – %.2f)", stats.Mean, stats.MeanLow, stats.MeanHigh)},
+		{"Median", formatNumber(stats.Median)},
+		{"Mode", strings.Join(mode, ", ")},
+		{"Standard deviation", fmt.Sprintf("%.2f", stats.StdDev)},
+	}
+	if net := stats.NetScore; net != nil {
+		lines = append(lines,
+			[2]string{"Net score", fmt.Sprintf("%+.0f  (95%% CI %+.0f – %+.0f)", net.Score, net.Low, net.High)},
+			[2]string{"Promoters / passives / detractors", fmt.Sprintf("%d / %d / %d", net.Promoters, net.Passives, net.Detractors)})
+	}
+
+	rep.ensureSpace(lineHeight * float64(len(lines)+2))
+	pdf.Ln(4)
+	pdf.SetFont(reportFont, "B", 10)
+	rep.color(brandLight)
+	pdf.CellFormat(contentWidth, lineHeight+1, "Statistics", "", 1, "L", true, 0, "")
+	for _, line := range lines {
+		pdf.SetFont(reportFont, "", 10)
+		pdf.CellFormat(70, lineHeight, line[0], "B", 0, "L", false, 0, "")
+		pdf.SetFont(reportFont, "B", 10)
+		pdf.CellFormat(contentWidth-70, lineHeight, line[1], "B", 1, "L", false, 0, "")
+	}
+	pdf.SetFont(reportFont, "", 8)
+	rep.textColor(textGray)
+	note := "Shares of each value with 95% Wilson intervals: "
+	for i, bucket := range stats.Distribution {
+		if i > 0 {
+			note += ", "
+		}
+		note += fmt.Sprintf("%d: %.0f%% (%.0f–%.0f%%)", bucket.Value, bucket.Percent, bucket.Low, bucket.High)
+	}
+	if stats.NetScore != nil {
+		note += fmt.Sprintf(". Net score: share of %ds minus share of 1–%ds.", services.ScaleMax, services.ScaleMax-2)
+	}
+	pdf.MultiCell(contentWidth, 4.5, note, "", "L", false)
+	rep.textColor([3]int{0, 0, 0})
+}
+
+// comparison charts the mean of every poll in the same series or from the
+// same template with its confidence interval, oldest first.
+func (rep *report) comparison() {
+	pdf, cmp := rep.pdf, rep.results.Comparison
+	rep.ensureSpace(110)
+	rep.heading("Comparison with polls of the same " + cmp.Basis)
+
+	const chartHeight = 50.0
+	pdf.Ln(2)
+	top := pdf.GetY()
+	base := top + chartHeight
+	axis := 10.0
+	slot := (contentWidth - axis) / float64(len(cmp.Polls))
+	y := func(v float64) float64 { return base - chartHeight*(v-1)/float64(services.ScaleMax-1) }
+
+	pdf.SetFont(reportFont, "", 8)
+	pdf.SetDrawColor(gridGray[0], gridGray[1], gridGray[2])
+	for v := 1; v <= services.ScaleMax; v++ {
+		pdf.Line(pageMargin+axis, y(float64(v)), pageMargin+contentWidth, y(float64(v)))
+		pdf.SetXY(pageMargin, y(float64(v))-2.5)
+		pdf.CellFormat(axis-2, 5, strconv.Itoa(v), "", 0, "R", false, 0, "")
+	}
+
+	var prev *gofpdf.PointType
+	for i, point := range cmp.Polls {
+		x := pageMargin + axis + slot*(float64(i)+0.5)
+		if point.Stats.Count > 0 {
+			pdf.SetDrawColor(brandDark[0], brandDark[1], brandDark[2])
+			pdf.SetLineWidth(0.3)
+			pdf.Line(x, y(math.Max(point.Stats.MeanLow, 1)), x, y(math.Min(point.Stats.MeanHigh, services.ScaleMax)))
+			current := gofpdf.PointType{X: x, Y: y(point.Stats.Mean)}
+			if prev != nil {
+				pdf.SetLineWidth(0.5)
+				pdf.Line(prev.X, prev.Y, current.X, current.Y)
+			}
+			pdf.SetLineWidth(0.2)
+			rep.color(brandDark)
+			if point.Current {
+				rep.color([3]int{239, 68, 68})
+			}
+			pdf.Circle(current.X, current.Y, 1.3, "F")
+			prev = &current
+		}
+		pdf.SetXY(x-slot/2, base+1)
+		label := point.StartDate.UTC().Format("Jan 2")
+		if slot < 12 {
+			label = point.StartDate.UTC().Format("1/2")
+		}
+		pdf.CellFormat(slot, 5, label, "", 0, "C", false, 0, "")
+	}
+	pdf.SetDrawColor(0, 0, 0)
+	pdf.SetXY(pageMargin, base+8)
+
+	pdf.SetFont(reportFont, "B", 9)
+	rep.color(brandLight)
+	pdf.CellFormat(25, 6, "Start", "", 0, "L", true, 0, "")
+	pdf.CellFormat(contentWidth-25-100, 6, "Poll", "", 0, "L", true, 0, "")
+	pdf.CellFormat(20, 6, "Responses", "", 0, "R", true, 0, "")
+	pdf.CellFormat(40, 6, "Mean (95% CI)", "", 0, "R", true, 0, "")
+	pdf.CellFormat(20, 6, "Median", "", 0, "R", true, 0, "")
+	pdf.CellFormat(20, 6, "Net score", "", 1, "R", true, 0, "")
+	for _, point := range cmp.Polls {
+		rep.ensureSpace(6)
+		style := ""
+		if point.Current {
+			style = "B"
+		}
+		pdf.SetFont(reportFont, style, 9)
+		net := "—"
+		if point.Stats.NetScore != nil && point.Stats.Count > 0 {
+			net = fmt.Sprintf("%+.0f", point.Stats.NetScore.Score)
+		}
+		mean := "—"
+		if point.Stats.Count > 0 {
+			mean = fmt.Sprintf("%.2f (%.2f–%.2f)", point.Stats.Mean, point.Stats.MeanLow, point.Stats.MeanHigh)
+		}
+		pdf.CellFormat(25, 6, point.StartDate.UTC().Format("2006-01-02"), "B", 0, "L", false, 0, "")
+		pdf.CellFormat(contentWidth-25-100, 6, rep.fit(point.Title, contentWidth-25-102), "B", 0, "L", false, 0, "")
+		pdf.CellFormat(20, 6, strconv.Itoa(point.Stats.Count), "B", 0, "R", false, 0, "")
+		pdf.CellFormat(40, 6, mean, "B", 0, "R", false, 0, "")
+		pdf.CellFormat(20, 6, formatNumber(point.Stats.Median), "B", 0, "R", false, 0, "")
+		pdf.CellFormat(20, 6, net, "B", 1, "R", false, 0, "")
+	}
+}
+
+func formatNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// textSummary lists the most used words and bigrams side by side and how
+// often each answer tag was given.
+func (rep *report) textSummary() {
+	pdf, res := rep.pdf, rep.results
+	rep.heading("Results")
+	text := res.Text
+	if text == nil {
+		text = &services.TextAnalytics{}
+	}
+	pdf.CellFormat(contentWidth, 6, fmt.Sprintf("%d answers, %d distinct, %d after grouping similar answers. All groups are listed in the appendix.",
+		text.Answers, text.Distinct, len(res.Rows)), "", 1, "L", false, 0, "")
+
+	const lineHeight, shown = 6.0, 15
+	rows := min(max(len(text.Words), len(text.Bigrams)), shown)
+	if rows > 0 {
+		half := (contentWidth - 6) / 2
+		rep.ensureSpace(lineHeight * float64(rows+2))
+		pdf.Ln(3)
+		pdf.SetFont(reportFont, "B", 10)
+		rep.color(brandLight)
+		pdf.CellFormat(half-20, lineHeight+1, "Top words", "", 0, "L", true, 0, "")
+		pdf.CellFormat(20, lineHeight+1, "Count", "", 0, "R", true, 0, "")
+		pdf.CellFormat(6, lineHeight+1, "", "", 0, "L", false, 0, "")
+		pdf.CellFormat(half-20, lineHeight+1, "Top word pairs", "", 0, "L", true, 0, "")
+		pdf.CellFormat(20, lineHeight+1, "Count", "", 1, "R", true, 0, "")
+		pdf.SetFont(reportFont, "", 10)
+		for i := 0; i < rows; i++ {
+			term, count, border := "", "", ""
+			if i < len(text.Words) {
+				term, count, border = rep.fit(text.Words[i].Term, half-22), strconv.Itoa(text.Words[i].Count), "B"
+			}
+			pdf.CellFormat(half-20, lineHeight, term, border, 0, "L", false, 0, "")
+			pdf.CellFormat(20, lineHeight, count, border, 0, "R", false, 0, "")
+			pdf.CellFormat(6, lineHeight, "", "", 0, "L", false, 0, "")
+			term, count, border = "", "", ""
+			if i < len(text.Bigrams) {
+				term, count, border = rep.fit(text.Bigrams[i].Term, half-22), strconv.Itoa(text.Bigrams[i].Count), "B"
+			}
+			pdf.CellFormat(half-20, lineHeight, term, border, 0, "L", false, 0, "")
+			pdf.CellFormat(20, lineHeight, count, border, 1, "R", false, 0, "")
+		}
+		pdf.SetFont(reportFont, "", 8)
+		rep.textColor(textGray)
+		pdf.CellFormat(contentWidth, 5, "Common words of the language ("+text.Language+") are left out.", "", 1, "L", false, 0, "")
+		rep.textColor([3]int{0, 0, 0})
+	}
+
+	if len(text.Tags) > 0 {
+		const countWidth, percentWidth = 25.0, 25.0
+		labelWidth := contentWidth - countWidth - percentWidth
+		rep.ensureSpace(lineHeight * 3)
+		pdf.Ln(3)
+		pdf.SetFont(reportFont, "B", 10)
+		rep.color(brandLight)
+		pdf.CellFormat(labelWidth, lineHeight+1, "Tag", "", 0, "L", true, 0, "")
+		pdf.CellFormat(countWidth, lineHeight+1, "Answers", "", 0, "R", true, 0, "")
+		pdf.CellFormat(percentWidth, lineHeight+1, "Share", "", 1, "R", true, 0, "")
+		pdf.SetFont(reportFont, "", 10)
+		for _, tag := range text.Tags {
+			rep.ensureSpace(lineHeight)
+			pdf.CellFormat(labelWidth, lineHeight, rep.fit(tag.Name, labelWidth-2), "B", 0, "L", false, 0, "")
+			pdf.CellFormat(countWidth, lineHeight, strconv.Itoa(tag.Count), "B", 0, "R", false, 0, "")
+			pdf.CellFormat(percentWidth, lineHeight, fmt.Sprintf("%.1f%%", tag.Percent), "B", 1, "R", false, 0, "")
+		}
+	}
+}
+
+// textAppendix lists every answer group with the spellings it merged and
+// its tags.
+func (rep *report) textAppendix() {
+	pdf, res := rep.pdf, rep.results
+	pdf.AddPage()
+	rep.heading("Appendix: Text Answers")
+	for i, row := range res.Rows {
+		answer := row.Label
+		if row.Count > 1 {
+			answer = fmt.Sprintf("%s  (×%d)", answer, row.Count)
+		}
+		pdf.SetFont(reportFont, "B", 9)
+		rep.textColor(textGray)
+		pdf.CellFormat(10, 6, strconv.Itoa(i+1)+".", "", 0, "R", false, 0, "")
+		pdf.SetFont(reportFont, "", 10)
+		rep.textColor([3]int{0, 0, 0})
+		pdf.MultiCell(contentWidth-12, 6, answer, "", "L", false)
+
+		var notes []string
+		if len(row.Variants) > 1 {
+			notes = append(notes, "Also: "+strings.Join(row.Variants[1:], " · "))
+		}
+		if len(row.Tags) > 0 {
+			notes = append(notes, "Tags: "+strings.Join(row.Tags, ", "))
+		}
+		if len(notes) > 0 {
+			pdf.SetFont(reportFont, "", 8)
+			rep.textColor(textGray)
+			pdf.SetX(pageMargin + 12)
+			pdf.MultiCell(contentWidth-12, 4.5, strings.Join(notes, "\n"), "", "L", false)
+			rep.textColor([3]int{0, 0, 0})
+		}
+		pdf.Ln(1)
+	}
+}
+
+func (rep *report) voterAppendix() {
+	pdf, res := rep.pdf, rep.results
+	rep.heading(fmt.Sprintf("Appendix: Voters (%d)", len(res.Voters)))
+	pdf.SetFont(reportFont, "", 9)
+	for _, voter := range res.Voters {
+		email := voter.Email
+		if email == "" {
+			email = "—"
+		}
+		pdf.CellFormat(contentWidth/2, 6, rep.fit(email, contentWidth/2-2), "B", 0, "L", false, 0, "")
+		pdf.CellFormat(contentWidth/2, 6, rep.fit(voter.UserID, contentWidth/2-2), "B", 1, "L", false, 0, "")
+	}
+}
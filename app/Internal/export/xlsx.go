@@ -0,0 +1,275 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"fakidoosuurdoris/app/Internal/services"
+)
+
+func init() {
+	Register("xlsx", xlsxExporter{})
+}
+
+type xlsxExporter struct{}
+
+func (xlsxExporter) ContentType() string {
+	return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+}
+func (xlsxExporter) Extension() string { return "xlsx" }
+
+func (xlsxExporter) WriteResults(w io.Writer, results *services.PollResults) error {
+	x := newWorkbook(w)
+	x.Sheet("Results")
+	x.Row("Poll ID", results.PollID)
+	x.Row("Title", results.Title)
+	x.Row("Question Type", results.QuestionType)
+	x.Row("Anonymous", strconv.FormatBool(results.IsAnonymous))
+	x.Row("Voters", results.VoterCount)
+	x.Row()
+	if results.Text == nil {
+		x.Row(resultLabel(results.QuestionType), "Count", "Percent")
+		for _, row := range results.Rows {
+			x.Row(row.Label, row.Count, math.Round(row.Percent*10)/10)
+		}
+	} else {
+		x.Row(resultLabel(results.QuestionType), "Count", "Percent", "Variants", "Tags")
+		for _, row := range results.Rows {
+			x.Row(row.Label, row.Count, math.Round(row.Percent*10)/10, strings.Join(row.Variants, "; "), strings.Join(row.Tags, "; "))
+		}
+
+		x.Sheet("Words")
+		x.Row("Word", "Count", "", "Bigram", "Count")
+		for i := 0; i < max(len(results.Text.Words), len(results.Text.Bigrams)); i++ {
+			cells := make([]interface{}, 5)
+			for j := range cells {
+				cells[j] = ""
+			}
+			if i < len(results.Text.Words) {
+				cells[0], cells[1] = results.Text.Words[i].Term, results.Text.Words[i].Count
+			}
+			if i < len(results.Text.Bigrams) {
+				cells[3], cells[4] = results.Text.Bigrams[i].Term, results.Text.Bigrams[i].Count
+			}
+			x.Row(cells...)
+		}
+
+		x.Sheet("Tags")
+		x.Row("Tag", "Answer Groups", "Count", "Percent")
+		for _, tag := range results.Text.Tags {
+			x.Row(tag.Name, tag.Groups, tag.Count, math.Round(tag.Percent*10)/10)
+		}
+	}
+
+	if stats := results.Scale; stats != nil {
+		x.Sheet("Statistics")
+		x.Row("Statistic", "Value", "95% CI Low", "95% CI High")
+		x.Row("Responses", stats.Count)
+		x.Row("Mean", stats.Mean, stats.MeanLow, stats.MeanHigh)
+		x.Row("Median", stats.Median)
+		mode := make([]string, len(stats.Mode))
+		for i, value := range stats.Mode {
+			mode[i] = strconv.Itoa(value)
+		}
+		x.Row("Mode", strings.Join(mode, "; "))
+		x.Row("Standard Deviation", stats.StdDev)
+		if net := stats.NetScore; net != nil {
+			x.Row("Net Score", net.Score, net.Low, net.High)
+			x.Row("Promoters", net.Promoters)
+			x.Row("Passives", net.Passives)
+			x.Row("Detractors", net.Detractors)
+		}
+		x.Row()
+		x.Row("Scale Value", "Count", "Percent", "95% CI Low", "95% CI High")
+		for _, bucket := range stats.Distribution {
+			x.Row(bucket.Value, bucket.Count, math.Round(bucket.Percent*10)/10, math.Round(bucket.Low*10)/10, math.Round(bucket.High*10)/10)
+		}
+
+		if results.Comparison != nil {
+			x.Sheet("Comparison")
+			x.Row("Poll ID", "Title", "Start Date", "Responses", "Mean", "Mean 95% CI Low", "Mean 95% CI High", "Median", "Net Score")
+			for _, point := range results.Comparison.Polls {
+				var net interface{} = ""
+				if point.Stats.NetScore != nil {
+					net = point.Stats.NetScore.Score
+				}
+				x.Row(point.PollID, point.Title, point.StartDate, point.Stats.Count, point.Stats.Mean,
+					point.Stats.MeanLow, point.Stats.MeanHigh, point.Stats.Median, net)
+			}
+		}
+	}
+
+	x.Sheet("Voters")
+	x.Row("User ID", "Email")
+	for _, voter := range results.Voters {
+		x.Row(voter.UserID, voter.Email)
+	}
+	return x.Close()
+}
+
+func (xlsxExporter) WriteBallots(w io.Writer, results *services.PollResults, ballots Ballots) error {
+	x := newWorkbook(w)
+	x.Sheet("Ballots")
+	cells := make([]interface{}, len(ballotHeader))
+	for i, name := range ballotHeader {
+		cells[i] = name
+	}
+	x.Row(cells...)
+	err := ballots(func(b services.Ballot) error {
+		record := ballotRecord(b)
+		cells := make([]interface{}, len(record))
+		for i, value := range record {
+			cells[i] = value
+		}
+		cells[0] = b.Number
+		if b.ScaleValue != nil {
+			cells[6] = *b.ScaleValue
+		}
+		return x.Row(cells...)
+	})
+	if err != nil {
+		return err
+	}
+	return x.Close()
+}
+
+// workbook writes a minimal SpreadsheetML package straight into a zip
+// stream, one sheet after the other, so rows are never held in memory.
+// Strings are stored inline, which avoids a shared string table. The first
+// write error is kept and returned by every later call.
+type workbook struct {
+	zip    *zip.Writer
+	sheets []string
+	sheet  io.Writer
+	row    int
+	err    error
+}
+
+func newWorkbook(w io.Writer) *workbook {
+	return &workbook{zip: zip.NewWriter(w)}
+}
+
+func (x *workbook) write(s string) {
+	if x.err == nil {
+		_, x.err = io.WriteString(x.sheet, s)
+	}
+}
+
+func (x *workbook) endSheet() {
+	if x.sheet != nil {
+		x.write(`</sheetData></worksheet>`)
+		x.sheet = nil
+	}
+}
+
+// Sheet starts a new worksheet; following rows are added to it.
+func (x *workbook) Sheet(name string) error {
+	x.endSheet()
+	if x.err != nil {
+		return x.err
+	}
+	x.sheets = append(x.sheets, name)
+	x.sheet, x.err = x.zip.Create(fmt.Sprintf("xl/worksheets/sheet%d.xml", len(x.sheets)))
+	x.row = 0
+	x.write(xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	return x.err
+}
+
+// Row appends a row. Integers and floats become numeric cells, times ISO
+// 8601 strings and everything else text.
+func (x *workbook) Row(cells ...interface{}) error {
+	x.row++
+	x.write(`<row r="` + strconv.Itoa(x.row) + `">`)
+	for i, cell := range cells {
+		ref := columnName(i) + strconv.Itoa(x.row)
+		switch v := cell.(type) {
+		case int:
+			x.write(`<c r="` + ref + `"><v>` + strconv.Itoa(v) + `</v></c>`)
+		case int64:
+			x.write(`<c r="` + ref + `"><v>` + strconv.FormatInt(v, 10) + `</v></c>`)
+		case float64:
+			x.write(`<c r="` + ref + `"><v>` + strconv.FormatFloat(v, 'f', -1, 64) + `</v></c>`)
+		case time.Time:
+			x.inlineString(ref, v.UTC().Format(time.RFC3339))
+		case string:
+			if v != "" {
+				x.inlineString(ref, v)
+			}
+		default:
+			x.inlineString(ref, fmt.Sprint(v))
+		}
+	}
+	x.write(`</row>`)
+	return x.err
+}
+
+func (x *workbook) inlineString(ref, s string) {
+	x.write(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">`)
+	if x.err == nil {
+		x.err = xml.EscapeText(x.sheet, []byte(s))
+	}
+	x.write(`</t></is></c>`)
+}
+
+// columnName returns the spreadsheet column letters for a zero-based index.
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// Close writes the workbook parts that list the sheets and finishes the
+// zip stream.
+func (x *workbook) Close() error {
+	x.endSheet()
+	if x.err != nil {
+		return x.err
+	}
+
+	var contentTypes, workbook, rels string
+	for i, name := range x.sheets {
+		n := strconv.Itoa(i + 1)
+		contentTypes += `<Override PartName="/xl/worksheets/sheet` + n + `.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`
+		workbook += `<sheet name="` + xmlAttr(name) + `" sheetId="` + n + `" r:id="rId` + n + `"/>`
+		rels += `<Relationship Id="rId` + n + `" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet` + n + `.xml"/>`
+	}
+
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+			`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+			`<Default Extension="xml" ContentType="application/xml"/>` +
+			`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+			contentTypes + `</Types>`},
+		{"_rels/.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+			`</Relationships>`},
+		{"xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets>` + workbook + `</sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			rels + `</Relationships>`},
+	}
+	for _, part := range parts {
+		f, err := x.zip.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, xml.Header+part.body); err != nil {
+			return err
+		}
+	}
+	return x.zip.Close()
+}
+
+func xmlAttr(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
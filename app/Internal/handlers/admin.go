@@ -1,31 +1,36 @@
 package handlers
 
 import (
-	"encoding/csv"
-	"encoding/json"
+	"database/sql"
+	"errors"
 	"fmt"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"fakidoosuurdoris/app/Internal/export"
 	"fakidoosuurdoris/app/Internal/services"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jung-kurt/gofpdf"
 )
 
+// AdminHandler serves the admin pages and result APIs. MinCellSize is the
+// default minimum group size of crosstabs.
 type AdminHandler struct {
 	PollService *services.PollService
 	AuthService *services.AuthService
 	Templates   *template.Template
+	MinCellSize int
 }
 
-func NewAdminHandler(pollService *services.PollService, authService *services.AuthService, templates *template.Template) *AdminHandler {
+func NewAdminHandler(pollService *services.PollService, authService *services.AuthService, templates *template.Template, minCellSize int) *AdminHandler {
 	return &AdminHandler{
 		PollService: pollService,
 		AuthService: authService,
 		Templates:   templates,
+		MinCellSize: minCellSize,
 	}
 }
 
@@ -53,21 +58,35 @@ func (h *AdminHandler) RenderAdminPolls(c *gin.Context) {
 		return
 	}
 
-	polls, err := h.PollService.GetAllPolls(c.Request.Context())
+	query, err := parsePollQuery(c, userID)
+	if err != nil {
+		c.HTML(http.StatusBadRequest, "admin_polls.html", gin.H{
+			"Title": "Poll Details",
+			"Error": "Invalid filter",
+			"Role":  role,
+		})
+		return
+	}
+
+	page, err := h.PollService.ListPolls(c.Request.Context(), query)
 	if err != nil {
 		c.HTML(http.StatusInternalServerError, "admin_polls.html", gin.H{
 			"Title": "Poll Details",
 			"Error": "Failed to load polls",
+			"Role":  role,
 		})
 		return
 	}
 
 	csrfToken, _ := c.Get("csrf_token")
 	c.HTML(http.StatusOK, "admin_polls.html", gin.H{
-		"Title":     "Poll Details",
-		"Polls":     polls,
-		"CSRFToken": csrfToken,
-		"Role":      role,
+		"Title":        "Poll Details",
+		"Polls":        page.Polls,
+		"CSRFToken":    csrfToken,
+		"Role":         role,
+		"FilterAction": "/admin/polls",
+		"Filters":      c.Request.URL.Query(),
+		"NextURL":      nextPageURL(c, page.NextCursor),
 	})
 }
 
@@ -104,11 +123,51 @@ func (h *AdminHandler) RenderAdminUsers(c *gin.Context) {
 	c.HTML(http.StatusOK, "admin_users.html", gin.H{
 		"Title":     "User Details",
 		"Users":     users,
+		"Message":   c.Query("message"),
 		"CSRFToken": csrfToken,
 		"Role":      role,
 	})
 }
 
+// UpdateUserDepartment sets the department a user's votes are counted under
+// in crosstabs.
+func (h *AdminHandler) UpdateUserDepartment(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.HTML(http.StatusUnauthorized, "admin_users.html", gin.H{
+			"Title": "User Details",
+			"Error": "Please log in",
+		})
+		return
+	}
+
+	role, err := h.AuthService.GetUserRole(c.Request.Context(), uid.(string))
+	if err != nil || role != "admin" {
+		c.HTML(http.StatusForbidden, "admin_users.html", gin.H{
+			"Title": "User Details",
+			"Error": "Access denied",
+		})
+		return
+	}
+
+	department := c.PostForm("department")
+	if len([]rune(department)) > 100 {
+		c.Redirect(http.StatusSeeOther, "/admin/users?message=Department+names+are+limited+to+100+characters")
+		return
+	}
+	if err := h.AuthService.SetUserDepartment(c.Request.Context(), c.Param("id"), department); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to set department of user", "user_id", c.Param("id"), "error", err)
+		c.HTML(http.StatusInternalServerError, "admin_users.html", gin.H{
+			"Title": "User Details",
+			"Error": "Failed to update department",
+			"Role":  role,
+		})
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/admin/users?message=Department+updated")
+}
+
 func (h *AdminHandler) RenderMakeAdmin(c *gin.Context) {
 	uid, exists := c.Get("uid")
 	if !exists {
@@ -157,15 +216,18 @@ func (h *AdminHandler) GetPollSummary(c *gin.Context) {
 		return
 	}
 
-	summary, err := h.PollService.GetPollSummary(c.Request.Context(), pollID)
+	results, err := h.PollService.GetPollResults(c.Request.Context(), pollID, c.Query("lang"))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to load summary: %v", err)})
 		return
 	}
 
-	c.JSON(http.StatusOK, summary)
+	c.JSON(http.StatusOK, results)
 }
 
+// DownloadPollSummary exports a poll in the registered format named by
+// ?format. With ?data=ballots it exports one record per ballot instead of
+// the aggregated results, for formats that support it.
 func (h *AdminHandler) DownloadPollSummary(c *gin.Context) {
 	uid, exists := c.Get("uid")
 	if !exists {
@@ -187,118 +249,180 @@ func (h *AdminHandler) DownloadPollSummary(c *gin.Context) {
 	}
 
 	format := c.Query("format")
-	if format != "csv" && format != "json" && format != "pdf" {
-		c.String(http.StatusBadRequest, "Invalid format. Use csv, json, or pdf")
+	exporter, ok := export.Lookup(format)
+	if !ok {
+		c.String(http.StatusBadRequest, "Invalid format. Use "+strings.Join(export.Formats(), ", "))
+		return
+	}
+
+	data := c.DefaultQuery("data", "results")
+	var ballotExporter export.BallotExporter
+	switch data {
+	case "results":
+	case "ballots":
+		if ballotExporter, ok = exporter.(export.BallotExporter); !ok {
+			c.String(http.StatusBadRequest, fmt.Sprintf("The %s format does not support ballots", format))
+			return
+		}
+	default:
+		c.String(http.StatusBadRequest, "Invalid data. Use results or ballots")
 		return
 	}
 
-	summary, err := h.PollService.GetPollSummary(c.Request.Context(), pollID)
+	results, err := h.PollService.GetPollResults(c.Request.Context(), pollID, c.Query("lang"))
 	if err != nil {
 		c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to load summary: %v", err))
 		return
 	}
 
-	if format == "csv" {
-		c.Header("Content-Type", "text/csv")
-		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=poll_%d_summary.csv", pollID))
+	name := "summary"
+	if data == "ballots" {
+		name = "ballots"
+	}
+	c.Header("Content-Type", exporter.ContentType())
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=poll_%d_%s.%s", pollID, name, exporter.Extension()))
+	c.Status(http.StatusOK)
+
+	// The response is streamed, so errors past this point can only be logged.
+	if ballotExporter != nil {
+		err = ballotExporter.WriteBallots(c.Writer, results, func(fn func(services.Ballot) error) error {
+			return h.PollService.StreamBallots(c.Request.Context(), pollID, fn)
+		})
+	} else {
+		err = exporter.WriteResults(c.Writer, results)
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to export poll", "poll_id", pollID, "format", format, "error", err)
+	}
+}
 
-		w := csv.NewWriter(c.Writer)
-		defer w.Flush()
+// GetPollCrosstab breaks a poll's results down by ?by=department, role,
+// cohort or poll (with ?poll_id). ?min_cell can raise the minimum cell size
+// above the configured one, and ?format=csv downloads the matrix.
+func (h *AdminHandler) GetPollCrosstab(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Please log in"})
+		return
+	}
+	userID := uid.(string)
 
-		w.Write([]string{"Poll ID", "Title", "Question Type", "Is Anonymous"})
-		w.Write([]string{strconv.FormatInt(summary.PollID, 10), summary.Title, summary.QuestionType, strconv.FormatBool(summary.IsAnonymous)})
+	role, err := h.AuthService.GetUserRole(c.Request.Context(), userID)
+	if err != nil || role != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
 
-		w.Write([]string{"", "", "", ""})
-		w.Write([]string{"Voters"})
-		w.Write([]string{"User ID", "Email"})
-		for _, voter := range summary.Voters {
-			var email string
-			if voter.Email == "" {
-				email = "Anonymous"
-			} else {
-				email = voter.Email
-			}
-			w.Write([]string{voter.UserID, email})
+	pollID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid poll ID"})
+		return
+	}
+	var segmentPollID int64
+	if c.Query("by") == services.DimensionPoll {
+		if segmentPollID, err = strconv.ParseInt(c.Query("poll_id"), 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid poll_id"})
+			return
 		}
-
-		w.Write([]string{"", "", "", ""})
-		w.Write([]string{"Results"})
-		if summary.QuestionType == "text" {
-			w.Write([]string{"Text Answer", "Count"})
-			for text, count := range summary.Results.(map[string]int) {
-				w.Write([]string{text, strconv.Itoa(count)})
-			}
-		} else if summary.QuestionType == "scale" {
-			w.Write([]string{"Scale Value", "Count"})
-			for value, count := range summary.Results.(map[int]int) {
-				w.Write([]string{strconv.Itoa(value), strconv.Itoa(count)})
-			}
-		} else {
-			w.Write([]string{"Option", "Count"})
-			for option, count := range summary.Results.(map[string]int) {
-				w.Write([]string{option, strconv.Itoa(count)})
-			}
+	}
+	minCell := h.MinCellSize
+	if value := c.Query("min_cell"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid min_cell"})
+			return
 		}
-	} else if format == "json" {
-		c.Header("Content-Type", "application/json")
-		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=poll_%d_summary.json", pollID))
-		enc := json.NewEncoder(c.Writer)
-		enc.SetIndent("", "  ")
-		enc.Encode(summary)
-	} else if format == "pdf" {
-		pdf := gofpdf.New("P", "mm", "A4", "")
-		pdf.AddPage()
-		pdf.SetFont("Arial", "B", 16)
-		pdf.Cell(40, 10, fmt.Sprintf("Poll Summary: %s", summary.Title))
-		pdf.Ln(10)
-		pdf.SetFont("Arial", "", 12)
-		pdf.Cell(40, 10, fmt.Sprintf("Poll ID: %d", summary.PollID))
-		pdf.Ln(10)
-		pdf.Cell(40, 10, fmt.Sprintf("Question Type: %s", summary.QuestionType))
-		pdf.Ln(10)
-		pdf.Cell(40, 10, fmt.Sprintf("Anonymous: %v", summary.IsAnonymous))
-		pdf.Ln(10)
-
-		pdf.Ln(5)
-		pdf.SetFont("Arial", "B", 12)
-		pdf.Cell(40, 10, "Voters")
-		pdf.Ln(10)
-		pdf.SetFont("Arial", "", 12)
-		for _, voter := range summary.Voters {
-			email := voter.Email
-			if email == "" {
-				email = "Anonymous"
-			}
-			pdf.Cell(40, 10, fmt.Sprintf("User ID: %s, Email: %s", voter.UserID, email))
-			pdf.Ln(10)
+		minCell = max(n, h.MinCellSize)
+	}
+
+	tab, err := h.PollService.Crosstab(c.Request.Context(), pollID, c.Query("by"), segmentPollID, minCell)
+	if errors.Is(err, services.ErrCrosstab) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Poll not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to load crosstab: %v", err)})
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=poll_%d_crosstab_%s.csv", pollID, tab.Dimension))
+		c.Status(http.StatusOK)
+		if err := export.WriteCrosstabCSV(c.Writer, tab); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to export crosstab of poll", "poll_id", pollID, "error", err)
 		}
+		return
+	}
+	c.JSON(http.StatusOK, tab)
+}
+
+func (h *AdminHandler) RenderDeleteUser(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.HTML(http.StatusUnauthorized, "delete_user.html", gin.H{
+			"Title": "Delete User",
+			"Error": "Please log in",
+		})
+		return
+	}
+	adminID := uid.(string)
+
+	role, err := h.AuthService.GetUserRole(c.Request.Context(), adminID)
+	if err != nil || role != "admin" {
+		c.HTML(http.StatusForbidden, "delete_user.html", gin.H{
+			"Title": "Delete User",
+			"Error": "Access denied",
+		})
+		return
+	}
+
+	h.renderDeleteUserForm(c, http.StatusOK, c.Param("id"), adminID, role, "")
+}
 
-		pdf.Ln(5)
-		pdf.SetFont("Arial", "B", 12)
-		pdf.Cell(40, 10, "Results")
-		pdf.Ln(10)
-		pdf.SetFont("Arial", "", 12)
-		if summary.QuestionType == "text" {
-			for text, count := range summary.Results.(map[string]int) {
-				pdf.Cell(40, 10, fmt.Sprintf("Answer: %s, Count: %d", text, count))
-				pdf.Ln(10)
-			}
-		} else if summary.QuestionType == "scale" {
-			for value, count := range summary.Results.(map[int]int) {
-				pdf.Cell(40, 10, fmt.Sprintf("Scale: %d, Count: %d", value, count))
-				pdf.Ln(10)
-			}
+func (h *AdminHandler) renderDeleteUserForm(c *gin.Context, status int, userID, adminID, role, errMsg string) {
+	users, err := h.AuthService.GetAllUsers(c.Request.Context())
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "delete_user.html", gin.H{
+			"Title": "Delete User",
+			"Error": "Failed to load users",
+			"Role":  role,
+		})
+		return
+	}
+
+	var target struct{ ID, Email, Role, Department string }
+	var others []struct{ ID, Email, Role, Department string }
+	for _, user := range users {
+		if user.ID == userID {
+			target = user
 		} else {
-			for option, count := range summary.Results.(map[string]int) {
-				pdf.Cell(40, 10, fmt.Sprintf("Option: %s, Count: %d", option, count))
-				pdf.Ln(10)
-			}
+			others = append(others, user)
 		}
-
-		c.Header("Content-Type", "application/pdf")
-		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=poll_%d_summary.pdf", pollID))
-		pdf.Output(c.Writer)
 	}
+	if target.ID == "" {
+		c.HTML(http.StatusNotFound, "delete_user.html", gin.H{
+			"Title": "Delete User",
+			"Error": "User not found",
+			"Role":  role,
+		})
+		return
+	}
+
+	csrfToken, _ := c.Get("csrf_token")
+	c.HTML(status, "delete_user.html", gin.H{
+		"Title":         "Delete User",
+		"Error":         errMsg,
+		"User":          target,
+		"Users":         others,
+		"CSRFToken":     csrfToken,
+		"Role":          role,
+		"CurrentUserID": adminID,
+	})
 }
 
 func (h *AdminHandler) DeleteUser(c *gin.Context) {
@@ -332,15 +456,24 @@ func (h *AdminHandler) DeleteUser(c *gin.Context) {
 		return
 	}
 
-	err = h.AuthService.DeleteUser(c.Request.Context(), userID, adminID)
+	var input struct {
+		Polls      string `form:"polls" binding:"required,oneof=delete anonymize reassign"`
+		ReassignTo string `form:"reassign_to"`
+		Votes      string `form:"votes" binding:"required,oneof=keep erase"`
+	}
+	if err := c.ShouldBind(&input); err != nil {
+		h.renderDeleteUserForm(c, http.StatusBadRequest, userID, adminID, role, "Choose what to do with the user's polls and votes")
+		return
+	}
+
+	err = h.AuthService.DeleteUser(c.Request.Context(), userID, adminID, services.DeleteUserOptions{
+		Polls:      services.PollDisposition(input.Polls),
+		ReassignTo: input.ReassignTo,
+		Votes:      services.VoteDisposition(input.Votes),
+	})
 	if err != nil {
-		log.Printf("User deletion failed: %v", err)
-		c.HTML(http.StatusBadRequest, "admin_users.html", gin.H{
-			"Title":         "User Details",
-			"Error":         fmt.Sprintf("Could not delete user: %v", err),
-			"Role":          role,
-			"CurrentUserID": adminID,
-		})
+		slog.ErrorContext(c.Request.Context(), "User deletion failed", "error", err)
+		h.renderDeleteUserForm(c, http.StatusBadRequest, userID, adminID, role, fmt.Sprintf("Could not delete user: %v", err))
 		return
 	}
 
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"fakidoosuurdoris/app/Internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requireAnswersAdmin renders an error page and returns false unless the
+// caller is an admin and the poll ID is valid.
+func (h *AdminHandler) requireAnswersAdmin(c *gin.Context) (int64, string, bool) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.HTML(http.StatusUnauthorized, "admin_text_answers.html", gin.H{
+			"Title": "Text Answers",
+			"Error": "Please log in",
+		})
+		return 0, "", false
+	}
+
+	role, err := h.AuthService.GetUserRole(c.Request.Context(), uid.(string))
+	if err != nil || role != "admin" {
+		c.HTML(http.StatusForbidden, "admin_text_answers.html", gin.H{
+			"Title": "Text Answers",
+			"Error": "Access denied",
+		})
+		return 0, "", false
+	}
+
+	pollID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.HTML(http.StatusBadRequest, "admin_text_answers.html", gin.H{
+			"Title": "Text Answers",
+			"Error": "Invalid poll ID",
+			"Role":  role,
+		})
+		return 0, "", false
+	}
+	return pollID, role, true
+}
+
+// RenderTextAnswers shows a text poll's grouped answers with word counts and
+// lets admins code them with tags.
+func (h *AdminHandler) RenderTextAnswers(c *gin.Context) {
+	pollID, role, ok := h.requireAnswersAdmin(c)
+	if !ok {
+		return
+	}
+	h.renderTextAnswers(c, http.StatusOK, pollID, role, "")
+}
+
+func (h *AdminHandler) renderTextAnswers(c *gin.Context, status int, pollID int64, role, errMsg string) {
+	results, err := h.PollService.GetPollResults(c.Request.Context(), pollID, c.Query("lang"))
+	if err == sql.ErrNoRows {
+		c.HTML(http.StatusNotFound, "admin_text_answers.html", gin.H{
+			"Title": "Text Answers",
+			"Error": "Poll not found",
+			"Role":  role,
+		})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load answers of poll", "poll_id", pollID, "error", err)
+		c.HTML(http.StatusInternalServerError, "admin_text_answers.html", gin.H{
+			"Title": "Text Answers",
+			"Error": "Failed to load answers",
+			"Role":  role,
+		})
+		return
+	}
+	if results.Text == nil {
+		c.HTML(http.StatusBadRequest, "admin_text_answers.html", gin.H{
+			"Title": "Text Answers",
+			"Error": "Only text polls have text answers",
+			"Role":  role,
+		})
+		return
+	}
+
+	csrfToken, _ := c.Get("csrf_token")
+	c.HTML(status, "admin_text_answers.html", gin.H{
+		"Title":     "Text Answers",
+		"Error":     errMsg,
+		"Message":   c.Query("message"),
+		"Results":   results,
+		"Languages": services.TextLanguages(),
+		"CSRFToken": csrfToken,
+		"Role":      role,
+	})
+}
+
+func answersURL(pollID int64, message string) string {
+	return fmt.Sprintf("/admin/polls/%d/answers?message=%s", pollID, url.QueryEscape(message))
+}
+
+func (h *AdminHandler) CreateAnswerTag(c *gin.Context) {
+	pollID, role, ok := h.requireAnswersAdmin(c)
+	if !ok {
+		return
+	}
+
+	if _, err := h.PollService.CreateAnswerTag(c.Request.Context(), pollID, c.PostForm("name")); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to create answer tag for poll", "poll_id", pollID, "error", err)
+		if errors.Is(err, services.ErrInvalidTag) {
+			h.renderTextAnswers(c, http.StatusBadRequest, pollID, role, "Tag names must be 1 to 60 characters")
+			return
+		}
+		h.renderTextAnswers(c, http.StatusInternalServerError, pollID, role, "Failed to create tag")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, answersURL(pollID, "Tag created"))
+}
+
+func (h *AdminHandler) DeleteAnswerTag(c *gin.Context) {
+	pollID, role, ok := h.requireAnswersAdmin(c)
+	if !ok {
+		return
+	}
+
+	tagID, err := strconv.ParseInt(c.Param("tagID"), 10, 64)
+	if err != nil {
+		h.renderTextAnswers(c, http.StatusBadRequest, pollID, role, "Invalid tag ID")
+		return
+	}
+
+	if err := h.PollService.DeleteAnswerTag(c.Request.Context(), pollID, tagID); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to delete answer tag", "tag_id", tagID, "error", err)
+		h.renderTextAnswers(c, http.StatusInternalServerError, pollID, role, "Failed to delete tag")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, answersURL(pollID, "Tag deleted"))
+}
+
+// CodeAnswers adds a tag to, or with action=remove removes it from, the
+// selected answer groups. Each keys[] value holds the newline-separated
+// normalized answers of one group.
+func (h *AdminHandler) CodeAnswers(c *gin.Context) {
+	pollID, role, ok := h.requireAnswersAdmin(c)
+	if !ok {
+		return
+	}
+
+	tagID, err := strconv.ParseInt(c.PostForm("tag_id"), 10, 64)
+	if err != nil {
+		h.renderTextAnswers(c, http.StatusBadRequest, pollID, role, "Choose a tag")
+		return
+	}
+	var keys []string
+	for _, group := range c.PostFormArray("keys[]") {
+		keys = append(keys, strings.Split(group, "\n")...)
+	}
+	if len(keys) == 0 {
+		h.renderTextAnswers(c, http.StatusBadRequest, pollID, role, "Select at least one answer")
+		return
+	}
+
+	tagged := c.PostForm("action") != "remove"
+	err = h.PollService.TagAnswers(c.Request.Context(), pollID, tagID, keys, tagged)
+	if err == sql.ErrNoRows {
+		h.renderTextAnswers(c, http.StatusNotFound, pollID, role, "Tag not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to tag answers of poll", "poll_id", pollID, "error", err)
+		h.renderTextAnswers(c, http.StatusInternalServerError, pollID, role, "Failed to update answers")
+		return
+	}
+
+	message := "Tag added"
+	if !tagged {
+		message = "Tag removed"
+	}
+	c.Redirect(http.StatusSeeOther, answersURL(pollID, message))
+}
@@ -2,10 +2,12 @@ package handlers
 
 import (
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
 
+	"fakidoosuurdoris/app/Internal/middlewares"
 	"fakidoosuurdoris/app/Internal/services"
+	"fakidoosuurdoris/app/config"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,6 +15,7 @@ import (
 type App struct {
 	AuthService *services.AuthService
 	Templates   *template.Template
+	Cookies     config.CookieConfig
 }
 
 func (app *App) RenderHome(c *gin.Context) {
@@ -53,7 +56,7 @@ func Register(app *App) gin.HandlerFunc {
 		}
 
 		if err := c.ShouldBind(&input); err != nil {
-			log.Printf("Registration form binding error: %v, Form data: %+v", err, c.Request.PostForm)
+			slog.WarnContext(c.Request.Context(), "Registration form binding error", "error", err)
 			csrfToken, _ := c.Get("csrf_token")
 			c.HTML(http.StatusBadRequest, "register.html", gin.H{
 				"Title":     "Register",
@@ -66,7 +69,7 @@ func Register(app *App) gin.HandlerFunc {
 
 		uid, err := app.AuthService.Register(c.Request.Context(), input.FirstName, input.LastName, input.Email, input.Password, "user")
 		if err != nil {
-			log.Printf("Registration failed: %v", err)
+			slog.WarnContext(c.Request.Context(), "Registration failed", "error", err)
 			csrfToken, _ := c.Get("csrf_token")
 			c.HTML(http.StatusInternalServerError, "register.html", gin.H{
 				"Title":     "Register",
@@ -81,7 +84,7 @@ func Register(app *App) gin.HandlerFunc {
 			c.Redirect(http.StatusSeeOther, "/login")
 			return
 		}
-		c.SetCookie("idToken", idToken, 3600, "/", "localhost", false, true)
+		c.SetCookie("idToken", idToken, app.Cookies.MaxAge(app.Cookies.SessionTTL), "/", app.Cookies.Domain, app.Cookies.Secure, true)
 		c.Set("uid", uid)
 
 		c.Redirect(http.StatusSeeOther, "/polls-list")
@@ -92,7 +95,8 @@ func Login(app *App) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		idToken := c.PostForm("idToken")
 		if idToken == "" {
-			log.Printf("No idToken provided, Form data: %+v", c.Request.PostForm)
+			slog.WarnContext(c.Request.Context(), "No idToken provided")
+			middlewares.LoginFailed(c)
 			c.HTML(http.StatusBadRequest, "login.html", gin.H{
 				"Title": "Login",
 				"Error": "Incorrect email or password",
@@ -100,11 +104,10 @@ func Login(app *App) gin.HandlerFunc {
 			return
 		}
 
-		log.Printf("Got idToken: %s...", idToken[:10])
-
 		token, uid, err := app.AuthService.Login(c.Request.Context(), idToken)
 		if err != nil {
-			log.Printf("Login error: %v", err)
+			slog.WarnContext(c.Request.Context(), "Login error", "error", err)
+			middlewares.LoginFailed(c)
 			c.HTML(http.StatusUnauthorized, "login.html", gin.H{
 				"Title": "Login",
 				"Error": "Invalid idToken. Please try again.",
@@ -114,7 +117,7 @@ func Login(app *App) gin.HandlerFunc {
 
 		role, err := app.AuthService.GetUserRole(c.Request.Context(), uid)
 		if err != nil {
-			log.Printf("Failed to get user role: %v", err)
+			slog.ErrorContext(c.Request.Context(), "Failed to get user role", "error", err)
 			c.HTML(http.StatusInternalServerError, "login.html", gin.H{
 				"Title": "Login",
 				"Error": "Could not verify user role",
@@ -122,7 +125,9 @@ func Login(app *App) gin.HandlerFunc {
 			return
 		}
 
-		c.SetCookie("idToken", token, 84600, "/", "localhost", false, true)
+		middlewares.LoginSucceeded(c)
+
+		c.SetCookie("idToken", token, app.Cookies.MaxAge(app.Cookies.SessionTTL), "/", app.Cookies.Domain, app.Cookies.Secure, true)
 		c.Set("uid", uid)
 
 		if role == "admin" {
@@ -147,7 +152,7 @@ func (app *App) MakeAdmin(c *gin.Context) {
 
 	_, adminID, err := app.AuthService.Login(c.Request.Context(), idToken)
 	if err != nil {
-		log.Printf("Login error: %v", err)
+		slog.WarnContext(c.Request.Context(), "Login error", "error", err)
 		c.HTML(http.StatusUnauthorized, "make_admin.html", gin.H{
 			"Title": "Make Admin",
 			"Error": "Invalid idToken",
@@ -167,7 +172,7 @@ func (app *App) MakeAdmin(c *gin.Context) {
 	}
 
 	if err := app.AuthService.SetAdminRole(c.Request.Context(), input.Email, adminID); err != nil {
-		log.Printf("Failed to set admin role: %v", err)
+		slog.ErrorContext(c.Request.Context(), "Failed to set admin role", "error", err)
 		c.HTML(http.StatusInternalServerError, "make_admin.html", gin.H{
 			"Title": "Make Admin",
 			"Error": "Failed to set admin role: " + err.Error(),
@@ -196,7 +201,7 @@ func (app *App) RenderMakeAdmin(c *gin.Context) {
 
 	isAdmin, err := app.AuthService.IsAdmin(c.Request.Context(), idToken)
 	if err != nil || !isAdmin {
-		log.Printf("Unauthorized access to admin page: %v", err)
+		slog.WarnContext(c.Request.Context(), "Unauthorized access to admin page", "error", err)
 		c.HTML(http.StatusForbidden, "home.html", gin.H{
 			"Title": "Home",
 			"Error": "Admin access required",
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"fakidoosuurdoris/app/Internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ChatHandler struct {
+	ChatService *services.ChatService
+}
+
+func NewChatHandler(chatService *services.ChatService) *ChatHandler {
+	return &ChatHandler{ChatService: chatService}
+}
+
+// readSigned reads the raw request body, verifies its signature and parses
+// it as a form.
+func (h *ChatHandler) readSigned(c *gin.Context) (url.Values, bool) {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read request"})
+		return nil, false
+	}
+	err = h.ChatService.VerifySignature(c.GetHeader("X-Slack-Request-Timestamp"), c.GetHeader("X-Slack-Signature"), body)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Rejected chat request", "error", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		return nil, false
+	}
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid form body"})
+		return nil, false
+	}
+	return values, true
+}
+
+func (h *ChatHandler) Command(c *gin.Context) {
+	form, ok := h.readSigned(c)
+	if !ok {
+		return
+	}
+
+	msg, err := h.ChatService.HandleCommand(c.Request.Context(), services.SlashCommand{
+		TeamID:      form.Get("team_id"),
+		ChannelID:   form.Get("channel_id"),
+		UserID:      form.Get("user_id"),
+		Command:     form.Get("command"),
+		Text:        form.Get("text"),
+		ResponseURL: form.Get("response_url"),
+	})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Chat command failed", "error", err)
+		c.JSON(http.StatusOK, services.ChatMessage{ResponseType: "ephemeral", Text: "Something went wrong, please try again."})
+		return
+	}
+	c.JSON(http.StatusOK, msg)
+}
+
+func (h *ChatHandler) Interaction(c *gin.Context) {
+	form, ok := h.readSigned(c)
+	if !ok {
+		return
+	}
+
+	var in services.ChatInteraction
+	if err := json.Unmarshal([]byte(form.Get("payload")), &in); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
+		return
+	}
+
+	msg, err := h.ChatService.HandleInteraction(c.Request.Context(), in)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Chat interaction failed", "error", err)
+		msg = services.ChatMessage{ResponseType: "ephemeral", Text: "Something went wrong, please try again."}
+	}
+
+	// Interactions must be acknowledged quickly; the reply goes to the
+	// response URL instead of the response body.
+	c.Status(http.StatusOK)
+	if in.ResponseURL != "" {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			defer cancel()
+			if err := h.ChatService.Post(ctx, in.ResponseURL, msg); err != nil {
+				slog.ErrorContext(ctx, "Failed to reply to chat interaction", "error", err)
+			}
+		}()
+	}
+}
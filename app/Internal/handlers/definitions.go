@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"fakidoosuurdoris/app/Internal/services"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// ImportPollsAPI creates or updates polls from JSON or YAML definitions,
+// chosen by the Content-Type. With ?dry_run=true the definitions are only
+// validated. Invalid definitions fail the whole import with 422 and a result
+// per definition.
+func (h *PollHandler) ImportPollsAPI(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Please log in"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 5<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read request"})
+		return
+	}
+	isYAML := strings.Contains(c.ContentType(), "yaml")
+	defs, err := services.DecodeDefinitions(body, isYAML)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid definitions: " + err.Error()})
+		return
+	}
+	if len(defs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No definitions given"})
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+	results, err := h.PollService.ImportPolls(c.Request.Context(), uid.(string), defs, dryRun)
+	switch {
+	case errors.Is(err, services.ErrInvalidDefinition):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"dry_run": dryRun, "results": results})
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can import polls"})
+	case err != nil:
+		slog.ErrorContext(c.Request.Context(), "Failed to import polls", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not import polls"})
+	default:
+		c.JSON(http.StatusOK, gin.H{"dry_run": dryRun, "results": results})
+	}
+}
+
+// ExportPollAPI returns a poll as a definition, in YAML with ?format=yaml
+// and in JSON otherwise.
+func (h *PollHandler) ExportPollAPI(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Please log in"})
+		return
+	}
+
+	pollID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid poll ID"})
+		return
+	}
+
+	def, err := h.PollService.ExportPoll(c.Request.Context(), pollID, uid.(string))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Poll not found"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to export poll", "poll_id", pollID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not export poll"})
+		return
+	}
+
+	if c.Query("format") == "yaml" {
+		out, err := yaml.Marshal(def)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to encode poll as YAML", "poll_id", pollID, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not export poll"})
+			return
+		}
+		c.Data(http.StatusOK, "application/yaml; charset=utf-8", out)
+		return
+	}
+	c.JSON(http.StatusOK, def)
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"fakidoosuurdoris/app/Internal/qrcode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Refresh limits of the results widget, in seconds.
+const (
+	embedRefreshDefault = 60
+	embedRefreshMin     = 10
+	embedRefreshMax     = 3600
+)
+
+// embedTheme holds the colours of the results widget.
+type embedTheme struct {
+	Background string
+	Text       string
+	Muted      string
+	Track      string
+	Accent     string
+}
+
+var embedThemes = map[string]embedTheme{
+	"light":    {Background: "#ffffff", Text: "#1f2937", Muted: "#6b7280", Track: "#e5e7eb", Accent: "#a78bfa"},
+	"dark":     {Background: "#111827", Text: "#f9fafb", Muted: "#9ca3af", Track: "#374151", Accent: "#a7f3d0"},
+	"contrast": {Background: "#000000", Text: "#ffffff", Muted: "#ffffff", Track: "#4b5563", Accent: "#ffff00"},
+}
+
+var hexColor = regexp.MustCompile(`^[0-9a-fA-F]{6}$`)
+
+// requestSecure reports whether the client reached us over HTTPS, directly
+// or through a proxy that terminates TLS.
+func requestSecure(c *gin.Context) bool {
+	return c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+}
+
+// isEmbed reports whether the request is for a page meant to be framed by
+// other sites.
+func isEmbed(c *gin.Context) bool {
+	return strings.HasPrefix(c.FullPath(), "/embed/")
+}
+
+// guestPage returns the template of the public poll page being served.
+func guestPage(c *gin.Context) string {
+	switch c.FullPath() {
+	case "/embed/:slug":
+		return "embed_vote.html"
+	case "/embed/:slug/results":
+		return "embed_results.html"
+	}
+	return "guest_vote.html"
+}
+
+// embedToken stands in for the CSRF token on embedded ballots, whose CSRF
+// cookie the browser may not send from inside a frame. It binds the form to
+// the guest's signed cookie and the poll, so other sites cannot forge it.
+func (h *GuestHandler) embedToken(slug, guestID string) string {
+	mac := hmac.New(sha256.New, h.CookieSecret)
+	mac.Write([]byte("embed:" + slug + ":" + guestID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RenderEmbedResults shows a poll's live results for framing on other
+// sites, if its creator allows it. The widget needs no JavaScript: it
+// reloads itself every ?refresh seconds (0 turns this off), ?theme picks
+// light, dark or contrast colours, ?accent=RRGGBB the colour of the bars,
+// and ?qr=1 adds a QR code of the voting link.
+func (h *GuestHandler) RenderEmbedResults(c *gin.Context) {
+	poll, settings := h.loadGuestPoll(c)
+	if poll == nil {
+		return
+	}
+	if !settings.EmbedResults {
+		c.HTML(http.StatusNotFound, "embed_results.html", gin.H{
+			"Title": "Results",
+			"Theme": embedThemes["light"],
+			"Error": "Results of this poll are not public",
+		})
+		return
+	}
+
+	theme, ok := embedThemes[c.DefaultQuery("theme", "light")]
+	if !ok {
+		theme = embedThemes["light"]
+	}
+	if accent := c.Query("accent"); hexColor.MatchString(accent) {
+		theme.Accent = "#" + accent
+	}
+	refresh := embedRefreshDefault
+	if raw := c.Query("refresh"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil {
+			refresh = n
+		}
+	}
+	if refresh != 0 {
+		refresh = min(max(refresh, embedRefreshMin), embedRefreshMax)
+	}
+
+	results, err := h.PollService.GetPollResults(c.Request.Context(), poll.ID, c.Query("lang"))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load embedded results of poll", "poll_id", poll.ID, "error", err)
+		c.HTML(http.StatusInternalServerError, "embed_results.html", gin.H{
+			"Title": "Results",
+			"Theme": theme,
+			"Error": "Could not load results",
+		})
+		return
+	}
+	// Text answers are not shown to the public, only how many there are.
+	rows := results.Rows
+	if poll.QuestionType == "text" {
+		rows = nil
+	}
+
+	data := gin.H{
+		"Title":      poll.Title,
+		"Poll":       poll,
+		"Rows":       rows,
+		"VoterCount": results.VoterCount,
+		"Theme":      theme,
+		"Refresh":    refresh,
+		"VoteLink":   "/p/" + settings.Slug,
+	}
+	if c.Query("qr") == "1" {
+		svg, err := qrcode.SVG(h.Notifications.BaseURL + "/p/" + settings.Slug)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to render QR code of poll", "poll_id", poll.ID, "error", err)
+		} else {
+			// The SVG is generated here from a URL, not taken from input.
+			data["QRCode"] = template.HTML(svg)
+		}
+	}
+	c.HTML(http.StatusOK, "embed_results.html", data)
+}
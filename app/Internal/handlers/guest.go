@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"fakidoosuurdoris/app/Internal/models"
+	"fakidoosuurdoris/app/Internal/services"
+	"fakidoosuurdoris/app/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// guestCookie identifies a browser that votes through public links. Its
+// value is a random ID and an HMAC of it, so guests cannot pick their own.
+const (
+	guestCookie       = "guest_id"
+	guestCookieMaxAge = 365 * 24 * 60 * 60
+)
+
+// GuestHandler serves the public voting pages at /p/:slug, which need no
+// account, and their embeddable versions at /embed/:slug.
+type GuestHandler struct {
+	PollService   *services.PollService
+	Notifications *services.NotificationService
+	Templates     *template.Template
+	CookieSecret  []byte
+	Cookies       config.CookieConfig
+}
+
+// NewGuestHandler returns a handler that signs guest cookies with
+// cookies.GuestSecret. Without a secret a random one is used, and guests
+// who voted before a restart are no longer recognised by their cookie.
+func NewGuestHandler(pollService *services.PollService, notifications *services.NotificationService, templates *template.Template, cookies config.CookieConfig) *GuestHandler {
+	key := []byte(cookies.GuestSecret)
+	if cookies.GuestSecret == "" {
+		slog.Warn("GUEST_COOKIE_SECRET not set, guest cookies will not survive a restart")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(fmt.Sprintf("generate guest cookie secret: %v", err))
+		}
+	}
+	return &GuestHandler{
+		PollService:   pollService,
+		Notifications: notifications,
+		Templates:     templates,
+		CookieSecret:  key,
+		Cookies:       cookies,
+	}
+}
+
+func (h *GuestHandler) signGuestID(id string) string {
+	mac := hmac.New(sha256.New, h.CookieSecret)
+	mac.Write([]byte(id))
+	return id + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// guestID returns the ID in the guest's cookie. If the cookie is missing or
+// its signature is wrong, a new ID is issued when issue is set and an empty
+// string returned otherwise.
+func (h *GuestHandler) guestID(c *gin.Context, issue bool) string {
+	if value, err := c.Cookie(guestCookie); err == nil {
+		id, _, _ := strings.Cut(value, ".")
+		if hmac.Equal([]byte(value), []byte(h.signGuestID(id))) {
+			return id
+		}
+	}
+	if !issue {
+		return ""
+	}
+	id := uuid.New().String()
+	if isEmbed(c) && (requestSecure(c) || h.Cookies.Secure) {
+		// Browsers only send cookies to framed pages if they are
+		// SameSite=None, which must also be Secure.
+		c.SetSameSite(http.SameSiteNoneMode)
+		c.SetCookie(guestCookie, h.signGuestID(id), guestCookieMaxAge, "/", h.Cookies.Domain, true, true)
+		return id
+	}
+	c.SetCookie(guestCookie, h.signGuestID(id), guestCookieMaxAge, "/", h.Cookies.Domain, h.Cookies.Secure, true)
+	return id
+}
+
+// loadGuestPoll returns the poll behind the slug in the URL, rendering an
+// error page and returning nil if there is none.
+func (h *GuestHandler) loadGuestPoll(c *gin.Context) (*models.Poll, *services.GuestSettings) {
+	poll, settings, err := h.PollService.GetPollBySlug(c.Request.Context(), c.Param("slug"))
+	if err == sql.ErrNoRows {
+		c.HTML(http.StatusNotFound, guestPage(c), gin.H{
+			"Title": "Vote",
+			"Error": "Poll not found",
+		})
+		return nil, nil
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load poll for slug", "error", err)
+		c.HTML(http.StatusInternalServerError, guestPage(c), gin.H{
+			"Title": "Vote",
+			"Error": "Could not load poll",
+		})
+		return nil, nil
+	}
+	return poll, settings
+}
+
+// renderBallot shows the voting form, or only the error if the poll cannot
+// be voted on.
+func (h *GuestHandler) renderBallot(c *gin.Context, status int, poll *models.Poll, settings *services.GuestSettings, guestID, invite, email, errMsg string) {
+	data := gin.H{
+		"Title":     "Vote",
+		"Poll":      poll,
+		"Slug":      settings.Slug,
+		"Action":    "/p/" + settings.Slug,
+		"Invite":    invite,
+		"NeedsCode": settings.EmailVerification && invite == "",
+		"Email":     email,
+		"Message":   c.Query("message"),
+		"Error":     errMsg,
+	}
+
+	now := time.Now()
+	if poll.StartDate.After(now) || (poll.EndDate != nil && poll.EndDate.Before(now)) {
+		data["Error"] = "Poll is not active"
+		c.HTML(http.StatusBadRequest, guestPage(c), data)
+		return
+	}
+	if invite == "" && settings.Access == services.GuestAccessInvite {
+		data["Error"] = "This poll can only be voted on with a personal invitation link"
+		c.HTML(http.StatusForbidden, guestPage(c), data)
+		return
+	}
+	if invite != "" {
+		valid, err := h.PollService.CheckGuestInvite(c.Request.Context(), poll.ID, invite)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to check invite for poll", "poll_id", poll.ID, "error", err)
+			data["Error"] = "Could not verify vote status"
+			c.HTML(http.StatusInternalServerError, guestPage(c), data)
+			return
+		}
+		if !valid {
+			data["Error"] = "This invitation is not valid or has already been used"
+			c.HTML(http.StatusForbidden, guestPage(c), data)
+			return
+		}
+	}
+	// Invitees vote under their invite, whoever else used the browser.
+	if invite == "" {
+		voted, err := h.PollService.HasGuestVoted(c.Request.Context(), poll.ID, guestID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to check guest vote for poll", "poll_id", poll.ID, "error", err)
+			data["Error"] = "Could not verify vote status"
+			c.HTML(http.StatusInternalServerError, guestPage(c), data)
+			return
+		}
+		if voted {
+			data["Error"] = "You have already voted"
+			c.HTML(http.StatusForbidden, guestPage(c), data)
+			return
+		}
+	}
+
+	if poll.QuestionType != "text" {
+		options, err := h.PollService.GetPollOptions(c.Request.Context(), poll.ID)
+		if err != nil {
+			data["Error"] = "Could not load poll options"
+			c.HTML(http.StatusInternalServerError, guestPage(c), data)
+			return
+		}
+		data["Options"] = services.OrderOptionsForVoter(poll, options, "guest:"+guestID)
+	}
+	csrfToken, _ := c.Get("csrf_token")
+	data["CSRFToken"] = csrfToken
+	if isEmbed(c) {
+		data["Action"] = "/embed/" + settings.Slug
+		data["EmbedToken"] = h.embedToken(settings.Slug, guestID)
+	}
+	data["ShowForm"] = true
+	c.HTML(status, guestPage(c), data)
+}
+
+// RenderGuestVote shows a public poll. Invitees arrive with ?invite=<token>.
+func (h *GuestHandler) RenderGuestVote(c *gin.Context) {
+	poll, settings := h.loadGuestPoll(c)
+	if poll == nil {
+		return
+	}
+	h.renderBallot(c, http.StatusOK, poll, settings, h.guestID(c, true), c.Query("invite"), c.Query("email"), "")
+}
+
+func (h *GuestHandler) GuestVote(c *gin.Context) {
+	poll, settings := h.loadGuestPoll(c)
+	if poll == nil {
+		return
+	}
+
+	var input struct {
+		OptionIDs  []int64 `form:"option_ids[]"`
+		TextAnswer string  `form:"text_answer"`
+		Invite     string  `form:"invite"`
+		Email      string  `form:"email"`
+		Code       string  `form:"code"`
+	}
+	if err := c.ShouldBind(&input); err != nil {
+		h.renderBallot(c, http.StatusBadRequest, poll, settings, h.guestID(c, true), input.Invite, input.Email, "Invalid vote data")
+		return
+	}
+
+	// The cookie is issued with the form, so a vote without one did not
+	// come from it.
+	guestID := h.guestID(c, false)
+	if guestID == "" {
+		h.renderBallot(c, http.StatusBadRequest, poll, settings, h.guestID(c, true), input.Invite, input.Email, "Please enable cookies to vote")
+		return
+	}
+	if isEmbed(c) && !hmac.Equal([]byte(c.PostForm("embed_token")), []byte(h.embedToken(settings.Slug, guestID))) {
+		h.renderBallot(c, http.StatusForbidden, poll, settings, guestID, input.Invite, input.Email, "This form has expired, please reload the page")
+		return
+	}
+	if msg := ballotError(poll.QuestionType, input.OptionIDs, input.TextAnswer); msg != "" {
+		h.renderBallot(c, http.StatusBadRequest, poll, settings, guestID, input.Invite, input.Email, msg)
+		return
+	}
+
+	err := h.PollService.RecordGuestVote(c.Request.Context(), services.GuestBallot{
+		PollID:      poll.ID,
+		GuestID:     guestID,
+		InviteToken: input.Invite,
+		Email:       input.Email,
+		Code:        input.Code,
+		OptionIDs:   input.OptionIDs,
+		TextAnswer:  input.TextAnswer,
+	})
+	switch {
+	case err == nil:
+		c.HTML(http.StatusOK, guestPage(c), gin.H{
+			"Title":        "Vote",
+			"Poll":         poll,
+			"Slug":         settings.Slug,
+			"EmbedResults": settings.EmbedResults,
+			"Message":      "Thank you, your vote was recorded.",
+		})
+	case errors.Is(err, services.ErrAlreadyVoted):
+		c.HTML(http.StatusForbidden, guestPage(c), gin.H{
+			"Title": "Vote",
+			"Poll":  poll,
+			"Error": "You have already voted",
+		})
+	case errors.Is(err, services.ErrGuestCode):
+		h.renderBallot(c, http.StatusBadRequest, poll, settings, guestID, input.Invite, input.Email, "The verification code is not valid or has expired")
+	case errors.Is(err, services.ErrGuestAccess):
+		h.renderBallot(c, http.StatusForbidden, poll, settings, guestID, input.Invite, input.Email, "You are not eligible to vote in this poll")
+	case err == sql.ErrNoRows:
+		h.renderBallot(c, http.StatusBadRequest, poll, settings, guestID, input.Invite, input.Email, "Your vote could not be recorded")
+	default:
+		slog.ErrorContext(c.Request.Context(), "Failed to record guest vote for poll", "poll_id", poll.ID, "error", err)
+		h.renderBallot(c, http.StatusInternalServerError, poll, settings, guestID, input.Invite, input.Email, "Failed to record vote")
+	}
+}
+
+// SendGuestCode emails a verification code to the address a guest entered.
+func (h *GuestHandler) SendGuestCode(c *gin.Context) {
+	poll, settings := h.loadGuestPoll(c)
+	if poll == nil {
+		return
+	}
+	guestID := h.guestID(c, true)
+	if !settings.EmailVerification {
+		h.renderBallot(c, http.StatusBadRequest, poll, settings, guestID, "", "", "This poll does not use email verification")
+		return
+	}
+	email := services.NormalizeGuestEmail(c.PostForm("email"))
+	if email == "" {
+		h.renderBallot(c, http.StatusBadRequest, poll, settings, guestID, "", c.PostForm("email"), "Enter a valid email address")
+		return
+	}
+
+	code, err := h.PollService.CreateGuestCode(c.Request.Context(), poll.ID, email)
+	if errors.Is(err, services.ErrGuestCodeSent) {
+		h.renderBallot(c, http.StatusTooManyRequests, poll, settings, guestID, "", email, "A code was sent less than a minute ago, please check your inbox")
+		return
+	}
+	if err == nil {
+		err = h.Notifications.SendGuestCode(c.Request.Context(), email, poll.Title, settings.Slug, code)
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to send guest code for poll", "poll_id", poll.ID, "error", err)
+		h.renderBallot(c, http.StatusInternalServerError, poll, settings, guestID, "", email, "Failed to send verification code")
+		return
+	}
+
+	query := url.Values{"email": {email}, "message": {"A verification code was sent to " + email}}
+	c.Redirect(http.StatusSeeOther, "/p/"+settings.Slug+"?"+query.Encode())
+}
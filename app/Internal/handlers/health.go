@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"fakidoosuurdoris/app/Internal/services"
+	"fakidoosuurdoris/app/Internal/version"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/sync/singleflight"
+)
+
+// readinessTimeout bounds each dependency check of /readyz.
+const readinessTimeout = 3 * time.Second
+
+// identityCheckInterval is how long the result of reaching the identity
+// provider is reused, so frequent probes do not each call an external
+// service.
+const identityCheckInterval = time.Minute
+
+// identityCheckTimeout bounds a call to the identity provider, below
+// readinessTimeout so a slow provider fails the check rather than the probe.
+const identityCheckTimeout = 2 * time.Second
+
+// HealthHandler serves the probes used by the orchestrator and the build
+// information.
+type HealthHandler struct {
+	DB          *sql.DB
+	AuthService *services.AuthService
+	draining    atomic.Bool
+
+	mu              sync.Mutex
+	identityErr     error
+	identityChecked time.Time
+	identityCall    singleflight.Group
+}
+
+func NewHealthHandler(db *sql.DB, authService *services.AuthService) *HealthHandler {
+	return &HealthHandler{DB: db, AuthService: authService}
+}
+
+// Drain makes the readiness probe fail, so no new requests are routed to
+// the server while it shuts down.
+func (h *HealthHandler) Drain() {
+	h.draining.Store(true)
+}
+
+// Healthz reports that the process is alive and serving requests.
+func (h *HealthHandler) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz reports whether the server can handle requests: the database
+// answers and the identity provider can be reached to verify sign-ins,
+// which is checked at most once per identityCheckInterval.
+func (h *HealthHandler) Readyz(c *gin.Context) {
+	if h.draining.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "shutting down"})
+		return
+	}
+
+	checks := map[string]func(context.Context) error{
+		"database": h.DB.PingContext,
+		"identity": h.pingIdentity,
+	}
+	results := gin.H{}
+	status := http.StatusOK
+	for name, check := range checks {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+		err := check(ctx)
+		cancel()
+		if err != nil {
+			slog.WarnContext(ctx, "Readiness check failed", "check", name, "error", err)
+			results[name] = "failed"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		results[name] = "ok"
+	}
+
+	overall := "ok"
+	if status != http.StatusOK {
+		overall = "unavailable"
+	}
+	c.JSON(status, gin.H{"status": overall, "checks": results})
+}
+
+// pingIdentity checks that the identity provider can be reached, at most
+// once per identityCheckInterval. Probes that find the result stale while
+// a check is running wait for that check instead of starting another, and
+// the lock is not held during the call, so none queue behind it.
+func (h *HealthHandler) pingIdentity(ctx context.Context) error {
+	h.mu.Lock()
+	err, checked := h.identityErr, h.identityChecked
+	h.mu.Unlock()
+	if time.Since(checked) < identityCheckInterval {
+		return err
+	}
+
+	// The call outlives the probe that started it, for the others waiting.
+	result := h.identityCall.DoChan("identity", func() (interface{}, error) {
+		callCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), identityCheckTimeout)
+		defer cancel()
+		err := h.AuthService.PingIdentityProvider(callCtx)
+		h.mu.Lock()
+		h.identityErr, h.identityChecked = err, time.Now()
+		h.mu.Unlock()
+		return nil, err
+	})
+	select {
+	case r := <-result:
+		return r.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Version reports the version and commit of the running build.
+func (h *HealthHandler) Version(c *gin.Context) {
+	c.JSON(http.StatusOK, version.Get())
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"fakidoosuurdoris/app/Internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const maxOptionImageSize = 5 << 20
+
+var optionImageTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// pendingOption is an option read from the poll form whose image, if any, has
+// not been stored yet.
+type pendingOption struct {
+	models.Option
+	Image *multipart.FileHeader
+}
+
+// parseOptionFields combines the parallel option fields of the create and edit
+// forms. Each row carries a key so its image upload (option_image_<key>) stays
+// attached to it after the author reorders rows. Rows without text are dropped.
+func parseOptionFields(c *gin.Context, texts, descriptions, links, images, keys []string) ([]pendingOption, error) {
+	at := func(values []string, i int) string {
+		if i < len(values) {
+			return strings.TrimSpace(values[i])
+		}
+		return ""
+	}
+
+	var options []pendingOption
+	for i := range texts {
+		text := at(texts, i)
+		if text == "" {
+			continue
+		}
+
+		link := at(links, i)
+		if link != "" {
+			u, err := url.Parse(link)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("Link for option %q must be an http or https URL", text)
+			}
+		}
+
+		option := pendingOption{Option: models.Option{
+			Text:        text,
+			Description: at(descriptions, i),
+			LinkURL:     link,
+			ImageURL:    at(images, i),
+		}}
+		if key := at(keys, i); key != "" {
+			if file, err := c.FormFile("option_image_" + key); err == nil {
+				option.Image = file
+			} else if !errors.Is(err, http.ErrMissingFile) {
+				return nil, err
+			}
+		}
+		options = append(options, option)
+	}
+	return options, nil
+}
+
+// saveOptionImages stores newly uploaded option images and returns the options
+// with their image URLs filled in. Images kept from the form must be ones
+// uploaded earlier, so a poll cannot point its options at any URL.
+func (h *PollHandler) saveOptionImages(c *gin.Context, pending []pendingOption) ([]models.Option, error) {
+	options := make([]models.Option, len(pending))
+	for i, p := range pending {
+		options[i] = p.Option
+		if p.Image == nil {
+			if p.ImageURL != "" && !h.Storage.Owns(p.ImageURL) {
+				return nil, fmt.Errorf("Image for option %q must be uploaded", p.Text)
+			}
+			continue
+		}
+		if p.Image.Size > maxOptionImageSize {
+			return nil, fmt.Errorf("Image for option %q is larger than 5 MB", p.Text)
+		}
+
+		f, err := p.Image.Open()
+		if err != nil {
+			return nil, err
+		}
+		head := make([]byte, 512)
+		n, _ := f.Read(head)
+		ext, ok := optionImageTypes[http.DetectContentType(head[:n])]
+		if !ok {
+			f.Close()
+			return nil, fmt.Errorf("Image for option %q must be PNG, JPEG, GIF or WebP", p.Text)
+		}
+		if _, err := f.Seek(0, 0); err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		imageURL, err := h.Storage.Save(c.Request.Context(), uuid.New().String()+ext, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		options[i].ImageURL = imageURL
+	}
+	return options, nil
+}
+
+// deleteUnusedImages removes images of replaced options that neither the
+// updated poll nor any copy or template of it still references.
+func (h *PollHandler) deleteUnusedImages(c *gin.Context, before, after []models.Option) {
+	kept := make(map[string]bool, len(after))
+	for _, option := range after {
+		kept[option.ImageURL] = true
+	}
+	for _, option := range before {
+		if option.ImageURL == "" || kept[option.ImageURL] {
+			continue
+		}
+		if inUse, err := h.PollService.ImageInUse(c.Request.Context(), option.ImageURL); err != nil || inUse {
+			if err != nil {
+				slog.ErrorContext(c.Request.Context(), "Failed to check use of option image", "image_url", option.ImageURL, "error", err)
+			}
+			continue
+		}
+		if err := h.Storage.Delete(c.Request.Context(), option.ImageURL); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to delete option image", "image_url", option.ImageURL, "error", err)
+		}
+	}
+}
+
+// formOptions rebuilds the option rows of a submitted form so it can be
+// shown again after a validation error.
+func formOptions(texts, descriptions, links, images []string) []models.Option {
+	at := func(values []string, i int) string {
+		if i < len(values) {
+			return values[i]
+		}
+		return ""
+	}
+
+	options := make([]models.Option, len(texts))
+	for i := range texts {
+		options[i] = models.Option{
+			Text:        texts[i],
+			Description: at(descriptions, i),
+			LinkURL:     at(links, i),
+			ImageURL:    at(images, i),
+		}
+	}
+	return options
+}
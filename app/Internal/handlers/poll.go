@@ -2,13 +2,15 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
 	"fakidoosuurdoris/app/Internal/models"
 	"fakidoosuurdoris/app/Internal/services"
+	"fakidoosuurdoris/app/Internal/storage"
+	"fmt"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -19,19 +21,25 @@ type PollHandler struct {
 	AuthService *services.AuthService
 	Templates   *template.Template
 	DB          *sql.DB
+	Storage     storage.Storage
+	// BaseURL is the configured address of the server, used for public
+	// links and QR codes rather than the Host header, which clients set.
+	BaseURL string
 }
 
-func NewPollHandler(pollService *services.PollService, authService *services.AuthService, templates *template.Template, db *sql.DB) *PollHandler {
+func NewPollHandler(pollService *services.PollService, authService *services.AuthService, templates *template.Template, db *sql.DB, store storage.Storage, baseURL string) *PollHandler {
 	return &PollHandler{
 		PollService: pollService,
 		AuthService: authService,
 		Templates:   templates,
 		DB:          db,
+		Storage:     store,
+		BaseURL:     baseURL,
 	}
 }
 
 func (h *PollHandler) RenderCreatePoll(c *gin.Context) {
-	log.Println("Showing create poll page")
+	slog.DebugContext(c.Request.Context(), "Showing create poll page")
 	csrfToken, _ := c.Get("csrf_token")
 	uid, exists := c.Get("uid")
 	var role string
@@ -49,7 +57,7 @@ func (h *PollHandler) CreatePoll(c *gin.Context) {
 
 	uid, exists := c.Get("uid")
 	if !exists {
-		log.Println("User not logged in")
+		slog.WarnContext(c.Request.Context(), "User not logged in")
 		csrfToken, _ := c.Get("csrf_token")
 		c.HTML(http.StatusUnauthorized, "createpolls.html", gin.H{
 			"Title":     "Create Poll",
@@ -60,9 +68,9 @@ func (h *PollHandler) CreatePoll(c *gin.Context) {
 	}
 
 	var role string
-	err := h.DB.QueryRow("SELECT role FROM users WHERE id = $1", uid.(string)).Scan(&role)
+	err := h.DB.QueryRowContext(c.Request.Context(), "SELECT role FROM users WHERE id = $1", uid.(string)).Scan(&role)
 	if err != nil || role != "admin" {
-		log.Printf("Unauthorized: User %s is not admin (role: %s, err: %v)", uid, role, err)
+		slog.WarnContext(c.Request.Context(), "Unauthorized: User is not admin", "user_id", uid, "role", role, "error", err)
 		csrfToken, _ := c.Get("csrf_token")
 		c.HTML(http.StatusForbidden, "createpolls.html", gin.H{
 			"Title":     "Create Poll",
@@ -79,69 +87,71 @@ func (h *PollHandler) CreatePoll(c *gin.Context) {
 		StartDate    string   `form:"start_date" binding:"required"`
 		EndDate      string   `form:"end_date"`
 		IsAnonymous  string   `form:"is_anonymous"`
+		OptionOrder  string   `form:"option_order"`
+		TemplateID   string   `form:"template_id"`
+
+		OptionDescriptions []string `form:"option_descriptions[]"`
+		OptionLinks        []string `form:"option_links[]"`
+		OptionImages       []string `form:"option_images[]"`
+		OptionKeys         []string `form:"option_keys[]"`
+
+		Repeat         string   `form:"repeat"`
+		RepeatInterval string   `form:"repeat_interval"`
+		RepeatWeekdays []string `form:"repeat_weekdays[]"`
+		RepeatEnd      string   `form:"repeat_end"`
+		RepeatUntil    string   `form:"repeat_until"`
+		RepeatCount    string   `form:"repeat_count"`
 	}
 
 	if err := c.ShouldBind(&input); err != nil {
-		log.Printf("Form binding error: %v, Form data: %+v", err, c.Request.PostForm)
+		slog.WarnContext(c.Request.Context(), "Form binding error", "error", err)
 		csrfToken, _ := c.Get("csrf_token")
 		c.HTML(http.StatusBadRequest, "createpolls.html", gin.H{
 			"Title":     "Create Poll",
 			"Error":     "Error occurred while trying to parse information",
 			"Input":     input,
+			"Options":   formOptions(input.Options, input.OptionDescriptions, input.OptionLinks, input.OptionImages),
 			"CSRFToken": csrfToken,
 		})
 		return
 	}
 
 	isAnonymous := input.IsAnonymous == "on"
-	log.Printf("Checkbox value: %q → anonymous = %v", input.IsAnonymous, isAnonymous)
-
-	var options []string
-	if input.QuestionType == "single_choice" || input.QuestionType == "multiple_choice" {
-		hasValidOption := false
-		for _, opt := range input.Options {
-			if trimmed := strings.TrimSpace(opt); trimmed != "" {
-				options = append(options, trimmed)
-				hasValidOption = true
-			}
-		}
-		if !hasValidOption {
-			log.Printf("No valid options provided for %s poll", input.QuestionType)
+	slog.DebugContext(c.Request.Context(), "Parsed anonymous checkbox", "checkbox", input.IsAnonymous, "anonymous", isAnonymous)
+
+	var options []pendingOption
+	if input.QuestionType != "text" {
+		options, err = parseOptionFields(c, input.Options, input.OptionDescriptions, input.OptionLinks, input.OptionImages, input.OptionKeys)
+		if err != nil {
 			csrfToken, _ := c.Get("csrf_token")
 			c.HTML(http.StatusBadRequest, "createpolls.html", gin.H{
 				"Title":     "Create Poll",
-				"Error":     "At least one non-empty option is required for single/multiple choice polls",
+				"Error":     err.Error(),
 				"Input":     input,
+				"Options":   formOptions(input.Options, input.OptionDescriptions, input.OptionLinks, input.OptionImages),
 				"CSRFToken": csrfToken,
 			})
 			return
 		}
-	} else if input.QuestionType == "scale" {
-		// hasValidOption := false
-		for _, opt := range input.Options {
-			if trimmed := strings.TrimSpace(opt); trimmed != "" {
-				options = append(options, trimmed)
-				// hasValidOption = true
-			}
-		}
-		// if !hasValidOption {
-		// 	log.Printf("No valid label provided for scale poll")
-		// 	csrfToken, _ := c.Get("csrf_token")
-		// 	c.HTML(http.StatusBadRequest, "createpolls.html", gin.H{
-		// 		"Title":     "Create Poll",
-		// 		"Error":     "A label (e.g., Rating) is required for scale polls",
-		// 		"Input":     input,
-		// 		"CSRFToken": csrfToken,
-		// 	})
-		// 	return
-		// }
+	}
+	if (input.QuestionType == "single_choice" || input.QuestionType == "multiple_choice") && len(options) == 0 {
+		slog.WarnContext(c.Request.Context(), "No valid options provided for poll", "question_type", input.QuestionType)
+		csrfToken, _ := c.Get("csrf_token")
+		c.HTML(http.StatusBadRequest, "createpolls.html", gin.H{
+			"Title":     "Create Poll",
+			"Error":     "At least one non-empty option is required for single/multiple choice polls",
+			"Input":     input,
+			"Options":   formOptions(input.Options, input.OptionDescriptions, input.OptionLinks, input.OptionImages),
+			"CSRFToken": csrfToken,
+		})
+		return
 	}
 
 	startDate, err := time.Parse("2006-01-02T15:04", input.StartDate)
 	if err != nil {
 		startDate, err = time.Parse("2006-01-02T15:04:05", input.StartDate+":00")
 		if err != nil {
-			log.Printf("Invalid start date: %v, Input: %s", err, input.StartDate)
+			slog.WarnContext(c.Request.Context(), "Invalid start date", "error", err, "start_date", input.StartDate)
 			csrfToken, _ := c.Get("csrf_token")
 			c.HTML(http.StatusBadRequest, "createpolls.html", gin.H{
 				"Title":     "Create Poll",
@@ -158,7 +168,7 @@ func (h *PollHandler) CreatePoll(c *gin.Context) {
 		if err != nil {
 			t, err = time.Parse("2006-01-02T15:04:05", input.EndDate+":00")
 			if err != nil {
-				log.Printf("Invalid end date: %v, Input: %s", err, input.EndDate)
+				slog.WarnContext(c.Request.Context(), "Invalid end date", "error", err, "end_date", input.EndDate)
 				csrfToken, _ := c.Get("csrf_token")
 				c.HTML(http.StatusBadRequest, "createpolls.html", gin.H{
 					"Title":     "Create Poll",
@@ -171,9 +181,61 @@ func (h *PollHandler) CreatePoll(c *gin.Context) {
 		endDate = &t
 	}
 
-	poll, err := h.PollService.CreatePoll(c.Request.Context(), input.Title, input.QuestionType, options, isAnonymous, uid.(string), startDate, endDate)
+	savedOptions, err := h.saveOptionImages(c, options)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Option image upload failed", "error", err)
+		csrfToken, _ := c.Get("csrf_token")
+		c.HTML(http.StatusBadRequest, "createpolls.html", gin.H{
+			"Title":     "Create Poll",
+			"Error":     err.Error(),
+			"Input":     input,
+			"Options":   formOptions(input.Options, input.OptionDescriptions, input.OptionLinks, input.OptionImages),
+			"CSRFToken": csrfToken,
+		})
+		return
+	}
+
+	if input.Repeat != "" {
+		rule, err := parseRecurrence(input.Repeat, input.RepeatInterval, input.RepeatWeekdays, input.RepeatEnd, input.RepeatUntil, input.RepeatCount)
+		if err == nil && endDate == nil {
+			err = fmt.Errorf("%w: recurring polls need an end date, which sets how long each occurrence stays open", services.ErrInvalidRecurrence)
+		}
+		var series *services.PollSeries
+		if err == nil {
+			series, err = h.PollService.CreateSeries(c.Request.Context(), uid.(string), services.PollSeries{
+				Title:           input.Title,
+				QuestionType:    input.QuestionType,
+				Options:         savedOptions,
+				OptionOrder:     input.OptionOrder,
+				IsAnonymous:     isAnonymous,
+				Rule:            *rule,
+				StartDate:       startDate,
+				DurationMinutes: int(endDate.Sub(startDate).Minutes()),
+			})
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Poll series creation failed", "error", err)
+			status, message := http.StatusInternalServerError, "Could not create recurring poll"
+			if errors.Is(err, services.ErrInvalidRecurrence) {
+				status, message = http.StatusBadRequest, err.Error()
+			}
+			csrfToken, _ := c.Get("csrf_token")
+			c.HTML(status, "createpolls.html", gin.H{
+				"Title":     "Create Poll",
+				"Error":     message,
+				"Input":     input,
+				"Options":   formOptions(input.Options, input.OptionDescriptions, input.OptionLinks, input.OptionImages),
+				"CSRFToken": csrfToken,
+			})
+			return
+		}
+		c.Redirect(http.StatusSeeOther, fmt.Sprintf("/series/%d", series.ID))
+		return
+	}
+
+	poll, err := h.PollService.CreatePoll(c.Request.Context(), input.Title, input.QuestionType, savedOptions, input.OptionOrder, isAnonymous, uid.(string), startDate, endDate)
 	if err != nil {
-		log.Printf("Poll creation failed: %v", err)
+		slog.ErrorContext(c.Request.Context(), "Poll creation failed", "error", err)
 		csrfToken, _ := c.Get("csrf_token")
 		c.HTML(http.StatusInternalServerError, "createpolls.html", gin.H{
 			"Title":     "Create Poll",
@@ -182,14 +244,21 @@ func (h *PollHandler) CreatePoll(c *gin.Context) {
 		})
 		return
 	}
-	log.Printf("Poll created with ID: %v", poll.ID)
-	log.Printf("Creating poll: title=%s, anonymous=%v", input.Title, isAnonymous)
+	if templateID, err := strconv.ParseInt(input.TemplateID, 10, 64); err == nil {
+		// The poll exists either way; losing the link only drops it from
+		// the template's result comparison.
+		if err := h.PollService.LinkTemplate(c.Request.Context(), poll.ID, templateID, uid.(string)); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to link poll to template", "poll_id", poll.ID, "template_id", templateID, "error", err)
+		}
+	}
+	slog.InfoContext(c.Request.Context(), "Poll created", "poll_id", poll.ID)
+	slog.DebugContext(c.Request.Context(), "Creating poll", "title", input.Title, "anonymous", isAnonymous)
 	c.Redirect(http.StatusSeeOther, "/my-polls")
 }
 
 func (h *PollHandler) RenderMyPolls(c *gin.Context) {
 	uid, exists := c.Get("uid")
-	log.Printf("RenderMyPolls: uid=%v, exists=%v", uid, exists)
+	slog.DebugContext(c.Request.Context(), "RenderMyPolls", "user_id", uid, "exists", exists)
 	if !exists {
 		c.Redirect(http.StatusSeeOther, "/login?expired=true")
 		return
@@ -198,42 +267,63 @@ func (h *PollHandler) RenderMyPolls(c *gin.Context) {
 	var role string
 	role, err := h.AuthService.GetUserRole(c.Request.Context(), uid.(string))
 	if err != nil {
-		log.Printf("Failed to fetch role: %v", err)
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch role", "error", err)
+	}
+
+	query, err := parsePollQuery(c, uid.(string))
+	if err != nil {
+		c.HTML(http.StatusBadRequest, "my_polls.html", gin.H{
+			"Title":     "My Polls",
+			"Error":     "Invalid filter",
+			"CSRFToken": c.GetString("csrf_token"),
+			"Role":      role,
+		})
+		return
 	}
+	query.CreatorID = uid.(string)
 
-	polls, err := h.PollService.GetUserPolls(c.Request.Context(), uid.(string))
+	page, err := h.PollService.ListPolls(c.Request.Context(), query)
 	if err != nil {
-		log.Printf("Failed to fetch polls: %v", err)
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch polls", "error", err)
 		c.HTML(http.StatusInternalServerError, "my_polls.html", gin.H{
+			"Title":     "My Polls",
 			"Error":     "Could not load polls",
 			"CSRFToken": c.GetString("csrf_token"),
+			"Role":      role,
 		})
 		return
 	}
+	polls := page.Polls
+
+	var pollIDs []int64
+	for _, poll := range polls {
+		if poll.QuestionType != "text" {
+			pollIDs = append(pollIDs, poll.ID)
+		}
+	}
+	options, err := h.PollService.GetOptionsForPolls(c.Request.Context(), pollIDs)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch poll options", "error", err)
+	}
 
 	pollData := make([]struct {
-		Poll    models.Poll
+		Poll    services.PollListItem
 		Options []models.Option
 	}, len(polls))
 	for i, poll := range polls {
 		pollData[i].Poll = poll
-		if poll.QuestionType != "text" {
-			options, err := h.PollService.GetPollOptions(c.Request.Context(), poll.ID)
-			if err != nil {
-				log.Printf("Failed to fetch options for poll %d: %v", poll.ID, err)
-				pollData[i].Options = nil
-			} else {
-				pollData[i].Options = options
-			}
-		}
+		pollData[i].Options = options[poll.ID]
 	}
 
 	csrfToken, _ := c.Get("csrf_token")
 	c.HTML(http.StatusOK, "my_polls.html", gin.H{
-		"Title":     "My Polls",
-		"Polls":     pollData,
-		"CSRFToken": csrfToken,
-		"Role":      role,
+		"Title":        "My Polls",
+		"Polls":        pollData,
+		"CSRFToken":    csrfToken,
+		"Role":         role,
+		"FilterAction": "/my-polls",
+		"Filters":      c.Request.URL.Query(),
+		"NextURL":      nextPageURL(c, page.NextCursor),
 	})
 }
 
@@ -241,35 +331,35 @@ func (h *PollHandler) RenderEditPoll(c *gin.Context) {
 	pollIDStr := c.Param("id")
 	pollID, err := strconv.ParseInt(pollIDStr, 10, 64)
 	if err != nil {
-		log.Printf("Invalid poll ID: %v", err)
+		slog.WarnContext(c.Request.Context(), "Invalid poll ID", "error", err)
 		c.HTML(http.StatusBadRequest, "home.html", gin.H{"Error": "Invalid poll ID"})
 		return
 	}
-	log.Printf("Rendering edit poll page for poll ID: %d", pollID)
+	slog.DebugContext(c.Request.Context(), "Rendering edit poll page for poll ID", "poll_id", pollID)
 
 	poll, err := h.PollService.GetPoll(c.Request.Context(), pollID)
 	if err != nil {
-		log.Printf("Poll not found: %v", err)
+		slog.WarnContext(c.Request.Context(), "Poll not found", "error", err)
 		c.HTML(http.StatusNotFound, "home.html", gin.H{"Error": "Poll not found"})
 		return
 	}
 
 	uid, exists := c.Get("uid")
 	if !exists {
-		log.Println("User not logged in")
+		slog.WarnContext(c.Request.Context(), "User not logged in")
 		c.HTML(http.StatusUnauthorized, "home.html", gin.H{"Error": "Please log in"})
 		return
 	}
 	if poll.UserID != uid.(string) {
-		log.Printf("Unauthorized access attempt by user %s for poll %d", uid.(string), pollID)
+		slog.WarnContext(c.Request.Context(), "Unauthorized access attempt by user for poll", "user_id", uid.(string), "poll_id", pollID)
 		c.HTML(http.StatusForbidden, "home.html", gin.H{"Error": "Unauthorized"})
 		return
 	}
 
 	var role string
-	err = h.DB.QueryRow("SELECT role FROM users WHERE id = $1", uid.(string)).Scan(&role)
+	err = h.DB.QueryRowContext(c.Request.Context(), "SELECT role FROM users WHERE id = $1", uid.(string)).Scan(&role)
 	if err != nil || role != "admin" {
-		log.Printf("Unauthorized: User %s is not admin (role: %s, err: %v)", uid, role, err)
+		slog.WarnContext(c.Request.Context(), "Unauthorized: User is not admin", "user_id", uid, "role", role, "error", err)
 		c.HTML(http.StatusForbidden, "home.html", gin.H{
 			"Error": "Admin access required",
 		})
@@ -278,7 +368,7 @@ func (h *PollHandler) RenderEditPoll(c *gin.Context) {
 
 	options, err := h.PollService.GetPollOptions(c.Request.Context(), pollID)
 	if err != nil {
-		log.Printf("Failed to fetch options for poll %d: %v", pollID, err)
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch options for poll", "poll_id", pollID, "error", err)
 		csrfToken, _ := c.Get("csrf_token")
 		c.HTML(http.StatusInternalServerError, "edit_xpoll.html", gin.H{
 			"Title":     "Edit Poll",
@@ -290,7 +380,7 @@ func (h *PollHandler) RenderEditPoll(c *gin.Context) {
 	}
 
 	csrfToken, _ := c.Get("csrf_token")
-	log.Println("Rendering editpoll.html")
+	slog.DebugContext(c.Request.Context(), "Rendering editpoll.html")
 	c.HTML(http.StatusOK, "edit_poll.html", gin.H{
 		"Title":     "Edit Poll",
 		"Poll":      poll,
@@ -303,7 +393,7 @@ func (h *PollHandler) UpdatePoll(c *gin.Context) {
 	pollIDStr := c.Param("id")
 	pollID, err := strconv.ParseInt(pollIDStr, 10, 64)
 	if err != nil {
-		log.Printf("Invalid poll ID: %v", err)
+		slog.WarnContext(c.Request.Context(), "Invalid poll ID", "error", err)
 		csrfToken, _ := c.Get("csrf_token")
 		c.HTML(http.StatusBadRequest, "edit_poll.html", gin.H{
 			"Title":     "Edit Poll",
@@ -315,7 +405,7 @@ func (h *PollHandler) UpdatePoll(c *gin.Context) {
 
 	poll, err := h.PollService.GetPoll(c.Request.Context(), pollID)
 	if err != nil {
-		log.Printf("Poll not found: %v", err)
+		slog.WarnContext(c.Request.Context(), "Poll not found", "error", err)
 		csrfToken, _ := c.Get("csrf_token")
 		c.HTML(http.StatusNotFound, "edit_poll.html", gin.H{
 			"Title":     "Edit Poll",
@@ -327,7 +417,7 @@ func (h *PollHandler) UpdatePoll(c *gin.Context) {
 
 	uid, exists := c.Get("uid")
 	if !exists {
-		log.Println("User not logged in")
+		slog.WarnContext(c.Request.Context(), "User not logged in")
 		csrfToken, _ := c.Get("csrf_token")
 		c.HTML(http.StatusUnauthorized, "edit_poll.html", gin.H{
 			"Title":     "Edit Poll",
@@ -338,7 +428,7 @@ func (h *PollHandler) UpdatePoll(c *gin.Context) {
 		return
 	}
 	if poll.UserID != uid.(string) {
-		log.Printf("Unauthorized access attempt by user %s for poll %d", uid.(string), pollID)
+		slog.WarnContext(c.Request.Context(), "Unauthorized access attempt by user for poll", "user_id", uid.(string), "poll_id", pollID)
 		csrfToken, _ := c.Get("csrf_token")
 		c.HTML(http.StatusForbidden, "edit_poll.html", gin.H{
 			"Title":     "Edit Poll",
@@ -350,9 +440,9 @@ func (h *PollHandler) UpdatePoll(c *gin.Context) {
 	}
 
 	var role string
-	err = h.DB.QueryRow("SELECT role FROM users WHERE id = $1", uid.(string)).Scan(&role)
+	err = h.DB.QueryRowContext(c.Request.Context(), "SELECT role FROM users WHERE id = $1", uid.(string)).Scan(&role)
 	if err != nil || role != "admin" {
-		log.Printf("Unauthorized: User %s is not admin (role: %s, err: %v)", uid, role, err)
+		slog.WarnContext(c.Request.Context(), "Unauthorized: User is not admin", "user_id", uid, "role", role, "error", err)
 		csrfToken, _ := c.Get("csrf_token")
 		c.HTML(http.StatusForbidden, "edit_poll.html", gin.H{
 			"Title":     "Edit Poll",
@@ -370,10 +460,16 @@ func (h *PollHandler) UpdatePoll(c *gin.Context) {
 		StartDate    string   `form:"start_date" binding:"required"`
 		EndDate      string   `form:"end_date"`
 		IsAnonymous  string   `form:"is_anonymous"`
+		OptionOrder  string   `form:"option_order"`
+
+		OptionDescriptions []string `form:"option_descriptions[]"`
+		OptionLinks        []string `form:"option_links[]"`
+		OptionImages       []string `form:"option_images[]"`
+		OptionKeys         []string `form:"option_keys[]"`
 	}
 
 	if err := c.ShouldBind(&input); err != nil {
-		log.Printf("Form binding error: %v, Form data: %+v", err, c.Request.PostForm)
+		slog.WarnContext(c.Request.Context(), "Form binding error", "error", err)
 		csrfToken, _ := c.Get("csrf_token")
 		c.HTML(http.StatusBadRequest, "edit_poll.html", gin.H{
 			"Title":     "Edit Poll",
@@ -387,23 +483,16 @@ func (h *PollHandler) UpdatePoll(c *gin.Context) {
 	}
 
 	isAnonymous := input.IsAnonymous == "on"
-	log.Printf("Checkbox value: %q → anonymous = %v", input.IsAnonymous, isAnonymous)
-
-	var options []string
-	if input.QuestionType == "single_choice" || input.QuestionType == "multiple_choice" {
-		hasValidOption := false
-		for _, opt := range input.Options {
-			if trimmed := strings.TrimSpace(opt); trimmed != "" {
-				options = append(options, trimmed)
-				hasValidOption = true
-			}
-		}
-		if !hasValidOption {
-			log.Printf("No valid options provided for %s poll", input.QuestionType)
+	slog.DebugContext(c.Request.Context(), "Parsed anonymous checkbox", "checkbox", input.IsAnonymous, "anonymous", isAnonymous)
+
+	var options []pendingOption
+	if input.QuestionType != "text" {
+		options, err = parseOptionFields(c, input.Options, input.OptionDescriptions, input.OptionLinks, input.OptionImages, input.OptionKeys)
+		if err != nil {
 			csrfToken, _ := c.Get("csrf_token")
 			c.HTML(http.StatusBadRequest, "edit_poll.html", gin.H{
 				"Title":     "Edit Poll",
-				"Error":     "At least one non-empty option is required for single/multiple choice polls",
+				"Error":     err.Error(),
 				"Input":     input,
 				"Poll":      poll,
 				"Options":   input.Options,
@@ -411,22 +500,26 @@ func (h *PollHandler) UpdatePoll(c *gin.Context) {
 			})
 			return
 		}
-	} else if input.QuestionType == "scale" {
-		//hasValidOption := false
-		for _, opt := range input.Options {
-			if trimmed := strings.TrimSpace(opt); trimmed != "" {
-				options = append(options, trimmed)
-				//hasValidOption = true
-			}
-		}
-
+	}
+	if (input.QuestionType == "single_choice" || input.QuestionType == "multiple_choice") && len(options) == 0 {
+		slog.WarnContext(c.Request.Context(), "No valid options provided for poll", "question_type", input.QuestionType)
+		csrfToken, _ := c.Get("csrf_token")
+		c.HTML(http.StatusBadRequest, "edit_poll.html", gin.H{
+			"Title":     "Edit Poll",
+			"Error":     "At least one non-empty option is required for single/multiple choice polls",
+			"Input":     input,
+			"Poll":      poll,
+			"Options":   input.Options,
+			"CSRFToken": csrfToken,
+		})
+		return
 	}
 
 	startDate, err := time.Parse("2006-01-02T15:04", input.StartDate)
 	if err != nil {
 		startDate, err = time.Parse("2006-01-02T15:04:05", input.StartDate+":00")
 		if err != nil {
-			log.Printf("Invalid start date: %v, Input: %s", err, input.StartDate)
+			slog.WarnContext(c.Request.Context(), "Invalid start date", "error", err, "start_date", input.StartDate)
 			csrfToken, _ := c.Get("csrf_token")
 			c.HTML(http.StatusBadRequest, "edit_poll.html", gin.H{
 				"Title":     "Edit Poll",
@@ -446,7 +539,7 @@ func (h *PollHandler) UpdatePoll(c *gin.Context) {
 		if err != nil {
 			t, err = time.Parse("2006-01-02T15:04:05", input.EndDate+":00")
 			if err != nil {
-				log.Printf("Invalid end date: %v, Input: %s", err, input.EndDate)
+				slog.WarnContext(c.Request.Context(), "Invalid end date", "error", err, "end_date", input.EndDate)
 				csrfToken, _ := c.Get("csrf_token")
 				c.HTML(http.StatusBadRequest, "edit_poll.html", gin.H{
 					"Title":     "Edit Poll",
@@ -462,9 +555,29 @@ func (h *PollHandler) UpdatePoll(c *gin.Context) {
 		endDate = &t
 	}
 
-	err = h.PollService.UpdatePoll(c.Request.Context(), pollID, input.Title, input.QuestionType, options, startDate, endDate, isAnonymous, uid.(string))
+	oldOptions, err := h.PollService.GetPollOptions(c.Request.Context(), pollID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch options for poll", "poll_id", pollID, "error", err)
+	}
+
+	savedOptions, err := h.saveOptionImages(c, options)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Option image upload failed", "error", err)
+		csrfToken, _ := c.Get("csrf_token")
+		c.HTML(http.StatusBadRequest, "edit_poll.html", gin.H{
+			"Title":     "Edit Poll",
+			"Error":     err.Error(),
+			"Input":     input,
+			"Poll":      poll,
+			"Options":   input.Options,
+			"CSRFToken": csrfToken,
+		})
+		return
+	}
+
+	err = h.PollService.UpdatePoll(c.Request.Context(), pollID, input.Title, input.QuestionType, savedOptions, input.OptionOrder, startDate, endDate, isAnonymous, uid.(string))
 	if err != nil {
-		log.Printf("Poll update failed: %v", err)
+		slog.ErrorContext(c.Request.Context(), "Poll update failed", "error", err)
 		csrfToken, _ := c.Get("csrf_token")
 		c.HTML(http.StatusInternalServerError, "edit_poll.html", gin.H{
 			"Title":     "Edit Poll",
@@ -476,7 +589,8 @@ func (h *PollHandler) UpdatePoll(c *gin.Context) {
 		})
 		return
 	}
-	log.Printf("Updating poll: title=%s, anonymous=%v", input.Title, isAnonymous)
+	slog.DebugContext(c.Request.Context(), "Updating poll", "title", input.Title, "anonymous", isAnonymous)
+	h.deleteUnusedImages(c, oldOptions, savedOptions)
 
 	c.Redirect(http.StatusSeeOther, "/my-polls")
 }
@@ -485,7 +599,7 @@ func (h *PollHandler) DeletePoll(c *gin.Context) {
 	pollIDStr := c.Param("id")
 	pollID, err := strconv.ParseInt(pollIDStr, 10, 64)
 	if err != nil {
-		log.Printf("Invalid poll ID: %v", err)
+		slog.WarnContext(c.Request.Context(), "Invalid poll ID", "error", err)
 		c.HTML(http.StatusBadRequest, "my_polls.html", gin.H{
 			"Error":     "Invalid poll ID",
 			"CSRFToken": c.GetString("csrf_token"),
@@ -503,9 +617,9 @@ func (h *PollHandler) DeletePoll(c *gin.Context) {
 	}
 
 	var role string
-	err = h.DB.QueryRow("SELECT role FROM users WHERE id = $1", uid.(string)).Scan(&role)
+	err = h.DB.QueryRowContext(c.Request.Context(), "SELECT role FROM users WHERE id = $1", uid.(string)).Scan(&role)
 	if err != nil || role != "admin" {
-		log.Printf("Unauthorized: User %s is not admin (role: %s, err: %v)", uid, role, err)
+		slog.WarnContext(c.Request.Context(), "Unauthorized: User is not admin", "user_id", uid, "role", role, "error", err)
 		c.HTML(http.StatusForbidden, "my_polls.html", gin.H{
 			"Error":     "Admin access required",
 			"CSRFToken": c.GetString("csrf_token"),
@@ -515,7 +629,7 @@ func (h *PollHandler) DeletePoll(c *gin.Context) {
 
 	err = h.PollService.DeletePoll(c.Request.Context(), pollID, uid.(string))
 	if err != nil {
-		log.Printf("Poll deletion failed: %v", err)
+		slog.ErrorContext(c.Request.Context(), "Poll deletion failed", "error", err)
 		c.HTML(http.StatusBadRequest, "my_polls.html", gin.H{
 			"Error":     "Could not delete poll",
 			"CSRFToken": c.GetString("csrf_token"),
@@ -532,24 +646,130 @@ func (h *PollHandler) RenderPollsList(c *gin.Context) {
 		return
 	}
 
-	polls, err := h.PollService.GetAllPolls(c.Request.Context())
+	role, err := h.AuthService.GetUserRole(c.Request.Context(), uid.(string))
 	if err != nil {
-		log.Printf("Failed to fetch polls: %v", err)
-		c.HTML(http.StatusInternalServerError, "polls_list.html", gin.H{
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch role", "error", err)
+	}
+
+	query, err := parsePollQuery(c, uid.(string))
+	if err != nil {
+		c.HTML(http.StatusBadRequest, "polls_list.html", gin.H{
 			"Title": "Polls List",
-			"Error": "Could not load polls",
+			"Error": "Invalid filter",
+			"Role":  role,
 		})
 		return
 	}
 
-	role, err := h.AuthService.GetUserRole(c.Request.Context(), uid.(string))
+	page, err := h.PollService.ListPolls(c.Request.Context(), query)
 	if err != nil {
-		log.Printf("Failed to fetch role: %v", err)
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch polls", "error", err)
+		c.HTML(http.StatusInternalServerError, "polls_list.html", gin.H{
+			"Title": "Polls List",
+			"Error": "Could not load polls",
+			"Role":  role,
+		})
+		return
 	}
 
 	c.HTML(http.StatusOK, "polls_list.html", gin.H{
-		"Title": "Polls List",
-		"Polls": polls,
-		"Role":  role,
+		"Title":        "Polls List",
+		"Polls":        page.Polls,
+		"Role":         role,
+		"FilterAction": "/polls-list",
+		"Filters":      c.Request.URL.Query(),
+		"NextURL":      nextPageURL(c, page.NextCursor),
 	})
 }
+
+func (h *PollHandler) ListPolls(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Please log in"})
+		return
+	}
+
+	query, err := parsePollQuery(c, uid.(string))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := h.PollService.ListPolls(c.Request.Context(), query)
+	if errors.Is(err, services.ErrInvalidPollQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch polls", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load polls"})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// parsePollQuery reads listing filters from the query string:
+// q, status, type, creator, anonymous, voted, from, to (YYYY-MM-DD),
+// sort, order (asc|desc, default desc), limit and after.
+func parsePollQuery(c *gin.Context, viewerID string) (services.PollQuery, error) {
+	query := services.PollQuery{
+		Search:    c.Query("q"),
+		Status:    c.Query("status"),
+		Type:      c.Query("type"),
+		CreatorID: c.Query("creator"),
+		ViewerID:  viewerID,
+		Sort:      c.Query("sort"),
+		Desc:      c.DefaultQuery("order", "desc") == "desc",
+		After:     c.Query("after"),
+	}
+
+	for _, f := range []struct {
+		name string
+		dst  **bool
+	}{{"anonymous", &query.Anonymous}, {"voted", &query.Voted}} {
+		if v := c.Query(f.name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return query, fmt.Errorf("%w: %s must be true or false", services.ErrInvalidPollQuery, f.name)
+			}
+			*f.dst = &b
+		}
+	}
+
+	if v := c.Query("from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return query, fmt.Errorf("%w: from must be YYYY-MM-DD", services.ErrInvalidPollQuery)
+		}
+		query.From = &t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return query, fmt.Errorf("%w: to must be YYYY-MM-DD", services.ErrInvalidPollQuery)
+		}
+		t = t.AddDate(0, 0, 1)
+		query.To = &t
+	}
+
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return query, fmt.Errorf("%w: limit must be a positive number", services.ErrInvalidPollQuery)
+		}
+		query.Limit = limit
+	}
+	return query, nil
+}
+
+// nextPageURL returns the current URL with its after parameter set to cursor,
+// or "" when there is no next page.
+func nextPageURL(c *gin.Context, cursor string) string {
+	if cursor == "" {
+		return ""
+	}
+	values := c.Request.URL.Query()
+	values.Set("after", cursor)
+	return c.Request.URL.Path + "?" + values.Encode()
+}
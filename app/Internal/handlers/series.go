@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"fakidoosuurdoris/app/Internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseRecurrence reads the repeat fields of the create poll form.
+func parseRecurrence(frequency, interval string, weekdays []string, end, until, count string) (*services.RecurrenceRule, error) {
+	rule := &services.RecurrenceRule{Frequency: frequency, Interval: 1}
+	if interval != "" {
+		n, err := strconv.Atoi(interval)
+		if err != nil {
+			return nil, fmt.Errorf("%w: repeat interval must be a number", services.ErrInvalidRecurrence)
+		}
+		rule.Interval = n
+	}
+	if frequency == services.FrequencyWeekly {
+		for _, day := range weekdays {
+			n, err := strconv.Atoi(day)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid weekday", services.ErrInvalidRecurrence)
+			}
+			rule.Weekdays = append(rule.Weekdays, time.Weekday(n))
+		}
+	}
+
+	switch end {
+	case "until":
+		t, err := time.Parse("2006-01-02", until)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid repeat end date", services.ErrInvalidRecurrence)
+		}
+		// The end date is inclusive.
+		t = t.Add(24*time.Hour - time.Second)
+		rule.Until = &t
+	case "count":
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return nil, fmt.Errorf("%w: number of occurrences must be a number", services.ErrInvalidRecurrence)
+		}
+		rule.Count = &n
+	}
+	return rule, rule.Validate()
+}
+
+func (h *PollHandler) RenderSeriesList(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login?expired=true")
+		return
+	}
+
+	role, err := h.AuthService.GetUserRole(c.Request.Context(), uid.(string))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch role", "error", err)
+	}
+
+	list, err := h.PollService.ListSeries(c.Request.Context(), uid.(string))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to list poll series", "error", err)
+		c.HTML(http.StatusInternalServerError, "poll_series.html", gin.H{
+			"Title": "Recurring Polls",
+			"Error": "Could not load recurring polls",
+			"Role":  role,
+		})
+		return
+	}
+
+	csrfToken, _ := c.Get("csrf_token")
+	c.HTML(http.StatusOK, "poll_series.html", gin.H{
+		"Title":      "Recurring Polls",
+		"SeriesList": list,
+		"CSRFToken":  csrfToken,
+		"Role":       role,
+	})
+}
+
+func (h *PollHandler) RenderSeries(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login?expired=true")
+		return
+	}
+
+	role, err := h.AuthService.GetUserRole(c.Request.Context(), uid.(string))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch role", "error", err)
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.HTML(http.StatusBadRequest, "poll_series.html", gin.H{
+			"Title": "Recurring Polls",
+			"Error": "Invalid series ID",
+			"Role":  role,
+		})
+		return
+	}
+
+	series, occurrences, err := h.PollService.SeriesTrend(c.Request.Context(), id, uid.(string))
+	if err != nil {
+		status, message := http.StatusInternalServerError, "Could not load recurring poll"
+		if err == sql.ErrNoRows {
+			status, message = http.StatusNotFound, "Recurring poll not found"
+		} else {
+			slog.ErrorContext(c.Request.Context(), "Failed to load series", "series_id", id, "error", err)
+		}
+		c.HTML(status, "poll_series.html", gin.H{
+			"Title": "Recurring Polls",
+			"Error": message,
+			"Role":  role,
+		})
+		return
+	}
+
+	// Scale averages are drawn as bars relative to the 1-5 scale; choice
+	// results as each option's share of the occurrence's votes.
+	type trendRow struct {
+		services.SeriesOccurrence
+		ScalePercent float64
+		Shares       []float64
+	}
+	rows := make([]trendRow, len(occurrences))
+	for i, o := range occurrences {
+		rows[i].SeriesOccurrence = o
+		if o.AverageScale != nil {
+			rows[i].ScalePercent = *o.AverageScale / 5 * 100
+		}
+		total := 0
+		for _, count := range o.OptionCounts {
+			total += count
+		}
+		for _, option := range series.Options {
+			share := 0.0
+			if total > 0 {
+				share = float64(o.OptionCounts[option.Text]) / float64(total) * 100
+			}
+			rows[i].Shares = append(rows[i].Shares, share)
+		}
+	}
+
+	csrfToken, _ := c.Get("csrf_token")
+	c.HTML(http.StatusOK, "poll_series.html", gin.H{
+		"Title":       "Recurring Polls",
+		"Series":      series,
+		"Rule":        series.Rule.Describe(),
+		"Occurrences": rows,
+		"CSRFToken":   csrfToken,
+		"Role":        role,
+	})
+}
+
+func (h *PollHandler) SetSeriesActive(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login?expired=true")
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/series")
+		return
+	}
+
+	if err := h.PollService.SetSeriesActive(c.Request.Context(), id, uid.(string), c.PostForm("active") == "true"); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to update series", "series_id", id, "error", err)
+	}
+	c.Redirect(http.StatusSeeOther, fmt.Sprintf("/series/%d", id))
+}
+
+func (h *PollHandler) SeriesTrendAPI(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Please log in"})
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid series ID"})
+		return
+	}
+
+	series, occurrences, err := h.PollService.SeriesTrend(c.Request.Context(), id, uid.(string))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Series not found"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load series", "series_id", id, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load series"})
+		return
+	}
+	if occurrences == nil {
+		occurrences = []services.SeriesOccurrence{}
+	}
+	c.JSON(http.StatusOK, gin.H{"series": series, "occurrences": occurrences})
+}
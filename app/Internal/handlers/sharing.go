@@ -0,0 +1,429 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"fakidoosuurdoris/app/Internal/export"
+	"fakidoosuurdoris/app/Internal/models"
+	"fakidoosuurdoris/app/Internal/qrcode"
+	"fakidoosuurdoris/app/Internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// inviteAuditLimit is the number of audit entries shown on the sharing page.
+const inviteAuditLimit = 100
+
+func sharingURL(pollID int64, message string) string {
+	return fmt.Sprintf("/polls/%d/sharing?message=%s", pollID, url.QueryEscape(message))
+}
+
+// RenderSharing shows a poll's public link and invites to its creator and
+// admins.
+func (h *PollHandler) RenderSharing(c *gin.Context) {
+	if _, exists := c.Get("uid"); !exists {
+		c.Redirect(http.StatusSeeOther, "/login?expired=true")
+		return
+	}
+	pollID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.HTML(http.StatusBadRequest, "poll_sharing.html", gin.H{
+			"Title": "Sharing",
+			"Error": "Invalid poll ID",
+		})
+		return
+	}
+	h.renderSharing(c, http.StatusOK, pollID, nil, "", "")
+}
+
+// renderSharing shows the sharing page. created are new invites whose links
+// are shown once; message defaults to the message query parameter.
+func (h *PollHandler) renderSharing(c *gin.Context, status int, pollID int64, created []services.GuestInvite, message, errMsg string) {
+	if message == "" {
+		message = c.Query("message")
+	}
+	uid := c.GetString("uid")
+	role, err := h.AuthService.GetUserRole(c.Request.Context(), uid)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch role", "error", err)
+	}
+
+	invites, err := h.PollService.ListGuestInvites(c.Request.Context(), pollID, uid)
+	if err == sql.ErrNoRows {
+		c.HTML(http.StatusNotFound, "poll_sharing.html", gin.H{
+			"Title": "Sharing",
+			"Error": "Poll not found",
+			"Role":  role,
+		})
+		return
+	}
+	var poll *models.Poll
+	var settings *services.GuestSettings
+	if err == nil {
+		poll, err = h.PollService.GetPoll(c.Request.Context(), pollID)
+	}
+	if err == nil {
+		settings, err = h.PollService.GetGuestSettings(c.Request.Context(), pollID)
+	}
+	var audit []services.InviteAuditEntry
+	if err == nil {
+		audit, err = h.PollService.ListInviteAudit(c.Request.Context(), pollID, uid, inviteAuditLimit)
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load sharing settings of poll", "poll_id", pollID, "error", err)
+		c.HTML(http.StatusInternalServerError, "poll_sharing.html", gin.H{
+			"Title": "Sharing",
+			"Error": "Could not load sharing settings",
+			"Role":  role,
+		})
+		return
+	}
+
+	csrfToken, _ := c.Get("csrf_token")
+	c.HTML(status, "poll_sharing.html", gin.H{
+		"Title":     "Sharing",
+		"Poll":      poll,
+		"Settings":  settings,
+		"Invites":   invites,
+		"Created":   created,
+		"Audit":     audit,
+		"BaseURL":   h.BaseURL,
+		"Error":     errMsg,
+		"Message":   message,
+		"CSRFToken": csrfToken,
+		"Role":      role,
+	})
+}
+
+// sharingPollID parses the poll ID of a sharing form, rendering an error
+// page if it is invalid.
+func (h *PollHandler) sharingPollID(c *gin.Context) (int64, bool) {
+	if _, exists := c.Get("uid"); !exists {
+		c.HTML(http.StatusUnauthorized, "poll_sharing.html", gin.H{
+			"Title": "Sharing",
+			"Error": "Please log in",
+		})
+		return 0, false
+	}
+	pollID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.HTML(http.StatusBadRequest, "poll_sharing.html", gin.H{
+			"Title": "Sharing",
+			"Error": "Invalid poll ID",
+		})
+		return 0, false
+	}
+	return pollID, true
+}
+
+func (h *PollHandler) UpdateSharing(c *gin.Context) {
+	pollID, ok := h.sharingPollID(c)
+	if !ok {
+		return
+	}
+
+	_, err := h.PollService.UpdateGuestSettings(c.Request.Context(), pollID, c.GetString("uid"), c.PostForm("enabled") == "on", services.GuestSettings{
+		Access:            c.PostForm("access"),
+		EmailVerification: c.PostForm("email_verification") == "on",
+		EmbedResults:      c.PostForm("embed_results") == "on",
+	})
+	if errors.Is(err, services.ErrInvalidGuest) {
+		h.renderSharing(c, http.StatusBadRequest, pollID, nil, "", "Choose who may vote through the link")
+		return
+	}
+	if err == sql.ErrNoRows {
+		h.renderSharing(c, http.StatusNotFound, pollID, nil, "", "Poll not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to update sharing settings of poll", "poll_id", pollID, "error", err)
+		h.renderSharing(c, http.StatusInternalServerError, pollID, nil, "", "Failed to update sharing settings")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, sharingURL(pollID, "Sharing settings updated"))
+}
+
+// PollQRCode serves a QR code of the poll's voting link as a PNG image, or
+// as SVG with ?format=svg. ?size sets the PNG's width in pixels and
+// ?download=1 offers the image as a file.
+func (h *PollHandler) PollQRCode(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	pollID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid poll ID"})
+		return
+	}
+	format := c.DefaultQuery("format", "png")
+	if format != "png" && format != "svg" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Format must be png or svg"})
+		return
+	}
+	size := qrcode.DefaultSize
+	if raw := c.Query("size"); raw != "" {
+		if size, err = strconv.Atoi(raw); err != nil || size < 64 || size > qrcode.MaxSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Size must be between 64 and %d", qrcode.MaxSize)})
+			return
+		}
+	}
+
+	path, err := h.PollService.VotePath(c.Request.Context(), pollID, uid.(string))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Poll not found"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load voting link of poll", "poll_id", pollID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load voting link"})
+		return
+	}
+
+	var image []byte
+	contentType := "image/png"
+	if format == "svg" {
+		image, err = qrcode.SVG(h.BaseURL + path)
+		contentType = "image/svg+xml"
+	} else {
+		image, err = qrcode.PNG(h.BaseURL+path, size)
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to render QR code of poll", "poll_id", pollID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render QR code"})
+		return
+	}
+	// The code changes when the public link is replaced.
+	c.Header("Cache-Control", "private, no-cache")
+	if c.Query("download") == "1" {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="poll-%d-qr.%s"`, pollID, format))
+	}
+	c.Data(http.StatusOK, contentType, image)
+}
+
+// RotateSharingLink replaces the public link, so the old one stops working.
+func (h *PollHandler) RotateSharingLink(c *gin.Context) {
+	pollID, ok := h.sharingPollID(c)
+	if !ok {
+		return
+	}
+
+	err := h.PollService.RotateGuestSlug(c.Request.Context(), pollID, c.GetString("uid"))
+	if err == sql.ErrNoRows {
+		h.renderSharing(c, http.StatusBadRequest, pollID, nil, "", "Public link voting is not enabled")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to rotate public link of poll", "poll_id", pollID, "error", err)
+		h.renderSharing(c, http.StatusInternalServerError, pollID, nil, "", "Failed to replace link")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, sharingURL(pollID, "A new link was created, the old one no longer works"))
+}
+
+// CreateInvites creates one invite per line of the invitees field, or count
+// unlabeled ones. With delivery=csv the new codes are downloaded for a mail
+// merge, with delivery=email those with an address are emailed, and
+// otherwise the links are shown once.
+func (h *PollHandler) CreateInvites(c *gin.Context) {
+	pollID, ok := h.sharingPollID(c)
+	if !ok {
+		return
+	}
+
+	var labels []string
+	for _, line := range strings.Split(c.PostForm("invitees"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			labels = append(labels, line)
+		}
+	}
+	if len(labels) == 0 && c.PostForm("count") != "" {
+		count, err := strconv.Atoi(c.PostForm("count"))
+		if err != nil || count < 0 {
+			h.renderSharing(c, http.StatusBadRequest, pollID, nil, "", "Number of codes must be a positive number")
+			return
+		}
+		if count <= services.MaxGuestInvites {
+			labels = make([]string, count)
+		}
+	}
+
+	ctx := c.Request.Context()
+	uid := c.GetString("uid")
+	invites, err := h.PollService.CreateGuestInvites(ctx, pollID, uid, labels)
+	if errors.Is(err, services.ErrInvalidGuest) {
+		h.renderSharing(c, http.StatusBadRequest, pollID, nil, "", fmt.Sprintf("Enter one invitee per line or a number of codes, at most %d at once", services.MaxGuestInvites))
+		return
+	}
+	if err == sql.ErrNoRows {
+		h.renderSharing(c, http.StatusNotFound, pollID, nil, "", "Poll not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create invites for poll", "poll_id", pollID, "error", err)
+		h.renderSharing(c, http.StatusInternalServerError, pollID, nil, "", "Failed to create invites")
+		return
+	}
+
+	switch c.PostForm("delivery") {
+	case "csv":
+		settings, err := h.PollService.GetGuestSettings(ctx, pollID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load sharing settings of poll", "poll_id", pollID, "error", err)
+			h.renderSharing(c, http.StatusOK, pollID, invites, "", "Invites were created but could not be downloaded")
+			return
+		}
+		linkBase := ""
+		if settings.Slug != "" {
+			linkBase = h.BaseURL + "/p/" + settings.Slug
+		}
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=poll_%d_invites.csv", pollID))
+		c.Status(http.StatusOK)
+		if err := export.WriteInvitesCSV(c.Writer, invites, linkBase); err != nil {
+			slog.ErrorContext(ctx, "Failed to export invites of poll", "poll_id", pollID, "error", err)
+		}
+	case "email":
+		ids := make([]int64, 0, len(invites))
+		for _, invite := range invites {
+			if invite.Email != "" {
+				ids = append(ids, invite.ID)
+			}
+		}
+		var unsent []services.GuestInvite
+		if len(ids) > 0 {
+			if _, err := h.PollService.SendGuestInvites(ctx, pollID, uid, ids); err != nil {
+				slog.ErrorContext(ctx, "Failed to send invites of poll", "poll_id", pollID, "error", err)
+				h.renderSharing(c, http.StatusOK, pollID, invites, "", "Invites were created but could not be emailed: "+sendError(err))
+				return
+			}
+		}
+		// Sending replaced the tokens of the emailed invites.
+		for _, invite := range invites {
+			if invite.Email == "" {
+				unsent = append(unsent, invite)
+			}
+		}
+		if len(unsent) == 0 {
+			c.Redirect(http.StatusSeeOther, sharingURL(pollID, fmt.Sprintf("%d invitations emailed", len(ids))))
+			return
+		}
+		h.renderSharing(c, http.StatusOK, pollID, unsent, fmt.Sprintf("%d invitations emailed, the invites below have no email address", len(ids)), "")
+	default:
+		h.renderSharing(c, http.StatusOK, pollID, invites, "", "")
+	}
+}
+
+// sendError describes why invites could not be emailed.
+func sendError(err error) string {
+	if errors.Is(err, services.ErrInvalidGuest) {
+		_, reason, _ := strings.Cut(err.Error(), ": ")
+		return reason
+	}
+	return "please try again"
+}
+
+// SendInvites emails one unsent invite, given by invite_id, or all of them.
+func (h *PollHandler) SendInvites(c *gin.Context) {
+	pollID, ok := h.sharingPollID(c)
+	if !ok {
+		return
+	}
+	var ids []int64
+	if value := c.PostForm("invite_id"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			h.renderSharing(c, http.StatusBadRequest, pollID, nil, "", "Invalid invite ID")
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	sent, err := h.PollService.SendGuestInvites(c.Request.Context(), pollID, c.GetString("uid"), ids)
+	if errors.Is(err, services.ErrInvalidGuest) {
+		h.renderSharing(c, http.StatusBadRequest, pollID, nil, "", "Invites could not be emailed: "+sendError(err))
+		return
+	}
+	if err == sql.ErrNoRows {
+		h.renderSharing(c, http.StatusNotFound, pollID, nil, "", "Poll not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to send invites of poll", "poll_id", pollID, "error", err)
+		h.renderSharing(c, http.StatusInternalServerError, pollID, nil, "", "Failed to send invites")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, sharingURL(pollID, fmt.Sprintf("%d invitations emailed", len(sent))))
+}
+
+// inviteID parses the invite ID of an invite form, rendering an error page
+// if it is invalid.
+func (h *PollHandler) inviteID(c *gin.Context, pollID int64) (int64, bool) {
+	inviteID, err := strconv.ParseInt(c.Param("inviteID"), 10, 64)
+	if err != nil {
+		h.renderSharing(c, http.StatusBadRequest, pollID, nil, "", "Invalid invite ID")
+		return 0, false
+	}
+	return inviteID, true
+}
+
+func (h *PollHandler) RevokeInvite(c *gin.Context) {
+	pollID, ok := h.sharingPollID(c)
+	if !ok {
+		return
+	}
+	inviteID, ok := h.inviteID(c, pollID)
+	if !ok {
+		return
+	}
+
+	err := h.PollService.RevokeGuestInvite(c.Request.Context(), pollID, inviteID, c.GetString("uid"), c.PostForm("reason"))
+	if err == sql.ErrNoRows {
+		h.renderSharing(c, http.StatusNotFound, pollID, nil, "", "Invite not found, used or already revoked")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to revoke invite", "invite_id", inviteID, "error", err)
+		h.renderSharing(c, http.StatusInternalServerError, pollID, nil, "", "Failed to revoke invite")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, sharingURL(pollID, "Invite revoked"))
+}
+
+// ReissueInvite revokes an invite and shows the link of its replacement
+// once.
+func (h *PollHandler) ReissueInvite(c *gin.Context) {
+	pollID, ok := h.sharingPollID(c)
+	if !ok {
+		return
+	}
+	inviteID, ok := h.inviteID(c, pollID)
+	if !ok {
+		return
+	}
+
+	invite, err := h.PollService.ReissueGuestInvite(c.Request.Context(), pollID, inviteID, c.GetString("uid"), c.PostForm("reason"))
+	if err == sql.ErrNoRows {
+		h.renderSharing(c, http.StatusNotFound, pollID, nil, "", "Invite not found, used or already revoked")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to reissue invite", "invite_id", inviteID, "error", err)
+		h.renderSharing(c, http.StatusInternalServerError, pollID, nil, "", "Failed to reissue invite")
+		return
+	}
+
+	h.renderSharing(c, http.StatusOK, pollID, []services.GuestInvite{*invite}, "", "")
+}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"fakidoosuurdoris/app/Internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// renderDraft shows the create poll form prefilled from a draft.
+func (h *PollHandler) renderDraft(c *gin.Context, userID string, draft *services.PollDraft) {
+	role, _ := h.AuthService.GetUserRole(c.Request.Context(), userID)
+
+	input := gin.H{
+		"Title":        draft.Title,
+		"QuestionType": draft.QuestionType,
+		"OptionOrder":  draft.OptionOrder,
+		"StartDate":    draft.StartDate.Format("2006-01-02T15:04"),
+		"EndDate":      "",
+		"IsAnonymous":  "",
+		"TemplateID":   "",
+	}
+	if draft.EndDate != nil {
+		input["EndDate"] = draft.EndDate.Format("2006-01-02T15:04")
+	}
+	if draft.IsAnonymous {
+		input["IsAnonymous"] = "on"
+	}
+	if draft.TemplateID != nil {
+		input["TemplateID"] = strconv.FormatInt(*draft.TemplateID, 10)
+	}
+
+	csrfToken, _ := c.Get("csrf_token")
+	c.HTML(http.StatusOK, "createpolls.html", gin.H{
+		"Title":     "Create Poll",
+		"Input":     input,
+		"Options":   draft.Options,
+		"CSRFToken": csrfToken,
+		"Role":      role,
+	})
+}
+
+func (h *PollHandler) DuplicatePoll(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login?expired=true")
+		return
+	}
+
+	pollID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.HTML(http.StatusBadRequest, "home.html", gin.H{"Error": "Invalid poll ID"})
+		return
+	}
+
+	draft, err := h.PollService.DraftFromPoll(c.Request.Context(), pollID, uid.(string))
+	if err == sql.ErrNoRows {
+		c.HTML(http.StatusNotFound, "home.html", gin.H{"Error": "Poll not found"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to duplicate poll", "poll_id", pollID, "error", err)
+		c.HTML(http.StatusInternalServerError, "home.html", gin.H{"Error": "Could not duplicate poll"})
+		return
+	}
+
+	h.renderDraft(c, uid.(string), draft)
+}
+
+func (h *PollHandler) SaveAsTemplate(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login?expired=true")
+		return
+	}
+
+	pollID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.HTML(http.StatusBadRequest, "home.html", gin.H{"Error": "Invalid poll ID"})
+		return
+	}
+
+	_, err = h.PollService.SaveTemplateFromPoll(c.Request.Context(), pollID, uid.(string), c.PostForm("name"), c.PostForm("shared") == "on")
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to save poll as template", "poll_id", pollID, "error", err)
+		c.Redirect(http.StatusSeeOther, "/templates?error=Could+not+save+template")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/templates?message=Template+saved")
+}
+
+func (h *PollHandler) RenderTemplates(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login?expired=true")
+		return
+	}
+	userID := uid.(string)
+
+	role, err := h.AuthService.GetUserRole(c.Request.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch role", "error", err)
+	}
+
+	templates, err := h.PollService.ListTemplates(c.Request.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to list templates", "error", err)
+		c.HTML(http.StatusInternalServerError, "poll_templates.html", gin.H{
+			"Title": "Poll Templates",
+			"Error": "Could not load templates",
+			"Role":  role,
+		})
+		return
+	}
+
+	csrfToken, _ := c.Get("csrf_token")
+	c.HTML(http.StatusOK, "poll_templates.html", gin.H{
+		"Title":         "Poll Templates",
+		"Templates":     templates,
+		"Error":         c.Query("error"),
+		"Message":       c.Query("message"),
+		"CSRFToken":     csrfToken,
+		"Role":          role,
+		"CurrentUserID": userID,
+	})
+}
+
+func (h *PollHandler) UseTemplate(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login?expired=true")
+		return
+	}
+
+	templateID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/templates?error=Invalid+template+ID")
+		return
+	}
+
+	draft, err := h.PollService.DraftFromTemplate(c.Request.Context(), templateID, uid.(string))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load template", "template_id", templateID, "error", err)
+		c.Redirect(http.StatusSeeOther, "/templates?error=Template+not+found")
+		return
+	}
+
+	h.renderDraft(c, uid.(string), draft)
+}
+
+func (h *PollHandler) DeleteTemplate(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login?expired=true")
+		return
+	}
+
+	templateID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.Redirect(http.StatusSeeOther, "/templates?error=Invalid+template+ID")
+		return
+	}
+
+	if err := h.PollService.DeleteTemplate(c.Request.Context(), templateID, uid.(string)); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to delete template", "template_id", templateID, "error", err)
+		c.Redirect(http.StatusSeeOther, "/templates?error=Could+not+delete+template")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/templates?message=Template+deleted")
+}
+
+func (h *PollHandler) ListTemplatesAPI(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Please log in"})
+		return
+	}
+
+	templates, err := h.PollService.ListTemplates(c.Request.Context(), uid.(string))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to list templates", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load templates"})
+		return
+	}
+	if templates == nil {
+		templates = []services.PollTemplate{}
+	}
+	c.JSON(http.StatusOK, gin.H{"templates": templates})
+}
+
+func (h *PollHandler) GetTemplateAPI(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Please log in"})
+		return
+	}
+
+	templateID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	t, err := h.PollService.GetTemplate(c.Request.Context(), templateID, uid.(string))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load template", "template_id", templateID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load template"})
+		return
+	}
+	c.JSON(http.StatusOK, t)
+}
+
+// CreateTemplateAPI creates a template either from an existing poll, when
+// poll_id is given, or from the template fields in the body.
+func (h *PollHandler) CreateTemplateAPI(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Please log in"})
+		return
+	}
+
+	var input struct {
+		services.PollTemplate
+		PollID *int64 `json:"poll_id"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON body"})
+		return
+	}
+
+	var t *services.PollTemplate
+	var err error
+	if input.PollID != nil {
+		t, err = h.PollService.SaveTemplateFromPoll(c.Request.Context(), *input.PollID, uid.(string), input.Name, input.Shared)
+	} else {
+		t, err = h.PollService.CreateTemplate(c.Request.Context(), uid.(string), input.PollTemplate)
+	}
+	switch {
+	case errors.Is(err, services.ErrInvalidTemplate):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case err == sql.ErrNoRows:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed"})
+	case err != nil:
+		slog.ErrorContext(c.Request.Context(), "Failed to create template", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create template"})
+	default:
+		c.JSON(http.StatusCreated, t)
+	}
+}
+
+func (h *PollHandler) DeleteTemplateAPI(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Please log in"})
+		return
+	}
+
+	templateID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID"})
+		return
+	}
+
+	err = h.PollService.DeleteTemplate(c.Request.Context(), templateID, uid.(string))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to delete template", "template_id", templateID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete template"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
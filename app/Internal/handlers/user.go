@@ -1,34 +1,52 @@
 package handlers
 
 import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
 	"html/template"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"fakidoosuurdoris/app/Internal/services"
+	"fakidoosuurdoris/app/config"
 
 	"github.com/gin-gonic/gin"
 )
 
 type UserHandler struct {
-	UserService *services.UserService
-	Templates   *template.Template
+	UserService         *services.UserService
+	NotificationService *services.NotificationService
+	ChatService         *services.ChatService
+	Templates           *template.Template
+	DeletionGrace       time.Duration
+	Cookies             config.CookieConfig
 }
 
-func NewUserHandler(userService *services.UserService, templates *template.Template) *UserHandler {
+func NewUserHandler(userService *services.UserService, notificationService *services.NotificationService, chatService *services.ChatService, templates *template.Template, deletionGrace time.Duration, cookies config.CookieConfig) *UserHandler {
 	return &UserHandler{
-		UserService: userService,
-		Templates:   templates,
+		UserService:         userService,
+		NotificationService: notificationService,
+		ChatService:         chatService,
+		Templates:           templates,
+		DeletionGrace:       deletionGrace,
+		Cookies:             cookies,
 	}
 }
 
 func (h *UserHandler) invalidateSession(c *gin.Context, redirectReason string) {
-	c.SetCookie("idToken", "", -1, "/", "localhost", false, true)
+	c.SetCookie("idToken", "", -1, "/", h.Cookies.Domain, h.Cookies.Secure, true)
 	c.Redirect(http.StatusSeeOther, "/login?"+redirectReason+"=true")
 }
 
 func (h *UserHandler) Logout(c *gin.Context) {
-	log.Printf("Logout: Clearing idToken")
+	slog.DebugContext(c.Request.Context(), "Logout: Clearing idToken")
 	h.invalidateSession(c, "logged_out")
 }
 
@@ -41,31 +59,56 @@ func (h *UserHandler) RenderProfile(c *gin.Context) {
 
 	user, err := h.UserService.GetUserByID(c.Request.Context(), uid.(string))
 	if err != nil {
-		log.Printf("Failed to fetch user: %v", err)
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch user", "error", err)
 		c.HTML(http.StatusInternalServerError, "profile.html", gin.H{"Error": "Could not load profile"})
 		return
 	}
 
 	totalPolls, err := h.UserService.GetUserPollsCount(c.Request.Context(), uid.(string))
 	if err != nil {
-		log.Printf("Failed to fetch poll count: %v", err)
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch poll count", "error", err)
 		totalPolls = 0
 	}
 
+	pendingDeletion, err := h.UserService.GetPendingDeletion(c.Request.Context(), uid.(string))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch pending deletion", "error", err)
+	}
+
+	preferences, err := h.NotificationService.GetPreferences(c.Request.Context(), uid.(string))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch notification preferences", "error", err)
+	}
+
+	chatCode, chatCodeExpires, err := h.ChatService.GetActiveLinkCode(c.Request.Context(), uid.(string))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch chat link code", "error", err)
+	}
+	chatLinks, err := h.ChatService.ListChatLinks(c.Request.Context(), uid.(string))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch chat links", "error", err)
+	}
+
 	csrfToken, _ := c.Get("csrf_token")
 	c.HTML(http.StatusOK, "profile.html", gin.H{
-		"Title":      "Profile",
-		"User":       user,
-		"TotalPolls": totalPolls,
-		"CSRFToken":  csrfToken,
+		"Title":           "Profile",
+		"User":            user,
+		"TotalPolls":      totalPolls,
+		"PendingDeletion": pendingDeletion,
+		"Preferences":     preferences,
+		"ChatCode":        chatCode,
+		"ChatCodeExpires": chatCodeExpires,
+		"ChatLinks":       chatLinks,
+		"Message":         c.Query("message"),
+		"CSRFToken":       csrfToken,
 	})
 }
 
 func (h *UserHandler) RenderEditProfile(c *gin.Context) {
 	uid, exists := c.Get("uid")
-	log.Printf("RenderEditProfile: uid=%v, exists=%v", uid, exists)
+	slog.DebugContext(c.Request.Context(), "RenderEditProfile", "user_id", uid, "exists", exists)
 	if !exists {
-		log.Printf("RenderEditProfile: No uid in context, redirecting to login")
+		slog.WarnContext(c.Request.Context(), "RenderEditProfile: No uid in context, redirecting to login")
 		c.Redirect(http.StatusSeeOther, "/login?expired=true")
 		return
 	}
@@ -73,7 +116,7 @@ func (h *UserHandler) RenderEditProfile(c *gin.Context) {
 
 	user, err := h.UserService.GetUserByID(c.Request.Context(), userID)
 	if err != nil {
-		log.Printf("RenderEditProfile: Failed to fetch user %s: %v", userID, err)
+		slog.ErrorContext(c.Request.Context(), "RenderEditProfile: Failed to fetch user", "user_id", userID, "error", err)
 		c.HTML(http.StatusInternalServerError, "profile_edit.html", gin.H{
 			"Error": "Could not load profile",
 		})
@@ -82,7 +125,7 @@ func (h *UserHandler) RenderEditProfile(c *gin.Context) {
 
 	role, err := h.UserService.GetUserRole(c.Request.Context(), userID)
 	if err != nil {
-		log.Printf("RenderEditProfile: Failed to fetch role for user %s: %v", userID, err)
+		slog.ErrorContext(c.Request.Context(), "RenderEditProfile: Failed to fetch role for user", "user_id", userID, "error", err)
 		c.HTML(http.StatusInternalServerError, "profile_edit.html", gin.H{
 			"Error": "Could not load user role",
 		})
@@ -96,7 +139,7 @@ func (h *UserHandler) RenderEditProfile(c *gin.Context) {
 		"CSRFToken": csrfToken,
 		"Role":      role,
 	}
-	log.Printf("RenderEditProfile: Rendering profile_edit.html for user %s with role: %s, data: %+v", userID, role, data)
+	slog.DebugContext(c.Request.Context(), "RenderEditProfile: Rendering profile_edit.html for user with role", "user_id", userID, "role", role)
 
 	c.HTML(http.StatusOK, "profile_edit.html", data)
 }
@@ -104,7 +147,7 @@ func (h *UserHandler) RenderEditProfile(c *gin.Context) {
 func (h *UserHandler) UpdateProfile(c *gin.Context) {
 	uid, exists := c.Get("uid")
 	if !exists {
-		log.Printf("No uid found in context for POST /profile, Cookies: %+v", c.Request.Cookies())
+		slog.WarnContext(c.Request.Context(), "No uid found in context for POST /profile")
 		c.HTML(http.StatusUnauthorized, "login.html", gin.H{
 			"Title": "Login",
 			"Error": "Please log in to update your profile",
@@ -114,7 +157,7 @@ func (h *UserHandler) UpdateProfile(c *gin.Context) {
 
 	uidStr, ok := uid.(string)
 	if !ok {
-		log.Printf("Invalid uid type in context: %T", uid)
+		slog.WarnContext(c.Request.Context(), "Invalid uid type in context", "type", fmt.Sprintf("%T", uid))
 		c.HTML(http.StatusInternalServerError, "login.html", gin.H{
 			"Title": "Login",
 			"Error": "Internal server error",
@@ -129,10 +172,10 @@ func (h *UserHandler) UpdateProfile(c *gin.Context) {
 	}
 
 	if err := c.ShouldBind(&input); err != nil {
-		log.Printf("Form binding error: %v, Form data: %+v", err, c.Request.PostForm)
+		slog.WarnContext(c.Request.Context(), "Form binding error", "error", err)
 		csrfToken, ok := c.Get("csrf_token")
 		if !ok {
-			log.Printf("CSRF token missing for POST /profile")
+			slog.WarnContext(c.Request.Context(), "CSRF token missing for POST /profile")
 			c.HTML(http.StatusInternalServerError, "profile_edit.html", gin.H{
 				"Title": "Edit Profile",
 				"Error": "Internal server error",
@@ -141,7 +184,7 @@ func (h *UserHandler) UpdateProfile(c *gin.Context) {
 		}
 		user, err := h.UserService.GetUserByID(c.Request.Context(), uidStr)
 		if err != nil {
-			log.Printf("Failed to fetch user for UID %s: %v", uidStr, err)
+			slog.ErrorContext(c.Request.Context(), "Failed to fetch user", "user_id", uidStr, "error", err)
 			c.HTML(http.StatusInternalServerError, "profile_edit.html", gin.H{
 				"Title":     "Edit Profile",
 				"Error":     "Failed to load user data",
@@ -161,10 +204,10 @@ func (h *UserHandler) UpdateProfile(c *gin.Context) {
 
 	err := h.UserService.UpdateUser(c.Request.Context(), uidStr, input.FirstName, input.LastName, input.Email)
 	if err != nil {
-		log.Printf("Profile update failed for UID %s: %v", uidStr, err)
+		slog.ErrorContext(c.Request.Context(), "Profile update failed", "user_id", uidStr, "error", err)
 		csrfToken, ok := c.Get("csrf_token")
 		if !ok {
-			log.Printf("CSRF token missing for POST /profile")
+			slog.WarnContext(c.Request.Context(), "CSRF token missing for POST /profile")
 			c.HTML(http.StatusInternalServerError, "profile_edit.html", gin.H{
 				"Title": "Edit Profile",
 				"Error": "Internal server error",
@@ -173,7 +216,7 @@ func (h *UserHandler) UpdateProfile(c *gin.Context) {
 		}
 		user, err := h.UserService.GetUserByID(c.Request.Context(), uidStr)
 		if err != nil {
-			log.Printf("Failed to fetch user for UID %s: %v", uidStr, err)
+			slog.ErrorContext(c.Request.Context(), "Failed to fetch user", "user_id", uidStr, "error", err)
 			c.HTML(http.StatusInternalServerError, "profile_edit.html", gin.H{
 				"Title":     "Edit Profile",
 				"Error":     "Failed to load user data",
@@ -198,7 +241,7 @@ func (h *UserHandler) UpdateProfile(c *gin.Context) {
 
 func (h *UserHandler) RenderChangePassword(c *gin.Context) {
 	uid, exists := c.Get("uid")
-	log.Printf("RenderChangePassword: uid=%v, exists=%v", uid, exists)
+	slog.DebugContext(c.Request.Context(), "RenderChangePassword", "user_id", uid, "exists", exists)
 	if !exists {
 		c.Redirect(http.StatusSeeOther, "/login?expired=true")
 		return
@@ -213,7 +256,7 @@ func (h *UserHandler) RenderChangePassword(c *gin.Context) {
 
 func (h *UserHandler) UpdatePassword(c *gin.Context) {
 	uid, exists := c.Get("uid")
-	log.Printf("UpdatePassword: uid=%v, exists=%v", uid, exists)
+	slog.DebugContext(c.Request.Context(), "UpdatePassword", "user_id", uid, "exists", exists)
 	if !exists {
 		c.Redirect(http.StatusSeeOther, "/login?expired=true")
 		return
@@ -226,7 +269,7 @@ func (h *UserHandler) UpdatePassword(c *gin.Context) {
 	}
 
 	if err := c.ShouldBind(&input); err != nil {
-		log.Printf("Form binding error: %v, Form data: %+v", err, c.Request.PostForm)
+		slog.WarnContext(c.Request.Context(), "Form binding error", "error", err)
 		csrfToken, _ := c.Get("csrf_token")
 		c.HTML(http.StatusBadRequest, "profile_password.html", gin.H{
 			"Title":     "Change Password",
@@ -238,7 +281,7 @@ func (h *UserHandler) UpdatePassword(c *gin.Context) {
 	}
 
 	if input.NewPassword != input.ConfirmPassword {
-		log.Printf("Password mismatch")
+		slog.WarnContext(c.Request.Context(), "Password mismatch")
 		csrfToken, _ := c.Get("csrf_token")
 		c.HTML(http.StatusBadRequest, "profile_password.html", gin.H{
 			"Title":     "Change Password",
@@ -251,7 +294,7 @@ func (h *UserHandler) UpdatePassword(c *gin.Context) {
 
 	err := h.UserService.UpdatePassword(c.Request.Context(), uid.(string), input.NewPassword)
 	if err != nil {
-		log.Printf("Password update failed: %v", err)
+		slog.ErrorContext(c.Request.Context(), "Password update failed", "error", err)
 		csrfToken, _ := c.Get("csrf_token")
 		c.HTML(http.StatusInternalServerError, "profile_password.html", gin.H{
 			"Title":     "Change Password",
@@ -265,3 +308,325 @@ func (h *UserHandler) UpdatePassword(c *gin.Context) {
 	// h.invalidateSession(c, "password_updated")
 	c.Redirect(http.StatusSeeOther, "/profile")
 }
+
+func (h *UserHandler) ExportData(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login?expired=true")
+		return
+	}
+
+	export, err := h.UserService.ExportUserData(c.Request.Context(), uid.(string))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to export data for user", "user_id", uid, "error", err)
+		c.HTML(http.StatusInternalServerError, "profile.html", gin.H{
+			"Title": "Profile",
+			"Error": "Could not export your data",
+		})
+		return
+	}
+
+	// The archive is built in memory so that a failure can still be reported
+	// instead of serving a truncated file.
+	var archive bytes.Buffer
+	if err := writeDataArchive(&archive, export); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to write export archive", "user_id", uid, "error", err)
+		c.HTML(http.StatusInternalServerError, "profile.html", gin.H{
+			"Title": "Profile",
+			"Error": "Could not export your data",
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=my_data_%s.zip", export.ExportedAt.Format("20060102")))
+	c.Data(http.StatusOK, "application/zip", archive.Bytes())
+}
+
+// writeDataArchive writes a user's data export as a ZIP of data.json and a
+// CSV file per kind of record.
+func writeDataArchive(w io.Writer, export *services.UserDataExport) error {
+	zw := zip.NewWriter(w)
+
+	f, err := zw.Create("data.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(export); err != nil {
+		return err
+	}
+
+	profile := export.Profile
+	err = writeCSVFile(zw, "profile.csv", []string{"User ID", "First Name", "Last Name", "Email", "Role", "Deletion Scheduled For"},
+		[][]string{{profile.ID, profile.FirstName, profile.LastName, profile.Email, profile.Role, formatOptionalTime(export.DeletionScheduledFor)}})
+	if err != nil {
+		return err
+	}
+
+	polls := make([][]string, 0, len(export.Polls))
+	for _, poll := range export.Polls {
+		var endDate string
+		if poll.EndDate != nil {
+			endDate = poll.EndDate.Format(time.RFC3339)
+		}
+		polls = append(polls, []string{strconv.FormatInt(poll.ID, 10), poll.Title, poll.QuestionType, poll.StartDate.Format(time.RFC3339), endDate, strconv.FormatBool(poll.IsAnonymous), poll.CreatedAt.Format(time.RFC3339)})
+	}
+	err = writeCSVFile(zw, "polls.csv", []string{"Poll ID", "Title", "Question Type", "Start Date", "End Date", "Is Anonymous", "Created At"}, polls)
+	if err != nil {
+		return err
+	}
+
+	votes := make([][]string, 0, len(export.Votes))
+	for _, vote := range export.Votes {
+		var option, text, scale string
+		if vote.OptionText != nil {
+			option = *vote.OptionText
+		}
+		if vote.TextAnswer != nil {
+			text = *vote.TextAnswer
+		}
+		if vote.ScaleValue != nil {
+			scale = strconv.FormatInt(*vote.ScaleValue, 10)
+		}
+		votes = append(votes, []string{strconv.FormatInt(vote.PollID, 10), vote.PollTitle, vote.QuestionType, option, text, scale, vote.CreatedAt.Format(time.RFC3339)})
+	}
+	err = writeCSVFile(zw, "votes.csv", []string{"Poll ID", "Poll Title", "Question Type", "Option", "Text Answer", "Scale Value", "Created At"}, votes)
+	if err != nil {
+		return err
+	}
+
+	links := make([][]string, 0, len(export.ChatLinks))
+	for _, link := range export.ChatLinks {
+		links = append(links, []string{link.TeamID, link.ChatUserID, link.LinkedAt.Format(time.RFC3339)})
+	}
+	err = writeCSVFile(zw, "chat_links.csv", []string{"Team ID", "Chat User ID", "Linked At"}, links)
+	if err != nil {
+		return err
+	}
+
+	templates := make([][]string, 0, len(export.Templates))
+	for _, t := range export.Templates {
+		options := make([]string, len(t.Options))
+		for i, option := range t.Options {
+			options[i] = option.Text
+		}
+		var duration string
+		if t.DurationMinutes != nil {
+			duration = strconv.Itoa(*t.DurationMinutes)
+		}
+		templates = append(templates, []string{strconv.FormatInt(t.ID, 10), t.Name, strconv.FormatBool(t.Shared), t.Title, t.QuestionType, strings.Join(options, "; "), duration, t.CreatedAt.Format(time.RFC3339)})
+	}
+	err = writeCSVFile(zw, "templates.csv", []string{"Template ID", "Name", "Shared", "Title", "Question Type", "Options", "Duration Minutes", "Created At"}, templates)
+	if err != nil {
+		return err
+	}
+
+	series := make([][]string, 0, len(export.Series))
+	for _, sr := range export.Series {
+		var nextRun string
+		if sr.NextRunAt != nil {
+			nextRun = sr.NextRunAt.Format(time.RFC3339)
+		}
+		series = append(series, []string{strconv.FormatInt(sr.ID, 10), sr.Title, sr.QuestionType, sr.Rule.Frequency, strconv.Itoa(sr.Rule.Interval), sr.StartDate.Format(time.RFC3339), strconv.Itoa(sr.DurationMinutes), nextRun, strconv.Itoa(sr.Occurrences), strconv.FormatBool(sr.Active), sr.CreatedAt.Format(time.RFC3339)})
+	}
+	err = writeCSVFile(zw, "series.csv", []string{"Series ID", "Title", "Question Type", "Frequency", "Interval", "Start Date", "Duration Minutes", "Next Run At", "Occurrences", "Active", "Created At"}, series)
+	if err != nil {
+		return err
+	}
+
+	invitations := make([][]string, 0, len(export.Invitations))
+	for _, invite := range export.Invitations {
+		invitations = append(invitations, []string{strconv.FormatInt(invite.ID, 10), strconv.FormatInt(invite.PollID, 10), invite.Label, invite.Email, invite.Status, invite.CreatedAt.Format(time.RFC3339), formatOptionalTime(invite.SentAt), formatOptionalTime(invite.UsedAt), formatOptionalTime(invite.RevokedAt)})
+	}
+	err = writeCSVFile(zw, "invitations.csv", []string{"Invite ID", "Poll ID", "Label", "Email", "Status", "Created At", "Sent At", "Used At", "Revoked At"}, invitations)
+	if err != nil {
+		return err
+	}
+
+	hooks := make([][]string, 0, len(export.Webhooks))
+	for _, hook := range export.Webhooks {
+		var pollID string
+		if hook.PollID != nil {
+			pollID = strconv.FormatInt(*hook.PollID, 10)
+		}
+		hooks = append(hooks, []string{strconv.FormatInt(hook.ID, 10), hook.URL, strings.Join(hook.Events, "; "), pollID, strconv.FormatBool(hook.Active), hook.CreatedAt.Format(time.RFC3339)})
+	}
+	err = writeCSVFile(zw, "webhooks.csv", []string{"Webhook ID", "URL", "Events", "Poll ID", "Active", "Created At"}, hooks)
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// writeCSVFile adds a CSV file with header and rows to zw.
+func writeCSVFile(zw *zip.Writer, name string, header []string, rows [][]string) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return err
+	}
+	return w.Error()
+}
+
+// formatOptionalTime formats t for a CSV cell, empty when t is nil.
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func (h *UserHandler) RenderDeleteAccount(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login?expired=true")
+		return
+	}
+	h.renderDeleteAccount(c, http.StatusOK, uid.(string), "")
+}
+
+func (h *UserHandler) renderDeleteAccount(c *gin.Context, status int, userID, errMsg string) {
+	user, err := h.UserService.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch user", "user_id", userID, "error", err)
+		c.HTML(http.StatusInternalServerError, "profile_delete.html", gin.H{
+			"Title": "Delete Account",
+			"Error": "Could not load profile",
+		})
+		return
+	}
+
+	pendingDeletion, err := h.UserService.GetPendingDeletion(c.Request.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch pending deletion for user", "user_id", userID, "error", err)
+	}
+
+	csrfToken, _ := c.Get("csrf_token")
+	c.HTML(status, "profile_delete.html", gin.H{
+		"Title":           "Delete Account",
+		"Error":           errMsg,
+		"User":            user,
+		"Role":            user.Role,
+		"PendingDeletion": pendingDeletion,
+		"GraceDays":       int(h.DeletionGrace.Hours() / 24),
+		"CSRFToken":       csrfToken,
+	})
+}
+
+func (h *UserHandler) RequestDeleteAccount(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login?expired=true")
+		return
+	}
+	userID := uid.(string)
+
+	var input struct {
+		ConfirmEmail string `form:"confirm_email" binding:"required"`
+	}
+	if err := c.ShouldBind(&input); err != nil {
+		h.renderDeleteAccount(c, http.StatusBadRequest, userID, "Type your email address to confirm")
+		return
+	}
+
+	user, err := h.UserService.GetUserByID(c.Request.Context(), userID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch user", "user_id", userID, "error", err)
+		h.renderDeleteAccount(c, http.StatusInternalServerError, userID, "Could not load profile")
+		return
+	}
+	if !strings.EqualFold(strings.TrimSpace(input.ConfirmEmail), user.Email) {
+		h.renderDeleteAccount(c, http.StatusBadRequest, userID, "The email address does not match your account")
+		return
+	}
+
+	scheduledFor, err := h.UserService.RequestAccountDeletion(c.Request.Context(), userID, h.DeletionGrace)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to schedule deletion for user", "user_id", userID, "error", err)
+		h.renderDeleteAccount(c, http.StatusInternalServerError, userID, "Could not schedule account deletion")
+		return
+	}
+	slog.InfoContext(c.Request.Context(), "Account scheduled for deletion", "user_id", userID, "scheduled_for", scheduledFor.Format(time.RFC3339))
+
+	c.Redirect(http.StatusSeeOther, "/profile/delete")
+}
+
+func (h *UserHandler) CancelDeleteAccount(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login?expired=true")
+		return
+	}
+
+	if err := h.UserService.CancelAccountDeletion(c.Request.Context(), uid.(string)); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to cancel deletion for user", "user_id", uid, "error", err)
+		h.renderDeleteAccount(c, http.StatusInternalServerError, uid.(string), "Could not cancel account deletion")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/profile")
+}
+
+func (h *UserHandler) UpdateNotificationPreferences(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login?expired=true")
+		return
+	}
+
+	// Unchecked checkboxes are not submitted, so absence means opted out.
+	prefs := services.NotificationPreferences{
+		PollOpened:   c.PostForm("poll_opened") == "on",
+		PollReminder: c.PostForm("poll_reminder") == "on",
+		PollClosed:   c.PostForm("poll_closed") == "on",
+		AdminGranted: c.PostForm("admin_granted") == "on",
+	}
+	if err := h.NotificationService.UpdatePreferences(c.Request.Context(), uid.(string), prefs); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to update notification preferences for user", "user_id", uid, "error", err)
+		c.Redirect(http.StatusSeeOther, "/profile?message=Could+not+save+email+preferences")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/profile?message=Email+preferences+saved")
+}
+
+func (h *UserHandler) CreateChatLinkCode(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login?expired=true")
+		return
+	}
+
+	if _, _, err := h.ChatService.CreateLinkCode(c.Request.Context(), uid.(string)); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to create chat link code for user", "user_id", uid, "error", err)
+		c.Redirect(http.StatusSeeOther, "/profile?message=Could+not+create+a+link+code")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/profile")
+}
+
+func (h *UserHandler) UnlinkChat(c *gin.Context) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.Redirect(http.StatusSeeOther, "/login?expired=true")
+		return
+	}
+
+	err := h.ChatService.UnlinkChat(c.Request.Context(), uid.(string), c.PostForm("team_id"), c.PostForm("chat_user_id"))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to unlink chat account for user", "user_id", uid, "error", err)
+		c.Redirect(http.StatusSeeOther, "/profile?message=Could+not+unlink+chat+account")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/profile?message=Chat+account+unlinked")
+}
@@ -4,7 +4,7 @@ import (
 	"fakidoosuurdoris/app/Internal/models"
 	"fakidoosuurdoris/app/Internal/services"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
@@ -60,6 +60,22 @@ func (h *VoteHandler) RenderVote(c *gin.Context) {
 		return
 	}
 
+	eligible, err := h.PollService.IsEligible(c.Request.Context(), pollID, uid.(string))
+	if err != nil {
+		c.HTML(http.StatusInternalServerError, "vote.html", gin.H{
+			"Title": "Vote",
+			"Error": "Could not verify vote status",
+		})
+		return
+	}
+	if !eligible {
+		c.HTML(http.StatusForbidden, "vote.html", gin.H{
+			"Title": "Vote",
+			"Error": "You are not eligible to vote in this poll",
+		})
+		return
+	}
+
 	hasVoted, err := h.PollService.HasVoted(c.Request.Context(), pollID, uid.(string))
 	if err != nil {
 		c.HTML(http.StatusInternalServerError, "vote.html", gin.H{
@@ -104,11 +120,12 @@ func (h *VoteHandler) RenderVote(c *gin.Context) {
 			})
 			return
 		}
+		options = services.OrderOptionsForVoter(poll, options, uid.(string))
 	}
 
 	role, err := h.AuthService.GetUserRole(c.Request.Context(), uid.(string))
 	if err != nil {
-		log.Printf("Failed to fetch role: %v", err)
+		slog.ErrorContext(c.Request.Context(), "Failed to fetch role", "error", err)
 		role = ""
 	}
 
@@ -163,35 +180,11 @@ func (h *VoteHandler) Vote(c *gin.Context) {
 		return
 	}
 
-	if poll.QuestionType == "single_choice" && len(input.OptionIDs) != 1 {
+	if msg := ballotError(poll.QuestionType, input.OptionIDs, input.TextAnswer); msg != "" {
 		c.HTML(http.StatusBadRequest, "vote.html", gin.H{
 			"Title": "Vote",
 			"Poll":  poll,
-			"Error": "Select exactly one option",
-		})
-		return
-	}
-	if poll.QuestionType == "multiple_choice" && len(input.OptionIDs) == 0 {
-		c.HTML(http.StatusBadRequest, "vote.html", gin.H{
-			"Title": "Vote",
-			"Poll":  poll,
-			"Error": "Select at least one option",
-		})
-		return
-	}
-	if poll.QuestionType == "scale" && (len(input.OptionIDs) != 1 || input.OptionIDs[0] < 1 || input.OptionIDs[0] > 5) {
-		c.HTML(http.StatusBadRequest, "vote.html", gin.H{
-			"Title": "Vote",
-			"Poll":  poll,
-			"Error": "Select a scale value between 1 and 5",
-		})
-		return
-	}
-	if poll.QuestionType == "text" && input.TextAnswer == "" {
-		c.HTML(http.StatusBadRequest, "vote.html", gin.H{
-			"Title": "Vote",
-			"Poll":  poll,
-			"Error": "Text response cannot be empty",
+			"Error": msg,
 		})
 		return
 	}
@@ -208,3 +201,19 @@ func (h *VoteHandler) Vote(c *gin.Context) {
 
 	c.Redirect(http.StatusSeeOther, "/polls-list")
 }
+
+// ballotError checks that a submitted answer has the shape the question type
+// expects and returns a message for the voter if it does not.
+func ballotError(questionType string, optionIDs []int64, textAnswer string) string {
+	switch {
+	case questionType == "single_choice" && len(optionIDs) != 1:
+		return "Select exactly one option"
+	case questionType == "multiple_choice" && len(optionIDs) == 0:
+		return "Select at least one option"
+	case questionType == "scale" && (len(optionIDs) != 1 || optionIDs[0] < 1 || optionIDs[0] > services.ScaleMax):
+		return "Select a scale value between 1 and 5"
+	case questionType == "text" && textAnswer == "":
+		return "Text response cannot be empty"
+	}
+	return ""
+}
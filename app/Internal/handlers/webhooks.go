@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"fakidoosuurdoris/app/Internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookHandler struct {
+	WebhookService *services.WebhookService
+	AuthService    *services.AuthService
+	Templates      *template.Template
+}
+
+func NewWebhookHandler(webhookService *services.WebhookService, authService *services.AuthService, templates *template.Template) *WebhookHandler {
+	return &WebhookHandler{
+		WebhookService: webhookService,
+		AuthService:    authService,
+		Templates:      templates,
+	}
+}
+
+// requireAdmin renders an error page and returns false unless the caller is
+// an admin.
+func (h *WebhookHandler) requireAdmin(c *gin.Context) (string, string, bool) {
+	uid, exists := c.Get("uid")
+	if !exists {
+		c.HTML(http.StatusUnauthorized, "admin_webhooks.html", gin.H{
+			"Title": "Webhooks",
+			"Error": "Please log in",
+		})
+		return "", "", false
+	}
+	adminID := uid.(string)
+
+	role, err := h.AuthService.GetUserRole(c.Request.Context(), adminID)
+	if err != nil || role != "admin" {
+		c.HTML(http.StatusForbidden, "admin_webhooks.html", gin.H{
+			"Title": "Webhooks",
+			"Error": "Access denied",
+		})
+		return "", "", false
+	}
+	return adminID, role, true
+}
+
+func (h *WebhookHandler) RenderWebhooks(c *gin.Context) {
+	adminID, role, ok := h.requireAdmin(c)
+	if !ok {
+		return
+	}
+	h.renderWebhooks(c, http.StatusOK, adminID, role, "")
+}
+
+func (h *WebhookHandler) renderWebhooks(c *gin.Context, status int, adminID, role, errMsg string) {
+	hooks, err := h.WebhookService.ListWebhooks(c.Request.Context(), adminID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to list webhooks", "error", err)
+		c.HTML(http.StatusInternalServerError, "admin_webhooks.html", gin.H{
+			"Title": "Webhooks",
+			"Error": "Failed to load webhooks",
+			"Role":  role,
+		})
+		return
+	}
+
+	csrfToken, _ := c.Get("csrf_token")
+	c.HTML(status, "admin_webhooks.html", gin.H{
+		"Title":     "Webhooks",
+		"Error":     errMsg,
+		"Message":   c.Query("message"),
+		"Webhooks":  hooks,
+		"Events":    services.WebhookEvents,
+		"CSRFToken": csrfToken,
+		"Role":      role,
+	})
+}
+
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	adminID, role, ok := h.requireAdmin(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		URL    string   `form:"url" binding:"required"`
+		Secret string   `form:"secret"`
+		Events []string `form:"events[]"`
+		PollID string   `form:"poll_id"`
+	}
+	if err := c.ShouldBind(&input); err != nil {
+		h.renderWebhooks(c, http.StatusBadRequest, adminID, role, "URL is required")
+		return
+	}
+
+	var pollID *int64
+	if trimmed := strings.TrimSpace(input.PollID); trimmed != "" {
+		id, err := strconv.ParseInt(trimmed, 10, 64)
+		if err != nil {
+			h.renderWebhooks(c, http.StatusBadRequest, adminID, role, "Invalid poll ID")
+			return
+		}
+		pollID = &id
+	}
+
+	_, err := h.WebhookService.CreateWebhook(c.Request.Context(), adminID, strings.TrimSpace(input.URL), input.Secret, input.Events, pollID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to create webhook", "error", err)
+		if errors.Is(err, services.ErrInvalidWebhook) {
+			h.renderWebhooks(c, http.StatusBadRequest, adminID, role, err.Error())
+			return
+		}
+		h.renderWebhooks(c, http.StatusInternalServerError, adminID, role, "Failed to create webhook")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/admin/webhooks?message=Webhook+created")
+}
+
+func (h *WebhookHandler) SetWebhookActive(c *gin.Context) {
+	adminID, role, ok := h.requireAdmin(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		h.renderWebhooks(c, http.StatusBadRequest, adminID, role, "Invalid webhook ID")
+		return
+	}
+
+	active := c.PostForm("active") == "true"
+	if err := h.WebhookService.SetWebhookActive(c.Request.Context(), adminID, id, active); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to update webhook", "webhook_id", id, "error", err)
+		h.renderWebhooks(c, http.StatusInternalServerError, adminID, role, "Failed to update webhook")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/admin/webhooks")
+}
+
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	adminID, role, ok := h.requireAdmin(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		h.renderWebhooks(c, http.StatusBadRequest, adminID, role, "Invalid webhook ID")
+		return
+	}
+
+	if err := h.WebhookService.DeleteWebhook(c.Request.Context(), adminID, id); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to delete webhook", "webhook_id", id, "error", err)
+		h.renderWebhooks(c, http.StatusInternalServerError, adminID, role, "Failed to delete webhook")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/admin/webhooks?message=Webhook+deleted")
+}
+
+func (h *WebhookHandler) RenderDeliveries(c *gin.Context) {
+	adminID, role, ok := h.requireAdmin(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		h.renderWebhooks(c, http.StatusBadRequest, adminID, role, "Invalid webhook ID")
+		return
+	}
+
+	hook, err := h.WebhookService.GetWebhook(c.Request.Context(), adminID, id)
+	if err == sql.ErrNoRows {
+		h.renderWebhooks(c, http.StatusNotFound, adminID, role, "Webhook not found")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load webhook", "webhook_id", id, "error", err)
+		h.renderWebhooks(c, http.StatusInternalServerError, adminID, role, "Failed to load webhook")
+		return
+	}
+
+	deliveries, err := h.WebhookService.ListDeliveries(c.Request.Context(), adminID, id, 100)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to list deliveries for webhook", "webhook_id", id, "error", err)
+		h.renderWebhooks(c, http.StatusInternalServerError, adminID, role, "Failed to load deliveries")
+		return
+	}
+
+	csrfToken, _ := c.Get("csrf_token")
+	c.HTML(http.StatusOK, "webhook_deliveries.html", gin.H{
+		"Title":      "Webhook Deliveries",
+		"Message":    c.Query("message"),
+		"Webhook":    hook,
+		"Deliveries": deliveries,
+		"CSRFToken":  csrfToken,
+		"Role":       role,
+	})
+}
+
+func (h *WebhookHandler) Redeliver(c *gin.Context) {
+	adminID, role, ok := h.requireAdmin(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		h.renderWebhooks(c, http.StatusBadRequest, adminID, role, "Invalid delivery ID")
+		return
+	}
+
+	webhookID, err := h.WebhookService.Redeliver(c.Request.Context(), adminID, id)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to redeliver", "delivery_id", id, "error", err)
+		h.renderWebhooks(c, http.StatusInternalServerError, adminID, role, "Failed to queue redelivery")
+		return
+	}
+
+	c.Redirect(http.StatusSeeOther, "/admin/webhooks/"+strconv.FormatInt(webhookID, 10)+"?message=Redelivery+queued")
+}
@@ -0,0 +1,112 @@
+// Package logging sets up structured logging: JSON records tagged with the
+// ID of the request and trace they belong to, filtered by a level that
+// routes can override, and scrubbed of tokens, passwords and email
+// addresses.
+package logging
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	levelKey
+)
+
+// WithRequestID returns a context that tags log records with id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the ID of the request ctx belongs to, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// WithLevel returns a context in which only records at level or above are
+// logged, overriding the configured level.
+func WithLevel(ctx context.Context, level slog.Level) context.Context {
+	return context.WithValue(ctx, levelKey, level)
+}
+
+// ParseLevel reads a level name: debug, info, warn or error.
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(strings.TrimSpace(name)))
+	return level, err
+}
+
+// New returns a logger writing JSON, or text if format is "text", to w.
+func New(w io.Writer, format string, level slog.Level) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var handler slog.Handler
+	if format == "text" {
+		handler = slog.NewTextHandler(w, opts)
+	} else {
+		handler = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(&Handler{next: handler, level: level})
+}
+
+// Setup makes logger the default for both slog and the log package, so
+// code that still calls log.Printf is formatted, filtered and redacted the
+// same way.
+func Setup(logger *slog.Logger) {
+	slog.SetDefault(logger)
+	log.SetFlags(0)
+}
+
+// Handler adds the request and trace IDs to records, applies the level of
+// the request's route and redacts secrets before passing records on.
+type Handler struct {
+	next  slog.Handler
+	level slog.Level
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	min := h.level
+	if ctx != nil {
+		if override, ok := ctx.Value(levelKey).(slog.Level); ok {
+			min = override
+		}
+	}
+	return level >= min
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, Redact(r.Message), r.PC)
+	if ctx != nil {
+		if id := RequestID(ctx); id != "" {
+			out.AddAttrs(slog.String("request_id", id))
+		}
+		if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+			out.AddAttrs(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+		}
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = redactAttr(a)
+	}
+	return &Handler{next: h.next.WithAttrs(redacted), level: h.level}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name), level: h.level}
+}
@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are parts of attribute names whose values are never
+// logged.
+var sensitiveKeys = []string{"password", "token", "secret", "authorization", "cookie"}
+
+// sensitiveCodeKeys are the names of attributes holding one-time codes.
+// They are matched whole, since keys such as status_code are harmless.
+var sensitiveCodeKeys = map[string]bool{"code": true, "verification_code": true}
+
+var (
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+-]+@([A-Za-z0-9-]+\.)+[A-Za-z]{2,}`)
+	jwtPattern    = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	bearerPattern = regexp.MustCompile(`(?i)(bearer\s+)[^\s"]+`)
+	secretPattern = regexp.MustCompile(`(?i)((?:password|token|secret)[=:]\s*)[^\s&"]+`)
+)
+
+// Redact removes email addresses, JSON web tokens, bearer tokens and
+// key=value secrets from s. Email addresses keep their domain, which is
+// often enough to tell deliveries apart.
+func Redact(s string) string {
+	s = jwtPattern.ReplaceAllString(s, redacted)
+	s = bearerPattern.ReplaceAllString(s, "${1}"+redacted)
+	s = secretPattern.ReplaceAllString(s, "${1}"+redacted)
+	return emailPattern.ReplaceAllStringFunc(s, func(email string) string {
+		return "***" + email[strings.LastIndex(email, "@"):]
+	})
+}
+
+func sensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	if sensitiveCodeKeys[key] {
+		return true
+	}
+	for _, part := range sensitiveKeys {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactAttr hides the values of sensitive attributes and scrubs strings
+// and errors in the others.
+func redactAttr(a slog.Attr) slog.Attr {
+	if sensitiveKey(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+	value := a.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Redact(value.String()))
+	case slog.KindGroup:
+		attrs := value.Group()
+		out := make([]any, len(attrs))
+		for i, attr := range attrs {
+			out[i] = redactAttr(attr)
+		}
+		return slog.Group(a.Key, out...)
+	case slog.KindAny:
+		switch v := value.Any().(type) {
+		case error:
+			return slog.String(a.Key, Redact(v.Error()))
+		case fmt.Stringer:
+			return slog.String(a.Key, Redact(v.String()))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: value}
+}
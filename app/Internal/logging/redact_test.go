@@ -0,0 +1,27 @@
+package logging
+
+import "testing"
+
+func TestSensitiveKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"password", true},
+		{"smtp_password", true},
+		{"id_token", true},
+		{"Authorization", true},
+		{"session_cookie", true},
+		{"code", true},
+		{"Verification_Code", true},
+		{"status_code", false},
+		{"error_code", false},
+		{"country_code", false},
+		{"poll_id", false},
+	}
+	for _, tt := range tests {
+		if got := sensitiveKey(tt.key); got != tt.want {
+			t.Errorf("sensitiveKey(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers a single plain-text email.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPMailer sends mail through an SMTP relay. Username may be empty for
+// relays that do not require authentication.
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(addr, auth, m.From, []string{msg.To}, format(m.From, msg))
+}
+
+// FileMailer is meant for local development. It writes each message as an
+// .eml file into Dir, or only logs it when Dir is empty. The body is never
+// logged, since invitations and guest codes carry credentials in it.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if m.Dir == "" {
+		slog.InfoContext(ctx, "Mail not sent, logged instead", "to", msg.To, "subject", msg.Subject, "body_bytes", len(msg.Body))
+		return nil
+	}
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405"), uuid.New().String()[:8])
+	return os.WriteFile(filepath.Join(m.Dir, name), format(m.From, msg), 0o644)
+}
+
+func format(from string, msg Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", headerValue(from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerValue(msg.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", headerValue(msg.Subject)))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// headerValue folds line breaks into spaces, so values such as poll titles
+// cannot end a header and start another.
+func headerValue(v string) string {
+	return strings.Join(strings.FieldsFunc(v, func(r rune) bool { return r == '\r' || r == '\n' }), " ")
+}
+
+//go:embed templates/*.txt
+var templateFS embed.FS
+
+var templates = template.Must(template.New("").
+	Funcs(template.FuncMap{"header": headerValue}).
+	ParseFS(templateFS, "templates/*.txt"))
+
+// Render executes the named email template. The first line of each template
+// is "Subject: ..." and the rest, after a blank line, is the body. Values
+// in the subject go through header, so they stay on its line.
+func Render(name string, to string, data interface{}) (Message, error) {
+	var b bytes.Buffer
+	if err := templates.ExecuteTemplate(&b, name+".txt", data); err != nil {
+		return Message{}, err
+	}
+	head, body, _ := strings.Cut(b.String(), "\n\n")
+	return Message{
+		To:      to,
+		Subject: strings.TrimSpace(strings.TrimPrefix(head, "Subject:")),
+		Body:    strings.TrimSpace(body) + "\n",
+	}, nil
+}
@@ -0,0 +1,74 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"mime"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestFileMailerLogsNoBody(t *testing.T) {
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+
+	const link = "http://localhost:8080/guest/abc?invite=s3cr3t-invite-token"
+	msg, err := Render("invitation", "guest@example.com", map[string]interface{}{
+		"Title": "Team lunch",
+		"Link":  link,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(msg.Body, link) {
+		t.Fatalf("rendered body %q does not contain the invite link", msg.Body)
+	}
+	if err := (&FileMailer{}).Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	out := logs.String()
+	if !strings.Contains(out, "Team lunch") {
+		t.Errorf("log %q does not name the subject", out)
+	}
+	for _, secret := range []string{"s3cr3t-invite-token", msg.Body} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log %q contains %q", out, secret)
+		}
+	}
+}
+
+func TestFormatKeepsSubjectInOneHeader(t *testing.T) {
+	msg, err := Render("poll_closed", "user@example.com", map[string]interface{}{
+		"Name":  "Ann",
+		"Title": "Lunch\r\nBcc: attacker@example.com\n\nInjected body",
+		"Link":  "http://localhost:8080/polls/1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := mail.ReadMessage(bytes.NewReader(format("no-reply@localhost", msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bcc := parsed.Header.Get("Bcc"); bcc != "" {
+		t.Errorf("injected Bcc header %q", bcc)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Poll closed: Lunch Bcc: attacker@example.com Injected body"; subject != want {
+		t.Errorf("subject %q, want %q", subject, want)
+	}
+	body, err := io.ReadAll(parsed.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(body), "Hello Ann,") {
+		t.Errorf("body %q does not start with the greeting", body)
+	}
+}
@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"context"
+	"database/sql/driver"
+	"time"
+)
+
+// InstrumentConnector wraps a database connector so that every query,
+// statement and transaction boundary is timed and reported to recorder as
+// the operations "query", "exec", "prepare", "begin", "commit" and
+// "rollback". Queries are timed until their first rows are available, not
+// until they have been read.
+func InstrumentConnector(connector driver.Connector, recorder Recorder) driver.Connector {
+	return &instrumentedConnector{Connector: connector, recorder: recorder}
+}
+
+type instrumentedConnector struct {
+	driver.Connector
+	recorder Recorder
+}
+
+func (ic *instrumentedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := ic.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedConn{Conn: conn, recorder: ic.recorder}, nil
+}
+
+// instrumentedConn forwards the optional driver interfaces to the wrapped
+// connection, returning driver.ErrSkip where it lacks one so database/sql
+// falls back as it would without the wrapper.
+type instrumentedConn struct {
+	driver.Conn
+	recorder Recorder
+}
+
+func (c *instrumentedConn) observe(operation string, start time.Time, err error) {
+	if err == driver.ErrSkip {
+		return
+	}
+	c.recorder.DBQuery(operation, time.Since(start), err)
+}
+
+func (c *instrumentedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	c.observe("query", start, err)
+	return rows, err
+}
+
+func (c *instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	c.observe("exec", start, err)
+	return result, err
+}
+
+func (c *instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	start := time.Now()
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	c.observe("prepare", start, err)
+	return stmt, err
+}
+
+func (c *instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	start := time.Now()
+	var tx driver.Tx
+	var err error
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = beginner.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	c.observe("begin", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedTx{Tx: tx, conn: c}, nil
+}
+
+func (c *instrumentedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *instrumentedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *instrumentedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+type instrumentedTx struct {
+	driver.Tx
+	conn *instrumentedConn
+}
+
+func (t *instrumentedTx) Commit() error {
+	start := time.Now()
+	err := t.Tx.Commit()
+	t.conn.observe("commit", start, err)
+	return err
+}
+
+func (t *instrumentedTx) Rollback() error {
+	start := time.Now()
+	err := t.Tx.Rollback()
+	t.conn.observe("rollback", start, err)
+	return err
+}
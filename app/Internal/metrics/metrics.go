@@ -0,0 +1,36 @@
+// Package metrics records what the service does, for Prometheus to scrape
+// at /metrics.
+package metrics
+
+import "time"
+
+// Reasons a vote is refused.
+const (
+	RejectAlreadyVoted = "already_voted"
+	RejectClosed       = "closed"
+	RejectInvalid      = "invalid"
+	RejectNotEligible  = "not_eligible"
+	RejectUnverified   = "unverified"
+)
+
+// Recorder receives the events services count. Prometheus implements it
+// for production and Nop stands in when metrics are not wanted; tests can
+// pass their own to assert on what was recorded.
+type Recorder interface {
+	VoteCast(pollType string)
+	VoteRejected(reason string)
+	Registration()
+	Login(success bool)
+	DBQuery(operation string, duration time.Duration, err error)
+	RateLimited(rule string)
+}
+
+// Nop discards everything.
+type Nop struct{}
+
+func (Nop) VoteCast(string)                      {}
+func (Nop) VoteRejected(string)                  {}
+func (Nop) Registration()                        {}
+func (Nop) Login(bool)                           {}
+func (Nop) DBQuery(string, time.Duration, error) {}
+func (Nop) RateLimited(string)                   {}
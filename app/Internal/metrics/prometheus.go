@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "voteeasy"
+
+// scrapeTimeout bounds the queries run when metrics are scraped.
+const scrapeTimeout = 5 * time.Second
+
+// Prometheus is a Recorder that keeps its metrics in its own registry,
+// along with HTTP traffic and Go runtime metrics.
+type Prometheus struct {
+	Registry *prometheus.Registry
+
+	httpDuration  *prometheus.HistogramVec
+	dbDuration    *prometheus.HistogramVec
+	dbErrors      *prometheus.CounterVec
+	votesCast     *prometheus.CounterVec
+	voteRejects   *prometheus.CounterVec
+	registrations prometheus.Counter
+	logins        *prometheus.CounterVec
+	rateLimited   *prometheus.CounterVec
+}
+
+func NewPrometheus() *Prometheus {
+	p := &Prometheus{
+		Registry: prometheus.NewRegistry(),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to serve HTTP requests, by route and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		dbDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "db_query_duration_seconds",
+			Help:      "Time taken by database calls, by operation.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"operation"}),
+		dbErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "db_query_errors_total",
+			Help:      "Database calls that failed, by operation.",
+		}, []string{"operation"}),
+		votesCast: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "votes_cast_total",
+			Help:      "Votes recorded, by poll type.",
+		}, []string{"poll_type"}),
+		voteRejects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "vote_rejections_total",
+			Help:      "Votes refused, by reason.",
+		}, []string{"reason"}),
+		registrations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "registrations_total",
+			Help:      "Accounts registered.",
+		}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "logins_total",
+			Help:      "Sign-ins, by result.",
+		}, []string{"result"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limited_total",
+			Help:      "Requests refused for coming too fast or during a lockout, by rule.",
+		}, []string{"rule"}),
+	}
+	p.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		p.httpDuration, p.dbDuration, p.dbErrors,
+		p.votesCast, p.voteRejects, p.registrations, p.logins,
+		p.rateLimited,
+	)
+	return p
+}
+
+func (p *Prometheus) VoteCast(pollType string) {
+	p.votesCast.WithLabelValues(pollType).Inc()
+}
+
+func (p *Prometheus) VoteRejected(reason string) {
+	p.voteRejects.WithLabelValues(reason).Inc()
+}
+
+func (p *Prometheus) Registration() {
+	p.registrations.Inc()
+}
+
+func (p *Prometheus) Login(success bool) {
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+	p.logins.WithLabelValues(result).Inc()
+}
+
+func (p *Prometheus) DBQuery(operation string, duration time.Duration, err error) {
+	p.dbDuration.WithLabelValues(operation).Observe(duration.Seconds())
+	if err != nil {
+		p.dbErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+func (p *Prometheus) RateLimited(rule string) {
+	p.rateLimited.WithLabelValues(rule).Inc()
+}
+
+// WatchDB exports the connection pool statistics of db.
+func (p *Prometheus) WatchDB(db *sql.DB) {
+	p.Registry.MustRegister(collectors.NewDBStatsCollector(db, "postgres"))
+}
+
+// WatchActivePolls exports the number of polls open for voting, counted by
+// count on every scrape.
+func (p *Prometheus) WatchActivePolls(count func(context.Context) (int, error)) {
+	p.Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_polls",
+		Help:      "Polls currently open for voting.",
+	}, func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+		defer cancel()
+		n, err := count(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to count active polls", "error", err)
+			return 0
+		}
+		return float64(n)
+	}))
+}
+
+// Middleware times every request. Requests that match no route are
+// grouped under "unmatched", so probing random URLs cannot create new
+// series.
+func (p *Prometheus) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		p.httpDuration.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (p *Prometheus) Handler() http.Handler {
+	return promhttp.HandlerFor(p.Registry, promhttp.HandlerOpts{})
+}
@@ -1,20 +1,29 @@
 package middlewares
 
 import (
-	"context"
-	"log"
+	"log/slog"
 	"net/http"
+	"strings"
+
+	"fakidoosuurdoris/app/Internal/tracing"
+	"fakidoosuurdoris/app/config"
 
 	"firebase.google.com/go/v4/auth"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
-func AuthMiddleware(authClient *auth.Client) gin.HandlerFunc {
+func AuthMiddleware(authClient *auth.Client, cookies config.CookieConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		idToken, err := c.Cookie("idToken")
+		// API clients such as the sync command send the ID token as a bearer
+		// token instead of the session cookie.
+		idToken, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		var err error
+		if !ok || idToken == "" {
+			idToken, err = c.Cookie("idToken")
+		}
 		if err != nil {
-			log.Println("No idToken cookie found")
+			slog.DebugContext(c.Request.Context(), "No session cookie, redirecting to login")
 			if c.Request.URL.Path == "/polls" {
 				c.Redirect(http.StatusSeeOther, "/login?expired=true")
 			} else {
@@ -24,10 +33,12 @@ func AuthMiddleware(authClient *auth.Client) gin.HandlerFunc {
 			return
 		}
 
-		token, err := authClient.VerifyIDToken(context.Background(), idToken)
+		ctx, done := tracing.Identity(c.Request.Context(), "VerifyIDToken")
+		token, err := authClient.VerifyIDToken(ctx, idToken)
+		done(err)
 		if err != nil {
-			log.Printf("Invalid token: %v", err)
-			c.SetCookie("idToken", "", -1, "/", "localhost", false, true) // Clear expired token
+			slog.WarnContext(c.Request.Context(), "Invalid session token", "error", err)
+			c.SetCookie("idToken", "", -1, "/", cookies.Domain, cookies.Secure, true) // Clear expired token
 			if c.Request.URL.Path == "/polls" {
 				c.Redirect(http.StatusSeeOther, "/login?expired=true")
 			} else {
@@ -42,29 +53,54 @@ func AuthMiddleware(authClient *auth.Client) gin.HandlerFunc {
 	}
 }
 
-func CSRFMiddleware() gin.HandlerFunc {
+func CSRFMiddleware(cookies config.CookieConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
 
 		csrfToken, err := c.Cookie("csrf_token")
 		if err != nil || csrfToken == "" {
 			csrfTokenStr := uuid.New().String()
-			c.SetCookie("csrf_token", csrfTokenStr, 3600, "/", "localhost", false, true)
+			c.SetCookie("csrf_token", csrfTokenStr, cookies.MaxAge(cookies.CSRFTTL), "/", cookies.Domain, cookies.Secure, true)
 			c.Set("csrf_token", csrfTokenStr)
 		} else {
 			c.Set("csrf_token", csrfToken)
 		}
 
+		// Chat integrations authenticate with a request signature over the raw
+		// body, which must not be consumed here.
+		if strings.HasPrefix(c.Request.URL.Path, "/integrations/") {
+			c.Next()
+			return
+		}
+
+		// Embedded pages are framed by other sites, where the CSRF cookie may
+		// not be sent; their forms carry a token signed for the guest instead.
+		if strings.HasPrefix(c.Request.URL.Path, "/embed/") {
+			c.Next()
+			return
+		}
+
+		// Browsers never attach a bearer token on their own, so requests that
+		// authenticate with one cannot be forged cross-site.
+		if strings.HasPrefix(c.GetHeader("Authorization"), "Bearer ") {
+			c.Next()
+			return
+		}
+
 		if c.Request.Method == "POST" && c.Request.URL.Path != "/login" && c.Request.URL.Path != "/register" {
 			formToken := c.PostForm("csrf_token")
+			if formToken == "" {
+				// JSON API clients send the token in a header instead.
+				formToken = c.GetHeader("X-CSRF-Token")
+			}
 			cookieToken, err := c.Cookie("csrf_token")
-			log.Printf("CSRF check: Form token=%s, Cookie token=%s, Error=%v", formToken, cookieToken, err)
 			if err != nil || formToken == "" || formToken != cookieToken {
-				log.Println("Invalid CSRF token")
+				slog.WarnContext(c.Request.Context(), "Invalid CSRF token",
+					"path", c.Request.URL.Path, "has_cookie", err == nil, "has_form_token", formToken != "")
 				if c.Request.URL.Path == "/polls" {
 					csrfTokenStr := cookieToken
 					if csrfTokenStr == "" {
 						csrfTokenStr = uuid.New().String()
-						c.SetCookie("csrf_token", csrfTokenStr, 3600, "/", "localhost", false, true)
+						c.SetCookie("csrf_token", csrfTokenStr, cookies.MaxAge(cookies.CSRFTTL), "/", cookies.Domain, cookies.Secure, true)
 					}
 					c.HTML(http.StatusBadRequest, "createpolls.html", gin.H{
 						"Title":     "Create Poll",
@@ -77,7 +113,6 @@ func CSRFMiddleware() gin.HandlerFunc {
 				c.Abort()
 				return
 			}
-			log.Println("CSRF token valid")
 		}
 	}
 }
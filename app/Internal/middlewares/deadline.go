@@ -0,0 +1,20 @@
+package middlewares
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NoWriteDeadline lifts the server's write timeout for downloads that are
+// streamed and may take longer to write than a page.
+func NoWriteDeadline() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := http.NewResponseController(c.Writer).SetWriteDeadline(time.Time{}); err != nil {
+			slog.WarnContext(c.Request.Context(), "Failed to lift write deadline", "path", c.FullPath(), "error", err)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,37 @@
+package middlewares
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FrameGuard stops other sites from framing the application, which would
+// let them trick users into clicking its buttons. Embed pages are left to
+// EmbedPolicy.
+func FrameGuard() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.HasPrefix(c.Request.URL.Path, "/embed/") {
+			c.Header("X-Frame-Options", "DENY")
+			c.Header("Content-Security-Policy", "frame-ancestors 'none'")
+		}
+		c.Next()
+	}
+}
+
+// EmbedPolicy lets the sites in frameAncestors frame the embed pages and
+// otherwise locks them down: no scripts, inline styles only, and forms and
+// images restricted to this site, apart from option images over HTTPS.
+func EmbedPolicy(frameAncestors []string) gin.HandlerFunc {
+	ancestors := "'self'"
+	if len(frameAncestors) > 0 {
+		ancestors = strings.Join(frameAncestors, " ")
+	}
+	policy := "default-src 'none'; style-src 'unsafe-inline'; img-src 'self' data: https:; " +
+		"form-action 'self'; base-uri 'none'; frame-ancestors " + ancestors
+	return func(c *gin.Context) {
+		c.Header("Content-Security-Policy", policy)
+		c.Header("Referrer-Policy", "no-referrer")
+		c.Next()
+	}
+}
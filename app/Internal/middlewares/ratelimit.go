@@ -0,0 +1,184 @@
+package middlewares
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fakidoosuurdoris/app/Internal/metrics"
+	"fakidoosuurdoris/app/Internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitHeaders are the headers RateLimit sets, for CORS to expose.
+var RateLimitHeaders = []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After"}
+
+// RateRule names a limit and says whom it applies to.
+type RateRule struct {
+	// Name labels the rule in keys, logs and metrics.
+	Name  string
+	Limit ratelimit.Limit
+	// ByUser keys the limit by the signed-in user, so users behind one
+	// address do not share it. Requests without a user fall back to their
+	// address.
+	ByUser bool
+	// Template is optional; when set, browsers that are refused see it,
+	// with Title and an error, instead of plain text.
+	Template string
+	Title    string
+}
+
+// RateLimit refuses requests beyond rule's limit with 429 Too Many
+// Requests and a Retry-After header. Every route keeps its own bucket per
+// client, and every response says how much of it is left in the
+// RateLimit-* headers of the IETF draft. When the store fails, requests are
+// let through rather than locking everyone out.
+func RateLimit(store ratelimit.Store, rule RateRule, recorder metrics.Recorder) gin.HandlerFunc {
+	if !rule.Limit.Enabled() {
+		return func(c *gin.Context) { c.Next() }
+	}
+	policy := fmt.Sprintf("%d;w=%d", rule.Limit.Requests, seconds(rule.Limit.Per))
+	return func(c *gin.Context) {
+		subject := "ip:" + c.ClientIP()
+		if uid := c.GetString("uid"); rule.ByUser && uid != "" {
+			subject = "user:" + uid
+		}
+		key := rule.Name + ":" + c.FullPath() + ":" + subject
+
+		result, err := store.Take(c.Request.Context(), key, rule.Limit)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Rate limit store failed, allowing request", "rule", rule.Name, "error", err)
+			c.Next()
+			return
+		}
+		c.Header("RateLimit-Limit", strconv.Itoa(rule.Limit.Requests))
+		c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+		c.Header("RateLimit-Policy", policy)
+		if !result.Allowed {
+			recorder.RateLimited(rule.Name)
+			slog.WarnContext(c.Request.Context(), "Rate limit exceeded", "rule", rule.Name, "client_ip", c.ClientIP())
+			tooManyRequests(c, result.RetryAfter, rule.Template, rule.Title,
+				"Too many requests. Please try again in "+wait(result.RetryAfter)+".")
+			return
+		}
+		c.Next()
+	}
+}
+
+// loginAttemptKey is the context key of the loginAttempt that
+// LoginLockout leaves for the sign-in handler.
+const loginAttemptKey = "login_attempt"
+
+// LoginLockout locks a client address out of signing in after repeated
+// failures, as policy sets out. The sign-in handler reports how each
+// attempt ended with LoginFailed or LoginSucceeded. Failures are not kept
+// per email address: the failures seen here are bad tokens, whose email
+// address is whatever the client sent, so anyone could lock a victim out.
+// Lockouts are answered like rate limits, as 429 with Retry-After.
+func LoginLockout(store ratelimit.Store, policy ratelimit.Lockout, recorder metrics.Recorder) gin.HandlerFunc {
+	if !policy.Enabled() {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		attempt := &loginAttempt{store: store, policy: policy, key: "login:ip:" + c.ClientIP()}
+
+		locked, err := store.LockedOut(ctx, attempt.key)
+		if err != nil {
+			slog.ErrorContext(ctx, "Rate limit store failed, allowing sign-in", "error", err)
+		}
+		if locked > 0 {
+			recorder.RateLimited("login_lockout")
+			tooManyRequests(c, locked, "login.html", "Login",
+				"Too many failed sign-ins. Please try again in "+wait(locked)+".")
+			return
+		}
+
+		c.Set(loginAttemptKey, attempt)
+		c.Next()
+	}
+}
+
+// loginAttempt is a sign-in let through by LoginLockout, with the key its
+// failures count against.
+type loginAttempt struct {
+	store  ratelimit.Store
+	policy ratelimit.Lockout
+	key    string
+}
+
+// LoginFailed counts a failed sign-in against the client address. It does
+// nothing without LoginLockout.
+func LoginFailed(c *gin.Context) {
+	value, _ := c.Get(loginAttemptKey)
+	attempt, ok := value.(*loginAttempt)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	lock, err := attempt.store.Fail(ctx, attempt.key, attempt.policy)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to record sign-in failure", "error", err)
+	} else if lock > 0 {
+		slog.WarnContext(ctx, "Sign-in locked after repeated failures", "client_ip", c.ClientIP(), "lockout", lock)
+	}
+}
+
+// LoginSucceeded clears the failures of the client address. It does
+// nothing without LoginLockout.
+func LoginSucceeded(c *gin.Context) {
+	value, _ := c.Get(loginAttemptKey)
+	attempt, ok := value.(*loginAttempt)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	if err := attempt.store.Reset(ctx, attempt.key); err != nil {
+		slog.ErrorContext(ctx, "Failed to clear sign-in failures", "error", err)
+	}
+}
+
+// tooManyRequests aborts with 429, as JSON for the API and as template or
+// plain text for browsers.
+func tooManyRequests(c *gin.Context, retryAfter time.Duration, template, title, message string) {
+	c.Header("Retry-After", strconv.Itoa(seconds(retryAfter)))
+	switch {
+	case strings.HasPrefix(c.Request.URL.Path, "/api/"):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": message})
+	case template != "":
+		csrfToken, _ := c.Get("csrf_token")
+		c.HTML(http.StatusTooManyRequests, template, gin.H{
+			"Title":     title,
+			"Error":     message,
+			"CSRFToken": csrfToken,
+		})
+	default:
+		c.String(http.StatusTooManyRequests, message)
+	}
+	c.Abort()
+}
+
+// seconds rounds d up to whole seconds, as the headers count them.
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// wait describes d for people, such as "5 seconds" or "3 minutes".
+func wait(d time.Duration) string {
+	s := seconds(d)
+	switch {
+	case s <= 1:
+		return "a second"
+	case s < 60:
+		return strconv.Itoa(s) + " seconds"
+	case s <= 60:
+		return "a minute"
+	default:
+		return strconv.Itoa((s+59)/60) + " minutes"
+	}
+}
@@ -0,0 +1,89 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"fakidoosuurdoris/app/Internal/metrics"
+	"fakidoosuurdoris/app/Internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loginRouter serves a sign-in that fails without an idToken, is not
+// reported with idToken "unreported" and otherwise succeeds.
+func loginRouter(store ratelimit.Store) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	policy := ratelimit.Lockout{Threshold: 2, Window: time.Hour, Base: time.Minute, Max: time.Hour}
+	r := gin.New()
+	r.POST("/api/login", LoginLockout(store, policy, metrics.Nop{}), func(c *gin.Context) {
+		switch c.PostForm("idToken") {
+		case "":
+			LoginFailed(c)
+			c.Status(http.StatusUnauthorized)
+		case "unreported":
+			c.Status(http.StatusUnauthorized)
+		default:
+			LoginSucceeded(c)
+			c.Status(http.StatusSeeOther)
+		}
+	})
+	return r
+}
+
+func postLogin(r *gin.Engine, addr, email, idToken string) *httptest.ResponseRecorder {
+	form := url.Values{"email": {email}, "idToken": {idToken}}
+	req := httptest.NewRequest(http.MethodPost, "/api/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = addr + ":1234"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestLoginLockout(t *testing.T) {
+	r := loginRouter(ratelimit.NewMemory())
+	steps := []struct {
+		name    string
+		addr    string
+		email   string
+		idToken string
+		want    int
+	}{
+		{"first failure", "10.0.0.1", "user@example.com", "", http.StatusUnauthorized},
+		{"unreported failure", "10.0.0.1", "user@example.com", "unreported", http.StatusUnauthorized},
+		{"unreported failure not counted", "10.0.0.1", "user@example.com", "token", http.StatusSeeOther},
+		{"failure after success", "10.0.0.1", "user@example.com", "", http.StatusUnauthorized},
+		{"success cleared earlier failures", "10.0.0.1", "user@example.com", "token", http.StatusSeeOther},
+		{"first failure of address", "10.0.0.2", "third@example.com", "", http.StatusUnauthorized},
+		{"second failure of address", "10.0.0.2", "fourth@example.com", "", http.StatusUnauthorized},
+		{"address locked for any email", "10.0.0.2", "fifth@example.com", "token", http.StatusTooManyRequests},
+		{"other addresses not locked", "10.0.0.3", "fifth@example.com", "token", http.StatusSeeOther},
+	}
+	for _, step := range steps {
+		w := postLogin(r, step.addr, step.email, step.idToken)
+		if w.Code != step.want {
+			t.Errorf("%s: status %d, want %d", step.name, w.Code, step.want)
+		}
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: no Retry-After", step.name)
+		}
+	}
+}
+
+func TestLoginLockoutIgnoresForgedEmail(t *testing.T) {
+	r := loginRouter(ratelimit.NewMemory())
+	for i := range 5 {
+		addr := "192.0.2." + strconv.Itoa(i+1)
+		postLogin(r, addr, "victim@example.com", "")
+		postLogin(r, addr, "victim@example.com", "")
+	}
+	if got := postLogin(r, "10.0.0.1", "victim@example.com", "token").Code; got != http.StatusSeeOther {
+		t.Errorf("victim signing in after forged failures: status %d, want %d", got, http.StatusSeeOther)
+	}
+}
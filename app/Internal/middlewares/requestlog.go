@@ -0,0 +1,77 @@
+package middlewares
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"time"
+
+	"fakidoosuurdoris/app/Internal/logging"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the request ID in both directions, so a proxy's
+// ID is kept and clients can quote it when reporting problems.
+const RequestIDHeader = "X-Request-ID"
+
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// RequestLogger gives every request an ID, stores it in the request
+// context so that everything logged while serving it can be correlated,
+// and logs the request once served. routeLevels sets the log level for
+// routes, by pattern, that should log more or less than the default.
+//
+// Only the path is logged, never the query string, which can carry invite
+// tokens and email addresses.
+func RequestLogger(routeLevels map[string]slog.Level) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(id) {
+			id = uuid.NewString()
+		}
+		c.Header(RequestIDHeader, id)
+
+		route := c.FullPath()
+		ctx := logging.WithRequestID(c.Request.Context(), id)
+		if level, ok := routeLevels[route]; ok {
+			ctx = logging.WithLevel(ctx, level)
+		}
+		c.Request = c.Request.WithContext(ctx)
+
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		if route == "" {
+			route = "unmatched"
+		}
+		// Later middlewares may have added to the context, such as the trace.
+		slog.Log(c.Request.Context(), level, "Request served",
+			"method", c.Request.Method,
+			"route", route,
+			"path", c.Request.URL.Path,
+			"status", status,
+			"duration_ms", float64(time.Since(start).Microseconds())/1000,
+			"bytes", max(c.Writer.Size(), 0),
+			"client_ip", c.ClientIP(),
+		)
+	}
+}
+
+// Recovery turns a panic into a 500 response and logs it, with its stack,
+// against the request that caused it.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, err any) {
+		slog.ErrorContext(c.Request.Context(), "Panic while serving request",
+			"error", err, "stack", string(debug.Stack()))
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
@@ -10,13 +10,18 @@ type Poll struct {
 	StartDate    time.Time  `json:"start_date"`
 	EndDate      *time.Time `json:"end_date"`
 	IsAnonymous  bool       `json:"is_anonymous"`
+	OptionOrder  string     `json:"option_order"` // fixed, random
 	CreatedAt    time.Time  `json:"created_at"`
 }
 
 type Option struct {
-	ID     int64  `json:"id"`
-	PollID int64  `json:"poll_id"`
-	Text   string `json:"option_text"`
+	ID          int64  `json:"id"`
+	PollID      int64  `json:"poll_id"`
+	Text        string `json:"option_text"`
+	Description string `json:"description"`
+	ImageURL    string `json:"image_url"`
+	LinkURL     string `json:"link_url"`
+	Position    int    `json:"position"`
 }
 
 type Vote struct {
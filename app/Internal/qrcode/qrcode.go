@@ -0,0 +1,75 @@
+// Package qrcode renders QR codes for poll links as PNG or SVG images.
+package qrcode
+
+import (
+	"fmt"
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// quietZone is the blank border, in modules, that scanners need around a
+// code.
+const quietZone = 4
+
+// Size limits of PNG images, in pixels.
+const (
+	DefaultSize = 256
+	MaxSize     = 2048
+)
+
+func encode(text string) (*qr.Code, error) {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return nil, fmt.Errorf("encode QR code: %w", err)
+	}
+	return code, nil
+}
+
+// PNG returns a code for text as a PNG image of at most size pixels a side.
+// Modules are whole pixels, so the image is smaller than size unless it is
+// an exact multiple of the module count.
+func PNG(text string, size int) ([]byte, error) {
+	code, err := encode(text)
+	if err != nil {
+		return nil, err
+	}
+	if size <= 0 {
+		size = DefaultSize
+	}
+	code.Scale = min(size, MaxSize) / (code.Size + 2*quietZone)
+	if code.Scale < 1 {
+		code.Scale = 1
+	}
+	return code.PNG(), nil
+}
+
+// SVG returns a code for text as an SVG image that scales to its container.
+// Each row of dark modules is drawn as horizontal runs in a single path.
+func SVG(text string) ([]byte, error) {
+	code, err := encode(text)
+	if err != nil {
+		return nil, err
+	}
+	n := code.Size + 2*quietZone
+
+	var path strings.Builder
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; {
+			if !code.Black(x, y) {
+				x++
+				continue
+			}
+			run := 1
+			for x+run < code.Size && code.Black(x+run, y) {
+				run++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", x+quietZone, y+quietZone, run, run)
+			x += run
+		}
+	}
+
+	svg := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`+
+		`<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="%s"/></svg>`, n, n, n, n, path.String())
+	return []byte(svg), nil
+}
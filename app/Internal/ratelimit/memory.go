@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often Memory drops buckets that have refilled and
+// failures that have been forgotten.
+const sweepInterval = time.Minute
+
+// Memory is a Store that keeps everything in the process.
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	failures  map[string]*failures
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// bucket holds the time at which it is full again rather than a token
+// count, so refilling needs no bookkeeping: each request moves it one
+// interval later.
+type bucket struct {
+	full time.Time
+}
+
+type failures struct {
+	count       int
+	last        time.Time
+	lockedUntil time.Time
+	window      time.Duration
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		buckets:  make(map[string]*bucket),
+		failures: make(map[string]*failures),
+		now:      time.Now,
+	}
+}
+
+func (m *Memory) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.sweep(now)
+
+	interval := limit.interval()
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{full: now}
+		m.buckets[key] = b
+	}
+	if b.full.Before(now) {
+		b.full = now
+	}
+
+	// The bucket holds Requests tokens when full; each one still missing
+	// pushes full an interval into the future.
+	empty := now.Add(limit.Per)
+	next := b.full.Add(interval)
+	if next.After(empty) {
+		return Result{
+			Allowed:    false,
+			RetryAfter: next.Sub(empty),
+			Reset:      b.full.Sub(now),
+		}, nil
+	}
+	b.full = next
+	return Result{
+		Allowed:   true,
+		Remaining: int(empty.Sub(next) / interval),
+		Reset:     next.Sub(now),
+	}, nil
+}
+
+func (m *Memory) Fail(ctx context.Context, key string, policy Lockout) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.sweep(now)
+
+	f, ok := m.failures[key]
+	if !ok || f.expired(now) {
+		f = &failures{}
+		m.failures[key] = f
+	}
+	f.count++
+	f.last = now
+	f.window = policy.Window
+	lock := policy.duration(f.count)
+	if lock > 0 {
+		f.lockedUntil = now.Add(lock)
+	}
+	return lock, nil
+}
+
+func (m *Memory) LockedOut(ctx context.Context, key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if f, ok := m.failures[key]; ok {
+		if remaining := f.lockedUntil.Sub(m.now()); remaining > 0 {
+			return remaining, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *Memory) Reset(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.failures, key)
+	return nil
+}
+
+// expired reports whether the failures are old enough to be forgotten.
+func (f *failures) expired(now time.Time) bool {
+	since := f.last
+	if f.lockedUntil.After(since) {
+		since = f.lockedUntil
+	}
+	return now.Sub(since) > f.window
+}
+
+// sweep drops state that no longer matters, so clients that come once do
+// not stay in memory. The caller holds the lock.
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, b := range m.buckets {
+		if !b.full.After(now) {
+			delete(m.buckets, key)
+		}
+	}
+	for key, f := range m.failures {
+		if f.expired(now) {
+			delete(m.failures, key)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Package ratelimit throttles clients with token buckets and locks out
+// clients that keep failing to sign in.
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Limit allows Requests in a burst, refilling evenly over Per: with 10 per
+// minute, a client that has used up its burst gets another request every
+// six seconds. A limit without requests is off.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// Enabled reports whether the limit allows anything at all.
+func (l Limit) Enabled() bool {
+	return l.Requests > 0 && l.Per > 0
+}
+
+// interval is how long one request takes to refill.
+func (l Limit) interval() time.Duration {
+	return l.Per / time.Duration(l.Requests)
+}
+
+// Result reports what a bucket looked like after a request was counted.
+type Result struct {
+	Allowed bool
+	// Remaining is how many requests may still be made at once.
+	Remaining int
+	// RetryAfter is how long a refused client must wait.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Lockout locks a client out once it has failed Threshold times, first for
+// Base and twice as long with each further failure, up to Max. Failures
+// are forgotten once a client has gone Window without one, counted from
+// the end of any lockout.
+type Lockout struct {
+	Threshold int
+	Window    time.Duration
+	Base      time.Duration
+	Max       time.Duration
+}
+
+// Enabled reports whether failures lead to lockouts.
+func (p Lockout) Enabled() bool {
+	return p.Threshold > 0 && p.Base > 0
+}
+
+// duration is how long a client is locked out after its failures-th
+// failure.
+func (p Lockout) duration(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+	lock := p.Base
+	for i := p.Threshold; i < failures && lock < p.Max; i++ {
+		lock *= 2
+	}
+	if p.Max > 0 && lock > p.Max {
+		lock = p.Max
+	}
+	return lock
+}
+
+// Store keeps buckets and failure counts. Memory keeps them in the
+// process, which suits a single replica; deployments with several need a
+// store they share, such as one backed by Redis or Postgres, or each
+// replica enforces limits of its own.
+type Store interface {
+	// Take counts a request against the bucket for key.
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+	// Fail records a failure for key and returns how long key is now
+	// locked out, if at all.
+	Fail(ctx context.Context, key string, policy Lockout) (time.Duration, error)
+	// LockedOut returns how long key is still locked out.
+	LockedOut(ctx context.Context, key string) (time.Duration, error)
+	// Reset forgets the failures of key.
+	Reset(ctx context.Context, key string) error
+}
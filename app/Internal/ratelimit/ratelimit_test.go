@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// clock is a settable time source for Memory.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+func newTestMemory() (*Memory, *clock) {
+	c := &clock{t: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)}
+	m := NewMemory()
+	m.now = c.now
+	return m, c
+}
+
+func TestMemoryTake(t *testing.T) {
+	limit := Limit{Requests: 3, Per: 3 * time.Second}
+	steps := []struct {
+		name    string
+		advance time.Duration
+		want    Result
+	}{
+		{"first of burst", 0, Result{Allowed: true, Remaining: 2, Reset: time.Second}},
+		{"second of burst", 0, Result{Allowed: true, Remaining: 1, Reset: 2 * time.Second}},
+		{"burst used up", 0, Result{Allowed: true, Remaining: 0, Reset: 3 * time.Second}},
+		{"refused", 0, Result{Allowed: false, RetryAfter: time.Second, Reset: 3 * time.Second}},
+		{"refused later", 400 * time.Millisecond, Result{Allowed: false, RetryAfter: 600 * time.Millisecond, Reset: 2600 * time.Millisecond}},
+		{"one refilled", 600 * time.Millisecond, Result{Allowed: true, Remaining: 0, Reset: 3 * time.Second}},
+		{"two refilled", 2 * time.Second, Result{Allowed: true, Remaining: 1, Reset: 2 * time.Second}},
+		{"full again", time.Hour, Result{Allowed: true, Remaining: 2, Reset: time.Second}},
+	}
+	m, c := newTestMemory()
+	for _, step := range steps {
+		c.advance(step.advance)
+		got, err := m.Take(context.Background(), "k", limit)
+		if err != nil {
+			t.Fatalf("%s: Take failed: %v", step.name, err)
+		}
+		if got != step.want {
+			t.Errorf("%s: Take = %+v, want %+v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestMemoryTakeKeysAreSeparate(t *testing.T) {
+	m, _ := newTestMemory()
+	limit := Limit{Requests: 1, Per: time.Minute}
+	for _, key := range []string{"a", "b"} {
+		if got, _ := m.Take(context.Background(), key, limit); !got.Allowed {
+			t.Errorf("first Take of %q refused", key)
+		}
+	}
+	if got, _ := m.Take(context.Background(), "a", limit); got.Allowed {
+		t.Errorf("second Take of %q allowed", "a")
+	}
+}
+
+func TestLockoutDuration(t *testing.T) {
+	policy := Lockout{Threshold: 3, Window: time.Hour, Base: time.Minute, Max: 10 * time.Minute}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 8 * time.Minute},
+		{7, 10 * time.Minute},
+		{50, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := policy.duration(tt.failures); got != tt.want {
+			t.Errorf("duration(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryLockout(t *testing.T) {
+	policy := Lockout{Threshold: 2, Window: 10 * time.Minute, Base: time.Minute, Max: time.Hour}
+	steps := []struct {
+		name    string
+		advance time.Duration
+		action  string // fail, check or reset
+		want    time.Duration
+	}{
+		{"below threshold", 0, "fail", 0},
+		{"not locked", 0, "check", 0},
+		{"threshold reached", time.Minute, "fail", time.Minute},
+		{"locked", 20 * time.Second, "check", 40 * time.Second},
+		{"lock over", 40 * time.Second, "check", 0},
+		{"doubled", 0, "fail", 2 * time.Minute},
+		{"cleared on success", 0, "reset", 0},
+		{"counted afresh", 0, "fail", 0},
+		{"remembered within window", 9 * time.Minute, "fail", time.Minute},
+		{"forgotten after window", time.Minute + 10*time.Minute + time.Second, "fail", 0},
+	}
+	m, c := newTestMemory()
+	ctx := context.Background()
+	for _, step := range steps {
+		c.advance(step.advance)
+		var got time.Duration
+		var err error
+		switch step.action {
+		case "fail":
+			got, err = m.Fail(ctx, "k", policy)
+		case "check":
+			got, err = m.LockedOut(ctx, "k")
+		case "reset":
+			err = m.Reset(ctx, "k")
+		}
+		if err != nil {
+			t.Fatalf("%s: %s failed: %v", step.name, step.action, err)
+		}
+		if got != step.want {
+			t.Errorf("%s: %s = %v, want %v", step.name, step.action, got, step.want)
+		}
+	}
+}
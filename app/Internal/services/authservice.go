@@ -4,144 +4,435 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"fakidoosuurdoris/app/Internal/metrics"
+	"fakidoosuurdoris/app/Internal/tracing"
 
 	firebase "firebase.google.com/go/v4"
 	"firebase.google.com/go/v4/auth"
+	"github.com/google/uuid"
 )
 
 type AuthService struct {
 	DB         *sql.DB
 	Firebase   *firebase.App
 	AuthClient *auth.Client
+	// Notifications is optional; when set, users are emailed about role changes.
+	Notifications *NotificationService
+	// Webhooks is optional; when set, account deletions are emitted as user.deleted.
+	Webhooks *WebhookService
+	// Metrics is optional; when set, registrations and logins are counted.
+	Metrics metrics.Recorder
 }
 
-func NewAuthService(db *sql.DB, fbApp *firebase.App, authClient *auth.Client) *AuthService {
+func NewAuthService(db *sql.DB, fbApp *firebase.App, authClient *auth.Client, notifications *NotificationService, webhooks *WebhookService, recorder metrics.Recorder) *AuthService {
 	return &AuthService{
-		DB:         db,
-		Firebase:   fbApp,
-		AuthClient: authClient,
+		DB:            db,
+		Firebase:      fbApp,
+		AuthClient:    authClient,
+		Notifications: notifications,
+		Webhooks:      webhooks,
+		Metrics:       recorder,
+	}
+}
+
+func (s *AuthService) metrics() metrics.Recorder {
+	if s.Metrics == nil {
+		return metrics.Nop{}
+	}
+	return s.Metrics
+}
+
+// firebaseKeysURL serves the keys ID tokens are signed with. VerifyIDToken
+// fetches them, so sign-ins fail while it cannot be reached.
+const firebaseKeysURL = "https://www.googleapis.com/robot/v1/metadata/x509/securetoken@system.gserviceaccount.com"
+
+// PingIdentityProvider checks that Firebase can be reached to verify
+// sign-ins.
+func (s *AuthService) PingIdentityProvider(ctx context.Context) (err error) {
+	ctx, done := tracing.Identity(ctx, "Ping")
+	defer func() { done(err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, firebaseKeysURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
 	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("identity provider returned %s", resp.Status)
+	}
+	return nil
 }
 
 func (s *AuthService) Register(ctx context.Context, firstname, lastname, email, password, role string) (string, error) {
+	ctx, span := startSpan(ctx, "AuthService.Register")
+	defer span.End()
+
 	params := (&auth.UserToCreate{}).
 		Email(email).
 		Password(password)
-	user, err := s.AuthClient.CreateUser(ctx, params)
+	idCtx, done := tracing.Identity(ctx, "CreateUser")
+	user, err := s.AuthClient.CreateUser(idCtx, params)
+	done(err)
 	if err != nil {
 		return "", err
 	}
 
 	query := `INSERT INTO users (id, firstname, lastname, email, role) VALUES ($1, $2, $3, $4, $5)`
-	_, err = s.DB.Exec(query, user.UID, firstname, lastname, email, role)
+	_, err = s.DB.ExecContext(ctx, query, user.UID, firstname, lastname, email, role)
 	if err != nil {
-		log.Printf("Database error: %v", err)
+		slog.ErrorContext(ctx, "Database error", "error", err)
 		return "", err
 	}
 
+	s.metrics().Registration()
 	return user.UID, nil
 }
 
 func (s *AuthService) Login(ctx context.Context, idToken string) (string, string, error) {
-	log.Println("Verifying ID token...")
+	ctx, span := startSpan(ctx, "AuthService.Login")
+	defer span.End()
+
+	slog.DebugContext(ctx, "Verifying ID token")
 	if s.AuthClient == nil {
 		return "", "", errors.New("auth client not initialized")
 	}
 
-	token, err := s.AuthClient.VerifyIDToken(ctx, idToken)
+	idCtx, done := tracing.Identity(ctx, "VerifyIDToken")
+	token, err := s.AuthClient.VerifyIDToken(idCtx, idToken)
+	done(err)
 	if err != nil {
-		log.Println("Failed to verify ID token:", err)
+		slog.WarnContext(ctx, "Failed to verify ID token", "error", err)
+		s.metrics().Login(false)
 		return "", "", err
 	}
 
-	log.Println("Token verified. Getting user...")
+	slog.DebugContext(ctx, "ID token verified")
 
-	user, err := s.AuthClient.GetUser(ctx, token.UID)
+	idCtx, done = tracing.Identity(ctx, "GetUser")
+	user, err := s.AuthClient.GetUser(idCtx, token.UID)
+	done(err)
 	if err != nil {
-		log.Println("Failed to get user:", err)
+		slog.ErrorContext(ctx, "Failed to get user", "error", err)
+		s.metrics().Login(false)
 		return "", "", err
 	}
 
-	log.Println("Login successful for UID:", user.UID)
+	slog.InfoContext(ctx, "Login successful", "user_id", user.UID)
+	s.metrics().Login(true)
 
 	return idToken, user.UID, nil
 }
 
 func (s *AuthService) GetUserRole(ctx context.Context, userID string) (string, error) {
+	ctx, span := startSpan(ctx, "AuthService.GetUserRole")
+	defer span.End()
+
 	query := "SELECT role FROM users WHERE id = $1"
 	var role string
-	log.Printf("Fetching role for user ID: %s", userID)
+	slog.DebugContext(ctx, "Fetching user role", "user_id", userID)
 	err := s.DB.QueryRowContext(ctx, query, userID).Scan(&role)
 	if err != nil {
-		log.Printf("Failed to fetch role for user ID %s: %v", userID, err)
+		slog.ErrorContext(ctx, "Failed to fetch user role", "user_id", userID, "error", err)
 		return "", err
 	}
-	log.Printf("Role for user ID %s: %s", userID, role)
+	slog.DebugContext(ctx, "Fetched user role", "user_id", userID, "role", role)
 	return role, nil
 }
 
-func (s *AuthService) DeleteUser(ctx context.Context, userID, adminID string) error {
+// DeletedUserID replaces the owner or voter ID of rows that outlive a
+// deleted account. Migration 001 creates its users row.
+const DeletedUserID = "deleted-user"
+
+type PollDisposition string
+
+const (
+	PollsDelete    PollDisposition = "delete"
+	PollsAnonymize PollDisposition = "anonymize"
+	PollsReassign  PollDisposition = "reassign"
+)
+
+type VoteDisposition string
+
+const (
+	VotesKeep  VoteDisposition = "keep"
+	VotesErase VoteDisposition = "erase"
+)
+
+// DeleteUserOptions tells DeleteUser what to do with the data a user leaves behind.
+type DeleteUserOptions struct {
+	Polls      PollDisposition
+	ReassignTo string
+	Votes      VoteDisposition
+}
+
+var (
+	ErrInvalidDeleteOptions  = errors.New("invalid user deletion options")
+	ErrInvalidReassignTarget = errors.New("reassign target must be another existing user")
+)
+
+// DeleteUser removes a user and handles their polls and votes in a single
+// transaction. The Firebase account is disabled before the database work so
+// that a failed transaction can be compensated by re-enabling it, and only
+// deleted once the transaction has committed.
+func (s *AuthService) DeleteUser(ctx context.Context, userID, adminID string, opts DeleteUserOptions) error {
+	ctx, span := startSpan(ctx, "AuthService.DeleteUser")
+	defer span.End()
+
 	role, err := s.GetUserRole(ctx, adminID)
 	if err != nil {
 		return err
 	}
-	if role != "admin" {
+	if role != "admin" || userID == DeletedUserID {
 		return sql.ErrNoRows
 	}
 
-	query := `SELECT id FROM polls WHERE user_id = $1`
-	rows, err := s.DB.QueryContext(ctx, query, userID)
+	switch opts.Polls {
+	case PollsDelete, PollsAnonymize:
+	case PollsReassign:
+		if opts.ReassignTo == "" || opts.ReassignTo == userID || opts.ReassignTo == DeletedUserID {
+			return ErrInvalidReassignTarget
+		}
+	default:
+		return ErrInvalidDeleteOptions
+	}
+	if opts.Votes != VotesKeep && opts.Votes != VotesErase {
+		return ErrInvalidDeleteOptions
+	}
+
+	return s.removeAccount(ctx, userID, opts)
+}
+
+// removeAccount disables the Firebase account, runs the database work and
+// finally deletes the Firebase account once the transaction has committed.
+func (s *AuthService) removeAccount(ctx context.Context, userID string, opts DeleteUserOptions) error {
+	if err := s.setAuthDisabled(ctx, userID, true); err != nil {
+		return err
+	}
+
+	if err := s.deleteUserData(ctx, userID, opts); err != nil {
+		if cerr := s.setAuthDisabled(ctx, userID, false); cerr != nil {
+			slog.ErrorContext(ctx, "Failed to re-enable account after aborted deletion", "user_id", userID, "error", cerr)
+		}
+		return err
+	}
+
+	idCtx, done := tracing.Identity(ctx, "DeleteUser")
+	err := s.AuthClient.DeleteUser(idCtx, userID)
+	done(err)
+	if err != nil && !auth.IsUserNotFound(err) {
+		// The database rows are gone and the account is disabled, so it can no
+		// longer sign in. Leave it for a manual cleanup rather than failing.
+		slog.ErrorContext(ctx, "User deleted from database but Firebase deletion failed", "user_id", userID, "error", err)
+	}
+	return nil
+}
+
+func (s *AuthService) setAuthDisabled(ctx context.Context, userID string, disabled bool) error {
+	idCtx, done := tracing.Identity(ctx, "UpdateUser")
+	_, err := s.AuthClient.UpdateUser(idCtx, userID, (&auth.UserToUpdate{}).Disabled(disabled))
+	done(err)
+	if err != nil && auth.IsUserNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *AuthService) deleteUserData(ctx context.Context, userID string, opts DeleteUserOptions) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var pollID string
-		if err := rows.Scan(&pollID); err != nil {
+	defer tx.Rollback()
+
+	var id string
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&id); err != nil {
+		return err
+	}
+
+	switch opts.Polls {
+	case PollsDelete:
+		_, err = tx.ExecContext(ctx, `DELETE FROM votes WHERE poll_id IN (SELECT id FROM polls WHERE user_id = $1)`, userID)
+		if err != nil {
 			return err
 		}
-		_, err = s.DB.ExecContext(ctx, `DELETE FROM options WHERE poll_id = $1`, pollID)
+		_, err = tx.ExecContext(ctx, `DELETE FROM options WHERE poll_id IN (SELECT id FROM polls WHERE user_id = $1)`, userID)
 		if err != nil {
 			return err
 		}
-		_, err = s.DB.ExecContext(ctx, `DELETE FROM polls WHERE id = $1`, pollID)
+		_, err = tx.ExecContext(ctx, `DELETE FROM polls WHERE user_id = $1`, userID)
+	case PollsAnonymize:
+		_, err = tx.ExecContext(ctx, `UPDATE polls SET user_id = $1 WHERE user_id = $2`, DeletedUserID, userID)
+	case PollsReassign:
+		var exists bool
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, opts.ReassignTo).Scan(&exists)
 		if err != nil {
 			return err
 		}
+		if !exists {
+			return ErrInvalidReassignTarget
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE polls SET user_id = $1 WHERE user_id = $2`, opts.ReassignTo, userID)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Recurring series follow their polls to a new owner and stop otherwise.
+	if opts.Polls == PollsReassign {
+		_, err = tx.ExecContext(ctx, `UPDATE poll_series SET owner_id = $1 WHERE owner_id = $2`, opts.ReassignTo, userID)
+	} else {
+		_, err = tx.ExecContext(ctx, `DELETE FROM poll_series WHERE owner_id = $1`, userID)
+	}
+	if err != nil {
+		return err
 	}
 
-	if err := s.AuthClient.DeleteUser(ctx, userID); err != nil {
+	if opts.Votes == VotesErase {
+		_, err = tx.ExecContext(ctx, `DELETE FROM votes WHERE user_id = $1 OR voted_by = $1`, userID)
+	} else {
+		err = pseudonymizeVotes(ctx, tx, userID)
+	}
+	if err != nil {
 		return err
 	}
 
-	_, err = s.DB.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, userID)
+	// Shared templates belong to everyone and outlive their author.
+	if _, err := tx.ExecContext(ctx, `UPDATE poll_templates SET owner_id = $1 WHERE owner_id = $2 AND shared`, DeletedUserID, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM poll_templates WHERE owner_id = $1`, userID); err != nil {
+		return err
+	}
+	// The invite audit trail keeps what was done, not by whom.
+	if _, err := tx.ExecContext(ctx, `UPDATE guest_invite_audit SET actor_id = $1 WHERE actor_id = $2`, DeletedUserID, userID); err != nil {
+		return err
+	}
+	for _, table := range []string{"account_deletions", "notification_preferences", "chat_link_codes", "chat_links"} {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, userID); err != nil {
+		return err
+	}
+
+	err = s.Webhooks.Emit(ctx, tx, EventUserDeleted, nil, UserEventData{
+		ID:    userID,
+		Polls: string(opts.Polls),
+		Votes: string(opts.Votes),
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// pseudonymizeVotes replaces the user's identity on their votes with a fresh
+// pseudonym. Every vote of the user gets the same pseudonym, so tallies and
+// distinct voter counts stay the same while the link to the person is gone.
+func pseudonymizeVotes(ctx context.Context, tx *sql.Tx, userID string) error {
+	pseudonym := DeletedUserID + ":" + uuid.New().String()
+	_, err := tx.ExecContext(ctx, `
+		UPDATE votes
+		SET user_id = CASE WHEN user_id IS NULL THEN NULL ELSE $1 END, voted_by = $1
+		WHERE user_id = $2 OR voted_by = $2
+	`, pseudonym, userID)
 	return err
 }
 
+// PurgeScheduledDeletions removes every account whose self-service deletion
+// grace period has ended. Their polls are kept without an owner and their
+// votes are pseudonymized.
+func (s *AuthService) PurgeScheduledDeletions(ctx context.Context) error {
+	ctx, span := startSpan(ctx, "AuthService.PurgeScheduledDeletions")
+	defer span.End()
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT user_id FROM account_deletions WHERE scheduled_for <= $1`, time.Now())
+	if err != nil {
+		return err
+	}
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		err := s.removeAccount(ctx, userID, DeleteUserOptions{Polls: PollsAnonymize, Votes: VotesKeep})
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to purge account", "user_id", userID, "error", err)
+			continue
+		}
+		slog.InfoContext(ctx, "Purged account after deletion grace period", "user_id", userID)
+	}
+	return nil
+}
+
+// RunDeletionPurger calls PurgeScheduledDeletions every interval until ctx is done.
+func (s *AuthService) RunDeletionPurger(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.PurgeScheduledDeletions(ctx); err != nil {
+			slog.ErrorContext(ctx, "Deletion purge failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func (s *AuthService) SetAdminRole(ctx context.Context, email, adminID string) error {
+	ctx, span := startSpan(ctx, "AuthService.SetAdminRole")
+	defer span.End()
+
 	// Verify caller is admin
 	role, err := s.GetUserRole(ctx, adminID)
 	if err != nil {
-		log.Printf("Error checking admin role: %v", err)
+		slog.ErrorContext(ctx, "Error checking admin role", "error", err)
 		return err
 	}
 	if role != "admin" {
-		log.Printf("Unauthorized: User %s is not admin", adminID)
+		slog.WarnContext(ctx, "Unauthorized: User is not admin", "admin_id", adminID)
 		return sql.ErrNoRows
 	}
 
 	// Get user by email
-	user, err := s.AuthClient.GetUserByEmail(ctx, email)
+	idCtx, done := tracing.Identity(ctx, "GetUserByEmail")
+	user, err := s.AuthClient.GetUserByEmail(idCtx, email)
+	done(err)
 	if err != nil {
-		log.Printf("Error finding user by email %s: %v", email, err)
+		slog.ErrorContext(ctx, "Error finding user by email", "email", email, "error", err)
 		return err
 	}
 
 	// Set Firebase custom claim
 	claims := map[string]interface{}{"admin": true}
-	if err := s.AuthClient.SetCustomUserClaims(ctx, user.UID, claims); err != nil {
-		log.Printf("Error setting admin claims for user %s: %v", user.UID, err)
+	idCtx, done = tracing.Identity(ctx, "SetCustomUserClaims")
+	err = s.AuthClient.SetCustomUserClaims(idCtx, user.UID, claims)
+	done(err)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error setting admin claims for user", "user_id", user.UID, "error", err)
 		return err
 	}
 
@@ -149,42 +440,67 @@ func (s *AuthService) SetAdminRole(ctx context.Context, email, adminID string) e
 	query := `UPDATE users SET role = 'admin' WHERE id = $1`
 	_, err = s.DB.ExecContext(ctx, query, user.UID)
 	if err != nil {
-		log.Printf("Error updating user role in database for user %s: %v", user.UID, err)
+		slog.ErrorContext(ctx, "Error updating user role in database for user", "user_id", user.UID, "error", err)
 		return err
 	}
 
-	log.Printf("Admin role set for user: %s (email: %s)", user.UID, email)
+	slog.InfoContext(ctx, "Admin role set for user", "user_id", user.UID, "email", email)
+	if s.Notifications != nil {
+		if err := s.Notifications.NotifyAdminGranted(ctx, user.UID); err != nil {
+			slog.ErrorContext(ctx, "Error queueing admin notification for user", "user_id", user.UID, "error", err)
+		}
+	}
 	return nil
 }
 
 func (s *AuthService) IsAdmin(ctx context.Context, idToken string) (bool, error) {
-	token, err := s.AuthClient.VerifyIDToken(ctx, idToken)
+	ctx, span := startSpan(ctx, "AuthService.IsAdmin")
+	defer span.End()
+
+	idCtx, done := tracing.Identity(ctx, "VerifyIDToken")
+	token, err := s.AuthClient.VerifyIDToken(idCtx, idToken)
+	done(err)
 	if err != nil {
-		log.Printf("Error verifying ID token: %v", err)
+		slog.WarnContext(ctx, "Error verifying ID token", "error", err)
 		return false, err
 	}
 	role, err := s.GetUserRole(ctx, token.UID)
 	if err != nil {
-		log.Printf("Error getting user role for UID %s: %v", token.UID, err)
+		slog.ErrorContext(ctx, "Error getting user role", "user_id", token.UID, "error", err)
 		return false, err
 	}
 	return role == "admin", nil
 }
 
-func (s *AuthService) GetAllUsers(ctx context.Context) ([]struct{ ID, Email, Role string }, error) {
-	rows, err := s.DB.QueryContext(ctx, "SELECT id, email, role FROM users")
+func (s *AuthService) GetAllUsers(ctx context.Context) ([]struct{ ID, Email, Role, Department string }, error) {
+	ctx, span := startSpan(ctx, "AuthService.GetAllUsers")
+	defer span.End()
+
+	rows, err := s.DB.QueryContext(ctx, "SELECT id, email, role, COALESCE(department, '') FROM users WHERE id <> $1", DeletedUserID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var users []struct{ ID, Email, Role string }
+	var users []struct{ ID, Email, Role, Department string }
 	for rows.Next() {
-		var user struct{ ID, Email, Role string }
-		if err := rows.Scan(&user.ID, &user.Email, &user.Role); err != nil {
+		var user struct{ ID, Email, Role, Department string }
+		if err := rows.Scan(&user.ID, &user.Email, &user.Role, &user.Department); err != nil {
 			return nil, err
 		}
 		users = append(users, user)
 	}
 	return users, nil
 }
+
+// SetUserDepartment sets the department used to segment poll results.
+func (s *AuthService) SetUserDepartment(ctx context.Context, userID, department string) error {
+	ctx, span := startSpan(ctx, "AuthService.SetUserDepartment")
+	defer span.End()
+
+	result, err := s.DB.ExecContext(ctx, `UPDATE users SET department = NULLIF(TRIM($1), '') WHERE id = $2`, department, userID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
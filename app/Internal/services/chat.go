@@ -0,0 +1,619 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"fakidoosuurdoris/app/Internal/models"
+)
+
+const (
+	chatLinkCodeTTL      = 10 * time.Minute
+	chatSignatureMaxSkew = 5 * time.Minute
+	chatDefaultDuration  = 24 * time.Hour
+	// chatResponseURLDuration is the longest a poll may run when its
+	// results can only go to the command's response URL, which expires
+	// 30 minutes after the command; the rest is left for the results
+	// worker to notice that the poll closed.
+	chatResponseURLDuration = 25 * time.Minute
+	// chatMaxButtons is the number of elements allowed in one actions block.
+	chatMaxButtons = 25
+	// chatResultsRetryWindow bounds how long posting results is retried
+	// after a poll closes.
+	chatResultsRetryWindow = 24 * time.Hour
+)
+
+var ErrInvalidChatSignature = errors.New("invalid chat request signature")
+
+// SlashCommand holds the fields of a Slack-compatible slash command request.
+type SlashCommand struct {
+	TeamID      string
+	ChannelID   string
+	UserID      string
+	Command     string
+	Text        string
+	ResponseURL string
+}
+
+// ChatInteraction is the subset of a Slack-compatible block_actions payload
+// used for voting buttons.
+type ChatInteraction struct {
+	Type string `json:"type"`
+	Team struct {
+		ID string `json:"id"`
+	} `json:"team"`
+	User struct {
+		ID string `json:"id"`
+	} `json:"user"`
+	ResponseURL string `json:"response_url"`
+	Actions     []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+}
+
+type ChatText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type ChatElement struct {
+	Type     string    `json:"type"`
+	Text     *ChatText `json:"text,omitempty"`
+	ActionID string    `json:"action_id,omitempty"`
+	Value    string    `json:"value,omitempty"`
+}
+
+type ChatBlock struct {
+	Type     string        `json:"type"`
+	Text     *ChatText     `json:"text,omitempty"`
+	Elements []ChatElement `json:"elements,omitempty"`
+}
+
+// ChatMessage is a Slack-compatible message. ResponseType is "in_channel" or
+// "ephemeral".
+type ChatMessage struct {
+	Channel         string      `json:"channel,omitempty"`
+	ResponseType    string      `json:"response_type,omitempty"`
+	ReplaceOriginal bool        `json:"replace_original"`
+	Text            string      `json:"text"`
+	Blocks          []ChatBlock `json:"blocks,omitempty"`
+}
+
+func ephemeral(format string, args ...interface{}) ChatMessage {
+	return ChatMessage{ResponseType: "ephemeral", Text: fmt.Sprintf(format, args...)}
+}
+
+type ChatLink struct {
+	TeamID     string    `json:"team_id"`
+	ChatUserID string    `json:"chat_user_id"`
+	LinkedAt   time.Time `json:"linked_at"`
+}
+
+// ChatService implements the slash-command and voting-button integration.
+// Requests are authenticated with the Slack v0 signing scheme. Results are
+// posted with chat.postMessage when BotToken is set and otherwise to the
+// response URL of the command that created the poll, which limits polls to
+// chatResponseURLDuration.
+type ChatService struct {
+	DB            *sql.DB
+	PollService   *PollService
+	SigningSecret string
+	BotToken      string
+	APIURL        string
+	Client        *http.Client
+}
+
+func NewChatService(db *sql.DB, pollService *PollService, signingSecret, botToken, apiURL string) *ChatService {
+	return &ChatService{
+		DB:            db,
+		PollService:   pollService,
+		SigningSecret: signingSecret,
+		BotToken:      botToken,
+		APIURL:        strings.TrimRight(apiURL, "/"),
+		Client:        &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// VerifySignature checks a request signed as
+// "v0=" + hex(HMAC-SHA256(secret, "v0:<timestamp>:<body>")). Without a
+// configured secret every request is rejected.
+func (s *ChatService) VerifySignature(timestamp, signature string, body []byte) error {
+	if s.SigningSecret == "" {
+		return ErrInvalidChatSignature
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidChatSignature
+	}
+	if skew := time.Since(time.Unix(ts, 0)); skew > chatSignatureMaxSkew || skew < -chatSignatureMaxSkew {
+		return ErrInvalidChatSignature
+	}
+	mac := hmac.New(sha256.New, []byte(s.SigningSecret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidChatSignature
+	}
+	return nil
+}
+
+// CreateLinkCode issues a short-lived one-time code the user types into chat
+// as "/poll link <code>". Earlier codes of the user stop working.
+func (s *ChatService) CreateLinkCode(ctx context.Context, userID string) (string, time.Time, error) {
+	const alphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	for i := range buf {
+		buf[i] = alphabet[int(buf[i])%len(alphabet)]
+	}
+	code := string(buf)
+	expiresAt := time.Now().Add(chatLinkCodeTTL)
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM chat_link_codes WHERE user_id = $1`, userID); err != nil {
+		return "", time.Time{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO chat_link_codes (code, user_id, expires_at) VALUES ($1, $2, $3)`, code, userID, expiresAt); err != nil {
+		return "", time.Time{}, err
+	}
+	return code, expiresAt, tx.Commit()
+}
+
+// GetActiveLinkCode returns the user's unexpired link code, or an empty code
+// if there is none.
+func (s *ChatService) GetActiveLinkCode(ctx context.Context, userID string) (string, time.Time, error) {
+	var code string
+	var expiresAt time.Time
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT code, expires_at FROM chat_link_codes WHERE user_id = $1 AND expires_at > $2
+	`, userID, time.Now()).Scan(&code, &expiresAt)
+	if err == sql.ErrNoRows {
+		return "", time.Time{}, nil
+	}
+	return code, expiresAt, err
+}
+
+func (s *ChatService) ListChatLinks(ctx context.Context, userID string) ([]ChatLink, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT team_id, chat_user_id, linked_at FROM chat_links WHERE user_id = $1 ORDER BY linked_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []ChatLink
+	for rows.Next() {
+		var link ChatLink
+		if err := rows.Scan(&link.TeamID, &link.ChatUserID, &link.LinkedAt); err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func (s *ChatService) UnlinkChat(ctx context.Context, userID, teamID, chatUserID string) error {
+	result, err := s.DB.ExecContext(ctx, `
+		DELETE FROM chat_links WHERE user_id = $1 AND team_id = $2 AND chat_user_id = $3
+	`, userID, teamID, chatUserID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+func (s *ChatService) linkAccount(ctx context.Context, teamID, chatUserID, code string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID string
+	err = tx.QueryRowContext(ctx, `
+		DELETE FROM chat_link_codes WHERE code = $1 AND expires_at > $2 RETURNING user_id
+	`, strings.ToUpper(code), time.Now()).Scan(&userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO chat_links (team_id, chat_user_id, user_id) VALUES ($1, $2, $3)
+		ON CONFLICT (team_id, chat_user_id) DO UPDATE SET user_id = EXCLUDED.user_id, linked_at = NOW()
+	`, teamID, chatUserID, userID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *ChatService) linkedUser(ctx context.Context, teamID, chatUserID string) (string, error) {
+	var userID string
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT user_id FROM chat_links WHERE team_id = $1 AND chat_user_id = $2
+	`, teamID, chatUserID).Scan(&userID)
+	return userID, err
+}
+
+const chatUsage = "Usage:\n" +
+	"`/poll create \"Title\" \"Option A\" \"Option B\" [--closes 24h]` creates a poll\n" +
+	"`/poll link CODE` links your chat account using the code from your profile page"
+
+// usage explains the commands, and without a bot token that results can
+// only be posted for short polls.
+func (s *ChatService) usage() string {
+	if s.BotToken != "" {
+		return chatUsage
+	}
+	return chatUsage + fmt.Sprintf("\nPolls close after at most %d minutes, as results are posted through the command's response URL.",
+		int(chatResponseURLDuration.Minutes()))
+}
+
+const chatLinkHint = "Your chat account is not linked yet. Generate a code on your profile page and run `/poll link CODE`."
+
+// HandleCommand answers a slash command. Errors are only returned for
+// failures the user cannot fix; everything else becomes an ephemeral reply.
+func (s *ChatService) HandleCommand(ctx context.Context, cmd SlashCommand) (ChatMessage, error) {
+	args, err := splitQuoted(cmd.Text)
+	if err != nil {
+		return ephemeral("Could not parse the command: %v\n%s", err, s.usage()), nil
+	}
+	if len(args) == 0 {
+		return ephemeral("%s", s.usage()), nil
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "link":
+		if len(args) != 2 {
+			return ephemeral("Usage: `/poll link CODE`"), nil
+		}
+		err := s.linkAccount(ctx, cmd.TeamID, cmd.UserID, args[1])
+		if err == sql.ErrNoRows {
+			return ephemeral("That code is invalid or has expired. Generate a new one on your profile page."), nil
+		}
+		if err != nil {
+			return ChatMessage{}, err
+		}
+		return ephemeral("Your chat account is now linked."), nil
+	case "create":
+		return s.createPoll(ctx, cmd, args[1:])
+	default:
+		return ephemeral("%s", s.usage()), nil
+	}
+}
+
+func (s *ChatService) createPoll(ctx context.Context, cmd SlashCommand, args []string) (ChatMessage, error) {
+	userID, err := s.linkedUser(ctx, cmd.TeamID, cmd.UserID)
+	if err == sql.ErrNoRows {
+		return ephemeral(chatLinkHint), nil
+	}
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	role, err := s.PollService.AuthService.GetUserRole(ctx, userID)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	if role != "admin" {
+		return ephemeral("Only admins can create polls."), nil
+	}
+
+	// Without a bot token results go to the command's response URL, which
+	// expires long before a day is up.
+	duration, maxDuration := chatDefaultDuration, time.Duration(0)
+	if s.BotToken == "" {
+		duration, maxDuration = chatResponseURLDuration, chatResponseURLDuration
+	}
+	var texts []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--closes" {
+			if i+1 >= len(args) {
+				return ephemeral("`--closes` needs a duration such as `2h` or `30m`."), nil
+			}
+			d, err := time.ParseDuration(args[i+1])
+			if err != nil || d <= 0 {
+				return ephemeral("`%s` is not a valid duration. Use something like `2h` or `30m`.", args[i+1]), nil
+			}
+			if maxDuration > 0 && d > maxDuration {
+				return ephemeral("Polls can run for at most %d minutes here, as their results are posted through this command's response URL, which expires after 30 minutes.",
+					int(maxDuration.Minutes())), nil
+			}
+			duration = d
+			i++
+			continue
+		}
+		texts = append(texts, args[i])
+	}
+	if len(texts) < 3 {
+		return ephemeral("A poll needs a title and at least two options.\n%s", s.usage()), nil
+	}
+	if len(texts)-1 > chatMaxButtons {
+		return ephemeral("A chat poll can have at most %d options.", chatMaxButtons), nil
+	}
+
+	options := make([]models.Option, 0, len(texts)-1)
+	for _, text := range texts[1:] {
+		options = append(options, models.Option{Text: text})
+	}
+	now := time.Now()
+	endDate := now.Add(duration)
+	// The poll and its link to the channel are created together, so a poll
+	// is never left without the channel its results go to.
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	defer tx.Rollback()
+
+	poll, err := s.PollService.createPoll(ctx, tx, texts[0], "single_choice", options, OptionOrderFixed, false, userID, now, &endDate, "")
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO chat_polls (poll_id, team_id, channel_id, response_url) VALUES ($1, $2, $3, $4)
+	`, poll.ID, cmd.TeamID, cmd.ChannelID, cmd.ResponseURL)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return ChatMessage{}, err
+	}
+
+	saved, err := s.PollService.GetPollOptions(ctx, poll.ID)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	return pollMessage(poll, saved), nil
+}
+
+func pollMessage(poll *models.Poll, options []models.Option) ChatMessage {
+	header := fmt.Sprintf("*%s*", poll.Title)
+	if poll.EndDate != nil {
+		header += fmt.Sprintf("\nCloses %s", poll.EndDate.UTC().Format("Jan 2, 2006 15:04 MST"))
+	}
+	buttons := make([]ChatElement, 0, len(options))
+	for _, option := range options {
+		buttons = append(buttons, ChatElement{
+			Type:     "button",
+			Text:     &ChatText{Type: "plain_text", Text: option.Text},
+			ActionID: fmt.Sprintf("vote_%d", option.ID),
+			Value:    fmt.Sprintf("%d:%d", poll.ID, option.ID),
+		})
+	}
+	return ChatMessage{
+		ResponseType: "in_channel",
+		Text:         poll.Title,
+		Blocks: []ChatBlock{
+			{Type: "section", Text: &ChatText{Type: "mrkdwn", Text: header}},
+			{Type: "actions", Elements: buttons},
+		},
+	}
+}
+
+// HandleInteraction records the vote behind a clicked button on behalf of
+// the linked user and returns an ephemeral confirmation.
+func (s *ChatService) HandleInteraction(ctx context.Context, in ChatInteraction) (ChatMessage, error) {
+	if in.Type != "block_actions" || len(in.Actions) == 0 {
+		return ephemeral("Unsupported action."), nil
+	}
+	var pollID, optionID int64
+	if _, err := fmt.Sscanf(in.Actions[0].Value, "%d:%d", &pollID, &optionID); err != nil {
+		return ephemeral("Unsupported action."), nil
+	}
+
+	userID, err := s.linkedUser(ctx, in.Team.ID, in.User.ID)
+	if err == sql.ErrNoRows {
+		return ephemeral(chatLinkHint), nil
+	}
+	if err != nil {
+		return ChatMessage{}, err
+	}
+
+	err = s.PollService.RecordVote(ctx, pollID, userID, []int64{optionID}, "")
+	if err == sql.ErrNoRows {
+		return ephemeral("Your vote was not recorded: you have already voted, the poll is not open or you are not eligible."), nil
+	}
+	if err != nil {
+		return ChatMessage{}, err
+	}
+	return ephemeral("Your vote was recorded."), nil
+}
+
+// Post sends msg to a response URL, or with chat.postMessage when url is
+// empty.
+func (s *ChatService) Post(ctx context.Context, url string, msg ChatMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	useAPI := url == ""
+	if useAPI {
+		if s.BotToken == "" {
+			return errors.New("no bot token configured")
+		}
+		url = s.APIURL + "/chat.postMessage"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	if useAPI {
+		req.Header.Set("Authorization", "Bearer "+s.BotToken)
+	}
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if useAPI {
+		var result struct {
+			OK    bool   `json:"ok"`
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return err
+		}
+		if !result.OK {
+			return fmt.Errorf("chat.postMessage: %s", result.Error)
+		}
+	}
+	return nil
+}
+
+// Run posts the results of closed chat polls every interval until ctx is
+// done.
+func (s *ChatService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.PostClosedResults(ctx); err != nil {
+			slog.ErrorContext(ctx, "Failed to post chat poll results", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PostClosedResults posts the results of every chat poll that has closed and
+// not been reported yet. Failed posts are retried on later runs for a day.
+func (s *ChatService) PostClosedResults(ctx context.Context) error {
+	now := time.Now()
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT cp.poll_id, cp.channel_id, COALESCE(cp.response_url, ''), p.title
+		FROM chat_polls cp
+		JOIN polls p ON p.id = cp.poll_id
+		WHERE cp.results_posted_at IS NULL AND p.end_date < $1 AND p.end_date > $2
+	`, now, now.Add(-chatResultsRetryWindow))
+	if err != nil {
+		return err
+	}
+	type closedPoll struct {
+		ID          int64
+		ChannelID   string
+		ResponseURL string
+		Title       string
+	}
+	var polls []closedPoll
+	for rows.Next() {
+		var p closedPoll
+		if err := rows.Scan(&p.ID, &p.ChannelID, &p.ResponseURL, &p.Title); err != nil {
+			rows.Close()
+			return err
+		}
+		polls = append(polls, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range polls {
+		text, err := s.resultsText(ctx, p.ID, p.Title)
+		if err != nil {
+			return err
+		}
+		msg := ChatMessage{ResponseType: "in_channel", Text: text}
+		url := p.ResponseURL
+		if s.BotToken != "" {
+			msg.Channel = p.ChannelID
+			url = ""
+		}
+		if err := s.Post(ctx, url, msg); err != nil {
+			slog.ErrorContext(ctx, "Failed to post results of poll", "poll_id", p.ID, "error", err)
+			continue
+		}
+		if _, err := s.DB.ExecContext(ctx, `UPDATE chat_polls SET results_posted_at = $1 WHERE poll_id = $2`, now, p.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *ChatService) resultsText(ctx context.Context, pollID int64, title string) (string, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT o.option_text, COUNT(v.id)
+		FROM options o
+		LEFT JOIN votes v ON v.option_id = o.id
+		WHERE o.poll_id = $1
+		GROUP BY o.id, o.option_text, o.position
+		ORDER BY o.position, o.id
+	`, pollID)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%s* has closed. Results:", title)
+	for rows.Next() {
+		var text string
+		var count int
+		if err := rows.Scan(&text, &count); err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "\n• %s: %d", text, count)
+	}
+	return b.String(), rows.Err()
+}
+
+// splitQuoted splits s on whitespace, keeping double-quoted sections (which
+// may use curly quotes, as chat clients often substitute them) together.
+func splitQuoted(s string) ([]string, error) {
+	s = strings.NewReplacer("“", `"`, "”", `"`).Replace(s)
+	var args []string
+	var current strings.Builder
+	inQuotes, hasArg := false, false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			hasArg = true
+		case !inQuotes && (r == ' ' || r == '\t' || r == '\n'):
+			if hasArg {
+				args = append(args, current.String())
+				current.Reset()
+				hasArg = false
+			}
+		default:
+			current.WriteRune(r)
+			hasArg = true
+		}
+	}
+	if inQuotes {
+		return nil, errors.New("unterminated quote")
+	}
+	if hasArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,306 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+var ErrCrosstab = errors.New("crosstab not available")
+
+// MinCellSize is the smallest group a crosstab reports on; callers may ask
+// for larger but never smaller groups.
+const MinCellSize = 3
+
+// Crosstab dimensions. DimensionPoll segments voters by their answer to
+// another poll.
+const (
+	DimensionDepartment = "department"
+	DimensionRole       = "role"
+	DimensionCohort     = "cohort"
+	DimensionPoll       = "poll"
+)
+
+// otherSegment collects the segments too small to report on their own.
+const otherSegment = "Other"
+
+// Crosstab breaks a poll's results down by a voter attribute: one row per
+// option or scale value, one column per segment. Segments with fewer voters
+// than the minimum cell size are merged into "Other", which is suppressed in
+// turn if still too small. Within a segment, counts below the minimum are
+// suppressed, and when that leaves a single hidden count in a segment whose
+// counts add up to its size, the next smallest is hidden too so it cannot
+// be worked out by subtraction.
+type Crosstab struct {
+	PollID       int64             `json:"poll_id"`
+	Title        string            `json:"title"`
+	QuestionType string            `json:"question_type"`
+	Dimension    string            `json:"dimension"`
+	SegmentPoll  *int64            `json:"segment_poll_id,omitempty"`
+	MinCellSize  int               `json:"min_cell_size"`
+	Segments     []CrosstabSegment `json:"segments"`
+	Rows         []CrosstabRow     `json:"rows"`
+}
+
+// CrosstabSegment is a column of the matrix. Voters is nil when the segment
+// is suppressed.
+type CrosstabSegment struct {
+	Label      string `json:"label"`
+	Voters     *int   `json:"voters"`
+	Suppressed bool   `json:"suppressed,omitempty"`
+}
+
+type CrosstabRow struct {
+	Label    string         `json:"label"`
+	OptionID int64          `json:"option_id,omitempty"`
+	Value    int            `json:"value,omitempty"`
+	Cells    []CrosstabCell `json:"cells"`
+}
+
+// CrosstabCell is the number of a segment's voters who gave a row's answer
+// and their share of the segment. Both are nil when suppressed.
+type CrosstabCell struct {
+	Count      *int     `json:"count"`
+	Percent    *float64 `json:"percent"`
+	Suppressed bool     `json:"suppressed,omitempty"`
+}
+
+// segmentQueries select each voter of poll $1 with their segment. Voters
+// may fall into several segments when segmenting by a multiple choice poll.
+var segmentQueries = map[string]string{
+	DimensionDepartment: `
+		SELECT DISTINCT v.voted_by, COALESCE(NULLIF(TRIM(u.department), ''), 'Unassigned')
+		FROM votes v JOIN users u ON u.id = v.voted_by
+		WHERE v.poll_id = $1`,
+	DimensionRole: `
+		SELECT DISTINCT v.voted_by, u.role
+		FROM votes v JOIN users u ON u.id = v.voted_by
+		WHERE v.poll_id = $1`,
+	DimensionCohort: `
+		SELECT DISTINCT v.voted_by, COALESCE(TO_CHAR(u.created_at, 'YYYY-MM'), 'Unknown')
+		FROM votes v JOIN users u ON u.id = v.voted_by
+		WHERE v.poll_id = $1`,
+	DimensionPoll: `
+		SELECT DISTINCT v.voted_by, COALESCE(o.option_text, s.scale_value::TEXT, 'No answer')
+		FROM votes v
+		LEFT JOIN votes s ON s.poll_id = $2 AND s.voted_by = v.voted_by
+		LEFT JOIN options o ON o.id = s.option_id
+		WHERE v.poll_id = $1 AND v.voted_by IS NOT NULL`,
+}
+
+// Crosstab returns the results of a named choice or scale poll broken down
+// by dimension. segmentPollID is the poll whose answers segment voters for
+// DimensionPoll; it must be a named choice or scale poll too. minCell is
+// raised to MinCellSize if lower.
+func (s *PollService) Crosstab(ctx context.Context, pollID int64, dimension string, segmentPollID int64, minCell int) (*Crosstab, error) {
+	ctx, span := startPollSpan(ctx, "PollService.Crosstab", pollID)
+	defer span.End()
+
+	query, ok := segmentQueries[dimension]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown dimension %q", ErrCrosstab, dimension)
+	}
+	minCell = max(minCell, MinCellSize)
+
+	results, err := s.GetPollResults(ctx, pollID, "")
+	if err != nil {
+		return nil, err
+	}
+	if results.IsAnonymous {
+		return nil, fmt.Errorf("%w: answers of anonymous polls cannot be linked to voters", ErrCrosstab)
+	}
+	if results.QuestionType == "text" {
+		return nil, fmt.Errorf("%w: text polls have no fixed answers to count", ErrCrosstab)
+	}
+
+	tab := &Crosstab{
+		PollID:       results.PollID,
+		Title:        results.Title,
+		QuestionType: results.QuestionType,
+		Dimension:    dimension,
+		MinCellSize:  minCell,
+		Segments:     []CrosstabSegment{},
+		Rows:         []CrosstabRow{},
+	}
+	args := []interface{}{pollID}
+	if dimension == DimensionPoll {
+		segmentPoll, err := s.GetPoll(ctx, segmentPollID)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: segment poll %d not found", ErrCrosstab, segmentPollID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if segmentPoll.IsAnonymous || segmentPoll.QuestionType == "text" || segmentPoll.ID == pollID {
+			return nil, fmt.Errorf("%w: voters can only be segmented by another named choice or scale poll", ErrCrosstab)
+		}
+		tab.SegmentPoll = &segmentPoll.ID
+		args = append(args, segmentPollID)
+	}
+
+	segmentsOf := make(map[string][]string)
+	sizes := make(map[string]int)
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var voter, segment string
+		if err := rows.Scan(&voter, &segment); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		segmentsOf[voter] = append(segmentsOf[voter], segment)
+		sizes[segment]++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Small segments are merged into otherSegment before anything is counted.
+	labels, column := segmentColumns(sizes, minCell)
+
+	rowOf := make(map[string]int)
+	for i, r := range results.Rows {
+		rowOf[answerKey(r.OptionID, r.Value)] = i
+	}
+	counts := make([][]int, len(results.Rows))
+	for i := range counts {
+		counts[i] = make([]int, len(labels))
+	}
+	voters := make([]int, len(labels))
+	for _, segments := range segmentsOf {
+		seen := make(map[int]bool)
+		for _, segment := range segments {
+			seen[column[segment]] = true
+		}
+		for col := range seen {
+			voters[col]++
+		}
+	}
+
+	rows, err = s.DB.QueryContext(ctx, `
+		SELECT voted_by, COALESCE(option_id, 0), COALESCE(scale_value, 0)
+		FROM votes
+		WHERE poll_id = $1 AND voted_by IS NOT NULL
+	`, pollID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var voter string
+		var optionID, value int64
+		if err := rows.Scan(&voter, &optionID, &value); err != nil {
+			return nil, err
+		}
+		row, ok := rowOf[answerKey(optionID, int(value))]
+		if !ok {
+			continue
+		}
+		seen := make(map[int]bool)
+		for _, segment := range segmentsOf[voter] {
+			if col := column[segment]; !seen[col] {
+				seen[col] = true
+				counts[row][col]++
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, r := range results.Rows {
+		tab.Rows = append(tab.Rows, CrosstabRow{Label: r.Label, OptionID: r.OptionID, Value: r.Value, Cells: make([]CrosstabCell, len(labels))})
+	}
+	tab.fill(labels, voters, counts, results.QuestionType != "multiple_choice")
+	return tab, nil
+}
+
+// segmentColumns orders the segments that have at least minCell voters by
+// label and maps every segment to its column, the smaller ones to a final
+// otherSegment column.
+func segmentColumns(sizes map[string]int, minCell int) (labels []string, column map[string]int) {
+	for label, size := range sizes {
+		if size >= minCell && label != otherSegment {
+			labels = append(labels, label)
+		}
+	}
+	sort.Strings(labels)
+	column = make(map[string]int)
+	for i, label := range labels {
+		column[label] = i
+	}
+	merged := false
+	for label := range sizes {
+		if _, ok := column[label]; !ok {
+			column[label] = len(labels)
+			merged = true
+		}
+	}
+	if merged {
+		labels = append(labels, otherSegment)
+	}
+	return labels, column
+}
+
+// fill adds a segment for each label and fills in the cells of tab's rows
+// from voters per column and counts per row and column, suppressing them as
+// described on Crosstab. additive says the counts of a segment add up to its
+// size, as single choice and scale answers do, so that a lone hidden count
+// could be recovered from the others.
+func (tab *Crosstab) fill(labels []string, voters []int, counts [][]int, additive bool) {
+	for col, label := range labels {
+		segment := CrosstabSegment{Label: label}
+		if voters[col] < tab.MinCellSize {
+			segment.Suppressed = true
+			for _, row := range tab.Rows {
+				row.Cells[col].Suppressed = true
+			}
+			tab.Segments = append(tab.Segments, segment)
+			continue
+		}
+		size := voters[col]
+		segment.Voters = &size
+		tab.Segments = append(tab.Segments, segment)
+
+		hidden := 0
+		for i := range tab.Rows {
+			if count := counts[i][col]; count > 0 && count < tab.MinCellSize {
+				tab.Rows[i].Cells[col].Suppressed = true
+				hidden++
+			}
+		}
+		if additive && hidden == 1 {
+			next := -1
+			for i := range tab.Rows {
+				if count := counts[i][col]; !tab.Rows[i].Cells[col].Suppressed && count > 0 && (next < 0 || count < counts[next][col]) {
+					next = i
+				}
+			}
+			if next >= 0 {
+				tab.Rows[next].Cells[col].Suppressed = true
+			}
+		}
+		for i := range tab.Rows {
+			if tab.Rows[i].Cells[col].Suppressed {
+				continue
+			}
+			count := counts[i][col]
+			percent := float64(count) * 100 / float64(size)
+			tab.Rows[i].Cells[col].Count = &count
+			tab.Rows[i].Cells[col].Percent = &percent
+		}
+	}
+}
+
+func answerKey(optionID int64, value int) string {
+	if optionID != 0 {
+		return "o" + strconv.FormatInt(optionID, 10)
+	}
+	return "v" + strconv.Itoa(value)
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestSegmentColumns(t *testing.T) {
+	tests := []struct {
+		name   string
+		sizes  map[string]int
+		labels []string
+		column map[string]int
+	}{
+		{
+			name:   "all large enough",
+			sizes:  map[string]int{"Sales": 5, "IT": 3},
+			labels: []string{"IT", "Sales"},
+			column: map[string]int{"IT": 0, "Sales": 1},
+		},
+		{
+			name:   "small segments merged",
+			sizes:  map[string]int{"Sales": 5, "IT": 3, "HR": 1, "Ops": 2},
+			labels: []string{"IT", "Sales", otherSegment},
+			column: map[string]int{"IT": 0, "Sales": 1, "HR": 2, "Ops": 2},
+		},
+		{
+			name:   "segment named like the merged one",
+			sizes:  map[string]int{"Sales": 5, otherSegment: 4, "HR": 1},
+			labels: []string{"Sales", otherSegment},
+			column: map[string]int{"Sales": 0, otherSegment: 1, "HR": 1},
+		},
+		{
+			name:   "all too small",
+			sizes:  map[string]int{"HR": 1, "Ops": 2},
+			labels: []string{otherSegment},
+			column: map[string]int{"HR": 0, "Ops": 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			labels, column := segmentColumns(tt.sizes, 3)
+			if !reflect.DeepEqual(labels, tt.labels) || !reflect.DeepEqual(column, tt.column) {
+				t.Errorf("segmentColumns() = %v, %v, want %v, %v", labels, column, tt.labels, tt.column)
+			}
+		})
+	}
+}
+
+func TestCrosstabFill(t *testing.T) {
+	// Cells are written as counts, with "-" for suppressed ones.
+	tests := []struct {
+		name     string
+		voters   []int
+		counts   [][]int
+		additive bool
+		segments []string
+		cells    [][]string
+	}{
+		{
+			name:     "nothing small",
+			voters:   []int{10},
+			counts:   [][]int{{7}, {3}, {0}},
+			additive: true,
+			segments: []string{"10"},
+			cells:    [][]string{{"7"}, {"3"}, {"0"}},
+		},
+		{
+			name:     "segment too small",
+			voters:   []int{2, 5},
+			counts:   [][]int{{2, 5}, {0, 0}},
+			additive: true,
+			segments: []string{"-", "5"},
+			cells:    [][]string{{"-", "5"}, {"-", "0"}},
+		},
+		{
+			name:     "lone small count hides the next smallest",
+			voters:   []int{10},
+			counts:   [][]int{{6}, {3}, {1}},
+			additive: true,
+			segments: []string{"10"},
+			cells:    [][]string{{"6"}, {"-"}, {"-"}},
+		},
+		{
+			name:     "two small counts hide nothing else",
+			voters:   []int{10},
+			counts:   [][]int{{6}, {2}, {2}},
+			additive: true,
+			segments: []string{"10"},
+			cells:    [][]string{{"6"}, {"-"}, {"-"}},
+		},
+		{
+			name:     "lone small count of multiple choice",
+			voters:   []int{8},
+			counts:   [][]int{{6}, {3}, {1}},
+			additive: false,
+			segments: []string{"8"},
+			cells:    [][]string{{"6"}, {"3"}, {"-"}},
+		},
+		{
+			name:     "columns suppressed independently",
+			voters:   []int{9, 4, 1},
+			counts:   [][]int{{5, 4, 1}, {4, 0, 0}},
+			additive: true,
+			segments: []string{"9", "4", "-"},
+			cells:    [][]string{{"5", "4", "-"}, {"4", "0", "-"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tab := &Crosstab{MinCellSize: 3}
+			labels := make([]string, len(tt.voters))
+			for col := range labels {
+				labels[col] = "S" + strconv.Itoa(col)
+			}
+			for range tt.counts {
+				tab.Rows = append(tab.Rows, CrosstabRow{Cells: make([]CrosstabCell, len(labels))})
+			}
+			tab.fill(labels, tt.voters, tt.counts, tt.additive)
+
+			var segments []string
+			for col, segment := range tab.Segments {
+				if segment.Label != labels[col] {
+					t.Errorf("segment %d is labelled %q, want %q", col, segment.Label, labels[col])
+				}
+				switch {
+				case segment.Suppressed && segment.Voters == nil:
+					segments = append(segments, "-")
+				case !segment.Suppressed && segment.Voters != nil:
+					segments = append(segments, strconv.Itoa(*segment.Voters))
+				default:
+					t.Errorf("segment %d is inconsistent: %+v", col, segment)
+				}
+			}
+			if !reflect.DeepEqual(segments, tt.segments) {
+				t.Errorf("segments = %v, want %v", segments, tt.segments)
+			}
+
+			cells := make([][]string, len(tab.Rows))
+			for i, row := range tab.Rows {
+				for col, cell := range row.Cells {
+					switch {
+					case cell.Suppressed && cell.Count == nil && cell.Percent == nil:
+						cells[i] = append(cells[i], "-")
+					case !cell.Suppressed && cell.Count != nil && cell.Percent != nil:
+						cells[i] = append(cells[i], strconv.Itoa(*cell.Count))
+						if want := float64(*cell.Count) * 100 / float64(tt.voters[col]); *cell.Percent != want {
+							t.Errorf("cell %d,%d is %v%%, want %v%%", i, col, *cell.Percent, want)
+						}
+					default:
+						t.Errorf("cell %d,%d is inconsistent: %+v", i, col, cell)
+					}
+				}
+			}
+			if !reflect.DeepEqual(cells, tt.cells) {
+				t.Errorf("cells = %v, want %v", cells, tt.cells)
+			}
+		})
+	}
+}
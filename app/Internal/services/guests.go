@@ -0,0 +1,398 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/mail"
+	"strings"
+	"time"
+
+	"fakidoosuurdoris/app/Internal/metrics"
+	"fakidoosuurdoris/app/Internal/models"
+)
+
+var (
+	ErrGuestAccess   = errors.New("guest voting not available")
+	ErrGuestCode     = errors.New("invalid or expired verification code")
+	ErrGuestCodeSent = errors.New("verification code sent recently")
+	ErrAlreadyVoted  = errors.New("already voted")
+	ErrInvalidGuest  = errors.New("invalid guest settings")
+)
+
+// Guest access modes. With GuestAccessLink anyone who has the poll's public
+// link may vote; with GuestAccessInvite only holders of an unused invite.
+const (
+	GuestAccessLink   = "link"
+	GuestAccessInvite = "invite"
+)
+
+const (
+	guestCodeTTL         = 15 * time.Minute
+	guestCodeResendAfter = time.Minute
+	guestCodeAttempts    = 5
+)
+
+// GuestSettings controls voting without an account. Slug is empty while
+// public link voting is off. EmbedResults lets other sites show the poll's
+// live results through the link.
+type GuestSettings struct {
+	Slug              string `json:"slug,omitempty"`
+	Access            string `json:"access"`
+	EmailVerification bool   `json:"email_verification"`
+	EmbedResults      bool   `json:"embed_results"`
+}
+
+// GuestBallot is a vote cast through a public link. GuestID comes from the
+// guest's signed cookie; InviteToken, Email and Code are set when the poll
+// asks for them.
+type GuestBallot struct {
+	PollID      int64
+	GuestID     string
+	InviteToken string
+	Email       string
+	Code        string
+	OptionIDs   []int64
+	TextAnswer  string
+}
+
+// randomToken returns n random bytes encoded for use in URLs.
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeGuestEmail lowercases a guest's email address, or returns an
+// empty string if it is not a valid address.
+func NormalizeGuestEmail(email string) string {
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(addr.Address)
+}
+
+func (s *PollService) GetGuestSettings(ctx context.Context, pollID int64) (*GuestSettings, error) {
+	ctx, span := startPollSpan(ctx, "PollService.GetGuestSettings", pollID)
+	defer span.End()
+
+	var settings GuestSettings
+	var slug sql.NullString
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT public_slug, guest_access, guest_email_verification, embed_results FROM polls WHERE id = $1
+	`, pollID).Scan(&slug, &settings.Access, &settings.EmailVerification, &settings.EmbedResults)
+	if err != nil {
+		return nil, err
+	}
+	settings.Slug = slug.String
+	return &settings, nil
+}
+
+// UpdateGuestSettings changes how a poll can be voted on without an account.
+// Enabling the public link gives the poll a new unguessable slug unless it
+// already has one; disabling it drops the slug, so old links stop working.
+// The slug of update is ignored. Only the poll's creator and admins may
+// change the settings.
+func (s *PollService) UpdateGuestSettings(ctx context.Context, pollID int64, userID string, enabled bool, update GuestSettings) (*GuestSettings, error) {
+	ctx, span := startPollSpan(ctx, "PollService.UpdateGuestSettings", pollID)
+	defer span.End()
+
+	if update.Access != GuestAccessLink && update.Access != GuestAccessInvite {
+		return nil, fmt.Errorf("%w: unknown access mode %q", ErrInvalidGuest, update.Access)
+	}
+	if _, err := s.authorizePollOwner(ctx, pollID, userID); err != nil {
+		return nil, err
+	}
+
+	settings, err := s.GetGuestSettings(ctx, pollID)
+	if err != nil {
+		return nil, err
+	}
+	settings.Access, settings.EmailVerification, settings.EmbedResults = update.Access, update.EmailVerification, update.EmbedResults
+	switch {
+	case !enabled:
+		settings.Slug = ""
+	case settings.Slug == "":
+		if settings.Slug, err = randomToken(16); err != nil {
+			return nil, err
+		}
+	}
+
+	var slug interface{}
+	if settings.Slug != "" {
+		slug = settings.Slug
+	}
+	_, err = s.DB.ExecContext(ctx, `
+		UPDATE polls SET public_slug = $1, guest_access = $2, guest_email_verification = $3, embed_results = $4 WHERE id = $5
+	`, slug, settings.Access, settings.EmailVerification, settings.EmbedResults, pollID)
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// RotateGuestSlug replaces a poll's public link, invalidating the old one.
+func (s *PollService) RotateGuestSlug(ctx context.Context, pollID int64, userID string) error {
+	ctx, span := startPollSpan(ctx, "PollService.RotateGuestSlug", pollID)
+	defer span.End()
+
+	if _, err := s.authorizePollOwner(ctx, pollID, userID); err != nil {
+		return err
+	}
+	slug, err := randomToken(16)
+	if err != nil {
+		return err
+	}
+	result, err := s.DB.ExecContext(ctx, `
+		UPDATE polls SET public_slug = $1 WHERE id = $2 AND public_slug IS NOT NULL
+	`, slug, pollID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+// VotePath returns the path people vote on a poll at: its public link if it
+// has one, and the members' voting page otherwise. Only the poll's creator
+// and admins may see it, since the public link works without an account.
+func (s *PollService) VotePath(ctx context.Context, pollID int64, userID string) (string, error) {
+	ctx, span := startPollSpan(ctx, "PollService.VotePath", pollID)
+	defer span.End()
+
+	if _, err := s.authorizePollOwner(ctx, pollID, userID); err != nil {
+		return "", err
+	}
+	settings, err := s.GetGuestSettings(ctx, pollID)
+	if err != nil {
+		return "", err
+	}
+	if settings.Slug != "" {
+		return "/p/" + settings.Slug, nil
+	}
+	return fmt.Sprintf("/vote/%d", pollID), nil
+}
+
+// GetPollBySlug returns the poll behind a public link.
+func (s *PollService) GetPollBySlug(ctx context.Context, slug string) (*models.Poll, *GuestSettings, error) {
+	ctx, span := startSpan(ctx, "PollService.GetPollBySlug")
+	defer span.End()
+
+	var pollID int64
+	err := s.DB.QueryRowContext(ctx, `SELECT id FROM polls WHERE public_slug = $1`, slug).Scan(&pollID)
+	if err != nil {
+		return nil, nil, err
+	}
+	poll, err := s.GetPoll(ctx, pollID)
+	if err != nil {
+		return nil, nil, err
+	}
+	settings, err := s.GetGuestSettings(ctx, pollID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return poll, settings, nil
+}
+
+// HasGuestVoted reports whether the guest with the given cookie ID has
+// voted in the poll.
+func (s *PollService) HasGuestVoted(ctx context.Context, pollID int64, guestID string) (bool, error) {
+	ctx, span := startPollSpan(ctx, "PollService.HasGuestVoted", pollID)
+	defer span.End()
+
+	var voted bool
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM guest_ballots WHERE poll_id = $1 AND guest_key = $2)
+	`, pollID, "cookie:"+guestID).Scan(&voted)
+	return voted, err
+}
+
+// CreateGuestCode issues a six-digit code that proves a guest owns email,
+// replacing any earlier code. A new code can be requested once a minute.
+func (s *PollService) CreateGuestCode(ctx context.Context, pollID int64, email string) (string, error) {
+	ctx, span := startPollSpan(ctx, "PollService.CreateGuestCode", pollID)
+	defer span.End()
+
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	code := fmt.Sprintf("%06d", n.Int64())
+	now := time.Now()
+	result, err := s.DB.ExecContext(ctx, `
+		INSERT INTO guest_email_codes (poll_id, email, code_hash, sent_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (poll_id, email) DO UPDATE
+		SET code_hash = EXCLUDED.code_hash, sent_at = EXCLUDED.sent_at, expires_at = EXCLUDED.expires_at, attempts = 0
+		WHERE guest_email_codes.sent_at <= $6
+	`, pollID, email, hashToken(code), now, now.Add(guestCodeTTL), now.Add(-guestCodeResendAfter))
+	if err != nil {
+		return "", err
+	}
+	if err := expectAffected(result); err != nil {
+		return "", ErrGuestCodeSent
+	}
+	return code, nil
+}
+
+// checkGuestCode verifies a guest's email code. Failed attempts are counted
+// and the code stops working after guestCodeAttempts of them.
+func (s *PollService) checkGuestCode(ctx context.Context, pollID int64, email, code string) error {
+	var hash string
+	var attempts int
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT code_hash, attempts FROM guest_email_codes WHERE poll_id = $1 AND email = $2 AND expires_at > $3
+	`, pollID, email, time.Now()).Scan(&hash, &attempts)
+	if err == sql.ErrNoRows {
+		return ErrGuestCode
+	}
+	if err != nil {
+		return err
+	}
+	if attempts >= guestCodeAttempts {
+		return ErrGuestCode
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(strings.TrimSpace(code))), []byte(hash)) != 1 {
+		if _, err := s.DB.ExecContext(ctx, `
+			UPDATE guest_email_codes SET attempts = attempts + 1 WHERE poll_id = $1 AND email = $2
+		`, pollID, email); err != nil {
+			return err
+		}
+		return ErrGuestCode
+	}
+	return nil
+}
+
+// RecordGuestVote records a vote cast without an account. An invite is the
+// voter's only identity, so several invitees can vote from one browser.
+// Without one, the guest's cookie and verified email are both claimed for
+// the poll, the vote is refused if either already voted, and it is recorded
+// under the email if there is one. Polls with eligibility rules only accept
+// invites or a verified email address the rules allow.
+func (s *PollService) RecordGuestVote(ctx context.Context, ballot GuestBallot) error {
+	ctx, span := startSpan(ctx, "PollService.RecordGuestVote")
+	defer span.End()
+
+	if ballot.GuestID == "" {
+		return ErrGuestAccess
+	}
+	poll, err := s.GetPoll(ctx, ballot.PollID)
+	if err != nil {
+		return err
+	}
+	settings, err := s.GetGuestSettings(ctx, poll.ID)
+	if err != nil {
+		return err
+	}
+	if settings.Slug == "" {
+		return ErrGuestAccess
+	}
+	now := time.Now()
+	if poll.StartDate.After(now) || (poll.EndDate != nil && poll.EndDate.Before(now)) {
+		return s.rejectVote(metrics.RejectClosed, sql.ErrNoRows)
+	}
+	if ballot.InviteToken == "" && settings.Access == GuestAccessInvite {
+		return s.rejectVote(metrics.RejectNotEligible, ErrGuestAccess)
+	}
+
+	email := ""
+	if ballot.InviteToken == "" && settings.EmailVerification {
+		if email = NormalizeGuestEmail(ballot.Email); email == "" {
+			return s.rejectVote(metrics.RejectUnverified, ErrGuestCode)
+		}
+		if err := s.checkGuestCode(ctx, poll.ID, email, ballot.Code); err != nil {
+			if err == ErrGuestCode {
+				return s.rejectVote(metrics.RejectUnverified, err)
+			}
+			return err
+		}
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var keys []string
+	if ballot.InviteToken != "" {
+		var inviteID int64
+		err := tx.QueryRowContext(ctx, `
+			UPDATE guest_invites SET used_at = $1
+			WHERE poll_id = $2 AND token_hash = $3 AND used_at IS NULL AND revoked_at IS NULL
+			RETURNING id
+		`, now, poll.ID, hashToken(ballot.InviteToken)).Scan(&inviteID)
+		if err == sql.ErrNoRows {
+			return s.rejectVote(metrics.RejectNotEligible, ErrGuestAccess)
+		}
+		if err != nil {
+			return err
+		}
+		if err := auditInvite(ctx, tx, poll.ID, inviteID, InviteActionUsed, "", ""); err != nil {
+			return err
+		}
+		keys = append(keys, fmt.Sprintf("invite:%d", inviteID))
+	} else {
+		keys = append(keys, "cookie:"+ballot.GuestID)
+		if email != "" {
+			keys = append(keys, "email:"+hashToken(email))
+			if _, err := tx.ExecContext(ctx, `DELETE FROM guest_email_codes WHERE poll_id = $1 AND email = $2`, poll.ID, email); err != nil {
+				return err
+			}
+		}
+		var eligible bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT NOT EXISTS (SELECT 1 FROM poll_eligibility WHERE poll_id = $1)
+				OR EXISTS (SELECT 1 FROM poll_eligibility WHERE poll_id = $1 AND kind = 'email' AND value = $2)
+		`, poll.ID, email).Scan(&eligible)
+		if err != nil {
+			return err
+		}
+		if !eligible {
+			return s.rejectVote(metrics.RejectNotEligible, ErrGuestAccess)
+		}
+	}
+
+	for _, key := range keys {
+		result, err := tx.ExecContext(ctx, `
+			INSERT INTO guest_ballots (poll_id, guest_key) VALUES ($1, $2) ON CONFLICT DO NOTHING
+		`, poll.ID, key)
+		if err != nil {
+			return err
+		}
+		if err := expectAffected(result); err != nil {
+			return s.rejectVote(metrics.RejectAlreadyVoted, ErrAlreadyVoted)
+		}
+	}
+
+	if err := insertVote(ctx, tx, poll, "", keys[len(keys)-1], ballot.OptionIDs, ballot.TextAnswer); err != nil {
+		if err == sql.ErrNoRows {
+			return s.rejectVote(metrics.RejectInvalid, err)
+		}
+		return err
+	}
+	// The event is queued in the vote's transaction, so a vote is never
+	// recorded without it.
+	if err := s.emitVoteCast(ctx, tx, poll, "", ballot.OptionIDs, ballot.TextAnswer); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.metrics().VoteCast(poll.QuestionType)
+	return nil
+}
@@ -0,0 +1,380 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Invite statuses. An invite is unsent until it is emailed, and ends up
+// used once its holder votes or revoked if it is withdrawn first.
+const (
+	InviteUnsent  = "unsent"
+	InviteSent    = "sent"
+	InviteUsed    = "used"
+	InviteRevoked = "revoked"
+)
+
+// Actions recorded in the invite audit trail.
+const (
+	InviteActionCreated  = "created"
+	InviteActionSent     = "sent"
+	InviteActionUsed     = "used"
+	InviteActionRevoked  = "revoked"
+	InviteActionReissued = "reissued"
+)
+
+// MaxGuestInvites is the most invites created at once.
+const MaxGuestInvites = 500
+
+// GuestInvite is a single-use invitation to vote without an account. Only a
+// hash of its token is stored, so Token is set just when the invite is
+// created, reissued or sent. Email is set when the label holds an address.
+type GuestInvite struct {
+	ID           int64      `json:"id"`
+	PollID       int64      `json:"poll_id"`
+	Label        string     `json:"label"`
+	Email        string     `json:"email,omitempty"`
+	Token        string     `json:"token,omitempty"`
+	Status       string     `json:"status"`
+	CreatedAt    time.Time  `json:"created_at"`
+	SentAt       *time.Time `json:"sent_at"`
+	UsedAt       *time.Time `json:"used_at"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+	ReissuedFrom *int64     `json:"reissued_from,omitempty"`
+}
+
+// InviteAuditEntry records who did what to an invite. ActorID is empty for
+// the invitee's own actions.
+type InviteAuditEntry struct {
+	ID        int64     `json:"id"`
+	InviteID  int64     `json:"invite_id"`
+	Action    string    `json:"action"`
+	ActorID   string    `json:"actor_id,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const inviteColumns = `id, poll_id, label, email, created_at, sent_at, used_at, revoked_at, reissued_from`
+
+func scanInvite(row interface{ Scan(...interface{}) error }) (GuestInvite, error) {
+	var invite GuestInvite
+	var sentAt, usedAt, revokedAt sql.NullTime
+	var reissuedFrom sql.NullInt64
+	err := row.Scan(&invite.ID, &invite.PollID, &invite.Label, &invite.Email, &invite.CreatedAt, &sentAt, &usedAt, &revokedAt, &reissuedFrom)
+	if err != nil {
+		return invite, err
+	}
+	invite.Status = InviteUnsent
+	if sentAt.Valid {
+		invite.SentAt = &sentAt.Time
+		invite.Status = InviteSent
+	}
+	if usedAt.Valid {
+		invite.UsedAt = &usedAt.Time
+		invite.Status = InviteUsed
+	}
+	if revokedAt.Valid {
+		invite.RevokedAt = &revokedAt.Time
+		invite.Status = InviteRevoked
+	}
+	if reissuedFrom.Valid {
+		invite.ReissuedFrom = &reissuedFrom.Int64
+	}
+	return invite, nil
+}
+
+func auditInvite(ctx context.Context, db execer, pollID, inviteID int64, action, actorID, detail string) error {
+	var actor interface{}
+	if actorID != "" {
+		actor = actorID
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO guest_invite_audit (poll_id, invite_id, action, actor_id, detail) VALUES ($1, $2, $3, $4, $5)
+	`, pollID, inviteID, action, actor, detail)
+	return err
+}
+
+// insertInvite stores a new invite with a fresh token and records its
+// creation.
+func insertInvite(ctx context.Context, tx *sql.Tx, pollID int64, label, email string, reissuedFrom *int64, actorID, detail string) (GuestInvite, error) {
+	token, err := randomToken(16)
+	if err != nil {
+		return GuestInvite{}, err
+	}
+	invite, err := scanInvite(tx.QueryRowContext(ctx, `
+		INSERT INTO guest_invites (poll_id, token_hash, label, email, reissued_from) VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+inviteColumns,
+		pollID, hashToken(token), label, email, reissuedFrom))
+	if err != nil {
+		return GuestInvite{}, err
+	}
+	invite.Token = token
+	action := InviteActionCreated
+	if reissuedFrom != nil {
+		action = InviteActionReissued
+	}
+	return invite, auditInvite(ctx, tx, pollID, invite.ID, action, actorID, detail)
+}
+
+func (s *PollService) ListGuestInvites(ctx context.Context, pollID int64, userID string) ([]GuestInvite, error) {
+	ctx, span := startPollSpan(ctx, "PollService.ListGuestInvites", pollID)
+	defer span.End()
+
+	if _, err := s.authorizePollOwner(ctx, pollID, userID); err != nil {
+		return nil, err
+	}
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+inviteColumns+` FROM guest_invites WHERE poll_id = $1 ORDER BY id`, pollID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invites []GuestInvite
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+// CreateGuestInvites creates one invite per label, such as the invitee's
+// name or email address; labels may be empty for anonymous codes. Labels
+// holding an address, alone or as "Name <address>", make invites that can
+// be emailed. The returned invites carry their tokens, which cannot be
+// recovered later.
+func (s *PollService) CreateGuestInvites(ctx context.Context, pollID int64, userID string, labels []string) ([]GuestInvite, error) {
+	ctx, span := startPollSpan(ctx, "PollService.CreateGuestInvites", pollID)
+	defer span.End()
+
+	if len(labels) == 0 || len(labels) > MaxGuestInvites {
+		return nil, fmt.Errorf("%w: between 1 and %d invites can be created at once", ErrInvalidGuest, MaxGuestInvites)
+	}
+	if _, err := s.authorizePollOwner(ctx, pollID, userID); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	invites := make([]GuestInvite, 0, len(labels))
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		invite, err := insertInvite(ctx, tx, pollID, label, NormalizeGuestEmail(label), nil, userID, "")
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	return invites, tx.Commit()
+}
+
+// SendGuestInvites emails the given unsent invites, or all unsent invites
+// with an address if ids is empty, and returns the IDs of those sent. Each
+// gets a new token, since the old one is only known as a hash, so codes
+// handed out another way stop working once emailed. Sending needs the
+// poll's public link.
+func (s *PollService) SendGuestInvites(ctx context.Context, pollID int64, userID string, ids []int64) ([]int64, error) {
+	ctx, span := startPollSpan(ctx, "PollService.SendGuestInvites", pollID)
+	defer span.End()
+
+	if s.AuthService == nil || s.AuthService.Notifications == nil {
+		return nil, fmt.Errorf("%w: email is not configured", ErrInvalidGuest)
+	}
+	poll, err := s.authorizePollOwner(ctx, pollID, userID)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := s.GetGuestSettings(ctx, pollID)
+	if err != nil {
+		return nil, err
+	}
+	if settings.Slug == "" {
+		return nil, fmt.Errorf("%w: allow voting without an account before sending invites", ErrInvalidGuest)
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var only interface{}
+	if len(ids) > 0 {
+		only = pq.Array(ids)
+	}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT `+inviteColumns+` FROM guest_invites
+		WHERE poll_id = $1 AND email <> '' AND sent_at IS NULL AND used_at IS NULL AND revoked_at IS NULL
+			AND ($2::BIGINT[] IS NULL OR id = ANY($2))
+		ORDER BY id
+		FOR UPDATE
+	`, pollID, only)
+	if err != nil {
+		return nil, err
+	}
+	var invites []GuestInvite
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	sent := make([]int64, 0, len(invites))
+	for _, invite := range invites {
+		token, err := randomToken(16)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE guest_invites SET token_hash = $1, sent_at = $2 WHERE id = $3
+		`, hashToken(token), now, invite.ID); err != nil {
+			return nil, err
+		}
+		link := s.AuthService.Notifications.BaseURL + "/p/" + settings.Slug + "?invite=" + token
+		if err := s.AuthService.Notifications.SendInvitation(ctx, tx, invite.Email, poll.Title, poll.EndDate, link); err != nil {
+			return nil, err
+		}
+		if err := auditInvite(ctx, tx, pollID, invite.ID, InviteActionSent, userID, "to "+invite.Email); err != nil {
+			return nil, err
+		}
+		sent = append(sent, invite.ID)
+	}
+	return sent, tx.Commit()
+}
+
+// RevokeGuestInvite withdraws an invite that has not been used.
+func (s *PollService) RevokeGuestInvite(ctx context.Context, pollID, inviteID int64, userID, reason string) error {
+	ctx, span := startPollSpan(ctx, "PollService.RevokeGuestInvite", pollID)
+	defer span.End()
+
+	if _, err := s.authorizePollOwner(ctx, pollID, userID); err != nil {
+		return err
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := revokeInvite(ctx, tx, pollID, inviteID); err != nil {
+		return err
+	}
+	if err := auditInvite(ctx, tx, pollID, inviteID, InviteActionRevoked, userID, strings.TrimSpace(reason)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReissueGuestInvite revokes an unused invite, for instance one whose link
+// was lost, and creates a replacement for the same invitee. The replacement
+// is unsent and carries its token.
+func (s *PollService) ReissueGuestInvite(ctx context.Context, pollID, inviteID int64, userID, reason string) (*GuestInvite, error) {
+	ctx, span := startPollSpan(ctx, "PollService.ReissueGuestInvite", pollID)
+	defer span.End()
+
+	if _, err := s.authorizePollOwner(ctx, pollID, userID); err != nil {
+		return nil, err
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	old, err := revokeInvite(ctx, tx, pollID, inviteID)
+	if err != nil {
+		return nil, err
+	}
+	reason = strings.TrimSpace(reason)
+	invite, err := insertInvite(ctx, tx, pollID, old.Label, old.Email, &old.ID, userID, joinDetail(fmt.Sprintf("replaces invite %d", old.ID), reason))
+	if err != nil {
+		return nil, err
+	}
+	detail := joinDetail(fmt.Sprintf("reissued as invite %d", invite.ID), reason)
+	if err := auditInvite(ctx, tx, pollID, old.ID, InviteActionRevoked, userID, detail); err != nil {
+		return nil, err
+	}
+	return &invite, tx.Commit()
+}
+
+func revokeInvite(ctx context.Context, tx *sql.Tx, pollID, inviteID int64) (GuestInvite, error) {
+	return scanInvite(tx.QueryRowContext(ctx, `
+		UPDATE guest_invites SET revoked_at = $1
+		WHERE id = $2 AND poll_id = $3 AND used_at IS NULL AND revoked_at IS NULL
+		RETURNING `+inviteColumns,
+		time.Now(), inviteID, pollID))
+}
+
+func joinDetail(detail, reason string) string {
+	if reason == "" {
+		return detail
+	}
+	return detail + ": " + reason
+}
+
+// CheckGuestInvite reports whether token is an invite to the poll that can
+// still be used.
+func (s *PollService) CheckGuestInvite(ctx context.Context, pollID int64, token string) (bool, error) {
+	ctx, span := startPollSpan(ctx, "PollService.CheckGuestInvite", pollID)
+	defer span.End()
+
+	var valid bool
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM guest_invites
+			WHERE poll_id = $1 AND token_hash = $2 AND used_at IS NULL AND revoked_at IS NULL
+		)
+	`, pollID, hashToken(token)).Scan(&valid)
+	return valid, err
+}
+
+// ListInviteAudit returns the most recent entries of a poll's invite audit
+// trail, newest first.
+func (s *PollService) ListInviteAudit(ctx context.Context, pollID int64, userID string, limit int) ([]InviteAuditEntry, error) {
+	ctx, span := startPollSpan(ctx, "PollService.ListInviteAudit", pollID)
+	defer span.End()
+
+	if _, err := s.authorizePollOwner(ctx, pollID, userID); err != nil {
+		return nil, err
+	}
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, invite_id, action, COALESCE(actor_id, ''), detail, created_at
+		FROM guest_invite_audit
+		WHERE poll_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`, pollID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []InviteAuditEntry
+	for rows.Next() {
+		var entry InviteAuditEntry
+		if err := rows.Scan(&entry.ID, &entry.InviteID, &entry.Action, &entry.ActorID, &entry.Detail, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
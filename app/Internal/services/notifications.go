@@ -0,0 +1,430 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"fakidoosuurdoris/app/Internal/mailer"
+)
+
+const (
+	outboxBatchSize   = 50
+	outboxMaxAttempts = 8
+)
+
+// NotificationService queues templated emails in the email_outbox table and
+// delivers them with a Mailer. Poll events are detected by scanning polls, so
+// nothing is lost when the process restarts between an event and its email.
+type NotificationService struct {
+	DB           *sql.DB
+	Mailer       mailer.Mailer
+	BaseURL      string
+	ReminderLead time.Duration
+}
+
+func NewNotificationService(db *sql.DB, m mailer.Mailer, baseURL string, reminderLead time.Duration) *NotificationService {
+	return &NotificationService{
+		DB:           db,
+		Mailer:       m,
+		BaseURL:      baseURL,
+		ReminderLead: reminderLead,
+	}
+}
+
+type NotificationPreferences struct {
+	PollOpened   bool `json:"poll_opened"`
+	PollReminder bool `json:"poll_reminder"`
+	PollClosed   bool `json:"poll_closed"`
+	AdminGranted bool `json:"admin_granted"`
+}
+
+// GetPreferences returns the user's preferences. Users who never saved any
+// get every notification.
+func (s *NotificationService) GetPreferences(ctx context.Context, userID string) (NotificationPreferences, error) {
+	prefs := NotificationPreferences{true, true, true, true}
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT poll_opened, poll_reminder, poll_closed, admin_granted
+		FROM notification_preferences WHERE user_id = $1
+	`, userID).Scan(&prefs.PollOpened, &prefs.PollReminder, &prefs.PollClosed, &prefs.AdminGranted)
+	if err == sql.ErrNoRows {
+		return prefs, nil
+	}
+	return prefs, err
+}
+
+func (s *NotificationService) UpdatePreferences(ctx context.Context, userID string, prefs NotificationPreferences) error {
+	_, err := s.DB.ExecContext(ctx, `
+		INSERT INTO notification_preferences (user_id, poll_opened, poll_reminder, poll_closed, admin_granted)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id) DO UPDATE SET
+			poll_opened = EXCLUDED.poll_opened,
+			poll_reminder = EXCLUDED.poll_reminder,
+			poll_closed = EXCLUDED.poll_closed,
+			admin_granted = EXCLUDED.admin_granted
+	`, userID, prefs.PollOpened, prefs.PollReminder, prefs.PollClosed, prefs.AdminGranted)
+	return err
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// enqueue stores a rendered email in the outbox. A non-empty dedupeKey makes
+// the call idempotent, so a scan that is retried never sends twice.
+func (s *NotificationService) enqueue(ctx context.Context, db execer, dedupeKey, template, to string, data map[string]interface{}) error {
+	data["PreferencesLink"] = s.BaseURL + "/profile"
+	msg, err := mailer.Render(template, to, data)
+	if err != nil {
+		return err
+	}
+	var key interface{}
+	if dedupeKey != "" {
+		key = dedupeKey
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO email_outbox (dedupe_key, recipient, subject, body)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (dedupe_key) DO NOTHING
+	`, key, msg.To, msg.Subject, msg.Body)
+	return err
+}
+
+// NotifyAdminGranted tells a user they were given the admin role.
+func (s *NotificationService) NotifyAdminGranted(ctx context.Context, userID string) error {
+	var name, email string
+	var enabled bool
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT u.firstname, u.email, COALESCE(np.admin_granted, TRUE)
+		FROM users u
+		LEFT JOIN notification_preferences np ON np.user_id = u.id
+		WHERE u.id = $1
+	`, userID).Scan(&name, &email, &enabled)
+	if err != nil || !enabled {
+		return err
+	}
+	return s.enqueue(ctx, s.DB, "", "admin_granted", email, map[string]interface{}{
+		"Name": name,
+		"Link": s.BaseURL + "/login",
+	})
+}
+
+// SendGuestCode emails a guest the code that verifies their address before
+// they vote through a public link.
+func (s *NotificationService) SendGuestCode(ctx context.Context, email, title, slug, code string) error {
+	return s.enqueue(ctx, s.DB, "", "guest_code", email, map[string]interface{}{
+		"Title":   title,
+		"Code":    code,
+		"Minutes": int(guestCodeTTL.Minutes()),
+		"Link":    s.BaseURL + "/p/" + slug,
+	})
+}
+
+// SendInvitation emails an invitee their personal voting link. db lets the
+// caller queue the email in the transaction that issued the link.
+func (s *NotificationService) SendInvitation(ctx context.Context, db execer, email, title string, endDate *time.Time, link string) error {
+	return s.enqueue(ctx, db, "", "invitation", email, map[string]interface{}{
+		"Title":   title,
+		"EndDate": endDate,
+		"Link":    link,
+	})
+}
+
+// Run scans for poll events and flushes the outbox every interval until ctx
+// is done.
+func (s *NotificationService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.queuePollOpened(ctx); err != nil {
+			slog.ErrorContext(ctx, "Failed to queue poll opened notifications", "error", err)
+		}
+		if err := s.queueReminders(ctx); err != nil {
+			slog.ErrorContext(ctx, "Failed to queue poll reminders", "error", err)
+		}
+		if err := s.queuePollClosed(ctx); err != nil {
+			slog.ErrorContext(ctx, "Failed to queue poll closed notifications", "error", err)
+		}
+		if err := s.FlushOutbox(ctx); err != nil {
+			slog.ErrorContext(ctx, "Failed to flush email outbox", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type pollEvent struct {
+	ID      int64
+	Title   string
+	UserID  string
+	EndDate *time.Time
+}
+
+// claimPolls locks the polls selected by query inside tx so that concurrent
+// replicas do not both notify for the same poll.
+func claimPolls(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]pollEvent, error) {
+	rows, err := tx.QueryContext(ctx, query+" FOR UPDATE SKIP LOCKED", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var polls []pollEvent
+	for rows.Next() {
+		var p pollEvent
+		var endDate sql.NullTime
+		if err := rows.Scan(&p.ID, &p.Title, &p.UserID, &endDate); err != nil {
+			return nil, err
+		}
+		if endDate.Valid {
+			p.EndDate = &endDate.Time
+		}
+		polls = append(polls, p)
+	}
+	return polls, rows.Err()
+}
+
+type recipient struct {
+	ID, Name, Email string
+}
+
+func queryRecipients(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]recipient, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []recipient
+	for rows.Next() {
+		var r recipient
+		if err := rows.Scan(&r.ID, &r.Name, &r.Email); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, rows.Err()
+}
+
+// queuePollOpened announces newly opened polls to the users eligible to
+// vote on them.
+func (s *NotificationService) queuePollOpened(ctx context.Context) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	polls, err := claimPolls(ctx, tx, `
+		SELECT id, title, user_id, end_date FROM polls
+		WHERE opened_notified_at IS NULL AND start_date <= $1
+	`, now)
+	if err != nil {
+		return err
+	}
+
+	for _, poll := range polls {
+		// A poll that already closed again before the scan ran is not worth announcing.
+		if poll.EndDate == nil || poll.EndDate.After(now) {
+			recipients, err := queryRecipients(ctx, tx, `
+				SELECT u.id, u.firstname, u.email
+				FROM users u
+				LEFT JOIN notification_preferences np ON np.user_id = u.id
+				WHERE COALESCE(np.poll_opened, TRUE) AND u.id <> $2 AND `+eligibleVoter+`
+			`, poll.ID, DeletedUserID)
+			if err != nil {
+				return err
+			}
+			for _, r := range recipients {
+				err := s.enqueue(ctx, tx, fmt.Sprintf("poll_opened:%d:%s", poll.ID, r.ID), "poll_opened", r.Email, map[string]interface{}{
+					"Name":    r.Name,
+					"Title":   poll.Title,
+					"EndDate": poll.EndDate,
+					"Link":    fmt.Sprintf("%s/vote/%d", s.BaseURL, poll.ID),
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE polls SET opened_notified_at = $1 WHERE id = $2`, now, poll.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// queueReminders reminds eligible users who have not voted yet that a poll
+// is about to close.
+func (s *NotificationService) queueReminders(ctx context.Context) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	polls, err := claimPolls(ctx, tx, `
+		SELECT id, title, user_id, end_date FROM polls
+		WHERE reminder_sent_at IS NULL AND start_date <= $1 AND end_date > $1 AND end_date <= $2
+	`, now, now.Add(s.ReminderLead))
+	if err != nil {
+		return err
+	}
+
+	for _, poll := range polls {
+		recipients, err := queryRecipients(ctx, tx, `
+			SELECT u.id, u.firstname, u.email
+			FROM users u
+			LEFT JOIN notification_preferences np ON np.user_id = u.id
+			WHERE COALESCE(np.poll_reminder, TRUE) AND u.id <> $2 AND `+eligibleVoter+`
+			AND NOT EXISTS (
+				SELECT 1 FROM votes v
+				WHERE v.poll_id = $1 AND (v.user_id = u.id OR (v.user_id IS NULL AND v.voted_by = u.id))
+			)
+		`, poll.ID, DeletedUserID)
+		if err != nil {
+			return err
+		}
+		for _, r := range recipients {
+			err := s.enqueue(ctx, tx, fmt.Sprintf("poll_reminder:%d:%s", poll.ID, r.ID), "poll_reminder", r.Email, map[string]interface{}{
+				"Name":    r.Name,
+				"Title":   poll.Title,
+				"EndDate": poll.EndDate,
+				"Link":    fmt.Sprintf("%s/vote/%d", s.BaseURL, poll.ID),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE polls SET reminder_sent_at = $1 WHERE id = $2`, now, poll.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// queuePollClosed tells the poll creator and the users who voted that a
+// poll has closed. Only the creator is sent the link to the full results,
+// which need the admin role to open.
+func (s *NotificationService) queuePollClosed(ctx context.Context) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	polls, err := claimPolls(ctx, tx, `
+		SELECT id, title, user_id, end_date FROM polls
+		WHERE closed_notified_at IS NULL AND end_date < $1
+	`, now)
+	if err != nil {
+		return err
+	}
+
+	for _, poll := range polls {
+		recipients, err := queryRecipients(ctx, tx, `
+			SELECT u.id, u.firstname, u.email
+			FROM users u
+			LEFT JOIN notification_preferences np ON np.user_id = u.id
+			WHERE COALESCE(np.poll_closed, TRUE) AND u.id <> $3
+			AND (u.id = $2 OR EXISTS (
+				SELECT 1 FROM votes v
+				WHERE v.poll_id = $1 AND (v.user_id = u.id OR (v.user_id IS NULL AND v.voted_by = u.id))
+			))
+		`, poll.ID, poll.UserID, DeletedUserID)
+		if err != nil {
+			return err
+		}
+		for _, r := range recipients {
+			owner := r.ID == poll.UserID
+			link := s.BaseURL + "/polls-list?status=closed"
+			if owner {
+				link = fmt.Sprintf("%s/api/admin/polls/%d/summary/download?format=pdf", s.BaseURL, poll.ID)
+			}
+			err := s.enqueue(ctx, tx, fmt.Sprintf("poll_closed:%d:%s", poll.ID, r.ID), "poll_closed", r.Email, map[string]interface{}{
+				"Name":  r.Name,
+				"Title": poll.Title,
+				"Owner": owner,
+				"Link":  link,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE polls SET closed_notified_at = $1 WHERE id = $2`, now, poll.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// FlushOutbox sends due emails. Failed sends are retried with exponential
+// backoff and given up after outboxMaxAttempts.
+func (s *NotificationService) FlushOutbox(ctx context.Context) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, recipient, subject, body, attempts
+		FROM email_outbox
+		WHERE status = 'pending' AND next_attempt_at <= $1
+		ORDER BY id
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`, time.Now(), outboxBatchSize)
+	if err != nil {
+		return err
+	}
+	type outboxEmail struct {
+		ID       int64
+		Msg      mailer.Message
+		Attempts int
+	}
+	var emails []outboxEmail
+	for rows.Next() {
+		var e outboxEmail
+		if err := rows.Scan(&e.ID, &e.Msg.To, &e.Msg.Subject, &e.Msg.Body, &e.Attempts); err != nil {
+			rows.Close()
+			return err
+		}
+		emails = append(emails, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, e := range emails {
+		attempts := e.Attempts + 1
+		if sendErr := s.Mailer.Send(ctx, e.Msg); sendErr != nil {
+			status := "pending"
+			if attempts >= outboxMaxAttempts {
+				status = "failed"
+			}
+			backoff := time.Duration(1<<uint(attempts)) * time.Minute
+			slog.ErrorContext(ctx, "Failed to send email", "email_id", e.ID, "to", e.Msg.To, "attempt", attempts, "error", sendErr)
+			_, err = tx.ExecContext(ctx, `
+				UPDATE email_outbox SET status = $1, attempts = $2, last_error = $3, next_attempt_at = $4 WHERE id = $5
+			`, status, attempts, sendErr.Error(), time.Now().Add(backoff), e.ID)
+		} else {
+			_, err = tx.ExecContext(ctx, `
+				UPDATE email_outbox SET status = 'sent', attempts = $1, last_error = NULL, sent_at = $2 WHERE id = $3
+			`, attempts, time.Now(), e.ID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
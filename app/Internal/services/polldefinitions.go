@@ -0,0 +1,531 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"fakidoosuurdoris/app/Internal/models"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalidDefinition is returned by ImportPolls when at least one
+// definition fails validation; nothing is imported in that case.
+var ErrInvalidDefinition = errors.New("invalid poll definition")
+
+// PollDefinition is the declarative form of a poll used for import and
+// export. Key is the poll's external key: importing a definition whose key
+// already exists updates that poll instead of creating a new one.
+type PollDefinition struct {
+	Key         string             `json:"key" yaml:"key"`
+	Title       string             `json:"title" yaml:"title"`
+	Type        string             `json:"type" yaml:"type"`
+	Options     []OptionDefinition `json:"options,omitempty" yaml:"options,omitempty"`
+	OptionOrder string             `json:"option_order,omitempty" yaml:"option_order,omitempty"`
+	Start       time.Time          `json:"start" yaml:"start"`
+	End         *time.Time         `json:"end,omitempty" yaml:"end,omitempty"`
+	Anonymous   bool               `json:"anonymous" yaml:"anonymous"`
+	Eligibility *Eligibility       `json:"eligibility,omitempty" yaml:"eligibility,omitempty"`
+}
+
+type OptionDefinition struct {
+	Text        string `json:"text" yaml:"text"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+	ImageURL    string `json:"image_url,omitempty" yaml:"image_url,omitempty"`
+	LinkURL     string `json:"link_url,omitempty" yaml:"link_url,omitempty"`
+}
+
+// UnmarshalJSON also accepts a bare string as an option with only a text.
+func (o *OptionDefinition) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*o = OptionDefinition{Text: text}
+		return nil
+	}
+	type plain OptionDefinition
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode((*plain)(o))
+}
+
+// Eligibility restricts who may vote in a poll. A user is eligible when their
+// role or email is listed; a poll without eligibility is open to every
+// signed-in user.
+type Eligibility struct {
+	Roles  []string `json:"roles,omitempty" yaml:"roles,omitempty"`
+	Emails []string `json:"emails,omitempty" yaml:"emails,omitempty"`
+}
+
+const (
+	ImportCreated   = "created"
+	ImportUpdated   = "updated"
+	ImportUnchanged = "unchanged"
+	ImportInvalid   = "invalid"
+)
+
+// ImportResult reports what an import did, or in a dry run would do, with
+// one definition. Index is the definition's position in the request.
+type ImportResult struct {
+	Index  int      `json:"index"`
+	Key    string   `json:"key"`
+	Status string   `json:"status"`
+	PollID int64    `json:"poll_id,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+var definitionKey = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._/-]{0,199}$`)
+
+var eligibleRoles = map[string]bool{"user": true, "admin": true}
+
+// DecodeDefinitions parses a JSON or YAML document holding either a single
+// definition or a list of them. YAML input may contain several documents.
+// Unknown fields are rejected so typos do not pass silently.
+func DecodeDefinitions(data []byte, isYAML bool) ([]PollDefinition, error) {
+	var items []json.RawMessage
+	if isYAML {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		for {
+			var doc interface{}
+			err := dec.Decode(&doc)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if doc == nil {
+				continue
+			}
+			// Round-trip through JSON so both formats share one decoder.
+			raw, err := json.Marshal(doc)
+			if err != nil {
+				return nil, err
+			}
+			docItems, err := splitDefinitions(raw)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, docItems...)
+		}
+	} else {
+		var err error
+		if items, err = splitDefinitions(data); err != nil {
+			return nil, err
+		}
+	}
+
+	defs := make([]PollDefinition, len(items))
+	for i, item := range items {
+		dec := json.NewDecoder(bytes.NewReader(item))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&defs[i]); err != nil {
+			return nil, fmt.Errorf("definition %d: %v", i, err)
+		}
+	}
+	return defs, nil
+}
+
+func splitDefinitions(data []byte) ([]json.RawMessage, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var items []json.RawMessage
+		err := json.Unmarshal(data, &items)
+		return items, err
+	}
+	return []json.RawMessage{json.RawMessage(data)}, nil
+}
+
+// normalize trims the definition and puts it in the canonical form used by
+// exports, so that an exported definition compares equal when re-imported.
+func (d PollDefinition) normalize() PollDefinition {
+	d.Key = strings.TrimSpace(d.Key)
+	d.Title = strings.TrimSpace(d.Title)
+	if d.OptionOrder != OptionOrderRandom {
+		d.OptionOrder = OptionOrderFixed
+	}
+	d.Start = d.Start.UTC().Truncate(time.Microsecond)
+	if d.End != nil {
+		end := d.End.UTC().Truncate(time.Microsecond)
+		d.End = &end
+	}
+
+	// Only choice polls use their options; the form ignores them otherwise.
+	var options []OptionDefinition
+	if d.Type == "single_choice" || d.Type == "multiple_choice" {
+		for _, o := range d.Options {
+			o.Text = strings.TrimSpace(o.Text)
+			if o.Text != "" {
+				options = append(options, o)
+			}
+		}
+	}
+	d.Options = options
+
+	if d.Eligibility != nil {
+		var e Eligibility
+		seen := make(map[string]bool)
+		for _, role := range d.Eligibility.Roles {
+			role = strings.ToLower(strings.TrimSpace(role))
+			if role != "" && !seen["role:"+role] {
+				seen["role:"+role] = true
+				e.Roles = append(e.Roles, role)
+			}
+		}
+		for _, email := range d.Eligibility.Emails {
+			email = strings.ToLower(strings.TrimSpace(email))
+			if email != "" && !seen["email:"+email] {
+				seen["email:"+email] = true
+				e.Emails = append(e.Emails, email)
+			}
+		}
+		sort.Strings(e.Roles)
+		sort.Strings(e.Emails)
+		d.Eligibility = nil
+		if len(e.Roles) > 0 || len(e.Emails) > 0 {
+			d.Eligibility = &e
+		}
+	}
+	return d
+}
+
+// validate returns every problem with a normalized definition.
+func (d PollDefinition) validate() []string {
+	var problems []string
+	if !definitionKey.MatchString(d.Key) {
+		problems = append(problems, "key is required and may only contain letters, digits, '.', '_', '-' and '/'")
+	}
+	if d.Title == "" {
+		problems = append(problems, "title is required")
+	}
+	if !questionTypes[d.Type] {
+		problems = append(problems, fmt.Sprintf("unknown type %q", d.Type))
+	}
+	if (d.Type == "single_choice" || d.Type == "multiple_choice") && len(d.Options) == 0 {
+		problems = append(problems, "choice polls need at least one option")
+	}
+	for _, o := range d.Options {
+		if o.LinkURL != "" {
+			u, err := url.Parse(o.LinkURL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				problems = append(problems, fmt.Sprintf("link for option %q must be an http or https URL", o.Text))
+			}
+		}
+		// Exports carry the paths of uploaded images, so those are allowed
+		// besides http and https URLs.
+		if o.ImageURL != "" {
+			u, err := url.Parse(o.ImageURL)
+			uploaded := err == nil && u.Scheme == "" && u.Host == "" && strings.HasPrefix(u.Path, "/")
+			if !uploaded && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+				problems = append(problems, fmt.Sprintf("image for option %q must be an http or https URL or an uploaded image", o.Text))
+			}
+		}
+	}
+	if d.Start.IsZero() {
+		problems = append(problems, "start is required")
+	}
+	if d.End != nil && !d.End.After(d.Start) {
+		problems = append(problems, "end must be after start")
+	}
+	if d.Eligibility != nil {
+		for _, role := range d.Eligibility.Roles {
+			if !eligibleRoles[role] {
+				problems = append(problems, fmt.Sprintf("unknown role %q", role))
+			}
+		}
+		for _, email := range d.Eligibility.Emails {
+			if _, err := mail.ParseAddress(email); err != nil {
+				problems = append(problems, fmt.Sprintf("invalid email %q", email))
+			}
+		}
+	}
+	return problems
+}
+
+func (d PollDefinition) pollOptions() []models.Option {
+	options := make([]models.Option, len(d.Options))
+	for i, o := range d.Options {
+		options[i] = models.Option{Text: o.Text, Description: o.Description, ImageURL: o.ImageURL, LinkURL: o.LinkURL}
+	}
+	return options
+}
+
+// ImportPolls creates or updates one poll per definition, matched by key.
+// All definitions are validated first and nothing is written unless every
+// one is valid, in which case ErrInvalidDefinition is returned along with the
+// per-definition results. The writes then happen in one transaction, so an
+// import either applies in full or not at all and can simply be retried.
+// With dryRun set only the validation runs. Polls
+// with votes keep their type and options, since changing them would orphan
+// the votes. Only admins may import.
+func (s *PollService) ImportPolls(ctx context.Context, userID string, defs []PollDefinition, dryRun bool) ([]ImportResult, error) {
+	ctx, span := startSpan(ctx, "PollService.ImportPolls")
+	defer span.End()
+
+	role, err := s.AuthService.GetUserRole(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if role != "admin" {
+		return nil, sql.ErrNoRows // Unauthorized
+	}
+
+	results := make([]ImportResult, len(defs))
+	existing := make([]*storedDefinition, len(defs))
+	keys := make(map[string]int)
+	invalid := false
+	for i := range defs {
+		defs[i] = defs[i].normalize()
+		def := defs[i]
+		result := ImportResult{Index: i, Key: def.Key, Errors: def.validate()}
+
+		if first, dup := keys[def.Key]; dup && def.Key != "" {
+			result.Errors = append(result.Errors, fmt.Sprintf("key is already used by definition %d", first))
+		} else {
+			keys[def.Key] = i
+		}
+
+		if len(result.Errors) == 0 {
+			current, votes, err := s.definitionByKey(ctx, def.Key)
+			switch {
+			case err == sql.ErrNoRows:
+				result.Status = ImportCreated
+			case err != nil:
+				return nil, err
+			default:
+				existing[i] = current
+				result.PollID = current.pollID
+				if reflect.DeepEqual(def, current.PollDefinition) {
+					result.Status = ImportUnchanged
+				} else {
+					result.Status = ImportUpdated
+				}
+				if votes > 0 && (def.Type != current.Type || !reflect.DeepEqual(def.Options, current.Options)) {
+					result.Errors = append(result.Errors, "the poll already has votes, so its type and options can no longer change")
+				}
+			}
+		}
+
+		if len(result.Errors) > 0 {
+			result.Status = ImportInvalid
+			invalid = true
+		}
+		results[i] = result
+	}
+	if invalid {
+		return results, ErrInvalidDefinition
+	}
+	if dryRun {
+		return results, nil
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for i, def := range defs {
+		switch results[i].Status {
+		case ImportCreated:
+			poll, err := s.createPoll(ctx, tx, def.Title, def.Type, def.pollOptions(), def.OptionOrder, def.Anonymous, userID, def.Start, def.End, def.Key)
+			if err != nil {
+				return nil, err
+			}
+			results[i].PollID = poll.ID
+			if err := setEligibility(ctx, tx, poll.ID, def.Eligibility); err != nil {
+				return nil, err
+			}
+		case ImportUpdated:
+			if err := applyDefinition(ctx, tx, existing[i], def); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// storedDefinition is a poll's current state in definition form.
+type storedDefinition struct {
+	PollDefinition
+	pollID int64
+}
+
+func (s *PollService) definitionByKey(ctx context.Context, key string) (*storedDefinition, int, error) {
+	var pollID int64
+	var votes int
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT p.id, (SELECT COUNT(*) FROM votes v WHERE v.poll_id = p.id)
+		FROM polls p WHERE p.external_key = $1
+	`, key).Scan(&pollID, &votes)
+	if err != nil {
+		return nil, 0, err
+	}
+	def, err := s.definition(ctx, pollID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &storedDefinition{PollDefinition: *def, pollID: pollID}, votes, nil
+}
+
+// applyDefinition updates a poll in place. Options are only replaced when
+// they changed, so an update that leaves them alone keeps their IDs.
+func applyDefinition(ctx context.Context, tx *sql.Tx, current *storedDefinition, def PollDefinition) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE polls SET title = $1, question_type = $2, start_date = $3, end_date = $4, is_anonymous = $5, option_order = $6
+		WHERE id = $7
+	`, def.Title, def.Type, def.Start, def.End, def.Anonymous, def.OptionOrder, current.pollID)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(def.Options, current.Options) {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM options WHERE poll_id = $1`, current.pollID); err != nil {
+			return err
+		}
+		if err := insertOptions(ctx, tx, current.pollID, def.pollOptions()); err != nil {
+			return err
+		}
+	}
+	return setEligibility(ctx, tx, current.pollID, def.Eligibility)
+}
+
+func setEligibility(ctx context.Context, db execer, pollID int64, e *Eligibility) error {
+	if _, err := db.ExecContext(ctx, `DELETE FROM poll_eligibility WHERE poll_id = $1`, pollID); err != nil {
+		return err
+	}
+	if e == nil {
+		return nil
+	}
+	for kind, values := range map[string][]string{"role": e.Roles, "email": e.Emails} {
+		for _, value := range values {
+			_, err := db.ExecContext(ctx, `INSERT INTO poll_eligibility (poll_id, kind, value) VALUES ($1, $2, $3)`, pollID, kind, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ExportPoll returns a poll its creator or an admin may see as a definition.
+// Polls that were not imported have no key; one must be set before the
+// definition can be imported.
+func (s *PollService) ExportPoll(ctx context.Context, pollID int64, userID string) (*PollDefinition, error) {
+	ctx, span := startPollSpan(ctx, "PollService.ExportPoll", pollID)
+	defer span.End()
+
+	if _, err := s.authorizePollOwner(ctx, pollID, userID); err != nil {
+		return nil, err
+	}
+	return s.definition(ctx, pollID)
+}
+
+func (s *PollService) definition(ctx context.Context, pollID int64) (*PollDefinition, error) {
+	poll, err := s.GetPoll(ctx, pollID)
+	if err != nil {
+		return nil, err
+	}
+	var key sql.NullString
+	if err := s.DB.QueryRowContext(ctx, `SELECT external_key FROM polls WHERE id = $1`, pollID).Scan(&key); err != nil {
+		return nil, err
+	}
+	options, err := s.GetPollOptions(ctx, pollID)
+	if err != nil {
+		return nil, err
+	}
+	eligibility, err := s.GetEligibility(ctx, pollID)
+	if err != nil {
+		return nil, err
+	}
+
+	def := PollDefinition{
+		Key:         key.String,
+		Title:       poll.Title,
+		Type:        poll.QuestionType,
+		OptionOrder: poll.OptionOrder,
+		Start:       poll.StartDate,
+		End:         poll.EndDate,
+		Anonymous:   poll.IsAnonymous,
+		Eligibility: eligibility,
+	}
+	for _, o := range options {
+		def.Options = append(def.Options, OptionDefinition{Text: o.Text, Description: o.Description, ImageURL: o.ImageURL, LinkURL: o.LinkURL})
+	}
+	def = def.normalize()
+	return &def, nil
+}
+
+// GetEligibility returns a poll's voter restrictions, or nil if anyone may
+// vote.
+func (s *PollService) GetEligibility(ctx context.Context, pollID int64) (*Eligibility, error) {
+	ctx, span := startPollSpan(ctx, "PollService.GetEligibility", pollID)
+	defer span.End()
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT kind, value FROM poll_eligibility WHERE poll_id = $1 ORDER BY kind, value`, pollID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var e *Eligibility
+	for rows.Next() {
+		var kind, value string
+		if err := rows.Scan(&kind, &value); err != nil {
+			return nil, err
+		}
+		if e == nil {
+			e = &Eligibility{}
+		}
+		switch kind {
+		case "role":
+			e.Roles = append(e.Roles, value)
+		case "email":
+			e.Emails = append(e.Emails, value)
+		}
+	}
+	return e, rows.Err()
+}
+
+// eligibleVoter is an SQL condition on users u that holds when u may vote
+// on the poll whose ID is $1.
+const eligibleVoter = `(
+	NOT EXISTS (SELECT 1 FROM poll_eligibility WHERE poll_id = $1)
+	OR EXISTS (
+		SELECT 1 FROM poll_eligibility e
+		WHERE e.poll_id = $1
+		AND ((e.kind = 'role' AND e.value = u.role) OR (e.kind = 'email' AND e.value = LOWER(u.email)))
+	)
+)`
+
+// IsEligible reports whether a user may vote in a poll under its
+// eligibility rules.
+func (s *PollService) IsEligible(ctx context.Context, pollID int64, userID string) (bool, error) {
+	ctx, span := startPollSpan(ctx, "PollService.IsEligible", pollID)
+	defer span.End()
+
+	var eligible bool
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT NOT EXISTS (SELECT 1 FROM poll_eligibility WHERE poll_id = $1)
+			OR EXISTS (
+				SELECT 1 FROM poll_eligibility e
+				JOIN users u ON u.id = $2
+				WHERE e.poll_id = $1
+				AND ((e.kind = 'role' AND e.value = u.role) OR (e.kind = 'email' AND e.value = LOWER(u.email)))
+			)
+	`, pollID, userID).Scan(&eligible)
+	return eligible, err
+}
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"fakidoosuurdoris/app/Internal/models"
+)
+
+const (
+	DefaultPollPageSize = 20
+	MaxPollPageSize     = 100
+)
+
+var ErrInvalidPollQuery = errors.New("invalid poll query")
+
+// PollQuery describes a filtered, sorted page of polls. Zero values mean "no
+// filter". After is the opaque cursor returned as PollPage.NextCursor.
+type PollQuery struct {
+	Search    string
+	Status    string // upcoming, active, closed
+	Type      string
+	CreatorID string
+	Anonymous *bool
+	From      *time.Time // start_date lower bound, inclusive
+	To        *time.Time // start_date upper bound, exclusive
+	Voted     *bool      // requires ViewerID
+	ViewerID  string
+	Sort      string // created_at (default), start_date, end_date, title, closing_soon
+	Desc      bool
+	Limit     int
+	After     string
+}
+
+// ClosingSoonWindow is how close to its end date an active poll has to be to
+// count as closing soon.
+const ClosingSoonWindow = 24 * time.Hour
+
+// PollListItem is a poll as shown in listings, with participation details
+// for the viewer who ran the query.
+type PollListItem struct {
+	models.Poll
+	CreatorName string `json:"creator_name"`
+	VoteCount   int    `json:"vote_count"`
+	Voted       bool   `json:"voted"`
+}
+
+func (p PollListItem) Status() string {
+	now := time.Now()
+	switch {
+	case p.StartDate.After(now):
+		return "upcoming"
+	case p.EndDate != nil && p.EndDate.Before(now):
+		return "closed"
+	default:
+		return "active"
+	}
+}
+
+// TimeRemaining is how long an active poll stays open, or 0 for polls that
+// are not active or have no end date.
+func (p PollListItem) TimeRemaining() time.Duration {
+	if p.EndDate == nil || p.Status() != "active" {
+		return 0
+	}
+	return time.Until(*p.EndDate)
+}
+
+func (p PollListItem) ClosingSoon() bool {
+	remaining := p.TimeRemaining()
+	return remaining > 0 && remaining <= ClosingSoonWindow
+}
+
+// TimeRemainingLabel renders TimeRemaining as "2d 4h", "3h 20m" or "15m".
+func (p PollListItem) TimeRemainingLabel() string {
+	remaining := p.TimeRemaining()
+	if remaining <= 0 {
+		return ""
+	}
+	days := int(remaining.Hours()) / 24
+	hours := int(remaining.Hours()) % 24
+	minutes := int(remaining.Minutes()) % 60
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	default:
+		return fmt.Sprintf("%dm", minutes)
+	}
+}
+
+type PollPage struct {
+	Polls      []PollListItem `json:"polls"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+type pollCursor struct {
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+// pollSortColumns maps a sort key to its SQL expression. end_date is coalesced
+// so that polls without an end sort last in ascending order. closing_soon is
+// end_date ascending restricted to polls that have not closed yet.
+var pollSortColumns = map[string]string{
+	"created_at":   "p.created_at",
+	"start_date":   "p.start_date",
+	"end_date":     "COALESCE(p.end_date, 'infinity'::timestamp)",
+	"closing_soon": "COALESCE(p.end_date, 'infinity'::timestamp)",
+	"title":        "p.title",
+}
+
+// ListPolls returns one page of polls matching q using keyset pagination on
+// the sort column and poll ID.
+func (s *PollService) ListPolls(ctx context.Context, q PollQuery) (*PollPage, error) {
+	ctx, span := startSpan(ctx, "PollService.ListPolls")
+	defer span.End()
+
+	if q.Sort == "" {
+		q.Sort = "created_at"
+	}
+	sortExpr, ok := pollSortColumns[q.Sort]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown sort %q", ErrInvalidPollQuery, q.Sort)
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultPollPageSize
+	}
+	if q.Limit > MaxPollPageSize {
+		q.Limit = MaxPollPageSize
+	}
+
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	// The viewer is always the first argument so the select list can refer to it.
+	viewer := arg(q.ViewerID)
+
+	if search := strings.TrimSpace(q.Search); search != "" {
+		where = append(where, fmt.Sprintf("to_tsvector('simple', p.title) @@ plainto_tsquery('simple', %s)", arg(search)))
+	}
+
+	now := time.Now()
+	switch q.Status {
+	case "":
+	case "upcoming":
+		where = append(where, "p.start_date > "+arg(now))
+	case "active":
+		n := arg(now)
+		where = append(where, fmt.Sprintf("p.start_date <= %s AND (p.end_date IS NULL OR p.end_date >= %s)", n, n))
+	case "closed":
+		where = append(where, "p.end_date < "+arg(now))
+	default:
+		return nil, fmt.Errorf("%w: unknown status %q", ErrInvalidPollQuery, q.Status)
+	}
+
+	if q.Type != "" {
+		where = append(where, "p.question_type = "+arg(q.Type))
+	}
+	if q.CreatorID != "" {
+		where = append(where, "p.user_id = "+arg(q.CreatorID))
+	}
+	if q.Anonymous != nil {
+		where = append(where, "p.is_anonymous = "+arg(*q.Anonymous))
+	}
+	if q.From != nil {
+		where = append(where, "p.start_date >= "+arg(*q.From))
+	}
+	if q.To != nil {
+		where = append(where, "p.start_date < "+arg(*q.To))
+	}
+	votedExpr := fmt.Sprintf("EXISTS (SELECT 1 FROM votes v WHERE v.poll_id = p.id AND (v.user_id = %[1]s OR (v.user_id IS NULL AND v.voted_by = %[1]s)))", viewer)
+	if q.Voted != nil {
+		if q.ViewerID == "" {
+			return nil, fmt.Errorf("%w: voted filter requires a viewer", ErrInvalidPollQuery)
+		}
+		if *q.Voted {
+			where = append(where, votedExpr)
+		} else {
+			where = append(where, "NOT "+votedExpr)
+		}
+	}
+
+	if q.Sort == "closing_soon" {
+		q.Desc = false
+		where = append(where, "(p.end_date IS NULL OR p.end_date >= "+arg(now)+")")
+	}
+
+	dir, cmp := "ASC", ">"
+	if q.Desc {
+		dir, cmp = "DESC", "<"
+	}
+
+	if q.After != "" {
+		cursor, err := decodePollCursor(q.After)
+		if err != nil {
+			return nil, err
+		}
+		value := arg(cursor.Value)
+		if q.Sort != "title" {
+			if _, err := time.Parse(time.RFC3339Nano, cursor.Value); err != nil && cursor.Value != "infinity" {
+				return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidPollQuery)
+			}
+			value += "::timestamp"
+		}
+		where = append(where, fmt.Sprintf("(%s, p.id) %s (%s, %s)", sortExpr, cmp, value, arg(cursor.ID)))
+	}
+
+	query := `
+		SELECT p.id, p.title, p.user_id, p.question_type, p.start_date, p.end_date, p.is_anonymous, p.created_at,
+			COALESCE(NULLIF(TRIM(COALESCE(u.firstname, '') || ' ' || COALESCE(u.lastname, '')), ''), u.email, ''),
+			COALESCE(vc.voters, 0),
+			` + votedExpr + `
+		FROM polls p
+		LEFT JOIN users u ON u.id = p.user_id
+		LEFT JOIN LATERAL (SELECT COUNT(DISTINCT COALESCE(v.voted_by, v.guest_key)) AS voters FROM votes v WHERE v.poll_id = p.id) vc ON TRUE`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s, p.id %s LIMIT %s", sortExpr, dir, dir, arg(q.Limit+1))
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	page := &PollPage{Polls: []PollListItem{}}
+	for rows.Next() {
+		var item PollListItem
+		var endDate sql.NullTime
+		if err := rows.Scan(&item.ID, &item.Title, &item.UserID, &item.QuestionType, &item.StartDate, &endDate, &item.IsAnonymous, &item.CreatedAt,
+			&item.CreatorName, &item.VoteCount, &item.Voted); err != nil {
+			return nil, err
+		}
+		if endDate.Valid {
+			item.EndDate = &endDate.Time
+		}
+		page.Polls = append(page.Polls, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(page.Polls) > q.Limit {
+		page.Polls = page.Polls[:q.Limit]
+		page.NextCursor = encodePollCursor(q.Sort, page.Polls[q.Limit-1].Poll)
+	}
+	return page, nil
+}
+
+func encodePollCursor(sort string, poll models.Poll) string {
+	var value string
+	switch sort {
+	case "title":
+		value = poll.Title
+	case "start_date":
+		value = poll.StartDate.Format(time.RFC3339Nano)
+	case "end_date", "closing_soon":
+		if poll.EndDate != nil {
+			value = poll.EndDate.Format(time.RFC3339Nano)
+		} else {
+			value = "infinity"
+		}
+	default:
+		value = poll.CreatedAt.Format(time.RFC3339Nano)
+	}
+	b, _ := json.Marshal(pollCursor{Value: value, ID: poll.ID})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodePollCursor(s string) (pollCursor, error) {
+	var cursor pollCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, fmt.Errorf("%w: malformed cursor", ErrInvalidPollQuery)
+	}
+	if err := json.Unmarshal(b, &cursor); err != nil {
+		return cursor, fmt.Errorf("%w: malformed cursor", ErrInvalidPollQuery)
+	}
+	return cursor, nil
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"fakidoosuurdoris/app/Internal/models"
+)
+
+func TestPollCursorRoundTrip(t *testing.T) {
+	start := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 3, 8, 17, 30, 0, 123456789, time.UTC)
+	created := time.Date(2026, 2, 27, 12, 0, 0, 500, time.UTC)
+	open := models.Poll{ID: 41, Title: "Lunch, or \"brunch\"?", StartDate: start, CreatedAt: created}
+	closing := open
+	closing.ID = 42
+	closing.EndDate = &end
+
+	tests := []struct {
+		sort string
+		poll models.Poll
+		want pollCursor
+	}{
+		{"", open, pollCursor{Value: "2026-02-27T12:00:00.0000005Z", ID: 41}},
+		{"created_at", open, pollCursor{Value: "2026-02-27T12:00:00.0000005Z", ID: 41}},
+		{"title", open, pollCursor{Value: "Lunch, or \"brunch\"?", ID: 41}},
+		{"start_date", open, pollCursor{Value: "2026-03-01T09:00:00Z", ID: 41}},
+		{"end_date", closing, pollCursor{Value: "2026-03-08T17:30:00.123456789Z", ID: 42}},
+		{"closing_soon", closing, pollCursor{Value: "2026-03-08T17:30:00.123456789Z", ID: 42}},
+		{"end_date", open, pollCursor{Value: "infinity", ID: 41}},
+	}
+	for _, tt := range tests {
+		encoded := encodePollCursor(tt.sort, tt.poll)
+		got, err := decodePollCursor(encoded)
+		if err != nil {
+			t.Errorf("decodePollCursor(encodePollCursor(%q, poll %d)) failed: %v", tt.sort, tt.poll.ID, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("cursor for %q, poll %d = %+v, want %+v", tt.sort, tt.poll.ID, got, tt.want)
+		}
+	}
+}
+
+func TestDecodePollCursorRejectsMalformed(t *testing.T) {
+	for _, cursor := range []string{
+		"not base64!",
+		"bm90IGpzb24",              // "not json"
+		"eyJ2IjoxLCJpZCI6",         // truncated JSON
+		"eyJ2IjoiYSIsImlkIjoiMSJ9", // {"v":"a","id":"1"}
+	} {
+		if _, err := decodePollCursor(cursor); !errors.Is(err, ErrInvalidPollQuery) {
+			t.Errorf("decodePollCursor(%q) error = %v, want ErrInvalidPollQuery", cursor, err)
+		}
+	}
+}
@@ -2,46 +2,119 @@ package services
 
 import (
 	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
 
-	"log"
+	"log/slog"
 	"time"
 
 	"database/sql"
 
+	"fakidoosuurdoris/app/Internal/metrics"
 	"fakidoosuurdoris/app/Internal/models"
+
+	"github.com/lib/pq"
 )
 
 type PollService struct {
 	DB          *sql.DB
 	AuthService *AuthService
+	Webhooks    *WebhookService
+	// Metrics is optional; when set, votes cast and refused are counted.
+	Metrics metrics.Recorder
+}
+
+func NewPollService(db *sql.DB, authService *AuthService, webhooks *WebhookService, recorder metrics.Recorder) *PollService {
+	return &PollService{DB: db, AuthService: authService, Webhooks: webhooks, Metrics: recorder}
 }
 
-func NewPollService(db *sql.DB, authService *AuthService) *PollService {
-	return &PollService{DB: db, AuthService: authService}
+func (s *PollService) metrics() metrics.Recorder {
+	if s.Metrics == nil {
+		return metrics.Nop{}
+	}
+	return s.Metrics
 }
 
-func (s *PollService) CreatePoll(ctx context.Context, title, questionType string, options []string, isAnonymous bool, userID string, startDate time.Time, endDate *time.Time) (*models.Poll, error) {
+// rejectVote counts a refused vote and returns err.
+func (s *PollService) rejectVote(reason string, err error) error {
+	s.metrics().VoteRejected(reason)
+	return err
+}
+
+// CountActivePolls returns the number of polls open for voting.
+func (s *PollService) CountActivePolls(ctx context.Context) (int, error) {
+	var n int
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM polls WHERE start_date <= $1 AND (end_date IS NULL OR end_date > $1)
+	`, time.Now()).Scan(&n)
+	return n, err
+}
+
+const (
+	OptionOrderFixed  = "fixed"
+	OptionOrderRandom = "random"
+)
+
+// CreatePoll stores a poll with its options and queues its poll.created
+// event, all in one transaction.
+func (s *PollService) CreatePoll(ctx context.Context, title, questionType string, options []models.Option, optionOrder string, isAnonymous bool, userID string, startDate time.Time, endDate *time.Time) (*models.Poll, error) {
+	ctx, span := startSpan(ctx, "PollService.CreatePoll")
+	defer span.End()
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	poll, err := s.createPoll(ctx, tx, title, questionType, options, optionOrder, isAnonymous, userID, startDate, endDate, "")
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return poll, nil
+}
+
+// createPoll does the work of CreatePoll with q, so callers can create polls
+// as part of a larger transaction. externalKey is the key of an imported
+// poll and empty otherwise.
+func (s *PollService) createPoll(ctx context.Context, q querier, title, questionType string, options []models.Option, optionOrder string, isAnonymous bool, userID string, startDate time.Time, endDate *time.Time, externalKey string) (*models.Poll, error) {
+	if optionOrder != OptionOrderRandom {
+		optionOrder = OptionOrderFixed
+	}
+
 	var pollID int64
 	var createdAt time.Time
-	query := `INSERT INTO polls (title, user_id, question_type, start_date, end_date, is_anonymous, created_at)
-          VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, created_at`
-	err := s.DB.QueryRowContext(ctx, query, title, userID, questionType, startDate, endDate, isAnonymous, time.Now()).Scan(&pollID, &createdAt)
+	query := `INSERT INTO polls (title, user_id, question_type, start_date, end_date, is_anonymous, option_order, created_at, external_key)
+          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, '')) RETURNING id, created_at`
+	err := q.QueryRowContext(ctx, query, title, userID, questionType, startDate, endDate, isAnonymous, optionOrder, time.Now(), externalKey).Scan(&pollID, &createdAt)
 	if err != nil {
 		return nil, err
 	}
 
 	if questionType != "text" {
-		for _, opt := range options {
-			if opt != "" {
-				query := `INSERT INTO options (poll_id, option_text) VALUES ($1, $2)`
-				_, err = s.DB.ExecContext(ctx, query, pollID, opt)
-				if err != nil {
-					return nil, err
-				}
-			}
+		if err := insertOptions(ctx, q, pollID, options); err != nil {
+			return nil, err
 		}
 	}
 
+	// A failed insert aborts the transaction, so the event cannot be skipped.
+	err = s.Webhooks.Emit(ctx, q, EventPollCreated, &pollID, PollEventData{
+		ID:           pollID,
+		Title:        title,
+		QuestionType: questionType,
+		CreatorID:    userID,
+		StartDate:    startDate,
+		EndDate:      endDate,
+		IsAnonymous:  isAnonymous,
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	return &models.Poll{
 		ID:           pollID,
 		Title:        title,
@@ -50,16 +123,57 @@ func (s *PollService) CreatePoll(ctx context.Context, title, questionType string
 		StartDate:    startDate,
 		EndDate:      endDate,
 		IsAnonymous:  isAnonymous,
+		OptionOrder:  optionOrder,
 		CreatedAt:    createdAt,
 	}, nil
 }
 
+// insertOptions stores the non-empty options in the given order; their
+// position is their index in the slice.
+func insertOptions(ctx context.Context, db execer, pollID int64, options []models.Option) error {
+	position := 0
+	for _, opt := range options {
+		if opt.Text == "" {
+			continue
+		}
+		query := `INSERT INTO options (poll_id, option_text, description, image_url, link_url, position) VALUES ($1, $2, $3, $4, $5, $6)`
+		if _, err := db.ExecContext(ctx, query, pollID, opt.Text, opt.Description, opt.ImageURL, opt.LinkURL, position); err != nil {
+			return err
+		}
+		position++
+	}
+	return nil
+}
+
+// authorizePollOwner loads a poll for a user who is about to manage it,
+// which only its creator and admins may do. Anyone else gets sql.ErrNoRows,
+// as though the poll did not exist.
+func (s *PollService) authorizePollOwner(ctx context.Context, pollID int64, userID string) (*models.Poll, error) {
+	poll, err := s.GetPoll(ctx, pollID)
+	if err != nil {
+		return nil, err
+	}
+	if poll.UserID != userID {
+		role, err := s.AuthService.GetUserRole(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if role != "admin" {
+			return nil, sql.ErrNoRows // Unauthorized
+		}
+	}
+	return poll, nil
+}
+
 func (s *PollService) GetPoll(ctx context.Context, id int64) (*models.Poll, error) {
-	query := `SELECT id, title, user_id, question_type, start_date, end_date, is_anonymous, created_at FROM polls WHERE id = $1`
+	ctx, span := startPollSpan(ctx, "PollService.GetPoll", id)
+	defer span.End()
+
+	query := `SELECT id, title, user_id, question_type, start_date, end_date, is_anonymous, option_order, created_at FROM polls WHERE id = $1`
 	var poll models.Poll
 	var endDate sql.NullTime
 	err := s.DB.QueryRowContext(ctx, query, id).Scan(
-		&poll.ID, &poll.Title, &poll.UserID, &poll.QuestionType, &poll.StartDate, &endDate, &poll.IsAnonymous, &poll.CreatedAt,
+		&poll.ID, &poll.Title, &poll.UserID, &poll.QuestionType, &poll.StartDate, &endDate, &poll.IsAnonymous, &poll.OptionOrder, &poll.CreatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -70,7 +184,10 @@ func (s *PollService) GetPoll(ctx context.Context, id int64) (*models.Poll, erro
 	return &poll, nil
 }
 
-func (s *PollService) UpdatePoll(ctx context.Context, id int64, title, questionType string, options []string, startDate time.Time, endDate *time.Time, isAnonymous bool, userID string) error {
+func (s *PollService) UpdatePoll(ctx context.Context, id int64, title, questionType string, options []models.Option, optionOrder string, startDate time.Time, endDate *time.Time, isAnonymous bool, userID string) error {
+	ctx, span := startPollSpan(ctx, "PollService.UpdatePoll", id)
+	defer span.End()
+
 	query := `SELECT user_id FROM polls WHERE id = $1`
 	var creatorID string
 	if err := s.DB.QueryRowContext(ctx, query, id).Scan(&creatorID); err != nil {
@@ -79,37 +196,39 @@ func (s *PollService) UpdatePoll(ctx context.Context, id int64, title, questionT
 	if creatorID != userID {
 		return sql.ErrNoRows
 	}
+	if optionOrder != OptionOrderRandom {
+		optionOrder = OptionOrderFixed
+	}
 
-	query = `UPDATE polls SET title = $1, question_type = $2, start_date = $3, end_date = $4, is_anonymous = $5 WHERE id = $6`
-	_, err := s.DB.ExecContext(ctx, query, title, questionType, startDate, endDate, isAnonymous, id)
+	// The options are replaced in one transaction, so a failed insert
+	// leaves the poll as it was.
+	tx, err := s.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
+	query = `UPDATE polls SET title = $1, question_type = $2, start_date = $3, end_date = $4, is_anonymous = $5, option_order = $6 WHERE id = $7`
+	_, err = tx.ExecContext(ctx, query, title, questionType, startDate, endDate, isAnonymous, optionOrder, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM options WHERE poll_id = $1`, id)
+	if err != nil {
+		return err
+	}
 	if questionType != "text" {
-		_, err = s.DB.ExecContext(ctx, `DELETE FROM options WHERE poll_id = $1`, id)
-		if err != nil {
-			return err
-		}
-		for _, opt := range options {
-			if opt != "" {
-				query = `INSERT INTO options (poll_id, option_text) VALUES ($1, $2)`
-				_, err = s.DB.ExecContext(ctx, query, id, opt)
-				if err != nil {
-					return err
-				}
-			}
-		}
-	} else {
-		_, err = s.DB.ExecContext(ctx, `DELETE FROM options WHERE poll_id = $1`, id)
-		if err != nil {
+		if err := insertOptions(ctx, tx, id, options); err != nil {
 			return err
 		}
 	}
-	return nil
+	return tx.Commit()
 }
 
 func (s *PollService) DeletePoll(ctx context.Context, id int64, userID string) error {
+	ctx, span := startPollSpan(ctx, "PollService.DeletePoll", id)
+	defer span.End()
 
 	query := `SELECT user_id FROM polls WHERE id = $1`
 	var creatorID string
@@ -161,7 +280,10 @@ func (s *PollService) DeletePoll(ctx context.Context, id int64, userID string) e
 }
 
 func (s *PollService) GetPollOptions(ctx context.Context, pollID int64) ([]models.Option, error) {
-	query := `SELECT id, poll_id, option_text FROM options WHERE poll_id = $1 ORDER BY id`
+	ctx, span := startPollSpan(ctx, "PollService.GetPollOptions", pollID)
+	defer span.End()
+
+	query := `SELECT id, poll_id, option_text, description, image_url, link_url, position FROM options WHERE poll_id = $1 ORDER BY position, id`
 	rows, err := s.DB.QueryContext(ctx, query, pollID)
 	if err != nil {
 		return nil, err
@@ -171,7 +293,7 @@ func (s *PollService) GetPollOptions(ctx context.Context, pollID int64) ([]model
 	var options []models.Option
 	for rows.Next() {
 		var option models.Option
-		if err := rows.Scan(&option.ID, &option.PollID, &option.Text); err != nil {
+		if err := rows.Scan(&option.ID, &option.PollID, &option.Text, &option.Description, &option.ImageURL, &option.LinkURL, &option.Position); err != nil {
 			return nil, err
 		}
 		options = append(options, option)
@@ -179,52 +301,60 @@ func (s *PollService) GetPollOptions(ctx context.Context, pollID int64) ([]model
 	return options, nil
 }
 
-func (s *PollService) GetUserPolls(ctx context.Context, userID string) ([]models.Poll, error) {
-	query := `SELECT id, title, user_id, question_type, start_date, end_date, is_anonymous, created_at FROM polls WHERE user_id = $1 ORDER BY created_at DESC`
-	rows, err := s.DB.QueryContext(ctx, query, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
+// GetOptionsForPolls loads the options of several polls in one query, keyed
+// by poll ID.
+func (s *PollService) GetOptionsForPolls(ctx context.Context, pollIDs []int64) (map[int64][]models.Option, error) {
+	ctx, span := startSpan(ctx, "PollService.GetOptionsForPolls")
+	defer span.End()
 
-	var polls []models.Poll
-	for rows.Next() {
-		var poll models.Poll
-		var endDate sql.NullTime
-		if err := rows.Scan(&poll.ID, &poll.Title, &poll.UserID, &poll.QuestionType, &poll.StartDate, &endDate, &poll.IsAnonymous, &poll.CreatedAt); err != nil {
-			return nil, err
-		}
-		if endDate.Valid {
-			poll.EndDate = &endDate.Time
-		}
-		polls = append(polls, poll)
+	options := make(map[int64][]models.Option, len(pollIDs))
+	if len(pollIDs) == 0 {
+		return options, nil
 	}
-	return polls, nil
-}
-
-func (s *PollService) GetAllPolls(ctx context.Context) ([]models.Poll, error) {
-	rows, err := s.DB.QueryContext(ctx, "SELECT id, title, question_type, is_anonymous, user_id, start_date, end_date FROM polls")
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, poll_id, option_text, description, image_url, link_url, position
+		FROM options WHERE poll_id = ANY($1)
+		ORDER BY poll_id, position, id
+	`, pq.Array(pollIDs))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var polls []models.Poll
 	for rows.Next() {
-		var poll models.Poll
-		var endDate sql.NullTime
-		if err := rows.Scan(&poll.ID, &poll.Title, &poll.QuestionType, &poll.IsAnonymous, &poll.UserID, &poll.StartDate, &endDate); err != nil {
+		var option models.Option
+		if err := rows.Scan(&option.ID, &option.PollID, &option.Text, &option.Description, &option.ImageURL, &option.LinkURL, &option.Position); err != nil {
 			return nil, err
 		}
-		if endDate.Valid {
-			poll.EndDate = &endDate.Time
-		}
-		polls = append(polls, poll)
+		options[option.PollID] = append(options[option.PollID], option)
 	}
-	return polls, nil
+	return options, rows.Err()
+}
+
+// OrderOptionsForVoter returns the options in the order a voter should see
+// them. For polls with random option order the shuffle is seeded by the poll
+// and voter, so each voter gets a stable order across page loads while
+// position bias is spread evenly over all voters.
+func OrderOptionsForVoter(poll *models.Poll, options []models.Option, voterID string) []models.Option {
+	if poll.OptionOrder != OptionOrderRandom || len(options) < 2 {
+		return options
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d:%s", poll.ID, voterID)
+	rng := rand.New(rand.NewSource(int64(h.Sum64())))
+
+	shuffled := make([]models.Option, len(options))
+	copy(shuffled, options)
+	rng.Shuffle(len(shuffled), func(i, j int) {
+		shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+	})
+	return shuffled
 }
 
 func (s *PollService) HasVoted(ctx context.Context, pollID int64, userID string) (bool, error) {
+	ctx, span := startPollSpan(ctx, "PollService.HasVoted", pollID)
+	defer span.End()
+
 	var count int
 	if userID == "" {
 		return false, nil
@@ -241,7 +371,16 @@ func (s *PollService) HasVoted(ctx context.Context, pollID int64, userID string)
 	return count > 0, nil
 }
 
+// RecordVote records a signed-in user's vote. Guests vote through
+// RecordGuestVote, which has its own guard against repeat votes, so an empty
+// userID is rejected.
 func (s *PollService) RecordVote(ctx context.Context, pollID int64, userID string, optionIDs []int64, textAnswer string) error {
+	ctx, span := startPollSpan(ctx, "PollService.RecordVote", pollID)
+	defer span.End()
+
+	if userID == "" {
+		return sql.ErrNoRows
+	}
 	poll, err := s.GetPoll(ctx, pollID)
 	if err != nil {
 		return err
@@ -249,63 +388,99 @@ func (s *PollService) RecordVote(ctx context.Context, pollID int64, userID strin
 
 	now := time.Now()
 	if poll.StartDate.After(now) || (poll.EndDate != nil && poll.EndDate.Before(now)) {
-		return sql.ErrNoRows
+		return s.rejectVote(metrics.RejectClosed, sql.ErrNoRows)
 	}
 
-	if userID != "" {
-		hasVoted, err := s.HasVoted(ctx, pollID, userID)
-		if err != nil {
-			return err
-		}
-		if hasVoted {
-			return sql.ErrNoRows
+	eligible, err := s.IsEligible(ctx, pollID, userID)
+	if err != nil {
+		return err
+	}
+	if !eligible {
+		return s.rejectVote(metrics.RejectNotEligible, sql.ErrNoRows)
+	}
+
+	hasVoted, err := s.HasVoted(ctx, pollID, userID)
+	if err != nil {
+		return err
+	}
+	if hasVoted {
+		return s.rejectVote(metrics.RejectAlreadyVoted, sql.ErrNoRows)
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertVote(ctx, tx, poll, userID, "", optionIDs, textAnswer); err != nil {
+		if err == sql.ErrNoRows {
+			return s.rejectVote(metrics.RejectInvalid, err)
 		}
+		return err
+	}
+	// The event is queued in the vote's transaction, so a vote is never
+	// recorded without it.
+	if err := s.emitVoteCast(ctx, tx, poll, userID, optionIDs, textAnswer); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
 	}
+	s.metrics().VoteCast(poll.QuestionType)
+	return nil
+}
 
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	execer
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// insertVote validates the answer against the poll and stores it for
+// voterID, which is kept out of user_id for anonymous polls. Guests have no
+// users row, so their votes carry guestKey instead and voterID is ignored.
+func insertVote(ctx context.Context, q querier, poll *models.Poll, voterID, guestKey string, optionIDs []int64, textAnswer string) error {
+	pollID := poll.ID
+	var userID, votedBy, guest sql.NullString
+	switch {
+	case guestKey != "":
+		guest = sql.NullString{String: guestKey, Valid: true}
+	case poll.IsAnonymous:
+		votedBy = sql.NullString{String: voterID, Valid: true}
+	default:
+		userID = sql.NullString{String: voterID, Valid: true}
+		votedBy = userID
+	}
+
+	var err error
 	if poll.QuestionType == "text" {
-		if poll.IsAnonymous {
-			_, err = s.DB.ExecContext(ctx, "INSERT INTO votes (poll_id, user_id, text_answer, created_at, voted_by) VALUES ($1, NULL::VARCHAR, $2, $3, $4)",
-				pollID, textAnswer, time.Now(), userID)
-		} else {
-			_, err = s.DB.ExecContext(ctx, "INSERT INTO votes (poll_id, user_id, text_answer, created_at, voted_by) VALUES ($1, $2, $3, $4, $2)",
-				pollID, userID, textAnswer, time.Now())
-		}
+		_, err = q.ExecContext(ctx, "INSERT INTO votes (poll_id, user_id, text_answer, created_at, voted_by, guest_key) VALUES ($1, $2, $3, $4, $5, $6)",
+			pollID, userID, textAnswer, time.Now(), votedBy, guest)
 	} else if poll.QuestionType == "scale" {
 		if len(optionIDs) != 1 {
 			return sql.ErrNoRows
 		}
-		scaleMax := 5
-		if optionIDs[0] < 1 || optionIDs[0] > int64(scaleMax) {
+		if optionIDs[0] < 1 || optionIDs[0] > ScaleMax {
 			return sql.ErrNoRows
 		}
-		if poll.IsAnonymous {
-			_, err = s.DB.ExecContext(ctx, "INSERT INTO votes (poll_id, user_id, scale_value, created_at, voted_by) VALUES ($1, NULL::VARCHAR, $2, $3, $4)",
-				pollID, optionIDs[0], time.Now(), userID)
-		} else {
-			_, err = s.DB.ExecContext(ctx, "INSERT INTO votes (poll_id, user_id, scale_value, created_at, voted_by) VALUES ($1, $2, $3, $4, $2)",
-				pollID, userID, optionIDs[0], time.Now())
-		}
-
+		_, err = q.ExecContext(ctx, "INSERT INTO votes (poll_id, user_id, scale_value, created_at, voted_by, guest_key) VALUES ($1, $2, $3, $4, $5, $6)",
+			pollID, userID, optionIDs[0], time.Now(), votedBy, guest)
 	} else {
 		// Validate optionIDs exist in options table
 		for _, optionID := range optionIDs {
 			var exists bool
-			err = s.DB.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM options WHERE poll_id = $1 AND id = $2)", pollID, optionID).Scan(&exists)
+			err = q.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM options WHERE poll_id = $1 AND id = $2)", pollID, optionID).Scan(&exists)
 			if err != nil || !exists {
-				log.Printf("Invalid option_id: %d for poll_id: %d", optionID, pollID)
+				slog.WarnContext(ctx, "Invalid option for poll", "option_id", optionID, "poll_id", pollID)
 				return sql.ErrNoRows
 			}
 		}
 		for _, optionID := range optionIDs {
-			if poll.IsAnonymous {
-				_, err = s.DB.ExecContext(ctx, "INSERT INTO votes (poll_id, user_id, option_id, created_at, voted_by) VALUES ($1, NULL::VARCHAR, $2::BIGINT, $3, $4)",
-					pollID, optionID, time.Now(), userID)
-			} else {
-				_, err = s.DB.ExecContext(ctx, "INSERT INTO votes (poll_id, user_id, option_id, created_at, voted_by) VALUES ($1, $2, $3::BIGINT, $4, $5)",
-					pollID, userID, optionID, time.Now(), userID)
-			}
+			_, err = q.ExecContext(ctx, "INSERT INTO votes (poll_id, user_id, option_id, created_at, voted_by, guest_key) VALUES ($1, $2, $3::BIGINT, $4, $5, $6)",
+				pollID, userID, optionID, time.Now(), votedBy, guest)
 			if err != nil {
-				log.Printf("Insert error: %v for option_id: %d", err, optionID)
+				slog.ErrorContext(ctx, "Failed to insert vote", "option_id", optionID, "error", err)
 				return err
 			}
 		}
@@ -313,121 +488,20 @@ func (s *PollService) RecordVote(ctx context.Context, pollID int64, userID strin
 	return err
 }
 
-type VoteSummary struct {
-	PollID       int64
-	Title        string
-	QuestionType string
-	IsAnonymous  bool
-	Voters       []struct{ UserID, Email string }
-	Results      interface{}
-}
-
-func (s *PollService) GetPollSummary(ctx context.Context, pollID int64) (VoteSummary, error) {
-	poll, err := s.GetPoll(ctx, pollID)
-	if err != nil {
-		return VoteSummary{}, err
-	}
-
-	summary := VoteSummary{
-		PollID:       poll.ID,
-		Title:        poll.Title,
-		QuestionType: poll.QuestionType,
-		IsAnonymous:  poll.IsAnonymous,
-	}
-
-	rows, err := s.DB.QueryContext(ctx, `
-		SELECT DISTINCT v.voted_by, u.email
-		FROM votes v
-		LEFT JOIN users u ON v.voted_by = u.id
-		WHERE v.poll_id = $1
-	`, pollID)
-	if err != nil {
-		return VoteSummary{}, err
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var voter struct{ UserID, Email string }
-		var email sql.NullString
-		if err := rows.Scan(&voter.UserID, &email); err != nil {
-			return VoteSummary{}, err
-		}
-		if email.Valid {
-			voter.Email = email.String
-		}
-		summary.Voters = append(summary.Voters, voter)
-	}
-
-	// Fetch results based on question type
-	if poll.QuestionType == "text" {
-		rows, err := s.DB.QueryContext(ctx, `
-			SELECT text_answer, COUNT(*) as count
-			FROM votes
-			WHERE poll_id = $1 AND text_answer IS NOT NULL
-			GROUP BY text_answer
-		`, pollID)
-		if err != nil {
-			return VoteSummary{}, err
-		}
-		defer rows.Close()
-
-		results := make(map[string]int)
-		for rows.Next() {
-			var textAnswer string
-			var count int
-			if err := rows.Scan(&textAnswer, &count); err != nil {
-				return VoteSummary{}, err
-			}
-			results[textAnswer] = count
-		}
-		summary.Results = results
-	} else if poll.QuestionType == "scale" {
-		rows, err := s.DB.QueryContext(ctx, `
-			SELECT scale_value, COUNT(*) as count
-			FROM votes
-			WHERE poll_id = $1 AND scale_value IS NOT NULL
-			GROUP BY scale_value
-			ORDER BY scale_value
-		`, pollID)
-		if err != nil {
-			return VoteSummary{}, err
-		}
-		defer rows.Close()
-
-		results := make(map[int]int)
-		for rows.Next() {
-			var scaleValue, count int
-			if err := rows.Scan(&scaleValue, &count); err != nil {
-				return VoteSummary{}, err
-			}
-			results[scaleValue] = count
-		}
-		summary.Results = results
-	} else {
-		rows, err := s.DB.QueryContext(ctx, `
-			SELECT o.id, o.text, COUNT(v.option_id) as count
-			FROM options o
-			LEFT JOIN votes v ON o.id = v.option_id AND v.poll_id = $1
-			WHERE o.poll_id = $1
-			GROUP BY o.id, o.text
-		`, pollID)
-		if err != nil {
-			return VoteSummary{}, err
-		}
-		defer rows.Close()
-
-		results := make(map[string]int)
-		for rows.Next() {
-			var optionID int64
-			var text string
-			var count int
-			if err := rows.Scan(&optionID, &text, &count); err != nil {
-				return VoteSummary{}, err
-			}
-			results[text] = count
-		}
-		summary.Results = results
-	}
-
-	return summary, nil
+// emitVoteCast queues vote.cast in db. voterID is left out of the event on
+// anonymous polls and for guests, who pass an empty one.
+func (s *PollService) emitVoteCast(ctx context.Context, db execer, poll *models.Poll, voterID string, optionIDs []int64, textAnswer string) error {
+	event := VoteEventData{PollID: poll.ID}
+	if !poll.IsAnonymous {
+		event.UserID = voterID
+	}
+	switch poll.QuestionType {
+	case "text":
+		event.TextAnswer = textAnswer
+	case "scale":
+		event.ScaleValue = &optionIDs[0]
+	default:
+		event.OptionIDs = optionIDs
+	}
+	return s.Webhooks.Emit(ctx, db, EventVoteCast, &poll.ID, event)
 }
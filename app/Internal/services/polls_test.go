@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"fakidoosuurdoris/app/Internal/metrics"
+)
+
+// fakeDB is a database/sql connector whose statements are answered by
+// respond with result columns and rows. Transactions end with a call to
+// finish, with whether they were committed.
+type fakeDB struct {
+	respond func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error)
+	finish  func(committed bool)
+}
+
+func (db fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn(db), nil }
+func (db fakeDB) Driver() driver.Driver                        { return db }
+func (db fakeDB) Open(string) (driver.Conn, error)             { return fakeConn(db), nil }
+
+type fakeConn fakeDB
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return fakeTx(c), nil }
+
+type fakeTx fakeDB
+
+func (tx fakeTx) Commit() error   { tx.finish(true); return nil }
+func (tx fakeTx) Rollback() error { tx.finish(false); return nil }
+
+func (c fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	columns, rows, err := c.respond(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{columns: columns, rows: rows}, nil
+}
+
+func (c fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if _, _, err := c.respond(query, args); err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(1), nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// fakeRecorder counts votes cast and refused.
+type fakeRecorder struct {
+	metrics.Nop
+	cast     map[string]int
+	rejected map[string]int
+}
+
+func (r *fakeRecorder) VoteCast(pollType string)   { r.cast[pollType]++ }
+func (r *fakeRecorder) VoteRejected(reason string) { r.rejected[reason]++ }
+
+var errInsert = errors.New("insert failed")
+
+func TestRecordVoteMetrics(t *testing.T) {
+	now := time.Now()
+	yesterday, tomorrow := now.AddDate(0, 0, -1), now.AddDate(0, 0, 1)
+	tests := []struct {
+		name         string
+		questionType string
+		start        time.Time
+		end          interface{}
+		ineligible   bool
+		voted        bool
+		optionExists bool
+		optionIDs    []int64
+		failInsert   int
+		wantErr      error
+		cast         map[string]int
+		rejected     map[string]int
+	}{
+		{
+			name: "single choice cast", questionType: "single_choice", start: yesterday, end: tomorrow,
+			optionExists: true, optionIDs: []int64{7},
+			cast: map[string]int{"single_choice": 1}, rejected: map[string]int{},
+		},
+		{
+			name: "scale cast", questionType: "scale", start: yesterday, end: nil,
+			optionIDs: []int64{4},
+			cast:      map[string]int{"scale": 1}, rejected: map[string]int{},
+		},
+		{
+			name: "text cast", questionType: "text", start: yesterday, end: nil,
+			cast: map[string]int{"text": 1}, rejected: map[string]int{},
+		},
+		{
+			name: "not yet open", questionType: "single_choice", start: tomorrow, end: nil,
+			optionExists: true, optionIDs: []int64{7}, wantErr: sql.ErrNoRows,
+			cast: map[string]int{}, rejected: map[string]int{metrics.RejectClosed: 1},
+		},
+		{
+			name: "closed", questionType: "single_choice", start: yesterday.AddDate(0, 0, -1), end: yesterday,
+			optionExists: true, optionIDs: []int64{7}, wantErr: sql.ErrNoRows,
+			cast: map[string]int{}, rejected: map[string]int{metrics.RejectClosed: 1},
+		},
+		{
+			name: "not eligible", questionType: "single_choice", start: yesterday, end: nil, ineligible: true,
+			optionExists: true, optionIDs: []int64{7}, wantErr: sql.ErrNoRows,
+			cast: map[string]int{}, rejected: map[string]int{metrics.RejectNotEligible: 1},
+		},
+		{
+			name: "already voted", questionType: "single_choice", start: yesterday, end: nil, voted: true,
+			optionExists: true, optionIDs: []int64{7}, wantErr: sql.ErrNoRows,
+			cast: map[string]int{}, rejected: map[string]int{metrics.RejectAlreadyVoted: 1},
+		},
+		{
+			name: "unknown option", questionType: "multiple_choice", start: yesterday, end: nil,
+			optionIDs: []int64{7, 8}, wantErr: sql.ErrNoRows,
+			cast: map[string]int{}, rejected: map[string]int{metrics.RejectInvalid: 1},
+		},
+		{
+			name: "second answer not stored", questionType: "multiple_choice", start: yesterday, end: nil,
+			optionExists: true, optionIDs: []int64{7, 8}, failInsert: 2, wantErr: errInsert,
+			cast: map[string]int{}, rejected: map[string]int{},
+		},
+		{
+			name: "scale value out of range", questionType: "scale", start: yesterday, end: nil,
+			optionIDs: []int64{ScaleMax + 1}, wantErr: sql.ErrNoRows,
+			cast: map[string]int{}, rejected: map[string]int{metrics.RejectInvalid: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inserted, committed := 0, false
+			db := sql.OpenDB(fakeDB{finish: func(c bool) { committed = committed || c }, respond: func(query string, args []driver.NamedValue) ([]string, [][]driver.Value, error) {
+				switch {
+				case strings.Contains(query, "FROM polls WHERE id"):
+					return []string{"id", "title", "user_id", "question_type", "start_date", "end_date", "is_anonymous", "option_order", "created_at"},
+						[][]driver.Value{{int64(1), "Lunch", "creator", tt.questionType, tt.start, tt.end, false, OptionOrderFixed, yesterday}}, nil
+				case strings.Contains(query, "poll_eligibility"):
+					return []string{"eligible"}, [][]driver.Value{{!tt.ineligible}}, nil
+				case strings.Contains(query, "SELECT COUNT(*)") && strings.Contains(query, "FROM votes"):
+					count := int64(0)
+					if tt.voted {
+						count = 1
+					}
+					return []string{"count"}, [][]driver.Value{{count}}, nil
+				case strings.Contains(query, "FROM options"):
+					return []string{"exists"}, [][]driver.Value{{tt.optionExists}}, nil
+				case strings.HasPrefix(query, "INSERT INTO votes"):
+					inserted++
+					if inserted == tt.failInsert {
+						return nil, nil, errInsert
+					}
+					return nil, nil, nil
+				}
+				return nil, nil, errors.New("unexpected query: " + query)
+			}})
+			defer db.Close()
+
+			recorder := &fakeRecorder{cast: map[string]int{}, rejected: map[string]int{}}
+			s := &PollService{DB: db, Metrics: recorder}
+			err := s.RecordVote(context.Background(), 1, "voter", tt.optionIDs, "Soup")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RecordVote() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(recorder.cast, tt.cast) {
+				t.Errorf("cast = %v, want %v", recorder.cast, tt.cast)
+			}
+			if !reflect.DeepEqual(recorder.rejected, tt.rejected) {
+				t.Errorf("rejected = %v, want %v", recorder.rejected, tt.rejected)
+			}
+			if committed != (tt.wantErr == nil) {
+				t.Errorf("committed = %v, want a commit only when the vote is cast", committed)
+			}
+			if tt.wantErr == nil && inserted != max(len(tt.optionIDs), 1) {
+				t.Errorf("%d answers stored, want one per option", inserted)
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"fakidoosuurdoris/app/Internal/models"
+)
+
+var ErrInvalidTemplate = errors.New("invalid poll template")
+
+var questionTypes = map[string]bool{"single_choice": true, "multiple_choice": true, "scale": true, "text": true}
+
+// PollTemplate is a reusable poll definition. Shared templates are visible to
+// everyone; personal ones only to their owner. DurationMinutes, when set, is
+// the time between start and end of polls created from it.
+type PollTemplate struct {
+	ID              int64           `json:"id"`
+	Name            string          `json:"name"`
+	OwnerID         string          `json:"owner_id"`
+	Shared          bool            `json:"shared"`
+	Title           string          `json:"title"`
+	QuestionType    string          `json:"question_type"`
+	Options         []models.Option `json:"options"`
+	OptionOrder     string          `json:"option_order"`
+	IsAnonymous     bool            `json:"is_anonymous"`
+	DurationMinutes *int            `json:"duration_minutes"`
+	CreatedAt       time.Time       `json:"created_at"`
+}
+
+// PollDraft prefills the create poll form; it is not stored. TemplateID is
+// set for drafts made from a template.
+type PollDraft struct {
+	Title        string
+	QuestionType string
+	Options      []models.Option
+	OptionOrder  string
+	IsAnonymous  bool
+	StartDate    time.Time
+	EndDate      *time.Time
+	TemplateID   *int64
+}
+
+// CreateTemplate stores t for ownerID. Option IDs and positions are dropped;
+// the slice order is the option order. Only admins may share templates.
+func (s *PollService) CreateTemplate(ctx context.Context, ownerID string, t PollTemplate) (*PollTemplate, error) {
+	ctx, span := startSpan(ctx, "PollService.CreateTemplate")
+	defer span.End()
+
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		t.Name = t.Title
+	}
+	if t.Name == "" || strings.TrimSpace(t.Title) == "" {
+		return nil, fmt.Errorf("%w: name and title are required", ErrInvalidTemplate)
+	}
+	if !questionTypes[t.QuestionType] {
+		return nil, fmt.Errorf("%w: unknown question type %q", ErrInvalidTemplate, t.QuestionType)
+	}
+	if t.DurationMinutes != nil && *t.DurationMinutes <= 0 {
+		return nil, fmt.Errorf("%w: duration must be positive", ErrInvalidTemplate)
+	}
+	if t.OptionOrder != OptionOrderRandom {
+		t.OptionOrder = OptionOrderFixed
+	}
+	if t.Shared {
+		role, err := s.AuthService.GetUserRole(ctx, ownerID)
+		if err != nil {
+			return nil, err
+		}
+		if role != "admin" {
+			return nil, sql.ErrNoRows // Only admins publish shared templates
+		}
+	}
+
+	options := make([]models.Option, 0, len(t.Options))
+	if t.QuestionType != "text" {
+		for _, option := range t.Options {
+			if strings.TrimSpace(option.Text) == "" {
+				continue
+			}
+			options = append(options, models.Option{
+				Text:        option.Text,
+				Description: option.Description,
+				ImageURL:    option.ImageURL,
+				LinkURL:     option.LinkURL,
+			})
+		}
+	}
+	t.Options = options
+	encoded, err := json.Marshal(options)
+	if err != nil {
+		return nil, err
+	}
+
+	t.OwnerID = ownerID
+	err = s.DB.QueryRowContext(ctx, `
+		INSERT INTO poll_templates (name, owner_id, shared, title, question_type, options, option_order, is_anonymous, duration_minutes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id, created_at
+	`, t.Name, ownerID, t.Shared, t.Title, t.QuestionType, string(encoded), t.OptionOrder, t.IsAnonymous, t.DurationMinutes).Scan(&t.ID, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// SaveTemplateFromPoll copies a poll's settings and options into a new
+// template. Only the poll's creator or an admin may do so.
+func (s *PollService) SaveTemplateFromPoll(ctx context.Context, pollID int64, userID, name string, shared bool) (*PollTemplate, error) {
+	ctx, span := startPollSpan(ctx, "PollService.SaveTemplateFromPoll", pollID)
+	defer span.End()
+
+	poll, options, err := s.pollForCopy(ctx, pollID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	t := PollTemplate{
+		Name:         name,
+		Shared:       shared,
+		Title:        poll.Title,
+		QuestionType: poll.QuestionType,
+		Options:      options,
+		OptionOrder:  poll.OptionOrder,
+		IsAnonymous:  poll.IsAnonymous,
+	}
+	if poll.EndDate != nil {
+		minutes := int(poll.EndDate.Sub(poll.StartDate).Minutes())
+		if minutes > 0 {
+			t.DurationMinutes = &minutes
+		}
+	}
+	return s.CreateTemplate(ctx, userID, t)
+}
+
+// pollForCopy loads a poll and its options for copying, under the same
+// rule as authorizePollOwner.
+func (s *PollService) pollForCopy(ctx context.Context, pollID int64, userID string) (*models.Poll, []models.Option, error) {
+	poll, err := s.authorizePollOwner(ctx, pollID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	options, err := s.GetPollOptions(ctx, pollID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return poll, options, nil
+}
+
+// ListTemplates returns the shared templates and the user's personal ones.
+func (s *PollService) ListTemplates(ctx context.Context, userID string) ([]PollTemplate, error) {
+	ctx, span := startSpan(ctx, "PollService.ListTemplates")
+	defer span.End()
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, name, owner_id, shared, title, question_type, options, option_order, is_anonymous, duration_minutes, created_at
+		FROM poll_templates
+		WHERE shared OR owner_id = $1
+		ORDER BY shared, LOWER(name), id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []PollTemplate
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, *t)
+	}
+	return templates, rows.Err()
+}
+
+// GetTemplate returns a template the user can see.
+func (s *PollService) GetTemplate(ctx context.Context, id int64, userID string) (*PollTemplate, error) {
+	ctx, span := startSpan(ctx, "PollService.GetTemplate")
+	defer span.End()
+
+	return scanTemplate(s.DB.QueryRowContext(ctx, `
+		SELECT id, name, owner_id, shared, title, question_type, options, option_order, is_anonymous, duration_minutes, created_at
+		FROM poll_templates
+		WHERE id = $1 AND (shared OR owner_id = $2)
+	`, id, userID))
+}
+
+func scanTemplate(row interface{ Scan(...interface{}) error }) (*PollTemplate, error) {
+	var t PollTemplate
+	var options []byte
+	var duration sql.NullInt64
+	err := row.Scan(&t.ID, &t.Name, &t.OwnerID, &t.Shared, &t.Title, &t.QuestionType, &options, &t.OptionOrder, &t.IsAnonymous, &duration, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(options, &t.Options); err != nil {
+		return nil, err
+	}
+	if duration.Valid {
+		minutes := int(duration.Int64)
+		t.DurationMinutes = &minutes
+	}
+	return &t, nil
+}
+
+// DeleteTemplate removes a template. Owners may delete their own templates
+// and admins may also delete shared ones.
+func (s *PollService) DeleteTemplate(ctx context.Context, id int64, userID string) error {
+	ctx, span := startSpan(ctx, "PollService.DeleteTemplate")
+	defer span.End()
+
+	role, err := s.AuthService.GetUserRole(ctx, userID)
+	if err != nil {
+		return err
+	}
+	result, err := s.DB.ExecContext(ctx, `
+		DELETE FROM poll_templates WHERE id = $1 AND (owner_id = $2 OR (shared AND $3))
+	`, id, userID, role == "admin")
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+// draftStart is when copied polls start: the next full hour.
+func draftStart(now time.Time) time.Time {
+	return now.Truncate(time.Hour).Add(time.Hour)
+}
+
+// DraftFromPoll copies a poll into a draft that starts at the next full hour
+// and keeps the original poll's duration.
+func (s *PollService) DraftFromPoll(ctx context.Context, pollID int64, userID string) (*PollDraft, error) {
+	ctx, span := startPollSpan(ctx, "PollService.DraftFromPoll", pollID)
+	defer span.End()
+
+	poll, options, err := s.pollForCopy(ctx, pollID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	draft := &PollDraft{
+		Title:        poll.Title,
+		QuestionType: poll.QuestionType,
+		Options:      options,
+		OptionOrder:  poll.OptionOrder,
+		IsAnonymous:  poll.IsAnonymous,
+		StartDate:    draftStart(time.Now()),
+	}
+	if poll.EndDate != nil {
+		end := draft.StartDate.Add(poll.EndDate.Sub(poll.StartDate))
+		draft.EndDate = &end
+	}
+	return draft, nil
+}
+
+// DraftFromTemplate turns a template into a draft starting at the next full
+// hour.
+func (s *PollService) DraftFromTemplate(ctx context.Context, templateID int64, userID string) (*PollDraft, error) {
+	ctx, span := startSpan(ctx, "PollService.DraftFromTemplate")
+	defer span.End()
+
+	t, err := s.GetTemplate(ctx, templateID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	draft := &PollDraft{
+		Title:        t.Title,
+		QuestionType: t.QuestionType,
+		Options:      t.Options,
+		OptionOrder:  t.OptionOrder,
+		IsAnonymous:  t.IsAnonymous,
+		StartDate:    draftStart(time.Now()),
+		TemplateID:   &t.ID,
+	}
+	if t.DurationMinutes != nil {
+		end := draft.StartDate.Add(time.Duration(*t.DurationMinutes) * time.Minute)
+		draft.EndDate = &end
+	}
+	return draft, nil
+}
+
+// ImageInUse reports whether an option image is still referenced by any poll
+// option or template. Copies share image files with their source.
+func (s *PollService) ImageInUse(ctx context.Context, imageURL string) (bool, error) {
+	ctx, span := startSpan(ctx, "PollService.ImageInUse")
+	defer span.End()
+
+	var inUse bool
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM options WHERE image_url = $1)
+			OR EXISTS (SELECT 1 FROM poll_templates WHERE options @> jsonb_build_array(jsonb_build_object('image_url', $1::text)))
+	`, imageURL).Scan(&inUse)
+	return inUse, err
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	FrequencyDaily   = "daily"
+	FrequencyWeekly  = "weekly"
+	FrequencyMonthly = "monthly"
+)
+
+var ErrInvalidRecurrence = errors.New("invalid recurrence rule")
+
+// RecurrenceRule is a small subset of RFC 5545 RRULE: FREQ, INTERVAL, BYDAY
+// (weekly only) and an UNTIL or COUNT end condition. Occurrences keep the
+// time of day of the series start. Monthly rules skip months that do not
+// have the start's day, as RRULE does.
+type RecurrenceRule struct {
+	Frequency string         `json:"frequency"`
+	Interval  int            `json:"interval"`
+	Weekdays  []time.Weekday `json:"weekdays,omitempty"`
+	Until     *time.Time     `json:"until,omitempty"`
+	Count     *int           `json:"count,omitempty"`
+}
+
+func (r RecurrenceRule) Validate() error {
+	switch r.Frequency {
+	case FrequencyDaily, FrequencyWeekly, FrequencyMonthly:
+	default:
+		return fmt.Errorf("%w: unknown frequency %q", ErrInvalidRecurrence, r.Frequency)
+	}
+	if r.Interval < 1 {
+		return fmt.Errorf("%w: interval must be at least 1", ErrInvalidRecurrence)
+	}
+	if len(r.Weekdays) > 0 && r.Frequency != FrequencyWeekly {
+		return fmt.Errorf("%w: weekdays only apply to weekly rules", ErrInvalidRecurrence)
+	}
+	for _, day := range r.Weekdays {
+		if day < time.Sunday || day > time.Saturday {
+			return fmt.Errorf("%w: invalid weekday %d", ErrInvalidRecurrence, day)
+		}
+	}
+	if r.Until != nil && r.Count != nil {
+		return fmt.Errorf("%w: use either an end date or a number of occurrences", ErrInvalidRecurrence)
+	}
+	if r.Count != nil && *r.Count < 1 {
+		return fmt.Errorf("%w: number of occurrences must be at least 1", ErrInvalidRecurrence)
+	}
+	return nil
+}
+
+// Next returns the first occurrence strictly after prev, or start itself
+// when prev is zero. It does not apply the end condition.
+func (r RecurrenceRule) Next(start, prev time.Time) time.Time {
+	if prev.IsZero() {
+		if r.Frequency == FrequencyWeekly && len(r.Weekdays) > 0 && !r.onWeekday(start) {
+			return r.nextWeekday(start, start)
+		}
+		return start
+	}
+
+	switch r.Frequency {
+	case FrequencyDaily:
+		return prev.AddDate(0, 0, r.Interval)
+	case FrequencyWeekly:
+		if len(r.Weekdays) == 0 {
+			return prev.AddDate(0, 0, 7*r.Interval)
+		}
+		return r.nextWeekday(start, prev.AddDate(0, 0, 1))
+	default:
+		year, month := prev.Year(), prev.Month()
+		for {
+			month += time.Month(r.Interval)
+			next := time.Date(year, month, start.Day(), start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+			// time.Date normalises overflowing days into the next month.
+			if next.Day() == start.Day() {
+				return next
+			}
+		}
+	}
+}
+
+// Done reports whether an occurrence at next, preceded by created
+// occurrences, falls outside the end condition.
+func (r RecurrenceRule) Done(next time.Time, created int) bool {
+	if r.Count != nil && created >= *r.Count {
+		return true
+	}
+	return r.Until != nil && next.After(*r.Until)
+}
+
+func (r RecurrenceRule) onWeekday(t time.Time) bool {
+	for _, day := range r.Weekdays {
+		if t.Weekday() == day {
+			return true
+		}
+	}
+	return false
+}
+
+// nextWeekday returns the first day on or after from that is one of the
+// rule's weekdays in a week that is a multiple of Interval weeks after the
+// week of start. Weeks begin on Monday.
+func (r RecurrenceRule) nextWeekday(start, from time.Time) time.Time {
+	week := weekStart(start)
+	day := time.Date(from.Year(), from.Month(), from.Day(), start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+	for i := 0; i < 7*(r.Interval+1); i++ {
+		weeks := int(weekStart(day).Sub(week).Hours()/24+0.5) / 7
+		if weeks%r.Interval == 0 && r.onWeekday(day) {
+			return day
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return day
+}
+
+func weekStart(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-offset, 0, 0, 0, 0, t.Location())
+}
+
+// Describe renders the rule in plain English, e.g. "every 2 weeks on Fri
+// until Jan 2, 2027".
+func (r RecurrenceRule) Describe() string {
+	unit := map[string]string{FrequencyDaily: "day", FrequencyWeekly: "week", FrequencyMonthly: "month"}[r.Frequency]
+	var b strings.Builder
+	if r.Interval == 1 {
+		b.WriteString("every " + unit)
+	} else {
+		fmt.Fprintf(&b, "every %d %ss", r.Interval, unit)
+	}
+	if len(r.Weekdays) > 0 {
+		names := make([]string, len(r.Weekdays))
+		for i, day := range r.Weekdays {
+			names[i] = day.String()[:3]
+		}
+		b.WriteString(" on " + strings.Join(names, ", "))
+	}
+	if r.Until != nil {
+		b.WriteString(" until " + r.Until.Format("Jan 2, 2006"))
+	}
+	if r.Count != nil {
+		fmt.Fprintf(&b, ", %d times", *r.Count)
+	}
+	return b.String()
+}
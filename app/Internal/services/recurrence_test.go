@@ -0,0 +1,42 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecurrenceRuleNext(t *testing.T) {
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 9, 30, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name  string
+		rule  RecurrenceRule
+		start time.Time
+		prev  time.Time
+		want  time.Time
+	}{
+		{"first daily is start", RecurrenceRule{Frequency: FrequencyDaily, Interval: 1}, date(2026, 1, 1), time.Time{}, date(2026, 1, 1)},
+		{"daily", RecurrenceRule{Frequency: FrequencyDaily, Interval: 2}, date(2026, 1, 1), date(2026, 1, 1), date(2026, 1, 3)},
+		{"daily across year", RecurrenceRule{Frequency: FrequencyDaily, Interval: 1}, date(2026, 12, 31), date(2026, 12, 31), date(2027, 1, 1)},
+		{"weekly", RecurrenceRule{Frequency: FrequencyWeekly, Interval: 1}, date(2026, 1, 2), date(2026, 1, 2), date(2026, 1, 9)},
+		{"first weekly moves to weekday", RecurrenceRule{Frequency: FrequencyWeekly, Interval: 1, Weekdays: []time.Weekday{time.Monday, time.Wednesday}}, date(2026, 1, 6), time.Time{}, date(2026, 1, 7)},
+		{"first weekly on weekday", RecurrenceRule{Frequency: FrequencyWeekly, Interval: 1, Weekdays: []time.Weekday{time.Tuesday}}, date(2026, 1, 6), time.Time{}, date(2026, 1, 6)},
+		{"weekly next weekday", RecurrenceRule{Frequency: FrequencyWeekly, Interval: 1, Weekdays: []time.Weekday{time.Monday, time.Wednesday}}, date(2026, 1, 6), date(2026, 1, 7), date(2026, 1, 12)},
+		{"fortnightly skips a week", RecurrenceRule{Frequency: FrequencyWeekly, Interval: 2, Weekdays: []time.Weekday{time.Friday}}, date(2026, 1, 2), date(2026, 1, 2), date(2026, 1, 16)},
+		{"fortnightly second weekday", RecurrenceRule{Frequency: FrequencyWeekly, Interval: 2, Weekdays: []time.Weekday{time.Monday, time.Friday}}, date(2026, 1, 5), date(2026, 1, 5), date(2026, 1, 9)},
+		{"weekly keeps start time", RecurrenceRule{Frequency: FrequencyWeekly, Interval: 1, Weekdays: []time.Weekday{time.Thursday}}, date(2026, 1, 1), time.Date(2026, 1, 1, 18, 0, 0, 0, time.UTC), date(2026, 1, 8)},
+		{"monthly", RecurrenceRule{Frequency: FrequencyMonthly, Interval: 1}, date(2026, 1, 15), date(2026, 1, 15), date(2026, 2, 15)},
+		{"quarterly across year", RecurrenceRule{Frequency: FrequencyMonthly, Interval: 3}, date(2026, 11, 1), date(2026, 11, 1), date(2027, 2, 1)},
+		{"monthly skips short months", RecurrenceRule{Frequency: FrequencyMonthly, Interval: 1}, date(2026, 1, 31), date(2026, 1, 31), date(2026, 3, 31)},
+		{"monthly on 29th skips non-leap February", RecurrenceRule{Frequency: FrequencyMonthly, Interval: 1}, date(2027, 1, 29), date(2027, 1, 29), date(2027, 3, 29)},
+		{"monthly on 29th in leap year", RecurrenceRule{Frequency: FrequencyMonthly, Interval: 1}, date(2028, 1, 29), date(2028, 1, 29), date(2028, 2, 29)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.Next(tt.start, tt.prev); !got.Equal(tt.want) {
+				t.Errorf("Next(%v, %v) = %v, want %v", tt.start, tt.prev, got, tt.want)
+			}
+		})
+	}
+}
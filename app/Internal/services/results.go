@@ -0,0 +1,263 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// ScaleMax is the highest value of a scale poll; scales start at 1.
+const ScaleMax = 5
+
+// PollResults is the result model shared by every export format.
+// EligibleCount is the number of users allowed to vote, the base of the
+// participation rate.
+type PollResults struct {
+	PollID        int64      `json:"poll_id"`
+	Title         string     `json:"title"`
+	QuestionType  string     `json:"question_type"`
+	IsAnonymous   bool       `json:"is_anonymous"`
+	StartDate     time.Time  `json:"start_date"`
+	EndDate       *time.Time `json:"end_date"`
+	VoterCount    int        `json:"voter_count"`
+	EligibleCount int        `json:"eligible_count"`
+	// Guests are counted in VoterCount and GuestCount but not listed in
+	// Voters.
+	GuestCount int         `json:"guest_count"`
+	Voters     []Voter     `json:"voters"`
+	Rows       []ResultRow `json:"results"`
+	// Text is only set for text polls, Scale and Comparison only for scale
+	// polls.
+	Text       *TextAnalytics   `json:"text_analytics,omitempty"`
+	Scale      *ScaleStats      `json:"scale_stats,omitempty"`
+	Comparison *ScaleComparison `json:"comparison,omitempty"`
+}
+
+type Voter struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email,omitempty"`
+}
+
+// ResultRow is one option, scale value or group of similar text answers.
+// Percent is the share of voters who gave it, so rows of a multiple choice
+// poll can add up to more than 100. For text answers Label is the most
+// common spelling, Variants the spellings given and Keys their normalized
+// forms, which answer tags are assigned to.
+type ResultRow struct {
+	Label    string   `json:"label"`
+	OptionID int64    `json:"option_id,omitempty"`
+	Value    int      `json:"value,omitempty"`
+	Count    int      `json:"count"`
+	Percent  float64  `json:"percent"`
+	Variants []string `json:"variants,omitempty"`
+	Keys     []string `json:"keys,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+}
+
+// Ballot is everything one voter submitted. For anonymous polls VoterID,
+// Email and CastAt are left empty.
+type Ballot struct {
+	Number     int        `json:"ballot"`
+	VoterID    string     `json:"voter_id,omitempty"`
+	Email      string     `json:"email,omitempty"`
+	CastAt     *time.Time `json:"cast_at,omitempty"`
+	OptionIDs  []int64    `json:"option_ids,omitempty"`
+	Choices    []string   `json:"choices,omitempty"`
+	ScaleValue *int64     `json:"scale_value,omitempty"`
+	TextAnswer *string    `json:"text_answer,omitempty"`
+}
+
+// GetPollResults returns a poll's voters and its results. Choice options
+// and text answer groups are sorted by count, most popular first; scale
+// values are listed in order, including those nobody picked, and come with
+// statistics and a comparison with related polls. The word counts of text
+// polls leave out the stop words of language, English if it is not
+// supported.
+func (s *PollService) GetPollResults(ctx context.Context, pollID int64, language string) (*PollResults, error) {
+	ctx, span := startPollSpan(ctx, "PollService.GetPollResults", pollID)
+	defer span.End()
+
+	poll, err := s.GetPoll(ctx, pollID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := &PollResults{
+		PollID:       poll.ID,
+		Title:        poll.Title,
+		QuestionType: poll.QuestionType,
+		IsAnonymous:  poll.IsAnonymous,
+		StartDate:    poll.StartDate,
+		EndDate:      poll.EndDate,
+		Voters:       []Voter{},
+		Rows:         []ResultRow{},
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT DISTINCT v.voted_by, u.email
+		FROM votes v
+		LEFT JOIN users u ON v.voted_by = u.id
+		WHERE v.poll_id = $1 AND v.voted_by IS NOT NULL
+		ORDER BY v.voted_by
+	`, pollID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var voter Voter
+		var email sql.NullString
+		if err := rows.Scan(&voter.UserID, &email); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		voter.Email = email.String
+		results.Voters = append(results.Voters, voter)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	err = s.DB.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT guest_key) FROM votes WHERE poll_id = $1 AND guest_key IS NOT NULL
+	`, pollID).Scan(&results.GuestCount)
+	if err != nil {
+		return nil, err
+	}
+	results.VoterCount = len(results.Voters) + results.GuestCount
+
+	err = s.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM users u
+		WHERE u.id <> $2 AND `+eligibleVoter+`
+	`, pollID, DeletedUserID).Scan(&results.EligibleCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if poll.QuestionType == "text" {
+		if err := s.textResults(ctx, results, language); err != nil {
+			return nil, err
+		}
+		return results, nil
+	}
+
+	var query string
+	switch poll.QuestionType {
+	case "scale":
+		query = `
+			SELECT s.value::TEXT, 0, s.value, COUNT(v.id)
+			FROM generate_series(1, ` + strconv.Itoa(ScaleMax) + `) AS s(value)
+			LEFT JOIN votes v ON v.poll_id = $1 AND v.scale_value = s.value
+			GROUP BY s.value
+			ORDER BY s.value`
+	default:
+		query = `
+			SELECT o.option_text, o.id, 0, COUNT(v.id)
+			FROM options o
+			LEFT JOIN votes v ON v.option_id = o.id AND v.poll_id = $1
+			WHERE o.poll_id = $1
+			GROUP BY o.id, o.option_text, o.position
+			ORDER BY COUNT(v.id) DESC, o.position, o.id`
+	}
+	rows, err = s.DB.QueryContext(ctx, query, pollID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row ResultRow
+		if err := rows.Scan(&row.Label, &row.OptionID, &row.Value, &row.Count); err != nil {
+			return nil, err
+		}
+		if results.VoterCount > 0 {
+			row.Percent = float64(row.Count) * 100 / float64(results.VoterCount)
+		}
+		results.Rows = append(results.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if poll.QuestionType == "scale" {
+		counts := make([]int, len(results.Rows))
+		for i, row := range results.Rows {
+			counts[i] = row.Count
+		}
+		stats := scaleStats(counts)
+		results.Scale = &stats
+		if results.Comparison, err = s.scaleComparison(ctx, pollID); err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
+
+// StreamBallots calls fn for each ballot of a poll as rows are read, so
+// large polls are never held in memory. Ballots of named polls come in the
+// order they were cast; those of anonymous polls in a random order, drawn
+// afresh for every export, and without voter or time. The order must not
+// be derived from the voter, as anyone who knows the voters could then
+// recompute it.
+func (s *PollService) StreamBallots(ctx context.Context, pollID int64, fn func(Ballot) error) error {
+	ctx, span := startPollSpan(ctx, "PollService.StreamBallots", pollID)
+	defer span.End()
+
+	poll, err := s.GetPoll(ctx, pollID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT b.voter, u.email, b.cast_at, b.option_ids, b.choices, b.scale_value, b.text_answer
+		FROM (
+			SELECT COALESCE(v.voted_by, v.user_id, 'guest:' || v.guest_key, v.id::TEXT) AS voter,
+				MIN(v.created_at) AS cast_at,
+				ARRAY_REMOVE(ARRAY_AGG(o.id ORDER BY o.position, o.id), NULL) AS option_ids,
+				ARRAY_REMOVE(ARRAY_AGG(o.option_text ORDER BY o.position, o.id), NULL) AS choices,
+				MAX(v.scale_value) AS scale_value,
+				MAX(v.text_answer) AS text_answer
+			FROM votes v
+			LEFT JOIN options o ON o.id = v.option_id
+			WHERE v.poll_id = $1
+			GROUP BY 1
+		) b
+		LEFT JOIN users u ON u.id = b.voter
+		ORDER BY CASE WHEN $2 THEN random() END, b.cast_at, b.voter
+	`, pollID, poll.IsAnonymous)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	number := 0
+	for rows.Next() {
+		var b Ballot
+		var voter string
+		var email sql.NullString
+		var castAt time.Time
+		var scale sql.NullInt64
+		var text sql.NullString
+		if err := rows.Scan(&voter, &email, &castAt, pq.Array(&b.OptionIDs), pq.Array(&b.Choices), &scale, &text); err != nil {
+			return err
+		}
+		number++
+		b.Number = number
+		if !poll.IsAnonymous {
+			b.VoterID = voter
+			b.Email = email.String
+			b.CastAt = &castAt
+		}
+		if scale.Valid {
+			b.ScaleValue = &scale.Int64
+		}
+		if text.Valid {
+			b.TextAnswer = &text.String
+		}
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+)
+
+// z95 is the standard normal quantile for a two-sided 95% interval, the
+// confidence level of every interval in the scale statistics.
+const z95 = 1.959964
+
+// ScaleStats summarises the responses of a scale poll. MeanLow and MeanHigh
+// bound the mean at 95% confidence, clipped to the scale; Mode lists every
+// most frequent value.
+type ScaleStats struct {
+	Count        int           `json:"count"`
+	Mean         float64       `json:"mean"`
+	Median       float64       `json:"median"`
+	Mode         []int         `json:"mode"`
+	StdDev       float64       `json:"std_dev"`
+	MeanLow      float64       `json:"mean_ci_low"`
+	MeanHigh     float64       `json:"mean_ci_high"`
+	Distribution []ScaleBucket `json:"distribution"`
+	NetScore     *NetScore     `json:"net_score,omitempty"`
+}
+
+// ScaleBucket is the share of responses with one value, with a Wilson
+// score interval around it.
+type ScaleBucket struct {
+	Value   int     `json:"value"`
+	Count   int     `json:"count"`
+	Percent float64 `json:"percent"`
+	Low     float64 `json:"ci_low"`
+	High    float64 `json:"ci_high"`
+}
+
+// NetScore is a Net Promoter style score on the 1 to ScaleMax scale: the
+// top value counts as a promoter, the one below as passive and the rest as
+// detractors, the usual mapping of the 0-10 NPS groups onto five points.
+// Score is the share of promoters minus that of detractors, from -100 to
+// 100.
+type NetScore struct {
+	Promoters  int     `json:"promoters"`
+	Passives   int     `json:"passives"`
+	Detractors int     `json:"detractors"`
+	Score      float64 `json:"score"`
+	Low        float64 `json:"ci_low"`
+	High       float64 `json:"ci_high"`
+}
+
+// ScaleComparison lists the scale polls that share a series or template
+// with a poll, oldest first, so results can be followed over time. Basis is
+// "series" or "template".
+type ScaleComparison struct {
+	Basis string       `json:"basis"`
+	Polls []ScalePoint `json:"polls"`
+}
+
+type ScalePoint struct {
+	PollID    int64      `json:"poll_id"`
+	Title     string     `json:"title"`
+	StartDate time.Time  `json:"start_date"`
+	EndDate   *time.Time `json:"end_date"`
+	Current   bool       `json:"current,omitempty"`
+	Stats     ScaleStats `json:"stats"`
+}
+
+// maxComparedPolls caps a comparison to the most recent polls.
+const maxComparedPolls = 24
+
+// scaleStats computes the statistics of a scale poll from its per-value
+// counts, given in value order.
+func scaleStats(counts []int) ScaleStats {
+	stats := ScaleStats{Mode: []int{}, Distribution: []ScaleBucket{}}
+	sum, best := 0, 0
+	for i, count := range counts {
+		value := i + 1
+		stats.Count += count
+		sum += value * count
+		switch {
+		case count > best:
+			best = count
+			stats.Mode = []int{value}
+		case count == best && count > 0:
+			stats.Mode = append(stats.Mode, value)
+		}
+	}
+
+	n := float64(stats.Count)
+	for i, count := range counts {
+		bucket := ScaleBucket{Value: i + 1, Count: count}
+		if stats.Count > 0 {
+			bucket.Percent = float64(count) * 100 / n
+			bucket.Low, bucket.High = wilsonInterval(count, stats.Count)
+		}
+		stats.Distribution = append(stats.Distribution, bucket)
+	}
+	if stats.Count == 0 {
+		return stats
+	}
+
+	stats.Mean = float64(sum) / n
+	stats.Median = scaleMedian(counts, stats.Count)
+	stats.MeanLow, stats.MeanHigh = stats.Mean, stats.Mean
+	if stats.Count > 1 {
+		squares := 0.0
+		for i, count := range counts {
+			d := float64(i+1) - stats.Mean
+			squares += d * d * float64(count)
+		}
+		stats.StdDev = math.Sqrt(squares / (n - 1))
+		margin := tQuantile(stats.Count-1) * stats.StdDev / math.Sqrt(n)
+		stats.MeanLow = math.Max(stats.Mean-margin, 1)
+		stats.MeanHigh = math.Min(stats.Mean+margin, float64(len(counts)))
+	}
+
+	if len(counts) >= 3 {
+		net := &NetScore{Promoters: counts[len(counts)-1], Passives: counts[len(counts)-2]}
+		net.Detractors = stats.Count - net.Promoters - net.Passives
+		promoters, detractors := float64(net.Promoters)/n, float64(net.Detractors)/n
+		net.Score = (promoters - detractors) * 100
+		// The score is a difference of two proportions of the same sample.
+		margin := z95 * math.Sqrt((promoters+detractors-math.Pow(promoters-detractors, 2))/n) * 100
+		net.Low, net.High = math.Max(net.Score-margin, -100), math.Min(net.Score+margin, 100)
+		stats.NetScore = net
+	}
+	return stats
+}
+
+// scaleMedian returns the median response from per-value counts, averaging
+// the two middle responses when there is an even number of them.
+func scaleMedian(counts []int, total int) float64 {
+	valueAt := func(k int) int {
+		seen := 0
+		for i, count := range counts {
+			seen += count
+			if seen > k {
+				return i + 1
+			}
+		}
+		return 0
+	}
+	if total%2 == 1 {
+		return float64(valueAt(total / 2))
+	}
+	return float64(valueAt(total/2-1)+valueAt(total/2)) / 2
+}
+
+// wilsonInterval returns the Wilson score interval of k successes in n
+// trials as percentages. Unlike the normal approximation it stays within
+// 0-100 and is usable for small samples.
+func wilsonInterval(k, n int) (float64, float64) {
+	p, fn := float64(k)/float64(n), float64(n)
+	z2 := z95 * z95
+	center := (p + z2/(2*fn)) / (1 + z2/fn)
+	margin := z95 / (1 + z2/fn) * math.Sqrt(p*(1-p)/fn+z2/(4*fn*fn))
+	return math.Max(center-margin, 0) * 100, math.Min(center+margin, 1) * 100
+}
+
+// tTable holds the two-sided 95% quantiles of Student's t distribution for
+// 1 to 30 degrees of freedom.
+var tTable = [...]float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// tQuantile returns the two-sided 95% t quantile for df degrees of freedom,
+// using a series expansion around the normal quantile beyond the table.
+func tQuantile(df int) float64 {
+	if df <= len(tTable) {
+		return tTable[df-1]
+	}
+	z, d := z95, float64(df)
+	return z + (z*z*z+z)/(4*d) + (5*math.Pow(z, 5)+16*z*z*z+3*z)/(96*d*d)
+}
+
+// scaleComparison returns the scale polls in the same series as the poll,
+// or else created from the same template, with their statistics. It
+// returns nil when the poll belongs to neither or is the only one.
+func (s *PollService) scaleComparison(ctx context.Context, pollID int64) (*ScaleComparison, error) {
+	var seriesID, templateID sql.NullInt64
+	err := s.DB.QueryRowContext(ctx, `SELECT series_id, template_id FROM polls WHERE id = $1`, pollID).Scan(&seriesID, &templateID)
+	if err != nil {
+		return nil, err
+	}
+
+	comparison := &ScaleComparison{}
+	var column string
+	var groupID int64
+	switch {
+	case seriesID.Valid:
+		comparison.Basis, column, groupID = "series", "series_id", seriesID.Int64
+	case templateID.Valid:
+		comparison.Basis, column, groupID = "template", "template_id", templateID.Int64
+	default:
+		return nil, nil
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT p.id, p.title, p.start_date, p.end_date, s.value, COUNT(v.id)
+		FROM (
+			SELECT id, title, start_date, end_date FROM polls
+			WHERE `+column+` = $1 AND question_type = 'scale' AND (start_date <= NOW() OR id = $2)
+			ORDER BY start_date DESC, id DESC
+			LIMIT $3
+		) p
+		CROSS JOIN generate_series(1, $4::INT) AS s(value)
+		LEFT JOIN votes v ON v.poll_id = p.id AND v.scale_value = s.value
+		GROUP BY p.id, p.title, p.start_date, p.end_date, s.value
+		ORDER BY p.start_date, p.id, s.value
+	`, groupID, pollID, maxComparedPolls, ScaleMax)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []int
+	for rows.Next() {
+		var point ScalePoint
+		var endDate sql.NullTime
+		var value, count int
+		if err := rows.Scan(&point.PollID, &point.Title, &point.StartDate, &endDate, &value, &count); err != nil {
+			return nil, err
+		}
+		if value == 1 {
+			if endDate.Valid {
+				point.EndDate = &endDate.Time
+			}
+			point.Current = point.PollID == pollID
+			comparison.Polls = append(comparison.Polls, point)
+			counts = counts[:0]
+		}
+		counts = append(counts, count)
+		if value == ScaleMax {
+			comparison.Polls[len(comparison.Polls)-1].Stats = scaleStats(counts)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(comparison.Polls) < 2 {
+		return nil, nil
+	}
+	return comparison, nil
+}
+
+// LinkTemplate records that a poll was created from a template, so its
+// results can be compared with the template's other polls.
+func (s *PollService) LinkTemplate(ctx context.Context, pollID, templateID int64, userID string) error {
+	ctx, span := startPollSpan(ctx, "PollService.LinkTemplate", pollID)
+	defer span.End()
+
+	if _, err := s.GetTemplate(ctx, templateID, userID); err != nil {
+		return err
+	}
+	_, err := s.DB.ExecContext(ctx, `UPDATE polls SET template_id = $1 WHERE id = $2`, templateID, pollID)
+	return err
+}
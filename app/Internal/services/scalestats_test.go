@@ -0,0 +1,174 @@
+package services
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// approx reports whether got is within 0.001 of want.
+func approx(got, want float64) bool {
+	return math.Abs(got-want) < 1e-3
+}
+
+func TestScaleStats(t *testing.T) {
+	tests := []struct {
+		name                  string
+		counts                []int
+		count                 int
+		mean, median, stdDev  float64
+		mode                  []int
+		meanLow, meanHigh     float64
+		net                   *NetScore
+		percents, lows, highs []float64
+	}{
+		{
+			name:     "no responses",
+			counts:   []int{0, 0, 0, 0, 0},
+			mode:     []int{},
+			percents: []float64{0, 0, 0, 0, 0},
+			lows:     []float64{0, 0, 0, 0, 0},
+			highs:    []float64{0, 0, 0, 0, 0},
+		},
+		{
+			name:   "single response",
+			counts: []int{1, 0, 0, 0, 0},
+			count:  1, mean: 1, median: 1, mode: []int{1},
+			meanLow: 1, meanHigh: 1,
+			net:      &NetScore{Detractors: 1, Score: -100, Low: -100, High: -100},
+			percents: []float64{100, 0, 0, 0, 0},
+			lows:     []float64{20.654, 0, 0, 0, 0},
+			highs:    []float64{100, 79.346, 79.346, 79.346, 79.346},
+		},
+		{
+			name:   "two modes",
+			counts: []int{0, 2, 0, 2, 0},
+			count:  4, mean: 3, median: 3, stdDev: 1.1547, mode: []int{2, 4},
+			meanLow: 1.1626, meanHigh: 4.8374,
+			net:      &NetScore{Passives: 2, Detractors: 2, Score: -50, Low: -98.9991, High: -1.0009},
+			percents: []float64{0, 50, 0, 50, 0},
+			lows:     []float64{0, 15.004, 0, 15.004, 0},
+			highs:    []float64{48.990, 84.996, 48.990, 84.996, 48.990},
+		},
+		{
+			name:   "uniform",
+			counts: []int{1, 1, 1, 1, 1},
+			count:  5, mean: 3, median: 3, stdDev: 1.5811, mode: []int{1, 2, 3, 4, 5},
+			meanLow: 1.0368, meanHigh: 4.9632,
+			net:      &NetScore{Promoters: 1, Passives: 1, Detractors: 3, Score: -40, Low: -100, High: 30.1218},
+			percents: []float64{20, 20, 20, 20, 20},
+			lows:     []float64{3.622, 3.622, 3.622, 3.622, 3.622},
+			highs:    []float64{62.447, 62.447, 62.447, 62.447, 62.447},
+		},
+		{
+			name:   "all promoters",
+			counts: []int{0, 0, 0, 0, 10},
+			count:  10, mean: 5, median: 5, mode: []int{5},
+			meanLow: 5, meanHigh: 5,
+			net:      &NetScore{Promoters: 10, Score: 100, Low: 100, High: 100},
+			percents: []float64{0, 0, 0, 0, 100},
+			lows:     []float64{0, 0, 0, 0, 72.246},
+			highs:    []float64{27.754, 27.754, 27.754, 27.754, 100},
+		},
+		{
+			name:   "mean interval clipped to scale",
+			counts: []int{3, 0, 0, 0, 1},
+			count:  4, mean: 2, median: 1, stdDev: 2, mode: []int{1},
+			meanLow: 1, meanHigh: 5,
+			net:      &NetScore{Promoters: 1, Detractors: 3, Score: -50, Low: -100, High: 34.8689},
+			percents: []float64{75, 0, 0, 0, 25},
+			lows:     []float64{30.064, 0, 0, 0, 4.559},
+			highs:    []float64{95.441, 48.990, 48.990, 48.990, 69.936},
+		},
+		{
+			name:   "even count median between values",
+			counts: []int{1, 0, 1},
+			count:  2, mean: 2, median: 2, stdDev: 1.4142, mode: []int{1, 3},
+			meanLow: 1, meanHigh: 3,
+			net:      &NetScore{Promoters: 1, Detractors: 1, Score: 0, Low: -100, High: 100},
+			percents: []float64{50, 0, 50},
+			lows:     []float64{9.453, 0, 9.453},
+			highs:    []float64{90.547, 65.762, 90.547},
+		},
+		{
+			name:   "no net score below three points",
+			counts: []int{3, 1},
+			count:  4, mean: 1.25, median: 1, stdDev: 0.5, mode: []int{1},
+			meanLow: 1, meanHigh: 2,
+			percents: []float64{75, 25},
+			lows:     []float64{30.064, 4.559},
+			highs:    []float64{95.441, 69.936},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := scaleStats(tt.counts)
+			if got.Count != tt.count {
+				t.Errorf("Count = %d, want %d", got.Count, tt.count)
+			}
+			if !approx(got.Mean, tt.mean) || !approx(got.Median, tt.median) || !approx(got.StdDev, tt.stdDev) {
+				t.Errorf("Mean, Median, StdDev = %v, %v, %v, want %v, %v, %v", got.Mean, got.Median, got.StdDev, tt.mean, tt.median, tt.stdDev)
+			}
+			if !reflect.DeepEqual(got.Mode, tt.mode) {
+				t.Errorf("Mode = %v, want %v", got.Mode, tt.mode)
+			}
+			if !approx(got.MeanLow, tt.meanLow) || !approx(got.MeanHigh, tt.meanHigh) {
+				t.Errorf("mean interval = [%v, %v], want [%v, %v]", got.MeanLow, got.MeanHigh, tt.meanLow, tt.meanHigh)
+			}
+			if len(got.Distribution) != len(tt.counts) {
+				t.Fatalf("Distribution has %d buckets, want %d", len(got.Distribution), len(tt.counts))
+			}
+			for i, bucket := range got.Distribution {
+				if bucket.Value != i+1 || bucket.Count != tt.counts[i] || !approx(bucket.Percent, tt.percents[i]) ||
+					!approx(bucket.Low, tt.lows[i]) || !approx(bucket.High, tt.highs[i]) {
+					t.Errorf("Distribution[%d] = %+v, want value %d, count %d, %v%% in [%v, %v]",
+						i, bucket, i+1, tt.counts[i], tt.percents[i], tt.lows[i], tt.highs[i])
+				}
+			}
+			switch {
+			case tt.net == nil && got.NetScore != nil:
+				t.Errorf("NetScore = %+v, want none", *got.NetScore)
+			case tt.net != nil && got.NetScore == nil:
+				t.Errorf("NetScore missing, want %+v", *tt.net)
+			case tt.net != nil:
+				net := *got.NetScore
+				if net.Promoters != tt.net.Promoters || net.Passives != tt.net.Passives || net.Detractors != tt.net.Detractors ||
+					!approx(net.Score, tt.net.Score) || !approx(net.Low, tt.net.Low) || !approx(net.High, tt.net.High) {
+					t.Errorf("NetScore = %+v, want %+v", net, *tt.net)
+				}
+			}
+		})
+	}
+}
+
+func TestTQuantile(t *testing.T) {
+	tests := []struct {
+		df   int
+		want float64
+	}{
+		{1, 12.706},
+		{2, 4.303},
+		{10, 2.228},
+		{30, 2.042},
+		// Beyond the table the expansion must stay close to published values.
+		{31, 2.040},
+		{40, 2.021},
+		{60, 2.000},
+		{120, 1.980},
+		{1000, 1.962},
+	}
+	for _, tt := range tests {
+		if got := tQuantile(tt.df); math.Abs(got-tt.want) > 1.5e-3 {
+			t.Errorf("tQuantile(%d) = %.4f, want %.3f", tt.df, got, tt.want)
+		}
+	}
+
+	prev := math.Inf(1)
+	for df := 1; df <= 500; df++ {
+		got := tQuantile(df)
+		if got >= prev || got <= z95 {
+			t.Fatalf("tQuantile(%d) = %v, want between %v and %v", df, got, z95, prev)
+		}
+		prev = got
+	}
+}
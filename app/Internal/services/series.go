@@ -0,0 +1,386 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"fakidoosuurdoris/app/Internal/models"
+
+	"github.com/lib/pq"
+)
+
+// SeriesLookahead is how long before its start an occurrence is created, so
+// the next poll of a series shows up as upcoming.
+const SeriesLookahead = 24 * time.Hour
+
+// PollSeries recreates a poll on a recurrence rule. Each occurrence starts at
+// a rule date and stays open for DurationMinutes. NextRunAt is nil once the
+// rule's end condition is reached.
+type PollSeries struct {
+	ID              int64           `json:"id"`
+	OwnerID         string          `json:"owner_id"`
+	Title           string          `json:"title"`
+	QuestionType    string          `json:"question_type"`
+	Options         []models.Option `json:"options"`
+	OptionOrder     string          `json:"option_order"`
+	IsAnonymous     bool            `json:"is_anonymous"`
+	Rule            RecurrenceRule  `json:"rule"`
+	StartDate       time.Time       `json:"start_date"`
+	DurationMinutes int             `json:"duration_minutes"`
+	NextRunAt       *time.Time      `json:"next_run_at"`
+	Occurrences     int             `json:"occurrences"`
+	Active          bool            `json:"active"`
+	CreatedAt       time.Time       `json:"created_at"`
+}
+
+// SeriesOccurrence summarises one poll of a series for trend views.
+// AverageScale is set for scale polls and OptionCounts, keyed by option
+// text, for choice polls.
+type SeriesOccurrence struct {
+	PollID       int64          `json:"poll_id"`
+	StartDate    time.Time      `json:"start_date"`
+	EndDate      *time.Time     `json:"end_date"`
+	VoteCount    int            `json:"vote_count"`
+	AverageScale *float64       `json:"average_scale,omitempty"`
+	OptionCounts map[string]int `json:"option_counts,omitempty"`
+}
+
+const seriesColumns = `id, owner_id, title, question_type, options, option_order, is_anonymous,
+	frequency, repeat_interval, weekdays, until, count, start_date, duration_minutes,
+	next_run_at, occurrences, active, created_at`
+
+// CreateSeries stores a new series and creates any occurrence that is
+// already due.
+func (s *PollService) CreateSeries(ctx context.Context, ownerID string, series PollSeries) (*PollSeries, error) {
+	ctx, span := startSpan(ctx, "PollService.CreateSeries")
+	defer span.End()
+
+	if err := series.Rule.Validate(); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(series.Title) == "" || !questionTypes[series.QuestionType] {
+		return nil, fmt.Errorf("%w: title and a valid question type are required", ErrInvalidRecurrence)
+	}
+	if series.DurationMinutes <= 0 {
+		return nil, fmt.Errorf("%w: each occurrence needs an end date after its start", ErrInvalidRecurrence)
+	}
+	if series.OptionOrder != OptionOrderRandom {
+		series.OptionOrder = OptionOrderFixed
+	}
+
+	series.OwnerID = ownerID
+	series.Active = true
+	next := series.Rule.Next(series.StartDate, time.Time{})
+	if !series.Rule.Done(next, 0) {
+		series.NextRunAt = &next
+	}
+
+	options, err := json.Marshal(series.Options)
+	if err != nil {
+		return nil, err
+	}
+	weekdays := make([]int64, len(series.Rule.Weekdays))
+	for i, day := range series.Rule.Weekdays {
+		weekdays[i] = int64(day)
+	}
+
+	err = s.DB.QueryRowContext(ctx, `
+		INSERT INTO poll_series (owner_id, title, question_type, options, option_order, is_anonymous,
+			frequency, repeat_interval, weekdays, until, count, start_date, duration_minutes, next_run_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		RETURNING id, created_at
+	`, ownerID, series.Title, series.QuestionType, string(options), series.OptionOrder, series.IsAnonymous,
+		series.Rule.Frequency, series.Rule.Interval, pq.Array(weekdays), series.Rule.Until, series.Rule.Count,
+		series.StartDate, series.DurationMinutes, series.NextRunAt).Scan(&series.ID, &series.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.InstantiateDueSeries(ctx); err != nil {
+		slog.ErrorContext(ctx, "Failed to create first occurrence of series", "series_id", series.ID, "error", err)
+	}
+	return &series, nil
+}
+
+func scanSeries(row interface{ Scan(...interface{}) error }) (*PollSeries, error) {
+	var series PollSeries
+	var options []byte
+	var weekdays []int64
+	var until, nextRunAt sql.NullTime
+	var count sql.NullInt64
+	err := row.Scan(&series.ID, &series.OwnerID, &series.Title, &series.QuestionType, &options, &series.OptionOrder, &series.IsAnonymous,
+		&series.Rule.Frequency, &series.Rule.Interval, pq.Array(&weekdays), &until, &count, &series.StartDate, &series.DurationMinutes,
+		&nextRunAt, &series.Occurrences, &series.Active, &series.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(options, &series.Options); err != nil {
+		return nil, err
+	}
+	for _, day := range weekdays {
+		series.Rule.Weekdays = append(series.Rule.Weekdays, time.Weekday(day))
+	}
+	if until.Valid {
+		series.Rule.Until = &until.Time
+	}
+	if count.Valid {
+		c := int(count.Int64)
+		series.Rule.Count = &c
+	}
+	if nextRunAt.Valid {
+		series.NextRunAt = &nextRunAt.Time
+	}
+	return &series, nil
+}
+
+// GetSeries returns a series its owner or an admin may see.
+func (s *PollService) GetSeries(ctx context.Context, id int64, userID string) (*PollSeries, error) {
+	ctx, span := startSpan(ctx, "PollService.GetSeries")
+	defer span.End()
+
+	series, err := scanSeries(s.DB.QueryRowContext(ctx, `SELECT `+seriesColumns+` FROM poll_series WHERE id = $1`, id))
+	if err != nil {
+		return nil, err
+	}
+	if series.OwnerID != userID {
+		role, err := s.AuthService.GetUserRole(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if role != "admin" {
+			return nil, sql.ErrNoRows // Unauthorized
+		}
+	}
+	return series, nil
+}
+
+func (s *PollService) ListSeries(ctx context.Context, ownerID string) ([]PollSeries, error) {
+	ctx, span := startSpan(ctx, "PollService.ListSeries")
+	defer span.End()
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+seriesColumns+` FROM poll_series WHERE owner_id = $1 ORDER BY created_at DESC`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []PollSeries
+	for rows.Next() {
+		series, err := scanSeries(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *series)
+	}
+	return list, rows.Err()
+}
+
+// SetSeriesActive pauses or resumes a series. Occurrences that would have
+// ended while it was paused are skipped on resume.
+func (s *PollService) SetSeriesActive(ctx context.Context, id int64, userID string, active bool) error {
+	ctx, span := startSpan(ctx, "PollService.SetSeriesActive")
+	defer span.End()
+
+	if _, err := s.GetSeries(ctx, id, userID); err != nil {
+		return err
+	}
+	_, err := s.DB.ExecContext(ctx, `UPDATE poll_series SET active = $1 WHERE id = $2`, active, id)
+	return err
+}
+
+// RunSeriesScheduler creates due occurrences every interval until ctx is
+// done.
+func (s *PollService) RunSeriesScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.InstantiateDueSeries(ctx); err != nil {
+			slog.ErrorContext(ctx, "Failed to create recurring polls", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// InstantiateDueSeries creates every occurrence that starts within
+// SeriesLookahead. Occurrences that would already have ended, for instance
+// after downtime or a pause, are skipped but still count towards the rule's
+// COUNT. Each series is advanced in its own transaction together with the
+// polls created for it, so a failing series neither blocks the others nor
+// leaves polls behind that a later run would create again.
+func (s *PollService) InstantiateDueSeries(ctx context.Context) error {
+	ctx, span := startSpan(ctx, "PollService.InstantiateDueSeries")
+	defer span.End()
+
+	now := time.Now()
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id FROM poll_series WHERE active AND next_run_at <= $1
+	`, now.Add(SeriesLookahead))
+	if err != nil {
+		return err
+	}
+	var due []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, id := range due {
+		if err := s.instantiateSeries(ctx, id, now); err != nil {
+			errs = append(errs, fmt.Errorf("series %d: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// instantiateSeries creates the due occurrences of one series and advances
+// it. A series another run has locked, or that is no longer due, is left
+// alone.
+func (s *PollService) instantiateSeries(ctx context.Context, id int64, now time.Time) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	series, err := scanSeries(tx.QueryRowContext(ctx, `
+		SELECT `+seriesColumns+` FROM poll_series
+		WHERE id = $1 AND active AND next_run_at <= $2
+		FOR UPDATE SKIP LOCKED
+	`, id, now.Add(SeriesLookahead)))
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	next := *series.NextRunAt
+	created := series.Occurrences
+	duration := time.Duration(series.DurationMinutes) * time.Minute
+	for !next.After(now.Add(SeriesLookahead)) {
+		end := next.Add(duration)
+		if end.After(now) {
+			poll, err := s.createPoll(ctx, tx, series.Title, series.QuestionType, series.Options, series.OptionOrder,
+				series.IsAnonymous, series.OwnerID, next, &end, "")
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, `UPDATE polls SET series_id = $1 WHERE id = $2`, series.ID, poll.ID); err != nil {
+				return err
+			}
+		}
+		created++
+		next = series.Rule.Next(series.StartDate, next)
+		if series.Rule.Done(next, created) {
+			break
+		}
+	}
+
+	var nextRunAt *time.Time
+	if !series.Rule.Done(next, created) {
+		nextRunAt = &next
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE poll_series SET next_run_at = $1, occurrences = $2 WHERE id = $3`, nextRunAt, created, series.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SeriesTrend returns the series' occurrences in start order with their
+// turnout and results.
+func (s *PollService) SeriesTrend(ctx context.Context, id int64, userID string) (*PollSeries, []SeriesOccurrence, error) {
+	ctx, span := startSpan(ctx, "PollService.SeriesTrend")
+	defer span.End()
+
+	series, err := s.GetSeries(ctx, id, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT p.id, p.start_date, p.end_date, COUNT(DISTINCT COALESCE(v.voted_by, v.guest_key)), AVG(v.scale_value)
+		FROM polls p
+		LEFT JOIN votes v ON v.poll_id = p.id
+		WHERE p.series_id = $1
+		GROUP BY p.id, p.start_date, p.end_date
+		ORDER BY p.start_date
+	`, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	var occurrences []SeriesOccurrence
+	index := make(map[int64]int)
+	for rows.Next() {
+		var o SeriesOccurrence
+		var endDate sql.NullTime
+		var average sql.NullFloat64
+		if err := rows.Scan(&o.PollID, &o.StartDate, &endDate, &o.VoteCount, &average); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		if endDate.Valid {
+			o.EndDate = &endDate.Time
+		}
+		if average.Valid && series.QuestionType == "scale" {
+			o.AverageScale = &average.Float64
+		}
+		index[o.PollID] = len(occurrences)
+		occurrences = append(occurrences, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if series.QuestionType == "single_choice" || series.QuestionType == "multiple_choice" {
+		rows, err := s.DB.QueryContext(ctx, `
+			SELECT o.poll_id, o.option_text, COUNT(v.id)
+			FROM options o
+			JOIN polls p ON p.id = o.poll_id
+			LEFT JOIN votes v ON v.option_id = o.id
+			WHERE p.series_id = $1
+			GROUP BY o.poll_id, o.id, o.option_text
+		`, id)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var pollID int64
+			var text string
+			var count int
+			if err := rows.Scan(&pollID, &text, &count); err != nil {
+				return nil, nil, err
+			}
+			o := &occurrences[index[pollID]]
+			if o.OptionCounts == nil {
+				o.OptionCounts = make(map[string]int)
+			}
+			o.OptionCounts[text] += count
+		}
+		if err := rows.Err(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return series, occurrences, nil
+}
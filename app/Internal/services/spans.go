@@ -0,0 +1,23 @@
+package services
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer starts the spans services record for their methods. It comes from
+// the global provider, which records nothing until tracing is set up.
+var tracer = otel.Tracer("fakidoosuurdoris/app/Internal/services")
+
+// startSpan starts a span for a service method.
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name)
+}
+
+// startPollSpan starts a span for a service method acting on a poll.
+func startPollSpan(ctx context.Context, name string, pollID int64) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attribute.Int64("poll.id", pollID)))
+}
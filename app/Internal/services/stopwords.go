@@ -0,0 +1,47 @@
+package services
+
+import "strings"
+
+// DefaultTextLanguage selects the stop-word list when none is given.
+const DefaultTextLanguage = "en"
+
+// stopWords lists, per language, words too common to be worth counting in
+// text answers. Entries are in normalized form, so without apostrophes.
+var stopWords = map[string]map[string]bool{
+	"en": wordSet(`a about above after again against all am an and any are as at be because been before
+		being below between both but by can could did do does doing down during each few for from further
+		had has have having he her here hers herself him himself his how i if in into is it its itself
+		just me more most my myself no nor not now of off on once only or other our ours ourselves out
+		over own same she should so some such than that the their theirs them themselves then there these
+		they this those through to too under until up very was we were what when where which while who
+		whom why will with would you your yours yourself yourselves im ive id dont doesnt didnt isnt
+		wasnt arent cant wont also get got really much many one like`),
+	"de": wordSet(`aber alle allem allen aller alles als also am an ander andere anderem anderen anderer
+		anderes auch auf aus bei bin bis bist da damit dann das dass dein deine dem den der des dich die
+		dies diese diesem diesen dieser dieses dir doch dort du durch ein eine einem einen einer eines er
+		es etwas euch euer eure für gegen gewesen hab habe haben hat hatte hier hin hinter ich ihm ihn
+		ihnen ihr ihre im in ist ja jede jedem jeden jeder jedes kein keine können man mein meine mich
+		mir mit muss nach nicht nichts noch nun nur ob oder ohne sehr sein seine sich sie sind so soll
+		sondern über um und uns unser unter viel vom von vor war waren was weil wenn wer wie wir wird
+		wo zu zum zur`),
+	"fr": wordSet(`à au aux avec ce ces cette dans de des du elle elles en est et eu il ils je la le les
+		leur leurs lui ma mais me même mes moi mon ne nos notre nous on ou où par pas pour qu que qui sa
+		se ses si son sur ta te tes toi ton tu un une vos votre vous y c d j l m n s t été être avoir
+		fait très plus aussi comme tout tous`),
+	"es": wordSet(`a al algo como con de del el ella ellas ellos en era es esa ese eso esta este esto
+		estos fue ha hay la las le les lo los me mi mis muy más nada ni no nos o os para pero por que
+		qué se ser si sin sobre su sus también te tu tus un una uno unos y ya yo`),
+}
+
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+// TextLanguages returns the languages that have a stop-word list.
+func TextLanguages() []string {
+	return []string{"de", "en", "es", "fr"}
+}
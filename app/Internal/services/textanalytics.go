@@ -0,0 +1,420 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+var ErrInvalidTag = errors.New("invalid answer tag")
+
+// TextAnalytics summarises the answers of a text poll. The answer groups
+// themselves are the poll's result rows.
+type TextAnalytics struct {
+	Language string      `json:"language"`
+	Answers  int         `json:"answers"`
+	Distinct int         `json:"distinct"`
+	Words    []TermCount `json:"words"`
+	Bigrams  []TermCount `json:"bigrams"`
+	Tags     []TagCount  `json:"tags"`
+}
+
+type TermCount struct {
+	Term  string `json:"term"`
+	Count int    `json:"count"`
+}
+
+// TagCount is how often answers coded with a tag were given. Groups is the
+// number of answer groups carrying it.
+type TagCount struct {
+	ID      int64   `json:"id"`
+	Name    string  `json:"name"`
+	Groups  int     `json:"groups"`
+	Count   int     `json:"count"`
+	Percent float64 `json:"percent"`
+}
+
+// AnswerTag is an admin-defined category for coding a text poll's answers.
+type AnswerTag struct {
+	ID     int64  `json:"id"`
+	PollID int64  `json:"poll_id"`
+	Name   string `json:"name"`
+}
+
+const (
+	topTerms = 30
+	// maxVariants caps the original spellings listed per answer group.
+	maxVariants = 10
+	// maxFuzzyLength is the longest normalized answer, in runes, that is
+	// compared by edit distance; longer answers are only grouped when equal.
+	maxFuzzyLength = 80
+)
+
+// NormalizeAnswer lower-cases an answer, drops apostrophes and turns runs
+// of whitespace, punctuation and symbols into single spaces, so "Yes",
+// "yes " and "YES!" all become "yes". Answers made only of symbols, such as
+// emoji, keep their trimmed form.
+func NormalizeAnswer(s string) string {
+	var b strings.Builder
+	gap := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r == '\'' || r == '’':
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			if gap && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			gap = false
+			b.WriteRune(r)
+		default:
+			gap = true
+		}
+	}
+	if b.Len() == 0 {
+		return strings.TrimSpace(strings.ToLower(s))
+	}
+	return b.String()
+}
+
+// editDistance returns the Levenshtein distance between a and b, or limit+1
+// as soon as it is certain to exceed limit.
+func editDistance(a, b []rune, limit int) int {
+	if d := len(a) - len(b); d > limit || -d > limit {
+		return limit + 1
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// fuzzyLimit is the edit distance up to which two answers of this length
+// count as the same: none for short answers, where one letter changes the
+// meaning, and about one typo per six letters otherwise.
+func fuzzyLimit(length int) int {
+	if length < 5 || length > maxFuzzyLength {
+		return 0
+	}
+	return min(length/6+1, 3)
+}
+
+type rawAnswer struct {
+	Text  string
+	Count int
+}
+
+type answerGroup struct {
+	key      string
+	runes    []rune
+	count    int
+	variants map[string]int
+}
+
+// groupAnswers merges answers that normalize equally and then clusters
+// near-duplicates: each group, most frequent first, joins the first
+// cluster whose leading answer is within fuzzyLimit edits.
+func groupAnswers(answers []rawAnswer) (clusters [][]*answerGroup, distinct int) {
+	byKey := make(map[string]*answerGroup)
+	var groups []*answerGroup
+	for _, a := range answers {
+		key := NormalizeAnswer(a.Text)
+		g, ok := byKey[key]
+		if !ok {
+			g = &answerGroup{key: key, runes: []rune(key), variants: make(map[string]int)}
+			byKey[key] = g
+			groups = append(groups, g)
+		}
+		g.count += a.Count
+		g.variants[strings.TrimSpace(a.Text)] += a.Count
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].count != groups[j].count {
+			return groups[i].count > groups[j].count
+		}
+		return groups[i].key < groups[j].key
+	})
+
+	for _, g := range groups {
+		joined := false
+		for i, cluster := range clusters {
+			lead := cluster[0]
+			limit := min(fuzzyLimit(len(lead.runes)), fuzzyLimit(len(g.runes)))
+			if limit > 0 && editDistance(lead.runes, g.runes, limit) <= limit {
+				clusters[i] = append(cluster, g)
+				joined = true
+				break
+			}
+		}
+		if !joined {
+			clusters = append(clusters, []*answerGroup{g})
+		}
+	}
+	return clusters, len(groups)
+}
+
+// topCounts returns the n most frequent terms, ties in alphabetical order.
+func topCounts(counts map[string]int, n int) []TermCount {
+	terms := make([]TermCount, 0, len(counts))
+	for term, count := range counts {
+		terms = append(terms, TermCount{Term: term, Count: count})
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].Count != terms[j].Count {
+			return terms[i].Count > terms[j].Count
+		}
+		return terms[i].Term < terms[j].Term
+	})
+	if len(terms) > n {
+		terms = terms[:n]
+	}
+	return terms
+}
+
+// analyzeText groups a text poll's answers into result rows and counts the
+// words and bigrams used, leaving out stop words of the language. Tags are
+// looked up by normalized answer.
+func analyzeText(answers []rawAnswer, language string, voters int, tags []AnswerTag, tagged map[string][]int64) ([]ResultRow, *TextAnalytics) {
+	stop, ok := stopWords[language]
+	if !ok {
+		language = DefaultTextLanguage
+		stop = stopWords[language]
+	}
+
+	analytics := &TextAnalytics{Language: language, Words: []TermCount{}, Bigrams: []TermCount{}, Tags: []TagCount{}}
+	words := make(map[string]int)
+	bigrams := make(map[string]int)
+	for _, a := range answers {
+		analytics.Answers += a.Count
+		var prev string
+		for _, word := range strings.Fields(NormalizeAnswer(a.Text)) {
+			if stop[word] || len([]rune(word)) < 2 {
+				prev = ""
+				continue
+			}
+			words[word] += a.Count
+			if prev != "" {
+				bigrams[prev+" "+word] += a.Count
+			}
+			prev = word
+		}
+	}
+	analytics.Words = topCounts(words, topTerms)
+	analytics.Bigrams = topCounts(bigrams, topTerms)
+
+	clusters, distinct := groupAnswers(answers)
+	analytics.Distinct = distinct
+
+	tagNames := make(map[int64]string)
+	tagTotals := make(map[int64]*TagCount)
+	for _, tag := range tags {
+		tagNames[tag.ID] = tag.Name
+		tagTotals[tag.ID] = &TagCount{ID: tag.ID, Name: tag.Name}
+	}
+
+	rows := make([]ResultRow, 0, len(clusters))
+	for _, cluster := range clusters {
+		row := ResultRow{}
+		variants := make(map[string]int)
+		tagIDs := make(map[int64]bool)
+		for _, g := range cluster {
+			row.Count += g.count
+			row.Keys = append(row.Keys, g.key)
+			for v, n := range g.variants {
+				variants[v] += n
+			}
+			for _, id := range tagged[g.key] {
+				tagIDs[id] = true
+			}
+		}
+		for _, v := range topCounts(variants, maxVariants) {
+			row.Variants = append(row.Variants, v.Term)
+		}
+		row.Label = row.Variants[0]
+		for _, tag := range tags {
+			if tagIDs[tag.ID] {
+				row.Tags = append(row.Tags, tag.Name)
+				tagTotals[tag.ID].Groups++
+				tagTotals[tag.ID].Count += row.Count
+			}
+		}
+		if voters > 0 {
+			row.Percent = float64(row.Count) * 100 / float64(voters)
+		}
+		rows = append(rows, row)
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Count > rows[j].Count })
+
+	for _, tag := range tags {
+		total := tagTotals[tag.ID]
+		if voters > 0 {
+			total.Percent = float64(total.Count) * 100 / float64(voters)
+		}
+		analytics.Tags = append(analytics.Tags, *total)
+	}
+	return rows, analytics
+}
+
+// textResults loads a text poll's answers and tags and fills in its result
+// rows and analytics.
+func (s *PollService) textResults(ctx context.Context, results *PollResults, language string) error {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT text_answer, COUNT(*)
+		FROM votes
+		WHERE poll_id = $1 AND text_answer IS NOT NULL
+		GROUP BY text_answer
+	`, results.PollID)
+	if err != nil {
+		return err
+	}
+	var answers []rawAnswer
+	for rows.Next() {
+		var a rawAnswer
+		if err := rows.Scan(&a.Text, &a.Count); err != nil {
+			rows.Close()
+			return err
+		}
+		answers = append(answers, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	tags, err := s.ListAnswerTags(ctx, results.PollID)
+	if err != nil {
+		return err
+	}
+	tagged := make(map[string][]int64)
+	rows, err = s.DB.QueryContext(ctx, `
+		SELECT a.answer, a.tag_id
+		FROM answer_tag_assignments a
+		JOIN answer_tags t ON t.id = a.tag_id
+		WHERE t.poll_id = $1
+	`, results.PollID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var answer string
+		var tagID int64
+		if err := rows.Scan(&answer, &tagID); err != nil {
+			return err
+		}
+		tagged[answer] = append(tagged[answer], tagID)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	results.Rows, results.Text = analyzeText(answers, language, results.VoterCount, tags, tagged)
+	return nil
+}
+
+func (s *PollService) ListAnswerTags(ctx context.Context, pollID int64) ([]AnswerTag, error) {
+	ctx, span := startPollSpan(ctx, "PollService.ListAnswerTags", pollID)
+	defer span.End()
+
+	rows, err := s.DB.QueryContext(ctx, `SELECT id, poll_id, name FROM answer_tags WHERE poll_id = $1 ORDER BY LOWER(name), id`, pollID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []AnswerTag
+	for rows.Next() {
+		var tag AnswerTag
+		if err := rows.Scan(&tag.ID, &tag.PollID, &tag.Name); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+func (s *PollService) CreateAnswerTag(ctx context.Context, pollID int64, name string) (*AnswerTag, error) {
+	ctx, span := startPollSpan(ctx, "PollService.CreateAnswerTag", pollID)
+	defer span.End()
+
+	name = strings.TrimSpace(name)
+	if name == "" || len([]rune(name)) > 60 {
+		return nil, ErrInvalidTag
+	}
+	tag := AnswerTag{PollID: pollID, Name: name}
+	err := s.DB.QueryRowContext(ctx, `
+		INSERT INTO answer_tags (poll_id, name) VALUES ($1, $2)
+		ON CONFLICT (poll_id, name) DO UPDATE SET name = EXCLUDED.name
+		RETURNING id
+	`, pollID, name).Scan(&tag.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+func (s *PollService) DeleteAnswerTag(ctx context.Context, pollID, tagID int64) error {
+	ctx, span := startPollSpan(ctx, "PollService.DeleteAnswerTag", pollID)
+	defer span.End()
+
+	result, err := s.DB.ExecContext(ctx, `DELETE FROM answer_tags WHERE id = $1 AND poll_id = $2`, tagID, pollID)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+// TagAnswers adds a tag to, or with tagged false removes it from, the given
+// answers. Answers are normalized first, so the tag covers every spelling.
+func (s *PollService) TagAnswers(ctx context.Context, pollID, tagID int64, answers []string, tagged bool) error {
+	ctx, span := startPollSpan(ctx, "PollService.TagAnswers", pollID)
+	defer span.End()
+
+	var exists bool
+	err := s.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM answer_tags WHERE id = $1 AND poll_id = $2)`, tagID, pollID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	keys := make([]string, 0, len(answers))
+	for _, answer := range answers {
+		if key := NormalizeAnswer(answer); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if tagged {
+		_, err = s.DB.ExecContext(ctx, `
+			INSERT INTO answer_tag_assignments (tag_id, answer)
+			SELECT $1, UNNEST($2::TEXT[])
+			ON CONFLICT DO NOTHING
+		`, tagID, pq.Array(keys))
+	} else {
+		_, err = s.DB.ExecContext(ctx, `DELETE FROM answer_tag_assignments WHERE tag_id = $1 AND answer = ANY($2)`, tagID, pq.Array(keys))
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		limit int
+		want  int
+	}{
+		{"", "", 3, 0},
+		{"kitten", "kitten", 3, 0},
+		{"", "abc", 3, 3},
+		{"kitten", "sitting", 3, 3},
+		{"flaw", "lawn", 3, 2},
+		{"café", "cafe", 3, 1},
+		{"ab", "ba", 3, 2},
+		// Beyond the limit only limit+1 is reported.
+		{"kitten", "sitting", 2, 3},
+		{"short", "much longer", 2, 3},
+		{"abcdef", "uvwxyz", 1, 2},
+	}
+	for _, tt := range tests {
+		if got := editDistance([]rune(tt.a), []rune(tt.b), tt.limit); got != tt.want {
+			t.Errorf("editDistance(%q, %q, %d) = %d, want %d", tt.a, tt.b, tt.limit, got, tt.want)
+		}
+		if got := editDistance([]rune(tt.b), []rune(tt.a), tt.limit); got != tt.want {
+			t.Errorf("editDistance(%q, %q, %d) = %d, want %d", tt.b, tt.a, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestGroupAnswers(t *testing.T) {
+	type group struct {
+		Key   string
+		Count int
+	}
+	tests := []struct {
+		name     string
+		answers  []rawAnswer
+		clusters [][]group
+		distinct int
+	}{
+		{
+			name:     "none",
+			answers:  nil,
+			clusters: nil,
+		},
+		{
+			name:     "normalized duplicates merge",
+			answers:  []rawAnswer{{"Yes", 2}, {"yes ", 1}, {"YES!", 1}, {"no", 1}},
+			clusters: [][]group{{{"yes", 4}}, {{"no", 1}}},
+			distinct: 2,
+		},
+		{
+			name:     "short answers need to be equal",
+			answers:  []rawAnswer{{"cat", 2}, {"car", 1}, {"yes", 1}, {"yea", 1}},
+			clusters: [][]group{{{"cat", 2}}, {{"car", 1}}, {{"yea", 1}}, {{"yes", 1}}},
+			distinct: 4,
+		},
+		{
+			name:     "typos join the most frequent spelling",
+			answers:  []rawAnswer{{"Coffee", 1}, {"coffe", 1}, {"Coffee!", 3}, {"tea", 2}},
+			clusters: [][]group{{{"coffee", 4}, {"coffe", 1}}, {{"tea", 2}}},
+			distinct: 3,
+		},
+		{
+			name:     "longer answers allow more edits",
+			answers:  []rawAnswer{{"more parking spaces", 3}, {"mor parkin spaces", 1}, {"more barking spaces", 1}, {"less parking", 1}},
+			clusters: [][]group{{{"more parking spaces", 3}, {"mor parkin spaces", 1}, {"more barking spaces", 1}}, {{"less parking", 1}}},
+			distinct: 4,
+		},
+		{
+			name:     "too many edits stay apart",
+			answers:  []rawAnswer{{"remote work", 2}, {"remote desk", 1}},
+			clusters: [][]group{{{"remote work", 2}}, {{"remote desk", 1}}},
+			distinct: 2,
+		},
+		{
+			name:     "ties ordered by key",
+			answers:  []rawAnswer{{"pears", 1}, {"apple", 1}, {"apples", 1}},
+			clusters: [][]group{{{"apple", 1}, {"apples", 1}}, {{"pears", 1}}},
+			distinct: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clusters, distinct := groupAnswers(tt.answers)
+			var got [][]group
+			for _, cluster := range clusters {
+				var groups []group
+				for _, g := range cluster {
+					groups = append(groups, group{g.key, g.count})
+				}
+				got = append(got, groups)
+			}
+			if !reflect.DeepEqual(got, tt.clusters) || distinct != tt.distinct {
+				t.Errorf("groupAnswers() = %v, %d, want %v, %d", got, distinct, tt.clusters, tt.distinct)
+			}
+		})
+	}
+}
+
+func TestGroupAnswersKeepsVariants(t *testing.T) {
+	clusters, _ := groupAnswers([]rawAnswer{{"Yes", 2}, {" Yes ", 1}, {"yes", 1}, {"YES!", 1}})
+	want := map[string]int{"Yes": 3, "yes": 1, "YES!": 1}
+	if len(clusters) != 1 || len(clusters[0]) != 1 {
+		t.Fatalf("groupAnswers() = %v clusters, want one group", clusters)
+	}
+	if got := clusters[0][0].variants; !reflect.DeepEqual(got, want) {
+		t.Errorf("variants = %v, want %v", got, want)
+	}
+}
@@ -3,9 +3,11 @@ package services
 import (
 	"context"
 	"database/sql"
-	"log"
+	"log/slog"
+	"time"
 
 	"fakidoosuurdoris/app/Internal/models"
+	"fakidoosuurdoris/app/Internal/tracing"
 
 	"firebase.google.com/go/v4/auth"
 )
@@ -35,7 +37,9 @@ func (s *UserService) GetUserByID(ctx context.Context, id string) (*models.User,
 func (s *UserService) UpdateUser(ctx context.Context, id, firstname, lastname, email string) error {
 	// Update Firebase Authentication email
 	params := (&auth.UserToUpdate{}).Email(email)
-	_, err := s.AuthClient.UpdateUser(ctx, id, params)
+	idCtx, done := tracing.Identity(ctx, "UpdateUser")
+	_, err := s.AuthClient.UpdateUser(idCtx, id, params)
+	done(err)
 	if err != nil {
 		return err
 	}
@@ -48,7 +52,9 @@ func (s *UserService) UpdateUser(ctx context.Context, id, firstname, lastname, e
 func (s *UserService) UpdatePassword(ctx context.Context, id, newPassword string) error {
 	// Update Firebase Authentication password
 	params := (&auth.UserToUpdate{}).Password(newPassword)
-	_, err := s.AuthClient.UpdateUser(ctx, id, params)
+	idCtx, done := tracing.Identity(ctx, "UpdateUser")
+	_, err := s.AuthClient.UpdateUser(idCtx, id, params)
+	done(err)
 	return err
 }
 
@@ -65,12 +71,263 @@ func (s *UserService) GetUserPollsCount(ctx context.Context, userID string) (int
 func (s *UserService) GetUserRole(ctx context.Context, userID string) (string, error) {
 	query := "SELECT role FROM users WHERE id = $1"
 	var role string
-	log.Printf("Fetching role for user ID: %s", userID)
+	slog.DebugContext(ctx, "Fetching user role", "user_id", userID)
 	err := s.DB.QueryRowContext(ctx, query, userID).Scan(&role)
 	if err != nil {
-		log.Printf("Failed to fetch role for user ID %s: %v", userID, err)
+		slog.ErrorContext(ctx, "Failed to fetch user role", "user_id", userID, "error", err)
 		return "", err
 	}
-	log.Printf("Role for user ID %s: %s", userID, role)
+	slog.DebugContext(ctx, "Fetched user role", "user_id", userID, "role", role)
 	return role, nil
 }
+
+// ExportedVote is a vote the user cast on a non-anonymous poll, as included in
+// their data export.
+type ExportedVote struct {
+	PollID       int64     `json:"poll_id"`
+	PollTitle    string    `json:"poll_title"`
+	QuestionType string    `json:"question_type"`
+	OptionText   *string   `json:"option_text"`
+	TextAnswer   *string   `json:"text_answer"`
+	ScaleValue   *int64    `json:"scale_value"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// UserDataExport is everything the service stores about a single user.
+type UserDataExport struct {
+	ExportedAt time.Time      `json:"exported_at"`
+	Profile    models.User    `json:"profile"`
+	Polls      []models.Poll  `json:"polls"`
+	Votes      []ExportedVote `json:"votes"`
+	// DeletionScheduledFor is when the account will be removed, if the
+	// user asked for that.
+	DeletionScheduledFor *time.Time `json:"deletion_scheduled_for"`
+
+	NotificationPreferences NotificationPreferences `json:"notification_preferences"`
+	ChatLinks               []ChatLink              `json:"chat_links"`
+	Templates               []PollTemplate          `json:"templates"`
+	Series                  []PollSeries            `json:"series"`
+	Invitations             []GuestInvite           `json:"invitations"`
+	Webhooks                []Webhook               `json:"webhooks"`
+}
+
+func (s *UserService) ExportUserData(ctx context.Context, userID string) (*UserDataExport, error) {
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	export := &UserDataExport{
+		ExportedAt: time.Now(),
+		Profile:    *user,
+		Polls:      []models.Poll{},
+		Votes:      []ExportedVote{},
+
+		NotificationPreferences: NotificationPreferences{true, true, true, true},
+		ChatLinks:               []ChatLink{},
+		Templates:               []PollTemplate{},
+		Series:                  []PollSeries{},
+		Invitations:             []GuestInvite{},
+		Webhooks:                []Webhook{},
+	}
+
+	if export.DeletionScheduledFor, err = s.GetPendingDeletion(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	prefs := &export.NotificationPreferences
+	err = s.DB.QueryRowContext(ctx, `
+		SELECT poll_opened, poll_reminder, poll_closed, admin_granted
+		FROM notification_preferences WHERE user_id = $1
+	`, userID).Scan(&prefs.PollOpened, &prefs.PollReminder, &prefs.PollClosed, &prefs.AdminGranted)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, title, user_id, question_type, start_date, end_date, is_anonymous, created_at
+		FROM polls
+		WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var poll models.Poll
+		var endDate sql.NullTime
+		if err := rows.Scan(&poll.ID, &poll.Title, &poll.UserID, &poll.QuestionType, &poll.StartDate, &endDate, &poll.IsAnonymous, &poll.CreatedAt); err != nil {
+			return nil, err
+		}
+		if endDate.Valid {
+			poll.EndDate = &endDate.Time
+		}
+		export.Polls = append(export.Polls, poll)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	linkRows, err := s.DB.QueryContext(ctx, `
+		SELECT team_id, chat_user_id, linked_at FROM chat_links WHERE user_id = $1 ORDER BY linked_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer linkRows.Close()
+	for linkRows.Next() {
+		var link ChatLink
+		if err := linkRows.Scan(&link.TeamID, &link.ChatUserID, &link.LinkedAt); err != nil {
+			return nil, err
+		}
+		export.ChatLinks = append(export.ChatLinks, link)
+	}
+	if err := linkRows.Err(); err != nil {
+		return nil, err
+	}
+
+	templateRows, err := s.DB.QueryContext(ctx, `
+		SELECT id, name, owner_id, shared, title, question_type, options, option_order, is_anonymous, duration_minutes, created_at
+		FROM poll_templates WHERE owner_id = $1 ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer templateRows.Close()
+	for templateRows.Next() {
+		t, err := scanTemplate(templateRows)
+		if err != nil {
+			return nil, err
+		}
+		export.Templates = append(export.Templates, *t)
+	}
+	if err := templateRows.Err(); err != nil {
+		return nil, err
+	}
+
+	seriesRows, err := s.DB.QueryContext(ctx, `
+		SELECT `+seriesColumns+` FROM poll_series WHERE owner_id = $1 ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer seriesRows.Close()
+	for seriesRows.Next() {
+		series, err := scanSeries(seriesRows)
+		if err != nil {
+			return nil, err
+		}
+		export.Series = append(export.Series, *series)
+	}
+	if err := seriesRows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Tokens are only stored hashed, so invites are exported without them.
+	inviteRows, err := s.DB.QueryContext(ctx, `
+		SELECT `+inviteColumns+` FROM guest_invites
+		WHERE poll_id IN (SELECT id FROM polls WHERE user_id = $1)
+		ORDER BY poll_id, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer inviteRows.Close()
+	for inviteRows.Next() {
+		invite, err := scanInvite(inviteRows)
+		if err != nil {
+			return nil, err
+		}
+		export.Invitations = append(export.Invitations, invite)
+	}
+	if err := inviteRows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Signing secrets are left out; they are not the user's data.
+	hookRows, err := s.DB.QueryContext(ctx, `
+		SELECT id, url, '', events, poll_id, active, created_by, created_at
+		FROM webhooks WHERE created_by = $1 ORDER BY id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer hookRows.Close()
+	for hookRows.Next() {
+		hook, err := scanWebhook(hookRows)
+		if err != nil {
+			return nil, err
+		}
+		export.Webhooks = append(export.Webhooks, *hook)
+	}
+	if err := hookRows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Votes on anonymous polls are stored without user_id and are deliberately
+	// not attributable, so they are left out of the export.
+	voteRows, err := s.DB.QueryContext(ctx, `
+		SELECT p.id, p.title, p.question_type, o.option_text, v.text_answer, v.scale_value, v.created_at
+		FROM votes v
+		JOIN polls p ON p.id = v.poll_id
+		LEFT JOIN options o ON o.id = v.option_id
+		WHERE v.user_id = $1 AND p.is_anonymous = FALSE
+		ORDER BY v.created_at
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer voteRows.Close()
+	for voteRows.Next() {
+		var vote ExportedVote
+		var optionText, textAnswer sql.NullString
+		var scaleValue sql.NullInt64
+		if err := voteRows.Scan(&vote.PollID, &vote.PollTitle, &vote.QuestionType, &optionText, &textAnswer, &scaleValue, &vote.CreatedAt); err != nil {
+			return nil, err
+		}
+		if optionText.Valid {
+			vote.OptionText = &optionText.String
+		}
+		if textAnswer.Valid {
+			vote.TextAnswer = &textAnswer.String
+		}
+		if scaleValue.Valid {
+			vote.ScaleValue = &scaleValue.Int64
+		}
+		export.Votes = append(export.Votes, vote)
+	}
+	return export, voteRows.Err()
+}
+
+// RequestAccountDeletion schedules the user's account for removal after the
+// grace period. Requesting again keeps the original schedule.
+func (s *UserService) RequestAccountDeletion(ctx context.Context, userID string, grace time.Duration) (time.Time, error) {
+	now := time.Now()
+	var scheduledFor time.Time
+	err := s.DB.QueryRowContext(ctx, `
+		INSERT INTO account_deletions (user_id, requested_at, scheduled_for)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET user_id = EXCLUDED.user_id
+		RETURNING scheduled_for
+	`, userID, now, now.Add(grace)).Scan(&scheduledFor)
+	return scheduledFor, err
+}
+
+func (s *UserService) CancelAccountDeletion(ctx context.Context, userID string) error {
+	_, err := s.DB.ExecContext(ctx, `DELETE FROM account_deletions WHERE user_id = $1`, userID)
+	return err
+}
+
+// GetPendingDeletion returns when the user's account is scheduled to be
+// removed, or nil if no deletion is pending.
+func (s *UserService) GetPendingDeletion(ctx context.Context, userID string) (*time.Time, error) {
+	var scheduledFor time.Time
+	err := s.DB.QueryRowContext(ctx, `SELECT scheduled_for FROM account_deletions WHERE user_id = $1`, userID).Scan(&scheduledFor)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &scheduledFor, nil
+}
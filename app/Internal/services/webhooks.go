@@ -0,0 +1,518 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	EventPollCreated = "poll.created"
+	EventPollOpened  = "poll.opened"
+	EventPollClosed  = "poll.closed"
+	EventVoteCast    = "vote.cast"
+	EventUserDeleted = "user.deleted"
+)
+
+// WebhookEvents lists every event a webhook can subscribe to.
+var WebhookEvents = []string{EventPollCreated, EventPollOpened, EventPollClosed, EventVoteCast, EventUserDeleted}
+
+const (
+	webhookBatchSize   = 20
+	webhookMaxAttempts = 10
+	// webhookLease keeps a claimed delivery from being picked up again while
+	// its request is still in flight.
+	webhookLease = 5 * time.Minute
+)
+
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+type Webhook struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	PollID    *int64    `json:"poll_id"`
+	Active    bool      `json:"active"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type WebhookDelivery struct {
+	ID            int64
+	WebhookID     int64
+	Event         string
+	Payload       string
+	Status        string
+	Attempts      int
+	ResponseCode  *int
+	LastError     string
+	NextAttemptAt time.Time
+	RedeliveryOf  *int64
+	CreatedAt     time.Time
+	DeliveredAt   *time.Time
+}
+
+// WebhookService records events for matching subscriptions and delivers them
+// in the background. Deliveries are signed with HMAC-SHA256 over
+// "<timestamp>.<body>" using the webhook's secret, sent in the
+// X-Webhook-Signature header as "sha256=<hex>".
+type WebhookService struct {
+	DB     *sql.DB
+	Client *http.Client
+}
+
+func NewWebhookService(db *sql.DB) *WebhookService {
+	return &WebhookService{
+		DB:     db,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (s *WebhookService) requireAdmin(ctx context.Context, userID string) error {
+	var role string
+	if err := s.DB.QueryRowContext(ctx, `SELECT role FROM users WHERE id = $1`, userID).Scan(&role); err != nil {
+		return err
+	}
+	if role != "admin" {
+		return sql.ErrNoRows // Unauthorized
+	}
+	return nil
+}
+
+// CreateWebhook subscribes url to events. A nil pollID subscribes to every
+// poll; an empty secret is replaced with a random one.
+func (s *WebhookService) CreateWebhook(ctx context.Context, adminID, rawURL, secret string, events []string, pollID *int64) (*Webhook, error) {
+	if err := s.requireAdmin(ctx, adminID); err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalidWebhook)
+	}
+	if len(events) == 0 {
+		return nil, fmt.Errorf("%w: choose at least one event", ErrInvalidWebhook)
+	}
+	for _, event := range events {
+		if !isWebhookEvent(event) {
+			return nil, fmt.Errorf("%w: unknown event %q", ErrInvalidWebhook, event)
+		}
+	}
+	if pollID != nil {
+		var exists bool
+		if err := s.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM polls WHERE id = $1)`, *pollID).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("%w: poll %d does not exist", ErrInvalidWebhook, *pollID)
+		}
+	}
+	if secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		secret = hex.EncodeToString(buf)
+	}
+
+	hook := &Webhook{URL: rawURL, Secret: secret, Events: events, PollID: pollID, Active: true, CreatedBy: adminID}
+	err = s.DB.QueryRowContext(ctx, `
+		INSERT INTO webhooks (url, secret, events, poll_id, created_by)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at
+	`, rawURL, secret, pq.Array(events), pollID, adminID).Scan(&hook.ID, &hook.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return hook, nil
+}
+
+func isWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *WebhookService) ListWebhooks(ctx context.Context, adminID string) ([]Webhook, error) {
+	if err := s.requireAdmin(ctx, adminID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, url, secret, events, poll_id, active, created_by, created_at
+		FROM webhooks ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []Webhook
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, *hook)
+	}
+	return hooks, rows.Err()
+}
+
+func (s *WebhookService) GetWebhook(ctx context.Context, adminID string, id int64) (*Webhook, error) {
+	if err := s.requireAdmin(ctx, adminID); err != nil {
+		return nil, err
+	}
+	return scanWebhook(s.DB.QueryRowContext(ctx, `
+		SELECT id, url, secret, events, poll_id, active, created_by, created_at
+		FROM webhooks WHERE id = $1
+	`, id))
+}
+
+func scanWebhook(row interface{ Scan(...interface{}) error }) (*Webhook, error) {
+	var hook Webhook
+	var pollID sql.NullInt64
+	if err := row.Scan(&hook.ID, &hook.URL, &hook.Secret, pq.Array(&hook.Events), &pollID, &hook.Active, &hook.CreatedBy, &hook.CreatedAt); err != nil {
+		return nil, err
+	}
+	if pollID.Valid {
+		hook.PollID = &pollID.Int64
+	}
+	return &hook, nil
+}
+
+func (s *WebhookService) SetWebhookActive(ctx context.Context, adminID string, id int64, active bool) error {
+	if err := s.requireAdmin(ctx, adminID); err != nil {
+		return err
+	}
+	result, err := s.DB.ExecContext(ctx, `UPDATE webhooks SET active = $1 WHERE id = $2`, active, id)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+// DeleteWebhook removes the subscription together with its delivery log.
+func (s *WebhookService) DeleteWebhook(ctx context.Context, adminID string, id int64) error {
+	if err := s.requireAdmin(ctx, adminID); err != nil {
+		return err
+	}
+	result, err := s.DB.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return expectAffected(result)
+}
+
+func expectAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListDeliveries returns the most recent deliveries of a webhook, newest first.
+func (s *WebhookService) ListDeliveries(ctx context.Context, adminID string, webhookID int64, limit int) ([]WebhookDelivery, error) {
+	if err := s.requireAdmin(ctx, adminID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, webhook_id, event, payload, status, attempts, response_code, COALESCE(last_error, ''),
+			next_attempt_at, redelivery_of, created_at, delivered_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`, webhookID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		var code sql.NullInt64
+		var redeliveryOf sql.NullInt64
+		var deliveredAt sql.NullTime
+		if err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &code, &d.LastError,
+			&d.NextAttemptAt, &redeliveryOf, &d.CreatedAt, &deliveredAt); err != nil {
+			return nil, err
+		}
+		if code.Valid {
+			c := int(code.Int64)
+			d.ResponseCode = &c
+		}
+		if redeliveryOf.Valid {
+			d.RedeliveryOf = &redeliveryOf.Int64
+		}
+		if deliveredAt.Valid {
+			d.DeliveredAt = &deliveredAt.Time
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// Redeliver queues a fresh copy of a past delivery. The payload, including
+// its event id, is unchanged so receivers can deduplicate.
+func (s *WebhookService) Redeliver(ctx context.Context, adminID string, deliveryID int64) (int64, error) {
+	if err := s.requireAdmin(ctx, adminID); err != nil {
+		return 0, err
+	}
+	var webhookID int64
+	err := s.DB.QueryRowContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, redelivery_of)
+		SELECT webhook_id, event, payload, id FROM webhook_deliveries WHERE id = $1
+		RETURNING webhook_id
+	`, deliveryID).Scan(&webhookID)
+	return webhookID, err
+}
+
+// Emit queues event for every active webhook subscribed to it, either
+// globally or for pollID. Pass a transaction as db to make the event part of
+// the change that caused it. A nil service emits nothing.
+func (s *WebhookService) Emit(ctx context.Context, db execer, event string, pollID *int64, data interface{}) error {
+	if s == nil {
+		return nil
+	}
+	payload, err := json.Marshal(struct {
+		ID        string      `json:"id"`
+		Event     string      `json:"event"`
+		CreatedAt time.Time   `json:"created_at"`
+		Data      interface{} `json:"data"`
+	}{uuid.NewString(), event, time.Now().UTC(), data})
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT id, $1, $2 FROM webhooks
+		WHERE active AND $1 = ANY(events) AND (poll_id IS NULL OR poll_id = $3)
+	`, event, string(payload), pollID)
+	return err
+}
+
+// PollEventData is the payload data of poll.* events.
+type PollEventData struct {
+	ID           int64      `json:"id"`
+	Title        string     `json:"title"`
+	QuestionType string     `json:"question_type"`
+	CreatorID    string     `json:"creator_id"`
+	StartDate    time.Time  `json:"start_date"`
+	EndDate      *time.Time `json:"end_date"`
+	IsAnonymous  bool       `json:"is_anonymous"`
+}
+
+// VoteEventData is the payload data of vote.cast. UserID is left empty on
+// anonymous polls.
+type VoteEventData struct {
+	PollID     int64   `json:"poll_id"`
+	UserID     string  `json:"user_id,omitempty"`
+	OptionIDs  []int64 `json:"option_ids,omitempty"`
+	ScaleValue *int64  `json:"scale_value,omitempty"`
+	TextAnswer string  `json:"text_answer,omitempty"`
+}
+
+// UserEventData is the payload data of user.deleted.
+type UserEventData struct {
+	ID    string `json:"id"`
+	Polls string `json:"polls"`
+	Votes string `json:"votes"`
+}
+
+// Run emits poll.opened and poll.closed as polls cross their dates and
+// delivers pending events every interval until ctx is done.
+func (s *WebhookService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.emitPollTransitions(ctx, EventPollOpened, "opened_event_at", "start_date <= $1"); err != nil {
+			slog.ErrorContext(ctx, "Failed to emit events", "event", EventPollOpened, "error", err)
+		}
+		if err := s.emitPollTransitions(ctx, EventPollClosed, "closed_event_at", "end_date < $1"); err != nil {
+			slog.ErrorContext(ctx, "Failed to emit events", "event", EventPollClosed, "error", err)
+		}
+		if err := s.DeliverPending(ctx); err != nil {
+			slog.ErrorContext(ctx, "Failed to deliver webhooks", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *WebhookService) emitPollTransitions(ctx context.Context, event, column, condition string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, title, question_type, user_id, start_date, end_date, is_anonymous
+		FROM polls WHERE `+column+` IS NULL AND `+condition+`
+		FOR UPDATE SKIP LOCKED
+	`, now)
+	if err != nil {
+		return err
+	}
+	var polls []PollEventData
+	for rows.Next() {
+		var p PollEventData
+		var endDate sql.NullTime
+		if err := rows.Scan(&p.ID, &p.Title, &p.QuestionType, &p.CreatorID, &p.StartDate, &endDate, &p.IsAnonymous); err != nil {
+			rows.Close()
+			return err
+		}
+		if endDate.Valid {
+			p.EndDate = &endDate.Time
+		}
+		polls = append(polls, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range polls {
+		if err := s.Emit(ctx, tx, event, &p.ID, p); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE polls SET `+column+` = $1 WHERE id = $2`, now, p.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+type pendingDelivery struct {
+	ID       int64
+	Event    string
+	Payload  string
+	Attempts int
+	URL      string
+	Secret   string
+}
+
+// DeliverPending sends due deliveries. Failures are retried with exponential
+// backoff and marked failed after webhookMaxAttempts.
+func (s *WebhookService) DeliverPending(ctx context.Context) error {
+	now := time.Now()
+	rows, err := s.DB.QueryContext(ctx, `
+		UPDATE webhook_deliveries d SET next_attempt_at = $2
+		FROM webhooks w
+		WHERE w.id = d.webhook_id AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= $1
+			ORDER BY id
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING d.id, d.event, d.payload, d.attempts, w.url, w.secret
+	`, now, now.Add(webhookLease), webhookBatchSize)
+	if err != nil {
+		return err
+	}
+	var due []pendingDelivery
+	for rows.Next() {
+		var d pendingDelivery
+		if err := rows.Scan(&d.ID, &d.Event, &d.Payload, &d.Attempts, &d.URL, &d.Secret); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, d := range due {
+		attempts := d.Attempts + 1
+		code, sendErr := s.send(ctx, d)
+		var responseCode interface{}
+		if code != 0 {
+			responseCode = code
+		}
+		if sendErr == nil {
+			_, err = s.DB.ExecContext(ctx, `
+				UPDATE webhook_deliveries
+				SET status = 'delivered', attempts = $1, response_code = $2, last_error = NULL, delivered_at = $3
+				WHERE id = $4
+			`, attempts, responseCode, time.Now(), d.ID)
+		} else {
+			status := "pending"
+			if attempts >= webhookMaxAttempts {
+				status = "failed"
+			}
+			backoff := time.Duration(1<<uint(attempts)) * 30 * time.Second
+			slog.ErrorContext(ctx, "Webhook delivery failed", "delivery_id", d.ID, "url", d.URL, "attempt", attempts, "error", sendErr)
+			_, err = s.DB.ExecContext(ctx, `
+				UPDATE webhook_deliveries
+				SET status = $1, attempts = $2, response_code = $3, last_error = $4, next_attempt_at = $5
+				WHERE id = $6
+			`, status, attempts, responseCode, sendErr.Error(), time.Now().Add(backoff), d.ID)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send posts a delivery and returns the response status code, or 0 if no
+// response was received.
+func (s *WebhookService) send(ctx context.Context, d pendingDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(d.Secret))
+	mac.Write([]byte(timestamp + "." + d.Payload))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewBufferString(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "VoteEasy-Webhooks/1.0")
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s: %s", resp.Status, body)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Storage keeps uploaded files and returns the URL they are served from.
+// LocalStorage is the only implementation for now; a cloud bucket can be
+// plugged in by implementing the same methods.
+type Storage interface {
+	Save(ctx context.Context, name string, r io.Reader) (string, error)
+	Delete(ctx context.Context, url string) error
+	// Owns reports whether url is one Save could have returned.
+	Owns(url string) bool
+}
+
+// LocalStorage writes files into Dir, which the router serves under BaseURL.
+type LocalStorage struct {
+	Dir     string
+	BaseURL string
+}
+
+func NewLocalStorage(dir, baseURL string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalStorage{Dir: dir, BaseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+func (s *LocalStorage) Save(ctx context.Context, name string, r io.Reader) (string, error) {
+	name = filepath.Base(name)
+	f, err := os.OpenFile(filepath.Join(s.Dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return s.BaseURL + "/" + name, nil
+}
+
+// Delete removes a file previously returned by Save. URLs that do not belong
+// to this storage are ignored.
+func (s *LocalStorage) Delete(ctx context.Context, url string) error {
+	if !s.Owns(url) {
+		return nil
+	}
+	name := strings.TrimPrefix(url, s.BaseURL+"/")
+	err := os.Remove(filepath.Join(s.Dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Owns reports whether url names a file directly in Dir, as Save returns
+// them.
+func (s *LocalStorage) Owns(url string) bool {
+	name, ok := strings.CutPrefix(url, s.BaseURL+"/")
+	return ok && name == filepath.Base(name) && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, "?#\\")
+}
@@ -0,0 +1,27 @@
+package storage
+
+import "testing"
+
+func TestLocalStorageOwns(t *testing.T) {
+	s := &LocalStorage{Dir: t.TempDir(), BaseURL: "/uploads"}
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"/uploads/0b6d9c2e.png", true},
+		{"", false},
+		{"/uploads/", false},
+		{"/uploads/..", false},
+		{"/uploads/../config.yaml", false},
+		{"/uploads/a/b.png", false},
+		{"/uploads/a.png?track=1", false},
+		{"/uploadsx/a.png", false},
+		{"https://tracker.example.com/uploads/a.png", false},
+		{"javascript:alert(1)", false},
+	}
+	for _, tt := range tests {
+		if got := s.Owns(tt.url); got != tt.want {
+			t.Errorf("Owns(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package tracing
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentConnector wraps a database connector so that every query,
+// statement and transaction boundary made within a trace gets a span of
+// its own, with the statement's text but not its arguments. Calls made
+// outside a trace, such as by background workers between their passes,
+// are not traced. Like the metrics, query spans end when the first rows
+// are available, not when they have been read.
+func InstrumentConnector(connector driver.Connector) driver.Connector {
+	return &tracedConnector{Connector: connector}
+}
+
+type tracedConnector struct {
+	driver.Connector
+}
+
+func (tc *tracedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := tc.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedConn{Conn: conn, tracer: otel.Tracer(instrumentation)}, nil
+}
+
+// tracedConn forwards the optional driver interfaces to the wrapped
+// connection, returning driver.ErrSkip where it lacks one so database/sql
+// falls back as it would without the wrapper.
+type tracedConn struct {
+	driver.Conn
+	tracer trace.Tracer
+}
+
+// start begins a span for query, or returns a nil span when ctx is not
+// part of a trace.
+func (c *tracedConn) start(ctx context.Context, query string) trace.Span {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return nil
+	}
+	operation := "SQL"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		operation = strings.ToUpper(fields[0])
+	}
+	_, span := c.tracer.Start(ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperationName(operation),
+			semconv.DBQueryText(query),
+		),
+	)
+	return span
+}
+
+func finish(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err == driver.ErrSkip {
+		err = nil
+	}
+	End(span, err)
+}
+
+func (c *tracedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	span := c.start(ctx, query)
+	rows, err := queryer.QueryContext(ctx, query, args)
+	finish(span, err)
+	return rows, err
+}
+
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	span := c.start(ctx, query)
+	result, err := execer.ExecContext(ctx, query, args)
+	finish(span, err)
+	return result, err
+}
+
+func (c *tracedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tracedStmt{Stmt: stmt, conn: c, query: query}, nil
+}
+
+func (c *tracedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	span := c.start(ctx, "BEGIN")
+	var tx driver.Tx
+	var err error
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = beginner.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin()
+	}
+	finish(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedTx{Tx: tx, conn: c, ctx: ctx}, nil
+}
+
+func (c *tracedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *tracedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// tracedTx keeps the context the transaction began in, as Commit and
+// Rollback are not given one.
+type tracedTx struct {
+	driver.Tx
+	conn *tracedConn
+	ctx  context.Context
+}
+
+func (t *tracedTx) Commit() error {
+	span := t.conn.start(t.ctx, "COMMIT")
+	err := t.Tx.Commit()
+	finish(span, err)
+	return err
+}
+
+func (t *tracedTx) Rollback() error {
+	span := t.conn.start(t.ctx, "ROLLBACK")
+	err := t.Tx.Rollback()
+	finish(span, err)
+	return err
+}
+
+// tracedStmt traces each execution of a prepared statement.
+type tracedStmt struct {
+	driver.Stmt
+	conn  *tracedConn
+	query string
+}
+
+func (s *tracedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	span := s.conn.start(ctx, s.query)
+	var result driver.Result
+	var err error
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		result, err = execer.ExecContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			result, err = s.Stmt.Exec(values)
+		}
+	}
+	finish(span, err)
+	return result, err
+}
+
+func (s *tracedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	span := s.conn.start(ctx, s.query)
+	var rows driver.Rows
+	var err error
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		rows, err = queryer.QueryContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			rows, err = s.Stmt.Query(values)
+		}
+	}
+	finish(span, err)
+	return rows, err
+}
+
+// namedValues converts arguments for drivers that only take positional
+// ones.
+func namedValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, driver.ErrSkip
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
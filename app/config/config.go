@@ -1,16 +1,729 @@
 package config
 
 import (
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
 )
 
+// Config is the application's configuration. Load fills it from defaults,
+// an optional YAML file, environment variables and command line flags, each
+// overriding the one before.
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Database DatabaseConfig `yaml:"database"`
+	Cookies  CookieConfig   `yaml:"cookies"`
+	CORS     CORSConfig     `yaml:"cors"`
+	Identity IdentityConfig `yaml:"identity"`
+	Mail     MailConfig     `yaml:"mail"`
+	Chat     ChatConfig     `yaml:"chat"`
+	Reports  ReportConfig   `yaml:"reports"`
+	Accounts AccountConfig  `yaml:"accounts"`
+	Results  ResultsConfig  `yaml:"results"`
+	Embeds   EmbedConfig    `yaml:"embeds"`
+	Features FeatureConfig  `yaml:"features"`
+	Logging  LoggingConfig  `yaml:"logging"`
+	Tracing  TracingConfig  `yaml:"tracing"`
+	Limits   LimitConfig    `yaml:"rate_limits"`
+	Sync     SyncConfig     `yaml:"sync"`
+}
+
+// ServerConfig sets where the server listens and what it serves. TLS is
+// used when both a certificate and a key file are set. BaseURL is the
+// address users reach the server at, used in emails and links.
+// ShutdownTimeout is how long requests and background work may take to
+// finish once the server is asked to stop. DrainDelay is how long the server
+// keeps serving after it starts failing its readiness probe on shutdown, so
+// load balancers stop sending it requests first. TrustedProxies lists the
+// addresses or CIDR ranges of proxies whose X-Forwarded-For header is
+// believed; with none, clients are identified by the address they connect
+// from, so they cannot pose as others to get around rate limits.
+type ServerConfig struct {
+	Addr              string        `yaml:"addr"`
+	BaseURL           string        `yaml:"base_url"`
+	TLSCertFile       string        `yaml:"tls_cert_file"`
+	TLSKeyFile        string        `yaml:"tls_key_file"`
+	TemplatesGlob     string        `yaml:"templates_glob"`
+	UploadDir         string        `yaml:"upload_dir"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`
+	DrainDelay        time.Duration `yaml:"drain_delay"`
+	TrustedProxies    []string      `yaml:"trusted_proxies"`
+}
+
+// DatabaseConfig holds the Postgres connection string and pool limits.
+type DatabaseConfig struct {
+	URL             string        `yaml:"url"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+}
+
+// CookieConfig holds the attributes of the cookies the application sets.
+// GuestSecret signs the cookies of guests who vote without an account.
+type CookieConfig struct {
+	Domain      string        `yaml:"domain"`
+	Secure      bool          `yaml:"secure"`
+	SessionTTL  time.Duration `yaml:"session_ttl"`
+	CSRFTTL     time.Duration `yaml:"csrf_ttl"`
+	GuestSecret string        `yaml:"guest_secret"`
+}
+
+// MaxAge returns ttl in seconds, for http cookies.
+func (c CookieConfig) MaxAge(ttl time.Duration) int {
+	return int(ttl / time.Second)
+}
+
+// CORSConfig lists the origins browsers may call the API from. It defaults
+// to the origin of the server's base URL.
+type CORSConfig struct {
+	Origins []string `yaml:"origins"`
+}
+
+// IdentityConfig selects the identity provider users sign in with.
+// Firebase is the only one supported.
+type IdentityConfig struct {
+	Provider        string `yaml:"provider"`
+	CredentialsFile string `yaml:"credentials_file"`
+}
+
+// MailConfig selects how emails are sent: "log" writes them to LogDir, or
+// logs their recipient and subject if it is empty, and "smtp" sends them. ReminderLead is how long
+// before a poll closes its reminders go out.
+type MailConfig struct {
+	Driver       string        `yaml:"driver"`
+	From         string        `yaml:"from"`
+	LogDir       string        `yaml:"log_dir"`
+	SMTPHost     string        `yaml:"smtp_host"`
+	SMTPPort     int           `yaml:"smtp_port"`
+	SMTPUsername string        `yaml:"smtp_username"`
+	SMTPPassword string        `yaml:"smtp_password"`
+	ReminderLead time.Duration `yaml:"reminder_lead"`
+}
+
+// ChatConfig connects the chat integration to its workspace.
+type ChatConfig struct {
+	SigningSecret string `yaml:"signing_secret"`
+	BotToken      string `yaml:"bot_token"`
+	APIURL        string `yaml:"api_url"`
+}
+
+// ReportConfig sets the branding of PDF reports.
+type ReportConfig struct {
+	LogoPath string `yaml:"logo_path"`
+	FontPath string `yaml:"font_path"`
+}
+
+// AccountConfig sets how long deleted accounts can be restored.
+type AccountConfig struct {
+	DeletionGrace time.Duration `yaml:"deletion_grace"`
+}
+
+// ResultsConfig sets the smallest group shown in crosstabs.
+type ResultsConfig struct {
+	CrosstabMinCell int `yaml:"crosstab_min_cell"`
+}
+
+// EmbedConfig lists the sites allowed to frame the embed pages.
+type EmbedConfig struct {
+	FrameAncestors []string `yaml:"frame_ancestors"`
+}
+
+// LoggingConfig sets how much is logged and in which format, json or text.
+// RouteLevels overrides Level for everything logged while serving a route,
+// keyed by the route pattern such as /polls/:id; quiet routes like probes
+// can log only warnings while a troublesome one logs at debug.
+type LoggingConfig struct {
+	Level       string            `yaml:"level"`
+	Format      string            `yaml:"format"`
+	RouteLevels map[string]string `yaml:"route_levels"`
+}
+
+// TracingConfig sets where traces are sent: exporter none, otlp or stdout,
+// which prints spans for local debugging. Endpoint is the OTLP/HTTP
+// collector, such as localhost:4318; when empty the exporter reads
+// OTEL_EXPORTER_OTLP_ENDPOINT itself. SampleRatio is the share of new
+// traces recorded; requests that arrive with a sampled trace are always
+// recorded.
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter"`
+	Endpoint    string  `yaml:"endpoint"`
+	Insecure    bool    `yaml:"insecure"`
+	ServiceName string  `yaml:"service_name"`
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// SyncConfig is used by the sync command, which uploads poll definitions to
+// the server at Server.BaseURL. Token is the Firebase ID token of an admin.
+type SyncConfig struct {
+	Token string `yaml:"token"`
+}
+
+// LimitConfig sets how fast clients may sign in, register, vote and call
+// the API. Sign-in and registration are limited per client address, votes
+// and API calls per user, and guest votes and code requests per address.
+// Lockout applies to sign-in failures on top of the limit.
+type LimitConfig struct {
+	Login     Rate          `yaml:"login"`
+	Register  Rate          `yaml:"register"`
+	Vote      Rate          `yaml:"vote"`
+	GuestVote Rate          `yaml:"guest_vote"`
+	API       Rate          `yaml:"api"`
+	Lockout   LockoutConfig `yaml:"lockout"`
+}
+
+// Rate is a number of requests allowed at once and refilled over a period,
+// written as 10/1m. A rate of off lets everything through.
+type Rate struct {
+	Requests int
+	Per      time.Duration
+}
+
+func (r Rate) MarshalText() ([]byte, error) {
+	if r.Requests == 0 {
+		return []byte("off"), nil
+	}
+	return []byte(fmt.Sprintf("%d/%s", r.Requests, r.Per)), nil
+}
+
+func (r *Rate) UnmarshalText(text []byte) error {
+	value := strings.TrimSpace(string(text))
+	if value == "off" || value == "0" {
+		*r = Rate{}
+		return nil
+	}
+	count, period, ok := strings.Cut(value, "/")
+	n, err := strconv.Atoi(count)
+	if !ok || err != nil || n <= 0 {
+		return fmt.Errorf("invalid rate %q, want requests/period such as 10/1m", value)
+	}
+	per, err := time.ParseDuration(period)
+	if err != nil || per <= 0 {
+		return fmt.Errorf("invalid rate %q, want requests/period such as 10/1m", value)
+	}
+	*r = Rate{Requests: n, Per: per}
+	return nil
+}
+
+// LockoutConfig locks a client out of signing in after Threshold failed
+// attempts, for Base and then twice as long with every further failure,
+// up to Max. Failures are forgotten after Window without one. A threshold
+// of 0 turns lockouts off.
+type LockoutConfig struct {
+	Threshold int           `yaml:"threshold"`
+	Window    time.Duration `yaml:"window"`
+	Base      time.Duration `yaml:"base"`
+	Max       time.Duration `yaml:"max"`
+}
+
+// FeatureConfig turns optional parts of the application on or off.
+type FeatureConfig struct {
+	GuestVoting bool `yaml:"guest_voting"`
+	Embeds      bool `yaml:"embeds"`
+	Chat        bool `yaml:"chat"`
+	Metrics     bool `yaml:"metrics"`
+}
+
+// Default returns the configuration used for anything not set elsewhere.
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:              ":8080",
+			BaseURL:           "http://localhost:8080",
+			TemplatesGlob:     "templates/*.html",
+			UploadDir:         "./uploads",
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      60 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   30 * time.Second,
+			DrainDelay:        5 * time.Second,
+		},
+		Database: DatabaseConfig{
+			MaxOpenConns:    25,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+		},
+		Cookies: CookieConfig{
+			Domain:     "localhost",
+			SessionTTL: 24 * time.Hour,
+			CSRFTTL:    time.Hour,
+		},
+		Identity: IdentityConfig{
+			Provider:        "firebase",
+			CredentialsFile: "./serviceAccountKey.json",
+		},
+		Mail: MailConfig{
+			Driver:       "log",
+			From:         "VoteEasy <no-reply@localhost>",
+			SMTPHost:     "localhost",
+			SMTPPort:     587,
+			ReminderLead: 24 * time.Hour,
+		},
+		Chat:     ChatConfig{APIURL: "https://slack.com/api"},
+		Accounts: AccountConfig{DeletionGrace: 14 * 24 * time.Hour},
+		Results:  ResultsConfig{CrosstabMinCell: 5},
+		Embeds:   EmbedConfig{FrameAncestors: []string{"'self'"}},
+		Features: FeatureConfig{GuestVoting: true, Embeds: true, Chat: true, Metrics: true},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: "json",
+			RouteLevels: map[string]string{
+				"/healthz": "warn",
+				"/readyz":  "warn",
+				"/metrics": "warn",
+			},
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			ServiceName: "voteeasy",
+			SampleRatio: 1,
+		},
+		Limits: LimitConfig{
+			Login:     Rate{Requests: 10, Per: time.Minute},
+			Register:  Rate{Requests: 5, Per: time.Hour},
+			Vote:      Rate{Requests: 30, Per: time.Minute},
+			GuestVote: Rate{Requests: 10, Per: time.Minute},
+			API:       Rate{Requests: 120, Per: time.Minute},
+			Lockout: LockoutConfig{
+				Threshold: 5,
+				Window:    15 * time.Minute,
+				Base:      time.Minute,
+				Max:       time.Hour,
+			},
+		},
+	}
+}
+
+// Load reads the configuration and validates it. The file is named by the
+// -config flag or CONFIG_FILE and is optional; args are the command line
+// flags without the program name.
+func Load(args []string) (*Config, error) {
+	cfg, err := Read(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Read reads the configuration like Load but does not validate it, for
+// commands such as sync that need only part of it.
+func Read(args []string) (*Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("voteeasy", flag.ContinueOnError)
+	path := fs.String("config", GetEnv("CONFIG_FILE", ""), "YAML configuration file (default $CONFIG_FILE)")
+	addr := fs.String("addr", "", "address to listen on, such as :8080")
+	baseURL := fs.String("base-url", "", "address users reach the server at")
+	tlsCert := fs.String("tls-cert", "", "TLS certificate file")
+	tlsKey := fs.String("tls-key", "", "TLS key file")
+	databaseURL := fs.String("database-url", "", "Postgres connection string")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *path != "" {
+		data, err := os.ReadFile(*path)
+		if err != nil {
+			return nil, fmt.Errorf("read config file: %w", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("parse config file %s: %w", *path, err)
+		}
+	}
+
+	envErr := cfg.loadEnv()
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Server.Addr = *addr
+		case "base-url":
+			cfg.Server.BaseURL = *baseURL
+		case "tls-cert":
+			cfg.Server.TLSCertFile = *tlsCert
+		case "tls-key":
+			cfg.Server.TLSKeyFile = *tlsKey
+		case "database-url":
+			cfg.Database.URL = *databaseURL
+		}
+	})
+
+	if len(cfg.CORS.Origins) == 0 {
+		if u, err := url.Parse(cfg.Server.BaseURL); err == nil {
+			cfg.CORS.Origins = []string{u.Scheme + "://" + u.Host}
+		}
+	}
+	if envErr != nil {
+		return nil, envErr
+	}
+	return &cfg, nil
+}
+
+// envReader applies environment variables to configuration fields,
+// collecting the ones that cannot be parsed.
+type envReader struct {
+	errs []error
+}
+
+func (r *envReader) lookup(key string) (string, bool) {
+	value, exists := os.LookupEnv(key)
+	return strings.TrimSpace(value), exists
+}
+
+func (r *envReader) str(dst *string, key string) {
+	if value, exists := r.lookup(key); exists {
+		*dst = value
+	}
+}
+
+func (r *envReader) integer(dst *int, key string) {
+	if value, exists := r.lookup(key); exists {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			r.errs = append(r.errs, fmt.Errorf("%s: invalid integer %q", key, value))
+			return
+		}
+		*dst = n
+	}
+}
+
+func (r *envReader) boolean(dst *bool, key string) {
+	if value, exists := r.lookup(key); exists {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			r.errs = append(r.errs, fmt.Errorf("%s: invalid boolean %q", key, value))
+			return
+		}
+		*dst = b
+	}
+}
+
+func (r *envReader) duration(dst *time.Duration, key string) {
+	if value, exists := r.lookup(key); exists {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			r.errs = append(r.errs, fmt.Errorf("%s: invalid duration %q", key, value))
+			return
+		}
+		*dst = d
+	}
+}
+
+// durationUnits reads a whole number of unit, for variables such as
+// REMINDER_HOURS that predate durations.
+func (r *envReader) durationUnits(dst *time.Duration, key string, unit time.Duration) {
+	var n int
+	if _, exists := r.lookup(key); !exists {
+		return
+	}
+	before := len(r.errs)
+	r.integer(&n, key)
+	if len(r.errs) == before {
+		*dst = time.Duration(n) * unit
+	}
+}
+
+func (r *envReader) decimal(dst *float64, key string) {
+	if value, exists := r.lookup(key); exists {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			r.errs = append(r.errs, fmt.Errorf("%s: invalid number %q", key, value))
+			return
+		}
+		*dst = f
+	}
+}
+
+// list reads a list separated by commas or spaces.
+func (r *envReader) list(dst *[]string, key string) {
+	if value, exists := r.lookup(key); exists {
+		*dst = strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})
+	}
+}
+
+// rate reads a rate such as 10/1m.
+func (r *envReader) rate(dst *Rate, key string) {
+	if value, exists := r.lookup(key); exists {
+		var rate Rate
+		if err := rate.UnmarshalText([]byte(value)); err != nil {
+			r.errs = append(r.errs, fmt.Errorf("%s: %w", key, err))
+			return
+		}
+		*dst = rate
+	}
+}
+
+// pairs reads key=value pairs separated by commas or spaces.
+func (r *envReader) pairs(dst *map[string]string, key string) {
+	var items []string
+	r.list(&items, key)
+	if items == nil {
+		return
+	}
+	pairs := make(map[string]string, len(items))
+	for _, item := range items {
+		k, v, ok := strings.Cut(item, "=")
+		if !ok || k == "" {
+			r.errs = append(r.errs, fmt.Errorf("%s: %q is not a key=value pair", key, item))
+			continue
+		}
+		pairs[k] = v
+	}
+	*dst = pairs
+}
+
+func (c *Config) loadEnv() error {
+	var r envReader
+	if port, exists := r.lookup("PORT"); exists && port != "" {
+		c.Server.Addr = ":" + port
+	}
+	r.str(&c.Server.Addr, "SERVER_ADDR")
+	r.str(&c.Server.BaseURL, "APP_BASE_URL")
+	r.str(&c.Server.TLSCertFile, "TLS_CERT_FILE")
+	r.str(&c.Server.TLSKeyFile, "TLS_KEY_FILE")
+	r.str(&c.Server.TemplatesGlob, "TEMPLATES_GLOB")
+	r.str(&c.Server.UploadDir, "UPLOAD_DIR")
+	r.duration(&c.Server.ReadHeaderTimeout, "SERVER_READ_HEADER_TIMEOUT")
+	r.duration(&c.Server.ReadTimeout, "SERVER_READ_TIMEOUT")
+	r.duration(&c.Server.WriteTimeout, "SERVER_WRITE_TIMEOUT")
+	r.duration(&c.Server.IdleTimeout, "SERVER_IDLE_TIMEOUT")
+	r.duration(&c.Server.ShutdownTimeout, "SERVER_SHUTDOWN_TIMEOUT")
+	r.duration(&c.Server.DrainDelay, "SERVER_DRAIN_DELAY")
+	r.list(&c.Server.TrustedProxies, "TRUSTED_PROXIES")
+
+	r.str(&c.Database.URL, "DATABASE_URL")
+	r.integer(&c.Database.MaxOpenConns, "DB_MAX_OPEN_CONNS")
+	r.integer(&c.Database.MaxIdleConns, "DB_MAX_IDLE_CONNS")
+	r.duration(&c.Database.ConnMaxLifetime, "DB_CONN_MAX_LIFETIME")
+	r.duration(&c.Database.ConnMaxIdleTime, "DB_CONN_MAX_IDLE_TIME")
+
+	r.str(&c.Cookies.Domain, "COOKIE_DOMAIN")
+	r.boolean(&c.Cookies.Secure, "COOKIE_SECURE")
+	r.duration(&c.Cookies.SessionTTL, "SESSION_TTL")
+	r.duration(&c.Cookies.CSRFTTL, "CSRF_TTL")
+	r.str(&c.Cookies.GuestSecret, "GUEST_COOKIE_SECRET")
+
+	r.list(&c.CORS.Origins, "CORS_ORIGINS")
+
+	r.str(&c.Identity.Provider, "IDENTITY_PROVIDER")
+	r.str(&c.Identity.CredentialsFile, "FIREBASE_CREDENTIALS")
+
+	r.str(&c.Mail.Driver, "MAIL_DRIVER")
+	r.str(&c.Mail.From, "MAIL_FROM")
+	r.str(&c.Mail.LogDir, "MAIL_LOG_DIR")
+	r.str(&c.Mail.SMTPHost, "SMTP_HOST")
+	r.integer(&c.Mail.SMTPPort, "SMTP_PORT")
+	r.str(&c.Mail.SMTPUsername, "SMTP_USERNAME")
+	r.str(&c.Mail.SMTPPassword, "SMTP_PASSWORD")
+	r.durationUnits(&c.Mail.ReminderLead, "REMINDER_HOURS", time.Hour)
+
+	r.str(&c.Chat.SigningSecret, "CHAT_SIGNING_SECRET")
+	r.str(&c.Chat.BotToken, "CHAT_BOT_TOKEN")
+	r.str(&c.Chat.APIURL, "CHAT_API_URL")
+
+	r.str(&c.Reports.LogoPath, "REPORT_LOGO")
+	r.str(&c.Reports.FontPath, "REPORT_FONT")
+
+	r.durationUnits(&c.Accounts.DeletionGrace, "ACCOUNT_DELETION_GRACE_DAYS", 24*time.Hour)
+	r.integer(&c.Results.CrosstabMinCell, "CROSSTAB_MIN_CELL")
+	r.list(&c.Embeds.FrameAncestors, "EMBED_FRAME_ANCESTORS")
+
+	r.boolean(&c.Features.GuestVoting, "FEATURE_GUEST_VOTING")
+	r.boolean(&c.Features.Embeds, "FEATURE_EMBEDS")
+	r.boolean(&c.Features.Chat, "FEATURE_CHAT")
+	r.boolean(&c.Features.Metrics, "FEATURE_METRICS")
+
+	r.str(&c.Logging.Level, "LOG_LEVEL")
+	r.str(&c.Logging.Format, "LOG_FORMAT")
+	r.pairs(&c.Logging.RouteLevels, "LOG_ROUTE_LEVELS")
+
+	r.str(&c.Tracing.Exporter, "TRACING_EXPORTER")
+	r.str(&c.Tracing.Endpoint, "TRACING_ENDPOINT")
+	r.boolean(&c.Tracing.Insecure, "TRACING_INSECURE")
+	r.str(&c.Tracing.ServiceName, "OTEL_SERVICE_NAME")
+	r.decimal(&c.Tracing.SampleRatio, "TRACING_SAMPLE_RATIO")
+
+	r.rate(&c.Limits.Login, "RATE_LIMIT_LOGIN")
+	r.rate(&c.Limits.Register, "RATE_LIMIT_REGISTER")
+	r.rate(&c.Limits.Vote, "RATE_LIMIT_VOTE")
+	r.rate(&c.Limits.GuestVote, "RATE_LIMIT_GUEST_VOTE")
+	r.rate(&c.Limits.API, "RATE_LIMIT_API")
+	r.integer(&c.Limits.Lockout.Threshold, "LOGIN_LOCKOUT_THRESHOLD")
+	r.duration(&c.Limits.Lockout.Window, "LOGIN_LOCKOUT_WINDOW")
+	r.duration(&c.Limits.Lockout.Base, "LOGIN_LOCKOUT_BASE")
+	r.duration(&c.Limits.Lockout.Max, "LOGIN_LOCKOUT_MAX")
+
+	r.str(&c.Sync.Token, "SYNC_TOKEN")
+	return errors.Join(r.errs...)
+}
+
+// Validate reports every setting that is missing or out of range.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr is required")
+	check(isAbsoluteURL(c.Server.BaseURL), "server.base_url %q is not an absolute URL", c.Server.BaseURL)
+	check((c.Server.TLSCertFile == "") == (c.Server.TLSKeyFile == ""), "server.tls_cert_file and server.tls_key_file must be set together")
+	check(c.Server.TemplatesGlob != "", "server.templates_glob is required")
+	check(c.Server.UploadDir != "", "server.upload_dir is required")
+	check(c.Server.ReadHeaderTimeout > 0, "server.read_header_timeout must be positive")
+	check(c.Server.ReadTimeout > 0, "server.read_timeout must be positive")
+	check(c.Server.WriteTimeout > 0, "server.write_timeout must be positive")
+	check(c.Server.IdleTimeout > 0, "server.idle_timeout must be positive")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive")
+	check(c.Server.DrainDelay >= 0, "server.drain_delay must not be negative")
+	for _, proxy := range c.Server.TrustedProxies {
+		check(isAddressOrRange(proxy), "server.trusted_proxies: %q is not an IP address or CIDR range", proxy)
+	}
+
+	check(c.Database.URL != "", "database.url is required (DATABASE_URL)")
+	check(c.Database.MaxOpenConns >= 0, "database.max_open_conns must not be negative")
+	check(c.Database.MaxIdleConns >= 0, "database.max_idle_conns must not be negative")
+	check(c.Database.MaxOpenConns == 0 || c.Database.MaxIdleConns <= c.Database.MaxOpenConns,
+		"database.max_idle_conns must not exceed database.max_open_conns")
+	check(c.Database.ConnMaxLifetime >= 0, "database.conn_max_lifetime must not be negative")
+	check(c.Database.ConnMaxIdleTime >= 0, "database.conn_max_idle_time must not be negative")
+
+	check(c.Cookies.SessionTTL >= time.Minute, "cookies.session_ttl must be at least a minute")
+	check(c.Cookies.CSRFTTL >= time.Minute, "cookies.csrf_ttl must be at least a minute")
+	for _, origin := range c.CORS.Origins {
+		check(isAbsoluteURL(origin), "cors.origins: %q is not an origin", origin)
+	}
+
+	check(c.Identity.Provider == "firebase", "identity.provider %q is not supported, use firebase", c.Identity.Provider)
+	check(c.Identity.CredentialsFile != "", "identity.credentials_file is required")
+
+	check(c.Mail.Driver == "log" || c.Mail.Driver == "smtp", "mail.driver must be log or smtp, not %q", c.Mail.Driver)
+	check(c.Mail.Driver != "smtp" || c.Mail.SMTPHost != "", "mail.smtp_host is required for the smtp driver")
+	check(c.Mail.SMTPPort > 0 && c.Mail.SMTPPort < 65536, "mail.smtp_port %d is out of range", c.Mail.SMTPPort)
+	check(c.Mail.ReminderLead >= 0, "mail.reminder_lead must not be negative")
+
+	check(c.Accounts.DeletionGrace >= 0, "accounts.deletion_grace must not be negative")
+	check(c.Results.CrosstabMinCell >= 1, "results.crosstab_min_cell must be at least 1")
+	check(!c.Features.Embeds || c.Features.GuestVoting, "features.embeds requires features.guest_voting")
+	check(!c.Features.Embeds || len(c.Embeds.FrameAncestors) > 0, "embeds.frame_ancestors must list at least one source")
+
+	check(isLevel(c.Logging.Level), "logging.level must be debug, info, warn or error, not %q", c.Logging.Level)
+	check(c.Logging.Format == "json" || c.Logging.Format == "text", "logging.format must be json or text, not %q", c.Logging.Format)
+	for route, level := range c.Logging.RouteLevels {
+		check(strings.HasPrefix(route, "/"), "logging.route_levels: %q is not a route", route)
+		check(isLevel(level), "logging.route_levels: %s has unknown level %q", route, level)
+	}
+
+	switch c.Tracing.Exporter {
+	case "none", "otlp", "stdout":
+	default:
+		check(false, "tracing.exporter must be none, otlp or stdout, not %q", c.Tracing.Exporter)
+	}
+	check(c.Tracing.ServiceName != "", "tracing.service_name is required")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+
+	lockout := c.Limits.Lockout
+	check(lockout.Threshold >= 0, "rate_limits.lockout.threshold must not be negative")
+	if lockout.Threshold > 0 {
+		check(lockout.Window > 0, "rate_limits.lockout.window must be positive")
+		check(lockout.Base > 0, "rate_limits.lockout.base must be positive")
+		check(lockout.Max >= lockout.Base, "rate_limits.lockout.max must be at least rate_limits.lockout.base")
+	}
+	return errors.Join(errs...)
+}
+
+func isLevel(name string) bool {
+	var level slog.Level
+	return level.UnmarshalText([]byte(name)) == nil
+}
+
+func isAddressOrRange(s string) bool {
+	if net.ParseIP(s) != nil {
+		return true
+	}
+	_, _, err := net.ParseCIDR(s)
+	return err == nil
+}
+
+func isAbsoluteURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// redacted replaces a secret that is set, so the output shows whether it
+// is.
+func redacted(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "[redacted]"
+}
+
+// dsnPassword matches the password of a key=value connection string, which
+// may be quoted.
+var dsnPassword = regexp.MustCompile(`(?i)\bpassword\s*=\s*('(?:[^'\\]|\\.)*'|\S*)`)
+
+// redactedDSN hides the passwords of a connection string, whether it is a
+// URL with a password in its user info or query, or key=value pairs.
+func redactedDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		if q := u.Query(); q.Has("password") {
+			q.Set("password", "xxxxx")
+			u.RawQuery = q.Encode()
+		}
+		return u.Redacted()
+	}
+	return dsnPassword.ReplaceAllString(dsn, "password=[redacted]")
+}
+
+// Redacted returns a copy of the configuration that is safe to log.
+func (c Config) Redacted() Config {
+	c.Database.URL = redactedDSN(c.Database.URL)
+	c.Sync.Token = redacted(c.Sync.Token)
+	c.Cookies.GuestSecret = redacted(c.Cookies.GuestSecret)
+	c.Mail.SMTPPassword = redacted(c.Mail.SMTPPassword)
+	c.Chat.SigningSecret = redacted(c.Chat.SigningSecret)
+	c.Chat.BotToken = redacted(c.Chat.BotToken)
+	return c
+}
+
+// String renders the configuration as YAML with its secrets redacted.
+func (c Config) String() string {
+	out, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return fmt.Sprintf("unprintable config: %v", err)
+	}
+	return string(out)
+}
+
 func LoadEnv() {
 	err := godotenv.Load()
 	if err != nil {
-		log.Println("No .env file found. Using system variables.")
+		slog.Info("No .env file found, using system variables")
 	}
 }
 
@@ -20,11 +733,3 @@ func GetEnv(key, fallback string) string {
 	}
 	return fallback
 }
-
-func GetPort() string {
-	port := os.Getenv("DOCKER_LISTEN_PORT")
-	if port == "" {
-		port = "8080"
-	}
-	return port
-}
@@ -1,20 +1,36 @@
 package main
 
 import (
+	"fakidoosuurdoris/app/Internal/cli"
+	"fakidoosuurdoris/app/Internal/database"
+	"fakidoosuurdoris/app/Internal/export"
 	"fakidoosuurdoris/app/Internal/handlers"
+	"fakidoosuurdoris/app/Internal/logging"
+	"fakidoosuurdoris/app/Internal/mailer"
+	"fakidoosuurdoris/app/Internal/metrics"
 	"fakidoosuurdoris/app/Internal/middlewares"
+	"fakidoosuurdoris/app/Internal/ratelimit"
 	"fakidoosuurdoris/app/Internal/services"
+	"fakidoosuurdoris/app/Internal/storage"
+	"fakidoosuurdoris/app/Internal/tracing"
 	"fakidoosuurdoris/app/config"
 
 	"context"
 	"database/sql"
+	"errors"
 	"html/template"
-	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	firebase "firebase.google.com/go/v4"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"google.golang.org/api/option"
 )
 
@@ -30,31 +46,69 @@ func methodOverride() gin.HandlerFunc {
 	}
 }
 
+// fatal logs msg as an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
 func main() {
 	config.LoadEnv()
 
-	credentialsPath := config.GetEnv("FIREBASE_CREDENTIALS", "./serviceAccountKey.json")
-	fbApp, err := firebase.NewApp(context.Background(), nil, option.WithCredentialsFile(credentialsPath))
+	if len(os.Args) > 1 && os.Args[1] == "sync" {
+		os.Exit(cli.Sync(os.Args[2:]))
+	}
+
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
+	}
+	logLevel, _ := logging.ParseLevel(cfg.Logging.Level)
+	logging.Setup(logging.New(os.Stderr, cfg.Logging.Format, logLevel))
+	routeLevels := make(map[string]slog.Level, len(cfg.Logging.RouteLevels))
+	for route, name := range cfg.Logging.RouteLevels {
+		routeLevels[route], _ = logging.ParseLevel(name)
+	}
+	slog.Info("Configuration loaded", "config", cfg)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		fatal("Failed to set up tracing", "error", err)
+	}
+
+	fbApp, err := firebase.NewApp(context.Background(), nil, option.WithCredentialsFile(cfg.Identity.CredentialsFile))
 	if err != nil {
-		log.Fatalf("Failed to initialize Firebase: %v", err)
+		fatal("Failed to initialize Firebase", "error", err)
 	}
 	authClient, err := fbApp.Auth(context.Background())
 	if err != nil {
-		log.Fatalf("Failed to initialize Firebase Auth: %v", err)
+		fatal("Failed to initialize Firebase Auth", "error", err)
 	}
 
-	connStr := config.GetEnv("DATABASE_URL", "")
-	if connStr == "" {
-		log.Fatal("DATABASE_URL not set in .env")
+	var recorder metrics.Recorder = metrics.Nop{}
+	var prom *metrics.Prometheus
+	if cfg.Features.Metrics {
+		prom = metrics.NewPrometheus()
+		recorder = prom
 	}
-	db, err := sql.Open("postgres", connStr)
+
+	connector, err := pq.NewConnector(cfg.Database.URL)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		fatal("Invalid database URL", "error", err)
 	}
+	db := sql.OpenDB(tracing.InstrumentConnector(metrics.InstrumentConnector(connector, recorder)))
 	defer db.Close()
+	db.SetMaxOpenConns(cfg.Database.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.Database.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.Database.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.Database.ConnMaxIdleTime)
 
 	if err = db.Ping(); err != nil {
-		log.Fatalf("Failed to ping database: %v", err)
+		fatal("Failed to ping database", "error", err)
+	}
+
+	if err = database.Migrate(context.Background(), db); err != nil {
+		fatal("Failed to apply migrations", "error", err)
 	}
 
 	funcMap := template.FuncMap{
@@ -63,49 +117,130 @@ func main() {
 		},
 	}
 
-	tmpl, err := template.New("").Funcs(funcMap).ParseGlob("templates/*.html")
+	tmpl, err := template.New("").Funcs(funcMap).ParseGlob(cfg.Server.TemplatesGlob)
+	if err != nil {
+		fatal("Failed to parse templates", "error", err)
+	}
+
+	store, err := storage.NewLocalStorage(cfg.Server.UploadDir, "/uploads")
 	if err != nil {
-		log.Fatal("Failed to parse templates:", err)
+		fatal("Failed to initialize upload storage", "error", err)
 	}
 
-	r := gin.Default()
+	export.Register("pdf", export.PDFReport{
+		LogoPath: cfg.Reports.LogoPath,
+		FontPath: cfg.Reports.FontPath,
+	})
+
+	r := gin.New()
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		fatal("Invalid trusted proxies", "error", err)
+	}
+	r.Use(middlewares.RequestLogger(routeLevels), middlewares.Recovery())
 	r.SetHTMLTemplate(tmpl)
+	r.Static("/uploads", cfg.Server.UploadDir)
 
-	authService := services.NewAuthService(db, fbApp, authClient) // Fixed: Pass only authClient
-	pollService := services.NewPollService(db, authService)
+	var mail mailer.Mailer
+	switch cfg.Mail.Driver {
+	case "smtp":
+		mail = &mailer.SMTPMailer{
+			Host:     cfg.Mail.SMTPHost,
+			Port:     cfg.Mail.SMTPPort,
+			Username: cfg.Mail.SMTPUsername,
+			Password: cfg.Mail.SMTPPassword,
+			From:     cfg.Mail.From,
+		}
+	default:
+		mail = &mailer.FileMailer{Dir: cfg.Mail.LogDir, From: cfg.Mail.From}
+	}
+	notificationService := services.NewNotificationService(db, mail, cfg.Server.BaseURL, cfg.Mail.ReminderLead)
+
+	webhookService := services.NewWebhookService(db)
+	authService := services.NewAuthService(db, fbApp, authClient, notificationService, webhookService, recorder)
+	pollService := services.NewPollService(db, authService, webhookService, recorder)
 	userService := services.NewUserService(db, authClient)
+	chatService := services.NewChatService(db, pollService, cfg.Chat.SigningSecret, cfg.Chat.BotToken, cfg.Chat.APIURL)
 
 	app := &handlers.App{
 		AuthService: authService,
 		Templates:   tmpl,
+		Cookies:     cfg.Cookies,
 	}
 
-	userHandler := handlers.NewUserHandler(userService, tmpl)
-	pollHandler := handlers.NewPollHandler(pollService, authService, tmpl, db)
+	userHandler := handlers.NewUserHandler(userService, notificationService, chatService, tmpl, cfg.Accounts.DeletionGrace, cfg.Cookies)
+	pollHandler := handlers.NewPollHandler(pollService, authService, tmpl, db, store, cfg.Server.BaseURL)
 	voteHandler := handlers.NewVoteHandler(pollService, authService, tmpl)
-	adminHandler := handlers.NewAdminHandler(pollService, authService, tmpl)
+	adminHandler := handlers.NewAdminHandler(pollService, authService, tmpl, cfg.Results.CrosstabMinCell)
+	webhookHandler := handlers.NewWebhookHandler(webhookService, authService, tmpl)
+	chatHandler := handlers.NewChatHandler(chatService)
+	guestHandler := handlers.NewGuestHandler(pollService, notificationService, tmpl, cfg.Cookies)
+	healthHandler := handlers.NewHealthHandler(db, authService)
+
+	// Probes are registered before tracing and the browser middlewares,
+	// which they do not need.
+	r.GET("/healthz", healthHandler.Healthz)
+	r.GET("/readyz", healthHandler.Readyz)
+	r.GET("/version", healthHandler.Version)
+	if prom != nil {
+		prom.WatchDB(db)
+		prom.WatchActivePolls(pollService.CountActivePolls)
+		r.GET("/metrics", gin.WrapH(prom.Handler()))
+		r.Use(prom.Middleware())
+	}
+	r.Use(tracing.Middleware())
 
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:8080"},
+		AllowOrigins:     cfg.CORS.Origins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", middlewares.RequestIDHeader},
+		ExposeHeaders:    append([]string{middlewares.RequestIDHeader}, middlewares.RateLimitHeaders...),
 		AllowCredentials: true,
 	}))
 
-	r.Use(middlewares.CSRFMiddleware())
+	r.Use(middlewares.FrameGuard())
+	r.Use(middlewares.CSRFMiddleware(cfg.Cookies))
 	r.Use(methodOverride())
 
+	// Limits are kept in memory, so with several replicas each enforces its
+	// own; a shared ratelimit.Store would make them apply across replicas.
+	limiter := ratelimit.NewMemory()
+	limit := func(rule middlewares.RateRule) gin.HandlerFunc {
+		return middlewares.RateLimit(limiter, rule, recorder)
+	}
+	loginLimit := limit(middlewares.RateRule{Name: "login", Limit: ratelimit.Limit(cfg.Limits.Login), Template: "login.html", Title: "Login"})
+	registerLimit := limit(middlewares.RateRule{Name: "register", Limit: ratelimit.Limit(cfg.Limits.Register), Template: "register.html", Title: "Register"})
+	voteLimit := limit(middlewares.RateRule{Name: "vote", Limit: ratelimit.Limit(cfg.Limits.Vote), ByUser: true})
+	guestVoteLimit := limit(middlewares.RateRule{Name: "guest_vote", Limit: ratelimit.Limit(cfg.Limits.GuestVote)})
+	apiLimit := limit(middlewares.RateRule{Name: "api", Limit: ratelimit.Limit(cfg.Limits.API), ByUser: true})
+	loginLockout := middlewares.LoginLockout(limiter, ratelimit.Lockout(cfg.Limits.Lockout), recorder)
+
 	r.GET("/", app.RenderHome)
 	r.GET("/home", app.RenderHome)
 
 	r.GET("/register", app.RenderRegister)
-	r.POST("/register", handlers.Register(app))
+	r.POST("/register", registerLimit, handlers.Register(app))
 
 	r.GET("/login", app.RenderLogin)
-	r.POST("/login", handlers.Login(app))
+	r.POST("/login", loginLimit, loginLockout, handlers.Login(app))
+
+	if cfg.Features.GuestVoting {
+		r.GET("/p/:slug", guestHandler.RenderGuestVote)
+		r.POST("/p/:slug", guestVoteLimit, guestHandler.GuestVote)
+		r.POST("/p/:slug/code", guestVoteLimit, guestHandler.SendGuestCode)
+	}
+
+	if cfg.Features.Embeds {
+		embed := r.Group("/embed")
+		embed.Use(middlewares.EmbedPolicy(cfg.Embeds.FrameAncestors))
+		{
+			embed.GET("/:slug", guestHandler.RenderGuestVote)
+			embed.POST("/:slug", guestVoteLimit, guestHandler.GuestVote)
+			embed.GET("/:slug/results", guestHandler.RenderEmbedResults)
+		}
+	}
 
 	protected := r.Group("/")
-	protected.Use(middlewares.AuthMiddleware(authClient), middlewares.CSRFMiddleware())
+	protected.Use(middlewares.AuthMiddleware(authClient, cfg.Cookies), middlewares.CSRFMiddleware(cfg.Cookies))
 	{
 		protected.GET("/polls", pollHandler.RenderCreatePoll)
 		protected.POST("/polls", pollHandler.CreatePoll)
@@ -114,33 +249,155 @@ func main() {
 		protected.GET("/polls/edit/:id", pollHandler.RenderEditPoll)
 		protected.POST("/polls/update/:id", pollHandler.UpdatePoll)
 		protected.POST("/polls/delete/:id", pollHandler.DeletePoll)
+		protected.GET("/polls/duplicate/:id", pollHandler.DuplicatePoll)
+		protected.POST("/polls/save-template/:id", pollHandler.SaveAsTemplate)
+		protected.GET("/polls/:id/sharing", pollHandler.RenderSharing)
+		protected.POST("/polls/:id/sharing", pollHandler.UpdateSharing)
+		protected.POST("/polls/:id/sharing/rotate", pollHandler.RotateSharingLink)
+		protected.GET("/polls/:id/qr", pollHandler.PollQRCode)
+		protected.POST("/polls/:id/sharing/invites", pollHandler.CreateInvites)
+		protected.POST("/polls/:id/sharing/invites/send", pollHandler.SendInvites)
+		protected.POST("/polls/:id/sharing/invites/:inviteID/revoke", pollHandler.RevokeInvite)
+		protected.POST("/polls/:id/sharing/invites/:inviteID/reissue", pollHandler.ReissueInvite)
+		protected.GET("/templates", pollHandler.RenderTemplates)
+		protected.GET("/templates/use/:id", pollHandler.UseTemplate)
+		protected.POST("/templates/delete/:id", pollHandler.DeleteTemplate)
+		protected.GET("/series", pollHandler.RenderSeriesList)
+		protected.GET("/series/:id", pollHandler.RenderSeries)
+		protected.POST("/series/active/:id", pollHandler.SetSeriesActive)
 		protected.GET("/profile", userHandler.RenderProfile)
 		protected.POST("/profile", userHandler.UpdateProfile)
 		protected.GET("/profile/edit", userHandler.RenderEditProfile)
 		protected.GET("/profile/password", userHandler.RenderChangePassword)
 		protected.POST("/profile/password", userHandler.UpdatePassword)
+		protected.GET("/profile/export", middlewares.NoWriteDeadline(), userHandler.ExportData)
+		protected.GET("/profile/delete", userHandler.RenderDeleteAccount)
+		protected.POST("/profile/delete", userHandler.RequestDeleteAccount)
+		protected.POST("/profile/delete/cancel", userHandler.CancelDeleteAccount)
+		protected.POST("/profile/notifications", userHandler.UpdateNotificationPreferences)
+		protected.POST("/profile/chat/code", userHandler.CreateChatLinkCode)
+		protected.POST("/profile/chat/unlink", userHandler.UnlinkChat)
 		protected.GET("/logout", userHandler.Logout)
 		protected.GET("/admin", app.RenderMakeAdmin)
 		protected.POST("/admin/make", app.MakeAdmin)
 		protected.GET("/vote/:id", voteHandler.RenderVote)
-		protected.POST("/vote/:id", voteHandler.Vote)
+		protected.POST("/vote/:id", voteLimit, voteHandler.Vote)
 		protected.GET("/admin/polls", adminHandler.RenderAdminPolls)
+		protected.GET("/admin/polls/:id/answers", adminHandler.RenderTextAnswers)
+		protected.POST("/admin/polls/:id/answers/tags", adminHandler.CreateAnswerTag)
+		protected.POST("/admin/polls/:id/answers/tags/:tagID/delete", adminHandler.DeleteAnswerTag)
+		protected.POST("/admin/polls/:id/answers/code", adminHandler.CodeAnswers)
 		protected.GET("/admin/users", adminHandler.RenderAdminUsers)
-		protected.GET("/api/admin/polls/:id/summary", adminHandler.GetPollSummary)
-		protected.GET("/api/admin/polls/:id/summary/download", adminHandler.DownloadPollSummary)
-		protected.GET("/admin/users/:id/delete", adminHandler.DeleteUser)
+		protected.GET("/api/admin/polls/:id/summary", apiLimit, adminHandler.GetPollSummary)
+		protected.GET("/api/admin/polls/:id/summary/download", apiLimit, middlewares.NoWriteDeadline(), adminHandler.DownloadPollSummary)
+		protected.GET("/api/admin/polls/:id/crosstab", apiLimit, adminHandler.GetPollCrosstab)
+		protected.POST("/admin/users/:id/department", adminHandler.UpdateUserDepartment)
+		protected.GET("/admin/users/:id/delete", adminHandler.RenderDeleteUser)
+		protected.POST("/admin/users/:id/delete", adminHandler.DeleteUser)
+		protected.GET("/admin/webhooks", webhookHandler.RenderWebhooks)
+		protected.POST("/admin/webhooks", webhookHandler.CreateWebhook)
+		protected.GET("/admin/webhooks/:id", webhookHandler.RenderDeliveries)
+		protected.POST("/admin/webhooks/:id/active", webhookHandler.SetWebhookActive)
+		protected.POST("/admin/webhooks/:id/delete", webhookHandler.DeleteWebhook)
+		protected.POST("/admin/webhooks/deliveries/:id/redeliver", webhookHandler.Redeliver)
+	}
+
+	if cfg.Features.Chat {
+		integrations := r.Group("/integrations/chat")
+		integrations.POST("/commands", chatHandler.Command)
+		integrations.POST("/interactions", chatHandler.Interaction)
 	}
 
 	api := r.Group("/api")
-	api.POST("/register", handlers.Register(app))
-	api.POST("/login", handlers.Login(app))
-	api.POST("/polls", middlewares.AuthMiddleware(authClient), pollHandler.CreatePoll)
+	api.POST("/register", registerLimit, handlers.Register(app))
+	api.POST("/login", loginLimit, loginLockout, handlers.Login(app))
+	api.POST("/polls", middlewares.AuthMiddleware(authClient, cfg.Cookies), apiLimit, pollHandler.CreatePoll)
 	protectedAPI := api.Group("")
-	protectedAPI.Use(middlewares.AuthMiddleware(authClient))
+	protectedAPI.Use(middlewares.AuthMiddleware(authClient, cfg.Cookies), apiLimit)
+	protectedAPI.GET("/polls", pollHandler.ListPolls)
+	protectedAPI.GET("/templates", pollHandler.ListTemplatesAPI)
+	protectedAPI.POST("/templates", pollHandler.CreateTemplateAPI)
+	protectedAPI.GET("/templates/:id", pollHandler.GetTemplateAPI)
+	protectedAPI.DELETE("/templates/:id", pollHandler.DeleteTemplateAPI)
+	protectedAPI.GET("/series/:id/trend", pollHandler.SeriesTrendAPI)
+	protectedAPI.POST("/v1/polls/import", pollHandler.ImportPollsAPI)
+	protectedAPI.GET("/v1/polls/:id/export", pollHandler.ExportPollAPI)
+
+	// Background workers stop with the server and are waited for before the
+	// database is closed. Work they are interrupted in is picked up again on
+	// the next start, since it is all driven from the database.
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	runWorker := func(run func(context.Context, time.Duration), interval time.Duration) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(workerCtx, interval)
+		}()
+	}
+	runWorker(authService.RunDeletionPurger, time.Hour)
+	runWorker(notificationService.Run, time.Minute)
+	runWorker(webhookService.Run, 15*time.Second)
+	if cfg.Features.Chat {
+		runWorker(chatService.Run, time.Minute)
+	}
+	runWorker(pollService.RunSeriesScheduler, time.Minute)
+
+	srv := &http.Server{
+		Addr:              cfg.Server.Addr,
+		Handler:           r,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("Server starting", "addr", cfg.Server.Addr, "base_url", cfg.Server.BaseURL)
+		if cfg.Server.TLSCertFile != "" {
+			serveErr <- srv.ListenAndServeTLS(cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile)
+		} else {
+			serveErr <- srv.ListenAndServe()
+		}
+	}()
+
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+	select {
+	case err := <-serveErr:
+		fatal("Failed to start server", "error", err)
+	case <-signals.Done():
+	}
+	stopSignals()
+
+	// Fail readiness first and keep serving until load balancers notice, so
+	// requests are not routed to a server that has stopped listening.
+	slog.Info("Draining before shutdown", "delay", cfg.Server.DrainDelay)
+	healthHandler.Drain()
+	time.Sleep(cfg.Server.DrainDelay)
+
+	slog.Info("Shutting down, waiting for requests and background work", "timeout", cfg.Server.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("Failed to finish requests", "error", err)
+	}
+	stopWorkers()
+	stopped := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		slog.Info("Server stopped")
+	case <-shutdownCtx.Done():
+		slog.Warn("Background workers did not stop in time")
+	}
 
-	port := config.GetPort()
-	log.Printf("Server starting on http://localhost:%s", port)
-	if err := r.Run(":" + port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
 	}
 }